@@ -0,0 +1,176 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for /ALTERNATENAME directives. /ALTERNATENAME:a=b tells the
+// linker to resolve references to a with b if nothing defines a; b may
+// itself have an alternate name, and so on. After all objects are
+// read, each referenced symbol that isn't defined but has an alternate
+// name is resolved by following the chain to the first name some
+// object defines, which makes the referenced symbol count as defined
+// in the breakdown (and so not unresolved), or failing that to the
+// first import symbol an import library supplies, which gives it that
+// DLL. The chains followed are listed under "Alternate names". Only
+// symbols of interest are tracked, so a chain ending in a symbol that
+// isn't (a base symbol, without -all) may be reported as unresolved.
+
+// aliasRes is the resolution of a referenced symbol through its
+// alternate names.
+type aliasRes struct {
+	// chain is the symbol and the alternate names followed, in order
+	chain []string
+	// objs are the objects defining the last name in the chain, or
+	// dll the DLL an import library has it in; if neither, the
+	// chain leads nowhere
+	objs []int
+	dll  string
+}
+
+// alternateNames returns the map from symbol to alternate name given
+// by the /ALTERNATENAME directives of the objects. As with the
+// linker, the first directive for a symbol wins.
+func (s *state) alternateNames() map[string]string {
+	oidxs := make([]int, 0, len(s.directives))
+	for k := range s.directives {
+		oidxs = append(oidxs, k)
+	}
+	sort.Ints(oidxs)
+	res := make(map[string]string)
+	for _, oidx := range oidxs {
+		for _, d := range s.directives[oidx] {
+			if d.name != "ALTERNATENAME" {
+				continue
+			}
+			from, to, ok := strings.Cut(d.arg, "=")
+			if !ok || from == "" || to == "" {
+				continue
+			}
+			if _, ok := res[from]; !ok {
+				res[from] = to
+			}
+		}
+	}
+	return res
+}
+
+// resolveAlias follows the alternate names of symbol sname, referred
+// to by object objidx, until it reaches one that is defined or that
+// an import library supplies.
+func (s *state) resolveAlias(sname string, objidx int, alts map[string]string) aliasRes {
+	ar := aliasRes{chain: []string{sname}}
+	seen := map[string]bool{sname: true}
+	for cur := sname; ; {
+		to, ok := alts[cur]
+		if !ok || seen[to] {
+			return ar
+		}
+		seen[to] = true
+		ar.chain = append(ar.chain, to)
+		if dl := s.defs[to]; len(dl) != 0 {
+			for _, di := range dl {
+				ar.objs = append(ar.objs, di.objidx)
+			}
+			sort.Ints(ar.objs)
+			ar.objs = dedupInts(ar.objs)
+			return ar
+		}
+		if x, imp := s.symKey(to, objidx); imp {
+			if dll, ok := s.dllmap[x]; ok {
+				ar.dll = dll
+				return ar
+			}
+		}
+		cur = to
+	}
+}
+
+// applyAliases resolves the referenced symbols that nothing defines
+// through their alternate names, if any, marking those resolved within
+// the set as defined and giving those an import library supplies the
+// DLL. The masks are recomputed when saved state is loaded, so this is
+// done after that.
+func (s *state) applyAliases() {
+	alts := s.alternateNames()
+	if len(alts) == 0 {
+		return
+	}
+	// The referring object decides how names are undecorated.
+	referrer := make(map[string]int)
+	for sname := range alts {
+		if len(s.defs[sname]) != 0 {
+			continue
+		}
+		for _, ri := range s.refs[sname] {
+			if !ri.def {
+				referrer[sname] = ri.objidx
+				break
+			}
+		}
+	}
+	s.aliases = make(map[string]aliasRes)
+	s.aliasUsed = make(map[string]bool)
+	for sname, objidx := range referrer {
+		ar := s.resolveAlias(sname, objidx, alts)
+		if len(ar.chain) == 1 {
+			continue
+		}
+		s.aliases[sname] = ar
+		switch {
+		case len(ar.objs) != 0:
+			s.maskAddDef(sname, objidx)
+		case ar.dll != "":
+			if x, _ := s.symKey(sname, objidx); s.dllmap[x] == "" {
+				s.dllmap[x] = ar.dll
+			}
+		default:
+			continue
+		}
+		for _, a := range ar.chain[1:] {
+			s.aliasUsed[a] = true
+		}
+	}
+}
+
+// writeAliases writes the alternate name chains followed for symbols
+// in s, if any, with where each leads, to w.
+func (s *state) writeAliases(w io.Writer) {
+	if len(s.aliases) == 0 {
+		return
+	}
+	snames := make([]string, 0, len(s.aliases))
+	for k := range s.aliases {
+		snames = append(snames, k)
+	}
+	sort.Strings(snames)
+	fmt.Fprintf(w, "Alternate names:\n")
+	for _, sname := range snames {
+		ar := s.aliases[sname]
+		chain := make([]string, len(ar.chain))
+		for i, a := range ar.chain {
+			chain[i] = fmt.Sprintf("%q", a)
+		}
+		var st string
+		switch {
+		case len(ar.objs) != 0:
+			var defs []string
+			for _, o := range ar.objs {
+				defs = append(defs, fmt.Sprintf("O%d %s", o, s.labels[o]))
+			}
+			st = "defined in " + strings.Join(defs, ", ")
+		case ar.dll != "":
+			st = "in " + ar.dll
+		default:
+			st = "unresolved"
+		}
+		fmt.Fprintf(w, " %s: %s\n", strings.Join(chain, " -> "), st)
+	}
+}
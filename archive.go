@@ -0,0 +1,204 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Support for reading Unix-style "ar" archives, in the flavors
+// produced by GNU ar, llvm-ar, BSD ar and the MSVC librarian
+// (including GNU/LLVM thin archives).
+
+const (
+	armag   = "!<arch>\n"
+	thinmag = "!<thin>\n"
+	arhdrsz = 60
+)
+
+type armember struct {
+	// member name
+	name string
+	// contents of the member (nil for members of thin archives)
+	data []byte
+	// for thin archives, path to the file holding the member
+	path string
+}
+
+// isArchive returns true if the specified file starts with archive
+// (regular or thin) magic.
+func isArchive(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var magic [len(armag)]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false, nil
+	}
+	return string(magic[:]) == armag || string(magic[:]) == thinmag, nil
+}
+
+// readArchive returns the object file members of the specified
+// archive, in the order in which they appear. Symbol tables and long
+// name tables are consumed and not returned.
+func readArchive(path string) ([]armember, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < len(armag) {
+		return nil, fmt.Errorf("%s: not an archive", path)
+	}
+	thin := false
+	switch string(content[:len(armag)]) {
+	case armag:
+	case thinmag:
+		thin = true
+	default:
+		return nil, fmt.Errorf("%s: not an archive", path)
+	}
+	var res []armember
+	var longnames []byte
+	off := len(armag)
+	for off < len(content) {
+		if content[off] == '\n' {
+			// stray padding
+			off++
+			continue
+		}
+		if off+arhdrsz > len(content) {
+			return nil, fmt.Errorf("%s: truncated member header at offset %d", path, off)
+		}
+		hdr := content[off : off+arhdrsz]
+		if string(hdr[58:60]) != "`\n" {
+			return nil, fmt.Errorf("%s: bad member header at offset %d", path, off)
+		}
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		size, err := strconv.Atoi(strings.TrimSpace(string(hdr[48:58])))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%s: bad member size at offset %d", path, off)
+		}
+		off += arhdrsz
+
+		// Symbol tables and the long name table are stored in the
+		// archive even when it is thin; members proper are not.
+		special := name == "/" || name == "//" || name == "/SYM64/" ||
+			strings.HasPrefix(name, "__.SYMDEF")
+		var data []byte
+		if !thin || special {
+			if off+size > len(content) {
+				return nil, fmt.Errorf("%s: truncated member %q at offset %d", path, name, off)
+			}
+			data = content[off : off+size]
+			off += size
+			if off%2 == 1 {
+				off++
+			}
+		}
+
+		switch {
+		case name == "//":
+			longnames = data
+			continue
+		case special:
+			continue
+		case strings.HasPrefix(name, "#1/"):
+			// BSD: name of the given length precedes the data.
+			n, err := strconv.Atoi(name[3:])
+			if err != nil || n > len(data) {
+				return nil, fmt.Errorf("%s: bad BSD member name %q", path, name)
+			}
+			name = string(bytes.TrimRight(data[:n], "\x00"))
+			data = data[n:]
+		case len(name) > 1 && name[0] == '/':
+			// GNU/MSVC: offset into the long name table.
+			loff, err := strconv.Atoi(name[1:])
+			if err != nil || loff >= len(longnames) {
+				return nil, fmt.Errorf("%s: bad long member name %q", path, name)
+			}
+			ln := longnames[loff:]
+			if end := bytes.IndexAny(ln, "\n\x00"); end != -1 {
+				ln = ln[:end]
+			}
+			name = strings.TrimSuffix(string(ln), "/")
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		m := armember{name: name, data: data}
+		if thin {
+			m.path = name
+			if !filepath.IsAbs(name) {
+				m.path = filepath.Join(filepath.Dir(path), name)
+			}
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// goobjmag is the header that starts a Go object file.
+const goobjmag = "go object "
+
+// skipReason returns a non-empty string explaining why the specified
+// archive member should not be analyzed, e.g. because it is the
+// package definition in a Go package archive rather than an object,
+// or a short import object from an import library (use -implib for
+// those). Go and ELF objects are analyzed along with COFF ones.
+func skipReason(m armember) string {
+	if m.name == "__.PKGDEF" {
+		return "package definition"
+	}
+	hdr := m.data
+	if m.path != "" {
+		f, err := os.Open(m.path)
+		if err != nil {
+			// Let the dumper report the problem.
+			return ""
+		}
+		defer f.Close()
+		var buf [shortImportHdrSz]byte
+		n, _ := io.ReadFull(f, buf[:])
+		hdr = buf[:n]
+	}
+	if bytes.HasPrefix(hdr, []byte(goobjmag)) || bytes.HasPrefix(hdr, []byte(elfmag)) {
+		return ""
+	}
+	if isShortImport(hdr) {
+		return "short import object"
+	}
+	if !isCOFFHeader(hdr) {
+		return "not a COFF object"
+	}
+	return ""
+}
+
+// isCOFFHeader returns true if the data looks like the start of a COFF
+// object: a known machine type, or the 0x0000/0xFFFF signature used by
+// bigobj and short import objects.
+func isCOFFHeader(hdr []byte) bool {
+	if len(hdr) < 4 {
+		return false
+	}
+	m := uint16(hdr[0]) | uint16(hdr[1])<<8
+	switch m {
+	case pe.IMAGE_FILE_MACHINE_AMD64, pe.IMAGE_FILE_MACHINE_I386,
+		pe.IMAGE_FILE_MACHINE_ARM64, pe.IMAGE_FILE_MACHINE_ARMNT,
+		pe.IMAGE_FILE_MACHINE_ARM:
+		return true
+	case pe.IMAGE_FILE_MACHINE_UNKNOWN:
+		return hdr[2] == 0xff && hdr[3] == 0xff
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Backends are the ways of reading an object: with the built-in COFF
+// reader, or by running a dumper. Unless told otherwise (with
+// -backend, or by naming a dumper with -objdump, $WINIMPSYM_OBJDUMP or
+// -dumper-flavor), each object is read with the first backend in
+// backendChain that can make sense of it.
+
+const (
+	backendAuto   = "auto"
+	backendNative = "native"
+	backendLLVM   = "llvm"
+	backendGNU    = "gnu"
+)
+
+var backendChain = []string{backendNative, backendLLVM, backendGNU}
+
+type backend struct {
+	// backend name, for display
+	name string
+	// flavor of the dumper output
+	flavor string
+	// dumper to run, nil for the built-in reader
+	dumper Dumper
+	// for backends set up on first use, whether that has been done,
+	// and the error if it failed
+	resolved bool
+	err      error
+}
+
+// setup resolves the dumper for backend b, if that hasn't been done.
+func (b *backend) setup(s *state) error {
+	if b.resolved {
+		return b.err
+	}
+	b.resolved = true
+	switch b.name {
+	case backendLLVM:
+		if b.err = resolveDumper(); b.err == nil {
+			b.dumper = newObjdumpDumper()
+			// Also use it for excerpts.
+			s.dumper = b.dumper
+		}
+	case backendGNU:
+		b.dumper, b.err = gnuDumper()
+	}
+	return b.err
+}
+
+// gnuDumper returns a Dumper for GNU objdump: the program named with
+// -objdump (or $WINIMPSYM_OBJDUMP), or else "objdump" if that is GNU
+// objdump.
+func gnuDumper() (Dumper, error) {
+	prog := *objdumpflag
+	if prog == "" {
+		prog = os.Getenv(dumperEnv)
+	}
+	explicit := prog != ""
+	if !explicit {
+		prog = "objdump"
+	}
+	ver, err := probeDumper([]string{prog})
+	if err != nil {
+		return nil, fmt.Errorf("can't use GNU objdump %q: %v", prog, err)
+	}
+	if !explicit && !strings.Contains(ver, "GNU") {
+		return nil, fmt.Errorf("%s is not GNU objdump (%s)", prog, ver)
+	}
+	if dumper == "" {
+		dumper, dumperArgv, dumperVersion = prog, []string{prog}, ver
+	}
+	return &objdumpDumper{name: prog, argv: []string{prog}, flavor: flavorGNU}, nil
+}
+
+// newBackends returns the backends to use for -backend=which.
+func newBackends(which string) ([]*backend, error) {
+	var names []string
+	switch which {
+	case backendAuto:
+		names = backendChain
+	case backendNative, backendLLVM, backendGNU:
+		names = []string{which}
+	default:
+		return nil, fmt.Errorf("bad -backend %q", which)
+	}
+	res := make([]*backend, 0, len(names))
+	for _, n := range names {
+		b := &backend{name: n, flavor: n}
+		if n == backendNative {
+			b.flavor = flavorNative
+			b.resolved = true
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+// backends returns the backends to try, in order, for each object.
+func (s *state) backends() []*backend {
+	if s.chain != nil {
+		return s.chain
+	}
+	// A single dumper, as selected by resolveDumper (or none for
+	// the built-in reader).
+	b := &backend{name: flavor, flavor: flavor, dumper: s.dumper, resolved: true}
+	if s.dumper == nil {
+		b.name, b.flavor = backendNative, flavorNative
+	}
+	s.chain = []*backend{b}
+	return s.chain
+}
+
+// pass1Backends runs pass1 for infile with the first backend that
+// succeeds, recording it for the current object.
+func (s *state) pass1Backends(infile string) error {
+	var errs []string
+	bl := s.backends()
+	for i, b := range bl {
+		err := b.setup(s)
+		if err == nil {
+			if err = s.pass1With(b, infile); err == nil {
+				s.objBackend[s.objidx] = b
+				return nil
+			}
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", b.name, err))
+		if i+1 < len(bl) {
+			verb(1, "reading %s with %s backend failed (%v), trying %s", infile, b.name, err, bl[i+1].name)
+		}
+	}
+	if len(errs) == 1 {
+		return fmt.Errorf("%s", strings.TrimPrefix(errs[0], bl[0].name+": "))
+	}
+	return fmt.Errorf("no backend could read it:\n  %s", strings.Join(errs, "\n  "))
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Support for comparing the Def/ref breakdown against a previously
+// recorded baseline (-baseline, -write-baseline). A baseline file has
+// one line per symbol, sorted by symbol, with the symbol and its
+// categories separated by a tab:
+//
+//	CreateFileA	refimp
+//	frobnicate	defbase refbase
+
+// baselineDiffExit is the exit status used when the breakdown differs
+// from the baseline.
+const baselineDiffExit = 3
+
+var maskNames = map[string]defrefmask{
+	"defbase":  defbase,
+	"refbase":  refbase,
+	"defimp":   defimp,
+	"refimp":   refimp,
+	"sameobj":  dsameobj,
+	"splitobj": dsplitobj,
+}
+
+// writeBaseline writes the def/ref breakdown for s to the specified
+// file in baseline format.
+func (s *state) writeBaseline(path string) error {
+	syms := make([]string, 0, len(s.defref))
+	for k := range s.defref {
+		syms = append(syms, k)
+	}
+	sort.Strings(syms)
+	sb := &strings.Builder{}
+	for _, k := range syms {
+		fmt.Fprintf(sb, "%s\t%s\n", k, strings.TrimSpace(s.defref[k].String()))
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0666)
+}
+
+// readBaseline reads a baseline file written by writeBaseline.
+// Blank lines and lines starting with '#' are ignored.
+func readBaseline(path string) (map[string]defrefmask, error) {
+	content, err := readText(path)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]defrefmask)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d: malformed line %q", path, i+1, line)
+		}
+		var drm defrefmask
+		for _, w := range strings.Fields(fields[1]) {
+			m, ok := maskNames[w]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unknown category %q", path, i+1, w)
+			}
+			drm |= m
+		}
+		res[fields[0]] = drm
+	}
+	return res, nil
+}
+
+// compareBaseline writes a report of the differences between the
+// baseline and the current def/ref breakdown to w, returning the
+// number of differences.
+func (s *state) compareBaseline(w io.Writer, baseline map[string]defrefmask) int {
+	syms := make([]string, 0, len(s.defref))
+	for k := range s.defref {
+		syms = append(syms, k)
+	}
+	for k := range baseline {
+		if _, ok := s.defref[k]; !ok {
+			syms = append(syms, k)
+		}
+	}
+	sort.Strings(syms)
+	ndiffs := 0
+	for _, k := range syms {
+		old, inold := baseline[k]
+		cur, incur := s.defref[k]
+		switch {
+		case !inold:
+			fmt.Fprintf(w, " + %q: %s\n", k, cur)
+		case !incur:
+			fmt.Fprintf(w, " - %q: %s\n", k, old)
+		case old != cur:
+			fmt.Fprintf(w, " ~ %q: %s =>%s\n", k, old, cur)
+		default:
+			continue
+		}
+		ndiffs++
+	}
+	return ndiffs
+}
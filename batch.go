@@ -0,0 +1,207 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Support for dumping several objects with one run of the dumper
+// (-batch). objdump takes any number of input files and starts its
+// output for each with a "file: file format ..." header, so with
+// thousands of objects batching saves most of the cost of starting
+// the dumper. The output for a batch is split up into a file for each
+// object before pass1, and pass1 reads each object's file as though it
+// had run the dumper itself. Objects in a batch that fails are left
+// for pass1 to dump one at a time, so that one bad object only affects
+// itself. Only the first backend is batched, and only if it is
+// objdump: the built-in reader has no dumper to start, and dumpbin and
+// llvm-readobj can't be split reliably. Since the default chain starts
+// with the built-in reader, -batch takes -backend=llvm or
+// -backend=gnu, and says so otherwise.
+
+// batchDumper is implemented by Dumpers that can dump several files in
+// one run.
+type batchDumper interface {
+	// FullBatch is Full for each of files, in one run. It returns
+	// errNoBatch if the dumper can't do this.
+	FullBatch(files []string, sections []string) (io.Reader, error)
+}
+
+// errNoBatch is returned by FullBatch when the dumper can't take more
+// than one file.
+var errNoBatch = errors.New("dumper takes one file at a time")
+
+// batchDumps dumps the objects in files that pass1 will read with the
+// first backend, in batches of -batch, recording the file holding the
+// output for each in s.batched.
+func (s *state) batchDumps(files []string) {
+	if *batchflag < 2 || *fromdumpflag != "" {
+		return
+	}
+	b := s.backends()[0]
+	if b.setup(s) != nil {
+		return
+	}
+	bd, ok := b.dumper.(batchDumper)
+	if !ok || (b.flavor != flavorLLVM && b.flavor != flavorGNU) {
+		fmt.Fprintf(os.Stderr, "warning: -batch has no effect with the %s backend; use -backend=llvm or -backend=gnu\n", b.name)
+		return
+	}
+	var batch []int
+	flush := func() {
+		if len(batch) != 0 {
+			s.runBatch(bd, files, batch)
+			batch = batch[:0]
+		}
+	}
+	for k, ifile := range files {
+		if _, ok := s.skipped[k]; ok || !s.batchable(k, ifile, b) {
+			continue
+		}
+		if batch = append(batch, k); len(batch) == *batchflag {
+			flush()
+		}
+	}
+	flush()
+}
+
+// batchable reports whether object k (in ifile) should be dumped in a
+// batch: that is, whether pass1 would run the dumper of backend b on
+// it, with no output recorded by -state to use instead.
+func (s *state) batchable(k int, ifile string, b *backend) bool {
+	if goObjectHeader(ifile) != "" {
+		return false
+	}
+	if img, err := isImage(ifile); err != nil || img {
+		return false
+	}
+	if dcache != nil {
+		ce, err := dcache.entry(s.objs[k], ifile)
+		if err != nil {
+			return false
+		}
+		if _, ok := ce.Output[flavor+" "+b.flavor+" full"]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// runBatch dumps the objects with the specified indices in one run of
+// bd, recording the output for each.
+func (s *state) runBatch(bd batchDumper, files []string, batch []int) {
+	paths := make([]string, len(batch))
+	for i, k := range batch {
+		paths[i] = files[k]
+	}
+	doing = fmt.Sprintf("dumping a batch of %d objects", len(batch))
+	defer func() { doing = "" }()
+	r, err := bd.FullBatch(paths, pass3SectionList)
+	if err == errNoBatch {
+		return
+	}
+	if err == nil {
+		err = s.splitBatchFiles(r, paths, batch)
+	}
+	if err != nil {
+		verb(1, "batch of %d objects failed, dumping them one at a time: %v", len(batch), err)
+		return
+	}
+	verb(1, "dumped %d objects in one run", len(batch))
+}
+
+// splitBatchFiles splits the batched dump r of paths (objects batch)
+// into a file for each object. If that fails, the files are removed
+// and none are recorded.
+func (s *state) splitBatchFiles(r io.Reader, paths []string, batch []int) error {
+	fl := make([]*os.File, 0, len(batch))
+	defer func() {
+		for _, f := range fl {
+			f.Close()
+		}
+	}()
+	ws := make([]io.Writer, len(batch))
+	for i := range batch {
+		f, err := newDumpFile()
+		if err != nil {
+			return err
+		}
+		fl = append(fl, f)
+		ws[i] = f
+	}
+	err := readStream(r, nil, func(r io.Reader) error {
+		return splitBatch(r, paths, ws)
+	})
+	for i, k := range batch {
+		if err != nil {
+			os.Remove(fl[i].Name())
+		} else {
+			s.batched[k] = fl[i].Name()
+		}
+	}
+	return err
+}
+
+// splitBatch splits the batched dump r of files, writing the part for
+// each file to the corresponding writer in ws. Each part starts with a
+// header line such as
+//
+//	testdata/sample.o:	file format coff-x86-64
+//
+// (or, for GNU objdump, spaces rather than a tab), preceded by an empty
+// line, and is what a dump of the file on its own would produce.
+func splitBatch(r io.Reader, files []string, ws []io.Writer) error {
+	br := bufio.NewReader(r)
+	var w io.Writer = io.Discard
+	next := 0
+	blank := false
+	for {
+		line, err := br.ReadString('\n')
+		if next < len(files) && isBatchHeader(line, files[next]) {
+			w = ws[next]
+			next++
+		}
+		// An empty line is held back until it is known which part it
+		// belongs to.
+		if blank {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if blank = line == "\n"; !blank {
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if blank {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	if next < len(files) {
+		return fmt.Errorf("no output for %s", files[next])
+	}
+	return nil
+}
+
+// isBatchHeader reports whether line is the header line for file f in
+// a batched dump.
+func isBatchHeader(line, f string) bool {
+	rest, ok := strings.CutPrefix(line, dumperPath(f)+":")
+	return ok && strings.HasPrefix(strings.TrimLeft(rest, " \t"), "file format ")
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for grouping the Def/ref breakdown by DLL (-by-dll), using
+// the symbol to DLL map from -implib (or from the import tables of
+// linked images):
+//
+//	Def/ref breakdown:
+//	 kernel32.dll:
+//	  "CreateFileA":  refimp
+//	  "ReadFile":     refimp
+//	 unknown:
+//	  "frobnicate":   refimp
+//
+// DLLs are listed by name, with the symbols not mapped to any DLL
+// last, under "unknown". The Summary block gets a line per DLL giving
+// the number of symbols it has ("dll kernel32.dll: 2").
+
+// unknownDLL is the group for symbols not mapped to a DLL.
+const unknownDLL = "unknown"
+
+// dllGroups returns the DLLs of the breakdown symbols, in order, and
+// the symbols of each.
+func (s *state) dllGroups() ([]string, map[string][]string) {
+	groups := make(map[string][]string)
+	var dlls []string
+	for _, x := range s.breakdownSyms() {
+		dll, ok := s.dllmap[x]
+		if !ok {
+			dll = unknownDLL
+		}
+		if _, ok := groups[dll]; !ok && dll != unknownDLL {
+			dlls = append(dlls, dll)
+		}
+		groups[dll] = append(groups[dll], x)
+	}
+	sort.Strings(dlls)
+	if _, ok := groups[unknownDLL]; ok {
+		dlls = append(dlls, unknownDLL)
+	}
+	return dlls, groups
+}
+
+// dllCounts returns the number of breakdown symbols for each DLL, in
+// the order of dllGroups.
+func (s *state) dllCounts() []ReportDLL {
+	dlls, groups := s.dllGroups()
+	res := make([]ReportDLL, 0, len(dlls))
+	for _, dll := range dlls {
+		res = append(res, ReportDLL{DLL: dll, Symbols: len(groups[dll])})
+	}
+	return res
+}
+
+// writeBreakdownByDLL writes the Def/ref breakdown to w, grouped by
+// DLL.
+func (s *state) writeBreakdownByDLL(w io.Writer) {
+	fmt.Fprintf(w, "Def/ref breakdown:\n")
+	dlls, groups := s.dllGroups()
+	counts := s.formRefCounts()
+	variants := s.variants()
+	kinds := s.kindCounts()
+	dkeys := s.defKeys()
+	exported := s.exportedSyms()
+	for _, dll := range dlls {
+		fmt.Fprintf(w, " %s:\n", dll)
+		syms := groups[dll]
+		sb := &strings.Builder{}
+		tw := textfmt.newTable(sb)
+		for _, v := range syms {
+			fmt.Fprintf(tw, "  %q%s:\t%s%s%s%s%s%s\n", v, demangledTag(v), s.defref[v], exportTag(v, exported), s.dupTag(v, dkeys), s.countsTag(v, counts), kindsTag(kinds[v]), variantsTag(variants[v]))
+		}
+		tw.Flush()
+		lines := strings.SplitAfter(sb.String(), "\n")
+		for i, v := range syms {
+			line := strings.TrimSuffix(lines[i], "\n")
+			fmt.Fprintf(w, "%s\n", colored(s.symColor(v), line))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Support for organizing the report by object (-by-object) rather than
+// by symbol: for each object, its sections, the symbols it defines and
+// those it refers to, with offsets. A symbol shows up under each object
+// that defines or refers to it. For example:
+//
+//	Objects:
+//	 O0: obj1.o foo.c
+//	  Sections:
+//	   0 ".text" 0xe27
+//	  Defs:
+//	   "bar" sec=1 val=0x40
+//	  Refs:
+//	   "__imp_foo" S=0 [0x99]
+//	  *"bar" S=1 [0x12]
+//
+// As in the Refs section, "*" marks references from the object
+// defining the symbol.
+
+// writeObjectBlocks writes the per-object part of the report to w.
+func (s *state) writeObjectBlocks(w io.Writer) {
+	type objref struct {
+		sym string
+		ri  refinfo
+	}
+	defs := make([][]string, len(s.objs))
+	refs := make([][]objref, len(s.objs))
+	sects := make([][]secinfo, len(s.objs))
+	syms := make([]string, 0, len(s.defs))
+	for k := range s.defs {
+		syms = append(syms, k)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		for _, di := range s.defs[sym] {
+			if oi := di.objidx; oi < len(defs) {
+				defs[oi] = append(defs[oi], sym)
+			}
+		}
+	}
+	syms = syms[:0]
+	for k := range s.refs {
+		syms = append(syms, k)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		for _, ri := range s.refs[sym] {
+			if ri.objidx < len(refs) && (!ri.def || len(ri.relocs) != 0) {
+				refs[ri.objidx] = append(refs[ri.objidx], objref{sym, ri})
+			}
+		}
+	}
+	for _, sn := range s.sects {
+		if sn.objidx < len(sects) {
+			sects[sn.objidx] = append(sects[sn.objidx], sn)
+		}
+	}
+
+	fmt.Fprintf(w, "Objects:\n")
+	mixed := s.mixedMachines() != nil
+	for i := range s.objs {
+		fmt.Fprintf(w, " O%d: %s%s %s\n", i, s.objName(i), s.objTags(i, mixed), s.objProvField(i))
+		if len(sects[i]) != 0 {
+			fmt.Fprintf(w, "  Sections:\n")
+			for _, sn := range sects[i] {
+				fmt.Fprintf(w, "   %d %q 0x%x%s\n", sn.idx, sn.name, sn.size, sn.auxString())
+			}
+		}
+		if len(defs[i]) != 0 {
+			fmt.Fprintf(w, "  Defs:\n")
+			for _, sym := range defs[i] {
+				di, _ := s.defIn(sym, i)
+				fmt.Fprintf(w, "   %q%s sec=%s val=0x%x%s\n", sym, demangledTag(sym), s.secField(i, di.secidx), di.value, s.comdatTag(di))
+			}
+		}
+		if len(refs[i]) != 0 {
+			fmt.Fprintf(w, "  Refs:\n")
+			for _, or := range refs[i] {
+				def := " "
+				if or.ri.def {
+					def = "*"
+				}
+				prefix := fmt.Sprintf("  %s%q%s S=%s ", def, or.sym, demangledTag(or.sym), s.refSecField(&or.ri))
+				fmt.Fprintf(w, "%s\n", textfmt.offsets(prefix, or.ri.relocs, ""))
+			}
+		}
+	}
+}
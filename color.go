@@ -0,0 +1,89 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Color in the Def/ref breakdown and Refs section of the report
+// (-color), by category: red for a symbol that is defined and also
+// referred to through an import symbol (the troublesome case), yellow
+// for one only referred to through an import symbol, and green for an
+// import symbol whose definition is there too. Watched symbols are in
+// bold. By default color is used only when the report goes to a
+// terminal, and not if NO_COLOR is set. Apart from the escape
+// sequences, the report is the same as without color.
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// useColor is set if the report is to be in color.
+var useColor bool
+
+// setupColor decides whether to use color, given the -color value,
+// with the report going to stdout unless toFile.
+func setupColor(mode string, toFile bool) error {
+	switch mode {
+	case colorAlways:
+		useColor = !toFile
+	case colorNever:
+		useColor = false
+	case colorAuto:
+		useColor = false
+		if toFile || os.Getenv("NO_COLOR") != "" {
+			break
+		}
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			useColor = os.Getenv("TERM") != "dumb"
+		}
+	default:
+		return fmt.Errorf("bad -color %q: expected auto, always or never", mode)
+	}
+	return nil
+}
+
+// symColor returns the escape sequence to show lines for base symbol
+// x with, or "" for none.
+func (s *state) symColor(x string) string {
+	if !useColor {
+		return ""
+	}
+	res := ""
+	drm := s.defref[x]
+	switch {
+	case drm&defbase != 0 && drm&refimp != 0:
+		res = ansiRed
+	case drm == refimp:
+		res = ansiYellow
+	case drm&refimp != 0 && drm&defimp != 0:
+		res = ansiGreen
+	}
+	if watchedName(x) {
+		res += ansiBold
+	}
+	return res
+}
+
+// colored returns line in the color given by the escape sequence c
+// (if any).
+func colored(c, line string) string {
+	if c == "" {
+		return line
+	}
+	return c + line + ansiReset
+}
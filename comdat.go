@@ -0,0 +1,126 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// Support for the COMDAT membership of definitions. Whether a
+// definition of __imp_X or X is in a COMDAT section (per the section's
+// aux record) decides what the linker does with several of them: a
+// COMDAT definition can be discarded in favor of another, while two
+// ordinary definitions of a symbol are a genuine duplicate. The
+// selection is shown on each COMDAT definition in the Defs listing,
+// and breakdown lines for symbols defined by more than one object are
+// tagged with whether the definitions can coexist.
+
+// comdatUnknown is the COMDAT selection of a definition in a section
+// whose aux record wasn't seen (or that wasn't read at all); 0 means
+// the section isn't a COMDAT.
+const comdatUnknown = -1
+
+// IMAGE_COMDAT_SELECT_NODUPLICATES
+const comdatNoDuplicates = 1
+
+// defComdat returns the COMDAT selection of the section holding
+// definition di: 0 if it isn't a COMDAT, comdatUnknown if that can't
+// be told.
+func (s *state) defComdat(di definfo) int {
+	si := s.symSection(di.objidx, di.secidx)
+	if si == nil || !si.haveAux {
+		return comdatUnknown
+	}
+	return si.comdatSelection
+}
+
+// comdatString describes COMDAT selection sel, as from defComdat.
+func comdatString(sel int) string {
+	switch sel {
+	case comdatUnknown:
+		return "unknown"
+	case 0:
+		return "not comdat"
+	}
+	return comdatName(sel)
+}
+
+// comdatTag returns the Defs listing annotation for definition di:
+// its COMDAT selection, if it is in a COMDAT.
+func (s *state) comdatTag(di definfo) string {
+	if sel := s.defComdat(di); sel > 0 {
+		return " comdat=" + comdatName(sel)
+	}
+	return ""
+}
+
+// How several definitions of a symbol get on at link time.
+const (
+	// one definition, or none
+	dupNone = iota
+	// all COMDATs the linker can pick one of
+	dupComdat
+	// some COMDAT status isn't known
+	dupUnknown
+	// an ordinary (or NODUPLICATES) definition along with another:
+	// a multiple definition error
+	dupConflict
+)
+
+// dupStatus returns how the definitions dl of a symbol get on at link
+// time.
+func (s *state) dupStatus(dl []definfo) int {
+	objs := make(map[int]bool)
+	for _, di := range dl {
+		objs[di.objidx] = true
+	}
+	if len(objs) < 2 {
+		return dupNone
+	}
+	res := dupComdat
+	for _, di := range dl {
+		switch sel := s.defComdat(di); sel {
+		case 0, comdatNoDuplicates:
+			return dupConflict
+		case comdatUnknown:
+			res = dupUnknown
+		}
+	}
+	return res
+}
+
+// dupTag returns the breakdown annotation for base symbol X saying how
+// the definitions of X and __imp_X from more than one object get on
+// (the worse of the two), or "" if neither has any.
+func (s *state) dupTag(x string, keys map[string][]string) string {
+	worst := dupNone
+	for _, sname := range keys[x] {
+		if st := s.dupStatus(s.defs[sname]); st > worst {
+			worst = st
+		}
+	}
+	switch worst {
+	case dupComdat:
+		return " [comdat defs]"
+	case dupUnknown:
+		return " [multiple defs]"
+	case dupConflict:
+		return " [duplicate defs]"
+	}
+	return ""
+}
+
+// defKeys returns the names of the defined symbols for each base
+// symbol (as in the Def/ref breakdown).
+func (s *state) defKeys() map[string][]string {
+	res := make(map[string][]string)
+	for sname, dl := range s.defs {
+		seen := make(map[string]bool)
+		for _, di := range dl {
+			x, _ := s.symKey(sname, di.objidx)
+			if !seen[x] {
+				seen[x] = true
+				res[x] = append(res[x], sname)
+			}
+		}
+	}
+	return res
+}
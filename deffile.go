@@ -0,0 +1,82 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Support for writing the imports the objects need as a
+// module-definition (.def) file (-emit-def), for putting together small
+// test cases. Each symbol with refimp set is listed, without its import
+// prefix or i386 decorations, in an IMPORTS section grouped by DLL:
+//
+//	IMPORTS
+//	; kernel32.dll
+//	  kernel32.CreateFileA
+//	; unknown DLL
+//	  frobnicate
+//
+// If no symbol is mapped to a DLL, the file is instead a flat list of
+// the names. Symbols also defined by the objects (defbase or defimp)
+// wouldn't come from a DLL, so they are commented out, with the reason:
+//
+//	; helper: defined locally (defbase)
+
+// writeDef writes the .def file for s to the specified file.
+func (s *state) writeDef(path string) error {
+	dlls, groups := s.dllGroups()
+	mapped := len(dlls) != 0 && dlls[0] != unknownDLL
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "; imports referenced by %d objects, written by winimpsym\n", len(s.objs))
+	indent := ""
+	if mapped {
+		fmt.Fprintf(sb, "IMPORTS\n")
+		indent = "  "
+	}
+	for _, dll := range dlls {
+		var lines []string
+		for _, x := range groups[dll] {
+			drm := s.defref[x]
+			if drm&refimp == 0 {
+				continue
+			}
+			if local := drm & (defbase | defimp); local != 0 {
+				lines = append(lines, fmt.Sprintf("; %s: defined locally (%s)", x, strings.TrimSpace(local.String())))
+				continue
+			}
+			entry := x
+			if dll != unknownDLL {
+				entry = defModule(dll) + "." + x
+			}
+			lines = append(lines, indent+entry)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if mapped {
+			if dll == unknownDLL {
+				fmt.Fprintf(sb, "; unknown DLL\n")
+			} else {
+				fmt.Fprintf(sb, "; %s\n", dll)
+			}
+		}
+		for _, l := range lines {
+			fmt.Fprintf(sb, "%s\n", l)
+		}
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0666)
+}
+
+// defModule returns the module name for dll in a .def file: its name
+// without the ".dll" extension.
+func defModule(dll string) string {
+	if len(dll) > 4 && strings.EqualFold(dll[len(dll)-4:], ".dll") {
+		return dll[:len(dll)-4]
+	}
+	return dll
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Support for showing C++ symbols demangled (-demangle). The
+// demangled form of a name is shown in parentheses after the name in
+// the breakdown, Defs and Refs listings and excerpt headers; all the
+// matching and keying is still done on the mangled names. Itanium
+// names (_ZN3FooC1Ev, or __ZN3FooC1Ev in i386 objects) are demangled
+// by running one of itaniumDemanglers, and MSVC names (?Foo@@YAXXZ)
+// by demangleMSVC, which only gets as far as the qualified name. A
+// name that can't be demangled is shown as is.
+
+// itaniumDemanglers is the programs tried, in order, for demangling
+// Itanium names. Each reads names one per line and writes them back
+// demangled.
+var itaniumDemanglers = []string{"llvm-cxxfilt", "c++filt"}
+
+// demangled maps the names of the symbols in the report (less any
+// import prefix) to their demangled forms, with -demangle.
+var demangled map[string]string
+
+// isItanium reports whether sname is an Itanium mangled name, as far
+// as we can tell.
+func isItanium(sname string) bool {
+	return strings.HasPrefix(sname, "_Z") || strings.HasPrefix(sname, "__Z")
+}
+
+// setupDemangled fills in demangled for the symbols of s.
+func (s *state) setupDemangled() {
+	demangled = make(map[string]string)
+	seen := make(map[string]bool)
+	var itanium []string
+	add := func(sname string) {
+		x, _ := cutImp(sname)
+		if seen[x] {
+			return
+		}
+		seen[x] = true
+		switch {
+		case isItanium(x):
+			itanium = append(itanium, x)
+		case strings.HasPrefix(x, "?"):
+			if dm, ok := demangleMSVC(x); ok {
+				demangled[x] = dm
+			}
+		}
+	}
+	for sname := range s.defs {
+		add(sname)
+	}
+	for sname := range s.refs {
+		add(sname)
+	}
+	for x := range s.defref {
+		add(x)
+	}
+	if len(itanium) == 0 {
+		return
+	}
+	dms, err := demangleItanium(itanium)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "note: -demangle: C++ names left mangled: %v\n", err)
+		return
+	}
+	for i, x := range itanium {
+		if dms[i] != x {
+			demangled[x] = dms[i]
+		}
+	}
+}
+
+// demangleItanium returns names demangled by the first of
+// itaniumDemanglers found.
+func demangleItanium(names []string) ([]string, error) {
+	prog := ""
+	for _, p := range itaniumDemanglers {
+		if _, err := exec.LookPath(p); err == nil {
+			prog = p
+			break
+		}
+	}
+	if prog == "" {
+		return nil, fmt.Errorf("none of %s found", strings.Join(itaniumDemanglers, ", "))
+	}
+	// A leading underscore for i386 is stripped first, and put back
+	// if the name doesn't demangle.
+	in := make([]string, len(names))
+	for i, n := range names {
+		in[i] = strings.TrimPrefix(n, "_")
+		if !strings.HasPrefix(in[i], "_Z") {
+			in[i] = n
+		}
+	}
+	cmd := exec.CommandContext(runCtx, prog)
+	cmd.Stdin = strings.NewReader(strings.Join(in, "\n") + "\n")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", prog, err)
+	}
+	res := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(res) != len(names) {
+		return nil, fmt.Errorf("%s: got %d names back, want %d", prog, len(res), len(names))
+	}
+	for i := range res {
+		if res[i] == in[i] {
+			res[i] = names[i]
+		}
+	}
+	return res, nil
+}
+
+// demangleMSVC demangles the qualified name of MSVC mangled name
+// sname: "?Bar@Foo@@QEAAXXZ" is "Foo::Bar", with constructors
+// ("??0Foo@@...") and destructors ("??1Foo@@...") as "Foo::Foo" and
+// "Foo::~Foo". Back-references to earlier names are followed; other
+// special names and templates aren't handled.
+func demangleMSVC(sname string) (string, bool) {
+	rest, ok := strings.CutPrefix(sname, "?")
+	if !ok {
+		return "", false
+	}
+	special := ""
+	if strings.HasPrefix(rest, "?") {
+		if len(rest) < 2 || (rest[1] != '0' && rest[1] != '1') {
+			return "", false
+		}
+		special, rest = rest[:2], rest[2:]
+	}
+	var names []string
+	for !strings.HasPrefix(rest, "@") {
+		switch {
+		case rest == "":
+			return "", false
+		case rest[0] >= '0' && rest[0] <= '9':
+			i := int(rest[0] - '0')
+			if i >= len(names) {
+				return "", false
+			}
+			names = append(names, names[i])
+			rest = rest[1:]
+		case rest[0] == '?':
+			// template or nested special name
+			return "", false
+		default:
+			i := strings.IndexByte(rest, '@')
+			if i <= 0 {
+				return "", false
+			}
+			names = append(names, rest[:i])
+			rest = rest[i+1:]
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	switch special {
+	case "?0":
+		names = append([]string{names[0]}, names...)
+	case "?1":
+		names = append([]string{"~" + names[0]}, names...)
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, "::"), true
+}
+
+// demangledTag returns the demangled form of sname as shown after it,
+// or "" if there is none or -demangle isn't given.
+func demangledTag(sname string) string {
+	if demangled == nil {
+		return ""
+	}
+	x, _ := cutImp(sname)
+	dm, ok := demangled[x]
+	if !ok {
+		return ""
+	}
+	return " (" + impPrefix(sname) + dm + ")"
+}
@@ -0,0 +1,365 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Support for the linker directives in the .drectve section of each
+// object: /DEFAULTLIB:msvcrt, /EXPORT:Foo, /ALTERNATENAME:a=b and the
+// like (or -export:Foo, as GNU tools write them), which go a long way
+// towards deciding how imports resolve. The section holds the
+// directives as a string, separated by spaces, with arguments quoted
+// where they have spaces in them. It is usually plain ASCII, but may
+// be UTF-8 with a byte order mark, or (rarely) UTF-16.
+//
+// The built-in reader reads the section directly. With objdump, its
+// contents are dumped separately ("objdump -s --section=.drectve"),
+// for objects that have the section, and with dumpbin, its
+// /DIRECTIVES are. Offline dumps may have either of these appended.
+// The directives are listed for each object under "Linker
+// directives". With -libpath, the import libraries named by
+// /DEFAULTLIB directives are looked for in the given directories and
+// read as with -implib.
+
+// drectveSection is the name of the section holding linker directives.
+const drectveSection = ".drectve"
+
+// directive is a linker directive: /NAME:arg. The name is upper-cased;
+// a directive with no argument has arg "". Anything that isn't a
+// directive (with no leading / or -) has only arg.
+type directive struct {
+	name string
+	arg  string
+}
+
+func (d directive) String() string {
+	arg := d.arg
+	if strings.ContainsAny(arg, " \t") {
+		arg = `"` + arg + `"`
+	}
+	switch {
+	case d.name == "":
+		return arg
+	case d.arg == "":
+		return "/" + d.name
+	}
+	return "/" + d.name + ":" + arg
+}
+
+// Byte order marks the directives may start with.
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+)
+
+// decodeDirectives returns the contents data of a .drectve section as
+// a string, decoding UTF-16 and dropping any byte order mark and
+// trailing NULs.
+func decodeDirectives(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		data = data[len(utf8BOM):]
+	case bytes.HasPrefix(data, utf16LEBOM):
+		data = data[len(utf16LEBOM):]
+		u := make([]uint16, len(data)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	}
+	return strings.TrimRight(string(data), "\x00")
+}
+
+// splitDirectives splits the directive string str into words at
+// spaces outside double quotes, dropping the quotes.
+func splitDirectives(str string) []string {
+	var words []string
+	var word strings.Builder
+	inword, quoted := false, false
+	for _, r := range str {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inword = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == 0):
+			if inword {
+				words = append(words, word.String())
+				word.Reset()
+				inword = false
+			}
+		default:
+			word.WriteRune(r)
+			inword = true
+		}
+	}
+	if inword {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseDirectives returns the directives in the contents data of a
+// .drectve section.
+func parseDirectives(data []byte) []directive {
+	var res []directive
+	for _, w := range splitDirectives(decodeDirectives(data)) {
+		if w == "" || (w[0] != '/' && w[0] != '-') {
+			res = append(res, directive{arg: w})
+			continue
+		}
+		name, arg, _ := strings.Cut(w[1:], ":")
+		res = append(res, directive{name: strings.ToUpper(name), arg: arg})
+	}
+	return res
+}
+
+// addDirectives records the directives in the contents data of the
+// .drectve section of the current object.
+func (s *state) addDirectives(data []byte) {
+	if ds := parseDirectives(data); len(ds) != 0 {
+		s.directives[s.objidx] = append(s.directives[s.objidx], ds...)
+	}
+}
+
+// readContents reads the hex dump of a section's contents, as from
+// "objdump -s", whose header is hline ("Contents of section
+// .drectve:"). If it is the .drectve section, the directives in it are
+// recorded. The line that ends the dump, if not blank, is returned.
+func (s *state) readContents(hline string) (string, error) {
+	sname := strings.TrimSuffix(strings.TrimPrefix(hline, "Contents of section "), ":")
+	var data []byte
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			if sname == drectveSection {
+				s.addDirectives(data)
+			}
+			return line, nil
+		}
+		b, err := parseContentsLine(line)
+		if err != nil {
+			return "", err
+		}
+		data = append(data, b...)
+	}
+	if sname == drectveSection {
+		s.addDirectives(data)
+	}
+	return "", s.scanErr()
+}
+
+// parseContentsLine returns the bytes in a line of an "objdump -s"
+// hex dump: an offset, up to four groups of hex digits, then (after
+// two or more spaces) the bytes as text.
+func parseContentsLine(line string) ([]byte, error) {
+	_, rest, ok := strings.Cut(strings.TrimLeft(line, " "), " ")
+	if !ok {
+		return nil, fmt.Errorf("bad line %s in section contents", line)
+	}
+	if i := strings.Index(rest, "  "); i != -1 {
+		rest = rest[:i]
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(rest, " ", ""))
+	if err != nil {
+		return nil, fmt.Errorf("bad line %s in section contents", line)
+	}
+	return b, nil
+}
+
+// readDirectivesDumpbin reads the "Linker Directives" listing from
+// dumpbin /DIRECTIVES, one directive (or several) to a line after a
+// line of dashes, ended by a blank line.
+func (s *state) readDirectivesDumpbin() error {
+	var sb strings.Builder
+	for s.scanner.Scan() {
+		line := strings.TrimSpace(s.scanner.Text())
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "---") {
+			continue
+		}
+		sb.WriteString(line + " ")
+	}
+	s.addDirectives([]byte(sb.String()))
+	return s.scanErr()
+}
+
+// digestDirectives reads the directives from r, the output of
+// Directives from a directivesDumper.
+func (s *state) digestDirectives(r io.Reader) error {
+	s.scanner = s.newScanner(r)
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		for line != "" {
+			next := ""
+			var err error
+			switch {
+			case strings.HasPrefix(line, "Contents of section "):
+				next, err = s.readContents(line)
+			case strings.TrimSpace(line) == "Linker Directives":
+				err = s.readDirectivesDumpbin()
+			}
+			if err != nil {
+				return err
+			}
+			line = next
+		}
+	}
+	return s.scanErr()
+}
+
+// directivesDumper is implemented by Dumpers that can dump the
+// .drectve section of an object.
+type directivesDumper interface {
+	// Directives returns a dump of the linker directives of file,
+	// either as the hex dump of the section ("Contents of section
+	// .drectve:") or as listed by dumpbin ("Linker Directives").
+	Directives(file string) (io.Reader, error)
+}
+
+// errNoDirectives is returned by Directives when the dumper can't
+// dump the directives.
+var errNoDirectives = errors.New("can't dump linker directives")
+
+func (od *objdumpDumper) Directives(file string) (io.Reader, error) {
+	switch od.flavor {
+	case flavorGNU:
+		return od.run(file, "-s", "-j", drectveSection)
+	case flavorDumpbin:
+		return od.run(file, "/DIRECTIVES")
+	case flavorReadobj:
+		// Use the llvm-objdump alongside, as for disassembly.
+		if _, err := exec.LookPath(od.disasmArgv[0]); err != nil {
+			return nil, errNoDirectives
+		}
+		d := &objdumpDumper{name: disasmName(od), argv: od.disasmArgv, flavor: flavorLLVM}
+		return d.Directives(file)
+	}
+	return od.run(file, "-s", "--section="+drectveSection)
+}
+
+// hasDirectives reports whether object infile may have a .drectve
+// section: false only if it can be read and has none.
+func hasDirectives(infile string) bool {
+	f, _, err := openCOFF(infile)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	return f.Section(drectveSection) != nil
+}
+
+// readDirectives reads the linker directives of object infile with
+// backend b's dumper, if it can dump them.
+func (s *state) readDirectives(infile string, b *backend) error {
+	dd, ok := b.dumper.(directivesDumper)
+	if !ok || !hasDirectives(infile) {
+		return nil
+	}
+	r, err := s.openDump(infile, b.flavor+" directives", func() (io.Reader, error) {
+		return dd.Directives(infile)
+	})
+	if err == errNoDirectives {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return readStream(r, nil, s.digestDirectives)
+}
+
+// defaultLibs returns the libraries named by /DEFAULTLIB directives,
+// sorted, without duplicates.
+func (s *state) defaultLibs() []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, ds := range s.directives {
+		for _, d := range ds {
+			if d.name == "DEFAULTLIB" && d.arg != "" && !seen[d.arg] {
+				seen[d.arg] = true
+				res = append(res, d.arg)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// findLib returns the path of library lib (given as in a /DEFAULTLIB
+// directive, with ".lib" implied) in the -libpath directories, or ""
+// if it isn't there.
+func findLib(lib string) string {
+	if filepath.Ext(lib) == "" {
+		lib += ".lib"
+	}
+	for _, dir := range strings.Split(*libpathflag, ",") {
+		for _, name := range []string{lib, strings.ToLower(lib)} {
+			p := filepath.Join(dir, name)
+			if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// readDefaultLibs reads the import libraries named by /DEFAULTLIB
+// directives that can be found in the -libpath directories, adding
+// their symbols to the DLL map (without overriding -implib). Libraries
+// that aren't import libraries (static libraries, with nothing but
+// objects) add nothing.
+func (s *state) readDefaultLibs() error {
+	for _, lib := range s.defaultLibs() {
+		p := findLib(lib)
+		if p == "" {
+			verb(1, "default library %s not found in -libpath", lib)
+			continue
+		}
+		m, err := readImportLib(p)
+		if err != nil {
+			return fmt.Errorf("reading default library %s: %v", p, err)
+		}
+		for sym, dll := range m {
+			if _, ok := s.dllmap[sym]; !ok {
+				s.dllmap[sym] = dll
+			}
+		}
+		s.implibs = append(s.implibs, p)
+	}
+	return nil
+}
+
+// writeDirectives writes the linker directives of each object in s,
+// if any, to w.
+func (s *state) writeDirectives(w io.Writer) {
+	if len(s.directives) == 0 {
+		return
+	}
+	oidxs := make([]int, 0, len(s.directives))
+	for k := range s.directives {
+		oidxs = append(oidxs, k)
+	}
+	sort.Ints(oidxs)
+	fmt.Fprintf(w, "Linker directives:\n")
+	for _, oidx := range oidxs {
+		for _, d := range s.directives[oidx] {
+			fmt.Fprintf(w, " O%d: %s\n", oidx, d)
+		}
+	}
+}
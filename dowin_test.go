@@ -1,17 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf16"
 )
 
-func TestBasic(t *testing.T) {
-	tdir := t.TempDir()
+var updateflag = flag.Bool("update", false, "Update golden files")
 
-	// Do a build of . into <tmpdir>/out.exe
+// buildTool does a build of . into <tmpdir>/out.exe, returning the
+// path of the executable.
+func buildTool(t *testing.T) string {
+	tdir := t.TempDir()
 	exe := filepath.Join(tdir, "out.exe")
 	gotoolpath := filepath.Join(runtime.GOROOT(), "bin", "go")
 	cmd := exec.Command(gotoolpath, "build", "-o", exe, ".")
@@ -20,17 +39,67 @@ func TestBasic(t *testing.T) {
 		t.Logf("build: %s\n", b)
 		t.Fatalf("build error: %v", err)
 	}
+	return exe
+}
 
-	// do a default dump run, if that doesn't succeed no point in doing more.
-	op := filepath.Join("testdata", "sample.o")
-	cmd = exec.Command(DefaultDumper, "-t", op)
+// checkDumper does a default dump run on the specified object; if that
+// doesn't succeed no point in doing more.
+func checkDumper(t *testing.T, op string) {
+	cmd := exec.Command(DefaultDumper, "-t", op)
 	t.Logf("cmd: %+v\n", cmd)
 	if _, err := cmd.CombinedOutput(); err != nil {
 		t.Skipf("objdump -t run failed")
 	}
+}
+
+// checkGolden compares the portion of output starting with the first
+// line beginning with 'from' against the golden file gf.
+func checkGolden(t *testing.T, output, from, gf string) {
+	if idx := strings.Index(output, from); idx != -1 {
+		output = output[idx:]
+	}
+	if *updateflag {
+		if err := os.WriteFile(gf, []byte(output), 0666); err != nil {
+			t.Fatalf("writing %s: %v", gf, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(gf)
+	if err != nil {
+		t.Fatalf("reading %s: %v", gf, err)
+	}
+	if output != string(want) {
+		t.Errorf("output does not match %s:\ngot:\n%s\nwant:\n%s",
+			gf, output, want)
+	}
+}
+
+// spacesRE matches the padding between columns of the report.
+var spacesRE = regexp.MustCompile(`  +`)
+
+// containsReport reports whether the report output contains want,
+// ignoring the padding used to line up columns: a run of spaces in
+// either counts as a single space.
+func containsReport(output, want string) bool {
+	return strings.Contains(spacesRE.ReplaceAllString(output, " "), spacesRE.ReplaceAllString(want, " "))
+}
+
+// relocsAt returns relocations at the specified offsets.
+func relocsAt(offs ...int) []relocinfo {
+	var res []relocinfo
+	for _, off := range offs {
+		res = append(res, relocinfo{off: off})
+	}
+	return res
+}
+
+func TestBasic(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
 
 	// Now run executable on test obj.
-	cmd = exec.Command(exe, "-i="+op, "-watch=foo,bar,_errno")
+	cmd := exec.Command(exe, "-i="+op, "-watch=foo,bar,_errno")
 	t.Logf("cmd: %+v\n", cmd)
 	var output string
 	if b, err := cmd.CombinedOutput(); err != nil {
@@ -68,3 +137,4596 @@ func TestBasic(t *testing.T) {
 		t.Errorf("drb[last] got %s want %s", cpl, wantlast)
 	}
 }
+
+func TestExcerptSource(t *testing.T) {
+	exe := buildTool(t)
+
+	tests := []struct {
+		obj    string
+		watch  string
+		golden string
+	}{
+		// Debug info with source available: interleaved excerpts.
+		{"srcdebug.o", "foo,bar", "srcdebug.excerpts.golden"},
+		// Sources can't be found: falls back to -ldr excerpts.
+		{"sample.o", "_errno", "sample.excerpts.golden"},
+	}
+	for _, tc := range tests {
+		op := filepath.Join("testdata", tc.obj)
+		checkDumper(t, op)
+		cmd := exec.Command(exe, "-format-version=2", "-i="+op, "-watch="+tc.watch,
+			"-excerpt-source")
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("run error: %v", err)
+		}
+		checkGolden(t, string(b), "\nexcerpts from",
+			filepath.Join("testdata", tc.golden))
+	}
+}
+
+func TestReadInputList(t *testing.T) {
+	in := "# objects\na.o b.o\n\n  c.o\t\n# d.o\ne.o\n"
+	got, err := splitPathList("", []byte(in), listFields)
+	if err != nil {
+		t.Fatalf("splitPathList: %v", err)
+	}
+	want := []string{"a.o", "b.o", "c.o", "e.o"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	// Very long lists (and lines) shouldn't be a problem.
+	long := strings.Repeat("obj/some/longish/path/to/x.o ", 50000)
+	got, err = splitPathList("", []byte(long), listFields)
+	if err != nil {
+		t.Fatalf("splitPathList: %v", err)
+	}
+	if len(got) != 50000 {
+		t.Errorf("got %d entries want %d", len(got), 50000)
+	}
+}
+
+func TestResponseFile(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+
+	rsp := filepath.Join(t.TempDir(), "objs.rsp")
+	content := "# objects\n\n" + filepath.Join("testdata", "srcdebug.o") + "\n"
+	if err := os.WriteFile(rsp, []byte(content), 0666); err != nil {
+		t.Fatalf("writing %s: %v", rsp, err)
+	}
+	cmd := exec.Command(exe, "-i="+op+",@"+rsp)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	want := " O1: testdata/srcdebug.o"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+
+	// A missing response file should be an error that names the file.
+	missing := filepath.Join(t.TempDir(), "missing.rsp")
+	cmd = exec.Command(exe, "-i=@"+missing)
+	b, err = cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected error for missing response file")
+	}
+	if !strings.Contains(string(b), missing) {
+		t.Errorf("error %q does not name %s", b, missing)
+	}
+}
+
+func TestExpandGlobs(t *testing.T) {
+	tdir := t.TempDir()
+	for _, f := range []string{"a.o", "b.o", "x.txt", "sub/c.o", "sub/deeper/d.o"} {
+		p := filepath.Join(tdir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j := func(f string) string { return filepath.Join(tdir, f) }
+
+	tests := []struct {
+		pat  string
+		want []string
+	}{
+		{"*.o", []string{"a.o", "b.o"}},
+		{"**/*.o", []string{"a.o", "b.o", "sub/c.o", "sub/deeper/d.o"}},
+		{"sub/**/*.o", []string{"sub/c.o", "sub/deeper/d.o"}},
+	}
+	for _, tc := range tests {
+		got, err := expandGlobs([]string{"first.o", j(tc.pat)})
+		if err != nil {
+			t.Fatalf("expandGlobs(%s): %v", tc.pat, err)
+		}
+		want := []string{"first.o"}
+		for _, w := range tc.want {
+			want = append(want, j(w))
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("expandGlobs(%s) got %v want %v", tc.pat, got, want)
+		}
+	}
+
+	// No matches is an error.
+	if _, err := expandGlobs([]string{j("**/*.obj")}); err == nil {
+		t.Errorf("expected error for pattern with no matches")
+	}
+}
+
+func TestFindObjects(t *testing.T) {
+	tdir := t.TempDir()
+	for _, f := range []string{"b.o", "a.obj", "x.txt", "sub/c.syso", "sub/d.o"} {
+		p := filepath.Join(tdir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// symlink cycle back to the root
+	if err := os.Symlink(tdir, filepath.Join(tdir, "sub", "loop")); err != nil {
+		t.Skipf("can't create symlink: %v", err)
+	}
+	j := func(fs ...string) string {
+		var res []string
+		for _, f := range fs {
+			res = append(res, filepath.Join(tdir, f))
+		}
+		return strings.Join(res, ",")
+	}
+
+	got, err := findObjects(tdir, nil)
+	if err != nil {
+		t.Fatalf("findObjects: %v", err)
+	}
+	if want := j("a.obj", "b.o", "sub/c.syso", "sub/d.o"); strings.Join(got, ",") != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	got, err = findObjects(tdir, regexp.MustCompile(`sub/`))
+	if err != nil {
+		t.Fatalf("findObjects: %v", err)
+	}
+	if want := j("sub/c.syso", "sub/d.o"); strings.Join(got, ",") != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	// dups.a contains two copies of srcdebug.o.
+	ap := filepath.Join("testdata", "dups.a")
+	cmd := exec.Command(exe, "-format-version=2", "-i="+ap, "-watch=foo")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: testdata/dups.a(srcdebug.o)",
+		" O1: testdata/dups.a(srcdebug.o#2)",
+		"excerpts from 'llvm-objdump-14 -ldr testdata/dups.a(srcdebug.o#2)`",
+		"=-= ref O1 testdata/dups.a(srcdebug.o#2) off=0x7:",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+// arhdr returns an archive member header for the given name and size.
+func arhdr(name string, size int) string {
+	return fmt.Sprintf("%-16s%-12s%-6s%-6s%-8s%-10d`\n",
+		name, "0", "0", "0", "644", size)
+}
+
+func TestThinArchive(t *testing.T) {
+	// Thin archive with a long member name and a short one.
+	long := "some/longer/path/member.o"
+	names := long + "/\n"
+	if len(names)%2 == 1 {
+		names += "\n"
+	}
+	ar := thinmag + arhdr("//", len(names)) + names +
+		arhdr("/0", 1234) + arhdr("short.o/", 5678)
+	ap := filepath.Join(t.TempDir(), "thin.a")
+	if err := os.WriteFile(ap, []byte(ar), 0666); err != nil {
+		t.Fatal(err)
+	}
+	members, err := readArchive(ap)
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("got %d members want 2", len(members))
+	}
+	for i, want := range []string{long, "short.o"} {
+		m := members[i]
+		if m.name != want {
+			t.Errorf("member %d name got %q want %q", i, m.name, want)
+		}
+		if wp := filepath.Join(filepath.Dir(ap), want); m.path != wp {
+			t.Errorf("member %d path got %q want %q", i, m.path, wp)
+		}
+	}
+}
+
+func TestReadInputFile(t *testing.T) {
+	tdir := t.TempDir()
+	for _, f := range []string{"a.o", "b.o"} {
+		if err := os.WriteFile(filepath.Join(tdir, f), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("WORK", tdir)
+	lf := filepath.Join(tdir, "objects.txt")
+	content := "$WORK/a.o \r\n\r\n${WORK}/b.o\r\n"
+	if err := os.WriteFile(lf, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readPathList(lf, listEnvLines)
+	if err != nil {
+		t.Fatalf("readPathList: %v", err)
+	}
+	want := filepath.Join(tdir, "a.o") + "," + filepath.Join(tdir, "b.o")
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	// Nonexistent objects are reported with the line number.
+	content += "$WORK/c.o\n"
+	if err := os.WriteFile(lf, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readPathList(lf, listEnvLines)
+	if err == nil || !strings.Contains(err.Error(), lf+":4:") {
+		t.Errorf("got error %v, wanted error mentioning %s:4", err, lf)
+	}
+
+	// The tool names the list file once, along with the line number.
+	exe := buildTool(t)
+	cmd := exec.Command(exe, "-ifile="+lf)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("-ifile with a missing object succeeded")
+	}
+	if out := string(b); !strings.Contains(out, lf+":4:") || strings.Count(out, lf) != 1 {
+		t.Errorf("got:\n%s\nwanted %s named once, with line 4", out, lf)
+	}
+}
+
+func TestImage(t *testing.T) {
+	exe := buildTool(t)
+	img := filepath.Join(runtime.GOROOT(), "src", "debug", "pe", "testdata", "gcc-amd64-mingw-exec")
+	if _, err := os.Stat(img); err != nil {
+		t.Skipf("no test image: %v", err)
+	}
+	cmd := exec.Command(exe, "-i="+img)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"Image imports:\n",
+		" O0: \"GetTickCount\" KERNEL32.dll\n",
+		" O0: \"_errno\" msvcrt.dll\n",
+		" \"GetTickCount\":  refimp [KERNEL32.dll]\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+
+	// A header may claim more than the usual 16 data directories.
+	// Add a 17th, moving the section table into the padding after it.
+	data, err := os.ReadFile(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peoff := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	nsect := int(binary.LittleEndian.Uint16(data[peoff+6:]))
+	optsz := int(binary.LittleEndian.Uint16(data[peoff+20:]))
+	sectab := peoff + 24 + optsz
+	end := sectab + nsect*40
+	if !bytes.Equal(data[end:end+8], make([]byte, 8)) {
+		t.Fatalf("no room after the section table")
+	}
+	copy(data[sectab+8:], data[sectab:end])
+	copy(data[sectab:sectab+8], make([]byte, 8))
+	binary.LittleEndian.PutUint16(data[peoff+20:], uint16(optsz+8))
+	// NumberOfRvaAndSizes, in a PE32+ optional header
+	binary.LittleEndian.PutUint32(data[peoff+24+108:], 17)
+	big := filepath.Join(t.TempDir(), "big.exe")
+	if err := os.WriteFile(big, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	imps, err := readImageImports(big)
+	if err != nil {
+		t.Fatalf("readImageImports: %v", err)
+	}
+	if len(imps) == 0 {
+		t.Errorf("no imports read with 17 data directories")
+	}
+}
+
+func TestParseWorkDir(t *testing.T) {
+	out := "WORK=/tmp/go-build123\r\nmkdir -p $WORK/b001/\ncd /src\n"
+	if got, want := parseWorkDir([]byte(out)), "/tmp/go-build123"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got := parseWorkDir([]byte("no work here\n")); got != "" {
+		t.Errorf("got %q want empty", got)
+	}
+}
+
+// goAsm is assembled into a Go object for tests.
+const goAsm = `#include "textflag.h"
+
+TEXT ·callimp(SB),NOSPLIT,$0
+	CALL	__imp_foo(SB)
+	CALL	bar(SB)
+	RET
+`
+
+// buildGoObject assembles goAsm into a Go object for windows/amd64,
+// returning its path.
+func buildGoObject(t *testing.T) string {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "x.s")
+	if err := os.WriteFile(src, []byte(goAsm), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		t.Skipf("go env GOROOT failed: %v", err)
+	}
+	obj := filepath.Join(dir, "x.o")
+	cmd := exec.Command("go", "tool", "asm", "-p", "main",
+		"-I", filepath.Join(strings.TrimSpace(string(out)), "pkg", "include"), "-o", obj, src)
+	cmd.Env = append(os.Environ(), "GOOS=windows", "GOARCH=amd64")
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("go tool asm failed: %v\n%s", err, b)
+	}
+	return obj
+}
+
+func TestGoArchive(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	goobj, err := os.ReadFile(buildGoObject(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock up a Go package archive: package definition, a Go object,
+	// and a cgo host object.
+	obj, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar := armag
+	for _, m := range []struct {
+		name string
+		data string
+	}{
+		{"__.PKGDEF", "go object windows amd64 go1.20\n"},
+		{"_go_.o", string(goobj)},
+		{"_x001.o", string(obj)},
+	} {
+		ar += arhdr(m.name+"/", len(m.data)) + m.data
+		if len(m.data)%2 == 1 {
+			ar += "\n"
+		}
+	}
+	ap := filepath.Join(t.TempDir(), "pkg.a")
+	if err := os.WriteFile(ap, []byte(ar), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(exe, "-format-version=2", "-v=1", "-watch=bar", "-i="+ap)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"skipped 1 non-COFF members: __.PKGDEF\n",
+		"Machine: amd64\n",
+		" O0: " + ap + "(_go_.o) [Go object] \n",
+		" O1: " + ap + "(_x001.o)",
+		// No offsets for Go objects.
+		" \"bar\":\n   0: O=0 S=UNDEF [] " + ap + "(_go_.o)\n   1: O=1 S=.text [0x24/REL32] " + ap + "(_x001.o)\n",
+		" \"bar\":  refbase refimp",
+		" \"foo\":  refimp",
+		// Excerpts come from the COFF object only.
+		"=-= ref O1 " + ap + "(_x001.o) off=0x24:",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestParseNmLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want nmsym
+		ok   bool
+	}{
+		{"     46b T main.callimp", nmsym{0x46b, 'T', "main.callimp"}, true},
+		{"         U __imp_foo", nmsym{0, 'U', "__imp_foo"}, true},
+		{"       b D type:func(int, string)", nmsym{0xb, 'D', "type:func(int, string)"}, true},
+		{"    1000 R", nmsym{}, false},
+		{"    zz T foo", nmsym{}, false},
+	} {
+		got, err := parseNmLine(tc.line)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseNmLine(%q) = %+v, %v, want %+v, ok=%v", tc.line, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestFromDump(t *testing.T) {
+	// No dumper needed here, we're working from captured output.
+	exe := buildTool(t)
+	dump := filepath.Join("testdata", "srcdebug.dump.txt")
+	disasm := filepath.Join("testdata", "srcdebug.disasm.txt")
+	cmd := exec.Command(exe, "-from-dump="+dump, "-from-disasm="+disasm,
+		"-watch=foo,bar")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: " + dump,
+		" \"__imp_bar\":\n   0: O=0 S=0 [0x1d]\n",
+		"Def/ref breakdown:\n \"bar\":  refbase refimp\n \"foo\":  refimp\n",
+		"excerpts from " + disasm + " for " + dump + "\n",
+		"=-= ref O0 off=0x24:\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+
+	// Dropping a dump drops its disassembly too.
+	tdir := t.TempDir()
+	var dumps, disasms []string
+	for _, n := range []string{"a", "b"} {
+		for _, f := range []struct {
+			src, dst string
+			list     *[]string
+		}{{dump, n + ".dump.txt", &dumps}, {disasm, n + ".dis.txt", &disasms}} {
+			content, err := os.ReadFile(f.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(tdir, f.dst)
+			if err := os.WriteFile(dst, content, 0666); err != nil {
+				t.Fatal(err)
+			}
+			*f.list = append(*f.list, dst)
+		}
+	}
+	cmd = exec.Command(exe, "-from-dump="+strings.Join(dumps, ","),
+		"-from-disasm="+strings.Join(disasms, ","), "-objexclude=a.dump", "-watch=foo")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	if want := "excerpts from " + disasms[1] + " for " + dumps[1] + "\n"; !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	cp := filepath.Join(t.TempDir(), "copy.o")
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cp, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	same := filepath.Join("testdata", "..", "testdata", "srcdebug.o")
+	cmd := exec.Command(exe, "-i="+op+","+same+","+cp, "-dedup-content")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"Collapsed inputs:\n",
+		" " + same + " => O0 " + op + " (same path)\n",
+		" " + cp + " => O0 " + op + " (same content)\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), " O1: ") {
+		t.Errorf("duplicate inputs not collapsed:\n%s", b)
+	}
+}
+
+func TestRepeatedInputs(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	sp := filepath.Join("testdata", "sample.o")
+	ap := filepath.Join("testdata", "dups.a")
+	cmd := exec.Command(exe, "-format-version=2", "-i="+sp, "-i", ap, "-watch=foo", op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	want := "Objects:\n" +
+		" O0: " + sp + " test.cgo2.c\n" +
+		" O1: " + ap + "(srcdebug.o) \n" +
+		" O2: " + ap + "(srcdebug.o#2) \n" +
+		" O3: " + op + " \n"
+	if !containsReport(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+}
+
+func TestObjMatch(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	// Provenance from a sidecar file counts as a match too.
+	tdir := t.TempDir()
+	cp := filepath.Join(tdir, "x.o")
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cp, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := filepath.Join(tdir, "x.txt")
+	if err := os.WriteFile(sidecar, []byte("pn: runtime/cgo\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	sp := filepath.Join("testdata", "sample.o")
+	cmd := exec.Command(exe, "-v=1", "-objmatch=srcdebug|runtime/cgo", sp, op, cp)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"skipping " + sp + ": does not match -objmatch",
+		" O0: " + op + " \n",
+		" O1: " + cp + " runtime/cgo\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+
+	// Filtering out everything is an error.
+	cmd = exec.Command(exe, "-objmatch=nomatch", sp)
+	if b, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("expected error, got output:\n%s", b)
+	}
+
+	// Exclusions apply after -objmatch, and also consider provenance.
+	cmd = exec.Command(exe, "-v=1", "-objmatch=srcdebug|runtime/cgo",
+		"-objexclude=cgo", sp, op, cp)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"skipping " + cp + ": matches -objexclude \"cgo\"",
+		"excluded 1 of 2 inputs with -objexclude",
+		"Objects:\n O0: " + op + " \nSections:",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestImportLib(t *testing.T) {
+	// ucrt.lib is a short-form import library made by llvm-dlltool,
+	// covering _errno and __acrt_iob_func.
+	m, err := readImportLib(filepath.Join("testdata", "ucrt.lib"))
+	if err != nil {
+		t.Fatalf("readImportLib: %v", err)
+	}
+	for _, sym := range []string{"_errno", "__acrt_iob_func"} {
+		if got, want := m[sym], "ucrtbase.dll"; got != want {
+			t.Errorf("dll for %s got %q want %q", sym, got, want)
+		}
+	}
+
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+	cmd := exec.Command(exe, "-implib="+filepath.Join("testdata", "ucrt.lib"),
+		"-i="+op+","+filepath.Join("testdata", "srcdebug.o"))
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" \"_errno\":  refimp [ucrtbase.dll]\n",
+		" \"foo\":  refimp [not in import libs]\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+
+	// Given as an input, an import library's short import objects
+	// are skipped, and the rest of its members analyzed.
+	cmd = exec.Command(exe, "-v=2", "-no-excerpts", "-i="+filepath.Join("testdata", "ucrt.lib")+","+op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"skipping member ucrtbase.dll: short import object\n",
+		" O2: testdata/ucrt.lib(ucrtbase.dll#3) \n",
+		" \"_errno\":  refimp\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestByDLL(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+	for _, tc := range []struct {
+		version string
+		want    string
+	}{
+		{"1", "Def/ref breakdown:\n ucrtbase.dll:\n" +
+			"  \"__acrt_iob_func\":  refimp\n  \"_errno\":  refimp\n" +
+			" unknown:\n  \"bar\":  refbase refimp\n  \"foo\":  refimp\n"},
+		// The summary is shown from version 2 on.
+		{"2", " references: 12\n dll ucrtbase.dll: 2\n dll unknown: 2\n"},
+	} {
+		cmd := exec.Command(exe, "-format-version="+tc.version, "-by-dll", "-no-excerpts",
+			"-implib="+filepath.Join("testdata", "ucrt.lib"),
+			op, filepath.Join("testdata", "srcdebug.o"))
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		if !strings.Contains(string(b), tc.want) {
+			t.Errorf("output missing %q:\n%s", tc.want, b)
+		}
+	}
+}
+
+func TestEmitDef(t *testing.T) {
+	s := newState([]string{"a.o"}, []string{"a.o"})
+	s.defref = map[string]defrefmask{
+		"CreateFileA": refimp,
+		"ReadFile":    refimp,
+		"_errno":      refimp,
+		"helper":      defbase | refimp,
+		"frobnicate":  refimp,
+		"callfoo":     defbase,
+	}
+	path := filepath.Join(t.TempDir(), "x.def")
+	if err := s.writeDef(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "; imports referenced by 1 objects, written by winimpsym\n" +
+		"CreateFileA\nReadFile\n_errno\nfrobnicate\n; helper: defined locally (defbase)\n"
+	if string(b) != want {
+		t.Errorf("unmapped: got:\n%s\nwant:\n%s", b, want)
+	}
+
+	s.dllmap = map[string]string{"CreateFileA": "KERNEL32.DLL", "ReadFile": "KERNEL32.DLL",
+		"_errno": "ucrtbase.dll", "helper": "ucrtbase.dll"}
+	if err := s.writeDef(path); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	want = "; imports referenced by 1 objects, written by winimpsym\n" +
+		"IMPORTS\n" +
+		"; KERNEL32.DLL\n  KERNEL32.CreateFileA\n  KERNEL32.ReadFile\n" +
+		"; ucrtbase.dll\n  ucrtbase._errno\n; helper: defined locally (defbase)\n" +
+		"; unknown DLL\n  frobnicate\n"
+	if string(b) != want {
+		t.Errorf("mapped: got:\n%s\nwant:\n%s", b, want)
+	}
+}
+
+func TestEmitInclude(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "i386.o")
+	checkDumper(t, op)
+	path := filepath.Join(t.TempDir(), "include.txt")
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"link", "/INCLUDE:__imp_@fastimp@4\n/INCLUDE:__imp__CreateFileA@4\n/INCLUDE:__imp__printf\n"},
+		{"pragma", "#pragma comment(linker, \"/INCLUDE:__imp_@fastimp@4\")\n" +
+			"#pragma comment(linker, \"/INCLUDE:__imp__CreateFileA@4\")\n" +
+			"#pragma comment(linker, \"/INCLUDE:__imp__printf\")\n"},
+	}
+	for _, tc := range tests {
+		cmd := exec.Command(exe, "-emit-include="+path, "-emit-include-format="+tc.format, op)
+		t.Logf("cmd: %+v\n", cmd)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tc.format, b, tc.want)
+		}
+	}
+
+	// With everything defined, the file is empty.
+	s := newState([]string{"a.o"}, []string{"a.o"})
+	s.refs["__imp_foo"] = reflist{{objidx: 0}}
+	s.defref["foo"] = defimp | refimp
+	if err := s.writeInclude(path, includeLink); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || len(b) != 0 {
+		t.Errorf("all defined: got %q, %v", b, err)
+	}
+
+	cmd := exec.Command(exe, "-emit-include-format=bogus", op)
+	if b, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(b), "bad -emit-include-format") {
+		t.Errorf("bad format: got %v:\n%s", err, b)
+	}
+}
+
+func TestSARIF(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	path := filepath.Join(t.TempDir(), "out.sarif")
+	cmd := exec.Command(exe, "-sarif="+path, "-sarif-rules=unresolved-import=note,duplicate-imp-def", op)
+	t.Logf("cmd: %+v\n", cmd)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check what the SARIF 2.1.0 schema requires of the log.
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string `json:"name"`
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				RuleIndex int    `json:"ruleIndex"`
+				Level     string `json:"level"`
+				Message   struct {
+					Text string `json:"text"`
+				} `json:"message"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(b, &log); err != nil {
+		t.Fatalf("bad JSON: %v\n%s", err, b)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || log.Runs[0].Tool.Driver.Name != "winimpsym" {
+		t.Fatalf("bad log:\n%s", b)
+	}
+	run := log.Runs[0]
+	if got := len(run.Tool.Driver.Rules); got != 2 {
+		t.Errorf("got %d rules, want 2", got)
+	}
+	var got []string
+	for _, r := range run.Results {
+		if r.RuleIndex >= len(run.Tool.Driver.Rules) || run.Tool.Driver.Rules[r.RuleIndex].ID != r.RuleID {
+			t.Errorf("result %+v: ruleIndex doesn't match ruleId", r)
+		}
+		if len(r.Locations) != 1 {
+			t.Fatalf("result %+v: want one location", r)
+		}
+		got = append(got, fmt.Sprintf("%s %s %s: %s", r.RuleID, r.Level,
+			r.Locations[0].PhysicalLocation.ArtifactLocation.URI, r.Message.Text))
+	}
+	want := []string{
+		"winimpsym/unresolved-import note testdata/srcdebug.o: __imp_bar is referred to, but no object defines it",
+		"winimpsym/unresolved-import note testdata/srcdebug.o: __imp_foo is referred to, but no object defines it",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The other kinds of findings.
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.defs["helper"] = []definfo{{objidx: 0, secidx: 1}}
+	s.refs["__imp_helper"] = reflist{{objidx: 1, relocs: []relocinfo{{off: 4}}}}
+	s.defref["helper"] = defbase | refimp
+	s.defs["__imp_foo"] = []definfo{{objidx: 0, secidx: 1}, {objidx: 1, secidx: 1}}
+	s.defs["bar"] = []definfo{{objidx: 0, secidx: 1}, {objidx: 1, secidx: 1}}
+	var fs []string
+	for _, f := range s.sarifFindings() {
+		fs = append(fs, fmt.Sprintf("%s O%d %s", f.id, f.objidx, f.msg))
+	}
+	wantfs := []string{
+		"duplicate-imp-def O1 __imp_foo is defined here and by a.o",
+		"unresolved-import O1 __imp_helper is referred to, but no object defines it",
+		"defbase-refimp O0 helper is defined here but referred to through __imp_helper",
+	}
+	if !reflect.DeepEqual(fs, wantfs) {
+		t.Errorf("findings got:\n%s\nwant:\n%s", strings.Join(fs, "\n"), strings.Join(wantfs, "\n"))
+	}
+
+	// With -imp-prefix, the finding names the import symbol with the
+	// prefix in use, even if nothing refers to it that way.
+	if err := setupImpPrefixes("__imp_aux_"); err != nil {
+		t.Fatal(err)
+	}
+	defer setupImpPrefixes("")
+	s = newState([]string{"a.o"}, []string{"a.o"})
+	s.defs["helper"] = []definfo{{objidx: 0, secidx: 1}}
+	s.defref["helper"] = defbase | refimp
+	if fs := s.sarifFindings(); len(fs) != 1 || fs[0].msg != "helper is defined here but referred to through __imp_aux_helper" {
+		t.Errorf("with -imp-prefix: got findings %+v", fs)
+	}
+
+	for _, bad := range []string{"bogus", "unresolved-import=loud"} {
+		if _, err := parseSarifRules(bad); err == nil {
+			t.Errorf("-sarif-rules=%s: no error", bad)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.addSection(secinfo{objidx: 1, name: ".text", size: 0x20, idx: 0})
+	s.defs["helper"] = []definfo{{objidx: 1, secidx: 1, value: 0x10}}
+	s.refs["__imp_helper"] = reflist{{objidx: 0, relocs: []relocinfo{{off: 0x9b, typ: "IMAGE_REL_AMD64_REL32"}}}}
+	s.defref["helper"] = defbase | refimp
+	s.refs["__imp_frob"] = reflist{{objidx: 1, relocs: []relocinfo{{off: 0x4, typ: "IMAGE_REL_AMD64_ADDR64"}}}}
+	s.defref["frob"] = refimp
+	h := s.serveHandler()
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	for _, tc := range []struct {
+		path string
+		code int
+		want []string
+		not  []string
+	}{
+		{"/", 200, []string{`<a href="/sym/frob">frob</a>`, `<a href="/sym/helper">helper</a>`, `<a href="/obj/1">O1</a>`}, nil},
+		{"/?q=defbase", 200, []string{`<a href="/sym/helper">helper</a>`}, []string{`/sym/frob"`}},
+		{"/sym/helper", 200, []string{"helper</span> in <a href=\"/obj/1\">O1</a> b.o sec=.text value=0x10",
+			`<a href="/obj/0">O0</a> a.o S=UNDEF <span class="mono">[0x9b/REL32]</span>`}, nil},
+		{"/obj/1", 200, []string{`<td class="mono">.text</td><td>0x20</td>`,
+			`<a class="mono" href="/sym/frob">__imp_frob</a> S=UNDEF <span class="mono">[0x4/ADDR64]</span>`}, nil},
+		{"/sym/nosuch", 404, nil, nil},
+		{"/obj/2", 404, nil, nil},
+		{"/api/symbol/nosuch", 404, nil, nil},
+	} {
+		code, body := get(tc.path)
+		if code != tc.code {
+			t.Errorf("%s: got status %d want %d", tc.path, code, tc.code)
+			continue
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(body, w) {
+				t.Errorf("%s: missing %q:\n%s", tc.path, w, body)
+			}
+		}
+		for _, w := range tc.not {
+			if strings.Contains(body, w) {
+				t.Errorf("%s: unexpected %q:\n%s", tc.path, w, body)
+			}
+		}
+	}
+
+	code, body := get("/api/symbol/helper")
+	if code != 200 {
+		t.Fatalf("/api/symbol/helper: status %d", code)
+	}
+	var as struct {
+		Sym        string
+		Categories []string
+		Defs       []struct{ Obj, Value int }
+		Refs       []struct {
+			Sym  string
+			Refs []struct {
+				Obj     int
+				Offsets []int
+				Types   []string
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &as); err != nil {
+		t.Fatalf("bad JSON: %v\n%s", err, body)
+	}
+	if got := fmt.Sprintf("%+v", as); got != "{Sym:helper Categories:[defbase refimp] Defs:[{Obj:1 Value:16}] Refs:[{Sym:__imp_helper Refs:[{Obj:0 Offsets:[155] Types:[REL32]}]}]}" {
+		t.Errorf("/api/symbol/helper: got %s", got)
+	}
+}
+
+func TestObjectTotals(t *testing.T) {
+	exe := buildTool(t)
+	op1 := filepath.Join("testdata", "srcdebug.o")
+	op2 := filepath.Join("testdata", "sample.o")
+	op3 := filepath.Join("testdata", "filesym.o")
+	checkDumper(t, op1)
+	cmd := exec.Command(exe, "-object-totals", "-no-excerpts", op1, op2, op3)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	want := "Object totals:\n" +
+		" O1: imprefs=2 impdefs=0 relocs=9 size=0x41d13 testdata/sample.o\n" +
+		" O0: imprefs=2 impdefs=0 relocs=3 size=0x2d testdata/srcdebug.o\n" +
+		" O2: imprefs=1 impdefs=0 relocs=1 size=0x7 testdata/filesym.o\n" +
+		"Def/ref breakdown:\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+
+	cmd = exec.Command(exe, "-object-totals", "-ndjson", op1, op2)
+	b, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	want = `"object_totals":[{"obj":1,"imprefs":2,"impdefs":0,"relocs":9,"size":269587},{"obj":0,"imprefs":2,"impdefs":0,"relocs":3,"size":45}]}`
+	if !strings.Contains(string(b), want) {
+		t.Errorf("-ndjson output missing %q:\n%s", want, b)
+	}
+}
+
+func TestDuplicateDefs(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "other.o")
+	if err := os.WriteFile(other, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both objects define callfoo.
+	cmd := exec.Command(exe, "-no-excerpts", "-watch=callfoo", op, other)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	for _, want := range []string{
+		"Defs:\n" +
+			" 0: \"callfoo\" obj=0 sec=1 val=0x0\n" +
+			" 1: \"callfoo\" obj=1 sec=1 val=0x0\n",
+		// A base symbol is listed once, with its status.
+		"Multiply-defined symbols:\n" +
+			" \"callfoo\": conflict\n" +
+			"  O0 sec=1 val=0x0 " + op + " (not comdat)\n" +
+			"  O1 sec=1 val=0x0 " + other + " (not comdat)\n",
+		" \"callfoo\":  defbase [duplicate defs]\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), "Duplicate definitions:") {
+		t.Errorf("base symbol listed under Duplicate definitions:\n%s", b)
+	}
+
+	// Import symbols defined more than once are.
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.defs["__imp_foo"] = []definfo{{objidx: 0, secidx: 1}, {objidx: 1, secidx: 1}}
+	s.defs["bar"] = []definfo{{objidx: 0, secidx: 1}, {objidx: 1, secidx: 1}}
+	var sb strings.Builder
+	s.writeDuplicateDefs(&sb, []string{"__imp_foo", "bar"})
+	if got, want := sb.String(), "Duplicate definitions:\n \"__imp_foo\":\n"+
+		"  O0 sec=1 val=0x0 a.o (unknown)\n  O1 sec=1 val=0x0 b.o (unknown)\n"; got != want {
+		t.Errorf("writeDuplicateDefs: got %q want %q", got, want)
+	}
+
+	// The definitions survive -save and -load.
+	saved := filepath.Join(t.TempDir(), "state.json")
+	cmd = exec.Command(exe, "-no-excerpts", "-watch=callfoo", "-save="+saved, op, other)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	ss, err := readSavedState(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = newState(nil, nil)
+	s.merge(ss, saved)
+	if got := len(s.defs["callfoo"]); got != 2 {
+		t.Errorf("after -load: got %d definitions of callfoo, want 2", got)
+	}
+}
+
+func TestInputSeparator(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdir := filepath.Join(t.TempDir(), "tmp dir")
+	if err := os.Mkdir(tdir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	spaces := filepath.Join(tdir, "with spaces.o")
+	comma := filepath.Join(tdir, "foo,bar.o")
+	for _, p := range []string{spaces, comma} {
+		if err := os.WriteFile(p, content, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(exe, "-isep=;", "-i="+spaces+";"+comma)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: " + spaces + " \n",
+		" O1: " + comma + " \n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestSidecar(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	tdir := t.TempDir()
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"x.o":    string(content),
+		"x.json": `{"path": "/src/x.c", "pkg": "runtime/cgo", "cmd": "gcc -c x.c"}`,
+		"y.obj":  string(content),
+		"y.txt":  "pn: /src/y.c\r\n",
+		"z.o":    string(content),
+	}
+	for f, c := range files {
+		if err := os.WriteFile(filepath.Join(tdir, f), []byte(c), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	x := filepath.Join(tdir, "x.o")
+	y := filepath.Join(tdir, "y.obj")
+	z := filepath.Join(tdir, "z.o")
+	cmd := exec.Command(exe, "-format-version=2", "-sidecar=.json:json,.txt:pn", x, y, z)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: " + x + " /src/x.c pkg=runtime/cgo cmd=\"gcc -c x.c\"\n",
+		" O1: " + y + " /src/y.c\n",
+		" O2: " + z + " \n",
+	} {
+		if !containsReport(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestFileSymbolProvenance(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "filesym.o")
+	checkDumper(t, op)
+
+	// filesym.o has a .file symbol whose name spans several aux
+	// records; sample.o has a short one.
+	sp := filepath.Join("testdata", "sample.o")
+	cmd := exec.Command(exe, "-format-version=2", op, sp)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: " + op + " some/very/long/directory/name/for/testing/foo_source_file.c\n",
+		" O1: " + sp + " test.cgo2.c\n",
+	} {
+		if !containsReport(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestReadWatchFile(t *testing.T) {
+	wf := filepath.Join(t.TempDir(), "watch.txt")
+	content := "# CRT bits\n_errno\n\n  __acrt_iob_func  \r\n_errno\n"
+	if err := os.WriteFile(wf, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readWatchFile(wf)
+	if err != nil {
+		t.Fatalf("readWatchFile: %v", err)
+	}
+	if want := "_errno,__acrt_iob_func,_errno"; strings.Join(got, ",") != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	content += "bad symbol\n"
+	if err := os.WriteFile(wf, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readWatchFile(wf)
+	if err == nil || !strings.Contains(err.Error(), wf+":6:") {
+		t.Errorf("got error %v, wanted error mentioning %s:6", err, wf)
+	}
+}
+
+func TestObjLabels(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := []string{
+		filepath.Join(wd, "testdata", "text.o"),
+		filepath.Join("testdata", "text.o"),
+		"/elsewhere/text.o",
+		"lib.a(text.o)",
+		"lib.a(text.o#2)",
+	}
+	want := []string{
+		filepath.Join("testdata", "text.o"),
+		filepath.Join("testdata", "text.o") + "#2",
+		"/elsewhere/text.o",
+		"lib.a(text.o)",
+		"lib.a(text.o#2)",
+	}
+	got := objLabels(objs)
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("objLabels: got %q want %q", got, want)
+	}
+}
+
+// gzipFile writes a gzip-compressed copy of src to dst.
+func gzipFile(t *testing.T, src, dst string) {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(content)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadText(t *testing.T) {
+	tdir := t.TempDir()
+	plain := filepath.Join(tdir, "list.txt")
+	if err := os.WriteFile(plain, []byte("a.o\nb.o\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	gz := filepath.Join(tdir, "list.txt.gz")
+	gzipFile(t, plain, gz)
+	// Compressed, but without the suffix.
+	magic := filepath.Join(tdir, "list.z")
+	gzipFile(t, plain, magic)
+	for _, p := range []string{plain, gz, magic} {
+		got, err := readText(p)
+		if err != nil {
+			t.Fatalf("readText(%s): %v", p, err)
+		}
+		if string(got) != "a.o\nb.o\n" {
+			t.Errorf("readText(%s): got %q", p, got)
+		}
+	}
+
+	bad := filepath.Join(tdir, "bad.txt.gz")
+	content, _ := os.ReadFile(gz)
+	if err := os.WriteFile(bad, content[:len(content)-6], 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readText(bad); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Errorf("readText(%s): got error %v, wanted error naming file", bad, err)
+	}
+}
+
+func TestFromDumpGzip(t *testing.T) {
+	exe := buildTool(t)
+	dump := filepath.Join(t.TempDir(), "srcdebug.dump.txt.gz")
+	gzipFile(t, filepath.Join("testdata", "srcdebug.dump.txt"), dump)
+	cmd := exec.Command(exe, "-from-dump="+dump, "-watch=foo,bar")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	want := "Def/ref breakdown:\n \"bar\":  refbase refimp\n \"foo\":  refimp\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+}
+
+func TestLinkResponseFile(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdir := filepath.Join(t.TempDir(), "tmp dir")
+	if err := os.Mkdir(tdir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	spaces := filepath.Join(tdir, "with spaces.obj")
+	if err := os.WriteFile(spaces, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	sp := filepath.Join("testdata", "sample.o")
+	// Import libraries map symbols to DLLs rather than being
+	// analyzed.
+	rsp := "/OUT:x.exe /NOLOGO -debug\r\n\"" + spaces + "\"\r\n" +
+		sp + " resources.res " + filepath.Join("testdata", "ucrt.lib") + "\r\n"
+	u := utf16.Encode([]rune(rsp))
+	buf := []byte{0xff, 0xfe}
+	for _, c := range u {
+		buf = append(buf, byte(c), byte(c>>8))
+	}
+	rspfile := filepath.Join(tdir, "link.rsp")
+	if err := os.WriteFile(rspfile, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-format-version=2", "-linkrsp="+rspfile)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"skipping \"resources.res\"",
+		" O0: " + spaces + " \n",
+		" O1: " + sp + " test.cgo2.c\n",
+		" \"_errno\":  refimp [ucrtbase.dll] (",
+	} {
+		if !containsReport(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), " O2: ") {
+		t.Errorf("unexpected extra objects:\n%s", b)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	sp := filepath.Join("testdata", "sample.o")
+
+	saved := filepath.Join(t.TempDir(), "saved.json")
+	cmd := exec.Command(exe, "-watch=callfoo", "-save="+saved, op)
+	t.Logf("cmd: %+v\n", cmd)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+
+	// Loaded objects follow the ones analyzed in this run.
+	cmd = exec.Command(exe, "-format-version=2", "-watch=callfoo", "-load="+saved, sp, op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: " + sp + " test.cgo2.c\n",
+		" O1: " + op + " \n",
+		" O2: " + op + "#2 \n",
+		"Conflicting definitions:\n \"callfoo\" defined in O1 and O2 (from " + saved + ")\n",
+		// The loaded definition is kept along with the existing one.
+		" 1: \"callfoo\" obj=2 sec=.text val=0x0\n",
+		"   1: O=2 S=.text [0x1d/REL32] " + op + "#2\n",
+		" \"_errno\":  refimp (",
+		" \"bar\":  refbase refimp (",
+	} {
+		if !containsReport(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	sp := filepath.Join("testdata", "sample.o")
+
+	bl := filepath.Join(t.TempDir(), "baseline.txt")
+	cmd := exec.Command(exe, "-write-baseline="+bl, op, sp)
+	t.Logf("cmd: %+v\n", cmd)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+
+	// Same objects in a different order: no differences.
+	cmd = exec.Command(exe, "-baseline="+bl, sp, op)
+	t.Logf("cmd: %+v\n", cmd)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+
+	// Doctor the baseline so that there are differences.
+	content, err := os.ReadFile(bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.Replace(content, []byte("bar\trefbase refimp\n"), []byte("bar\trefimp\n"), 1)
+	content = append(content, "gone\tdefbase\n"...)
+	if err := os.WriteFile(bl, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(exe, "-baseline="+bl, op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != baselineDiffExit {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("got %v, wanted exit status %d", err, baselineDiffExit)
+	}
+	want := "Baseline differences (" + bl + "):\n" +
+		" - \"__acrt_iob_func\":  refimp\n" +
+		" - \"_errno\":  refimp\n" +
+		" ~ \"bar\":  refimp => refbase refimp\n" +
+		" - \"gone\":  defbase\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+}
+
+func TestIncrementalState(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	tdir := t.TempDir()
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := filepath.Join(tdir, "x.o")
+	if err := os.WriteFile(x, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	sp := filepath.Join("testdata", "sample.o")
+	st := filepath.Join(tdir, "state.json")
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command(exe, args...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		return string(b), err
+	}
+	first, err := run("-state="+st, "-backend=llvm", x, sp)
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, first)
+	}
+
+	// Nothing has changed, so the dumper isn't needed (and a broken
+	// one won't be noticed).
+	if runtime.GOOS == "windows" {
+		t.Skip("broken dumper script needs a Unix shell")
+	}
+	broken := filepath.Join(tdir, "broken-objdump")
+	script := "#!/bin/sh\n[ \"$1\" = --version ] && echo broken && exit 0\nexit 1\n"
+	if err := os.WriteFile(broken, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	second, err := run("-state="+st, "-objdump="+broken, x, sp)
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, second)
+	}
+	// Only the dumper named in the header should differ.
+	dumperre := regexp.MustCompile(`Dumper: .*\n`)
+	if dumperre.ReplaceAllString(first, "") != dumperre.ReplaceAllString(second, "") {
+		t.Errorf("incremental output differs:\n%s\nvs from scratch:\n%s", second, first)
+	}
+
+	// A changed object has to be dumped again.
+	if err := os.WriteFile(x, append(content, 0), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := run("-state="+st, "-objdump="+broken, x, sp); err == nil {
+		t.Errorf("changed object not dumped again:\n%s", b)
+	}
+
+	// Entries for objects that have gone away are pruned.
+	if err := os.Remove(x); err != nil {
+		t.Fatal(err)
+	}
+	b, err := run("-state="+st, sp)
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	if want := "note: pruning state for " + x; !strings.Contains(b, want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+}
+
+func TestSyso(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	content, err := os.ReadFile(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdir := t.TempDir()
+	amd64 := filepath.Join(tdir, "rsrc_windows_amd64.syso")
+	arm64 := filepath.Join(tdir, "sub", "rsrc_windows_arm64.syso")
+	elf := filepath.Join(tdir, "sub", "x_linux_amd64.syso")
+	armcontent := append([]byte{0x64, 0xaa}, content[2:]...)
+	for p, c := range map[string][]byte{
+		amd64: content,
+		arm64: armcontent,
+		elf:   []byte("\x7fELF\x02\x01\x01"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, c, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(exe, "-syso="+tdir)
+	cmd.Env = append(os.Environ(), "GOARCH=amd64")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"note: skipping " + arm64 + ": not for GOARCH=amd64\n",
+		"note: skipping " + elf + ": not a COFF object\n",
+		" O0: " + amd64 + " [syso] \n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), " O1: ") {
+		t.Errorf("unexpected extra objects:\n%s", b)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	f, err := os.Open(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tdir := t.TempDir()
+	cmd := exec.Command(exe, "-stdin", "-watch=foo")
+	cmd.Stdin = f
+	// Check that the temp file is cleaned up.
+	cmd.Env = append(os.Environ(), "TMPDIR="+tdir)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: <stdin> \n",
+		"excerpts from 'llvm-objdump-14 -ldr <stdin>`",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+	if ents, _ := os.ReadDir(tdir); len(ents) != 0 {
+		t.Errorf("temp files left behind: %v", ents)
+	}
+
+	cmd = exec.Command(exe, "-stdin", op)
+	if b, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(b), "-stdin can't be combined") {
+		t.Errorf("-stdin with inputs: got %v\n%s", err, b)
+	}
+}
+
+func TestParseObjRange(t *testing.T) {
+	for _, tc := range []struct {
+		spec   string
+		lo, hi int
+		ok     bool
+	}{
+		{"0:3", 0, 3, true},
+		{"1:2", 1, 2, true},
+		{":2", 0, 2, true},
+		{"2:", 2, 3, true},
+		{"2", 0, 0, false},
+		{"0:4", 0, 0, false},
+		{"-1:2", 0, 0, false},
+		{"2:2", 0, 0, false},
+		{"x:2", 0, 0, false},
+	} {
+		lo, hi, err := parseObjRange(tc.spec, 3)
+		if (err == nil) != tc.ok || lo != tc.lo || hi != tc.hi {
+			t.Errorf("parseObjRange(%q): got %d,%d,%v", tc.spec, lo, hi, err)
+		}
+	}
+}
+
+func TestObjRange(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	sp := filepath.Join("testdata", "sample.o")
+	fp := filepath.Join("testdata", "filesym.o")
+	cmd := exec.Command(exe, "-objrange=1:2", sp, op, fp)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		" O0: " + sp + " [skipped: outside -objrange] \n" +
+			" O1: " + op + " \n" +
+			" O2: " + fp + " [skipped: outside -objrange] \n",
+		"   0: O=1 S=0 [0x1d]\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), "_errno") {
+		t.Errorf("skipped object analyzed:\n%s", b)
+	}
+}
+
+func TestNormalizeWinPath(t *testing.T) {
+	deep := strings.Repeat(`very\deep\`, 30) + "x.o"
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{`C:\short\x.o`, `C:\short\x.o`},
+		{`\\server\share\x.o`, `\\server\share\x.o`},
+		{`C:\` + deep, `\\?\C:\` + deep},
+		{`C:/` + strings.ReplaceAll(deep, `\`, "/"), `\\?\C:\` + deep},
+		{`\\server\share\` + deep, `\\?\UNC\server\share\` + deep},
+		{`\\?\C:\` + deep, `\\?\C:\` + deep},
+		{deep, deep},
+	} {
+		if got := normalizeWinPath(tc.in); got != tc.want {
+			t.Errorf("normalizeWinPath(%q):\ngot  %q\nwant %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+const msvcMap = ` out
+
+ Timestamp is 62a0c1e5 (Wed Jun  8 12:00:00 2022)
+
+ Preferred load address is 0000000140000000
+
+ Start         Length     Name                   Class
+ 0001:00000000 00000100H .text                   CODE
+
+  Address         Publics by Value              Rva+Base               Lib:Object
+
+ 0000:00000000       __guard_fids_count         0000000000000000     <absolute>
+ 0001:00000000       callfoo                    0000000140001000 f   srcdebug.obj
+ 0001:00000012       callbar                    0000000140001012 f   other.obj
+ 0002:00000120       __imp_bar                  0000000140002120     libbar:bar.dll
+
+ entry point at        0001:00000000
+`
+
+const lldMap = ` Address  Size     Align Out     In      Symbol
+ 140001000 00000100  4096 .text
+ 140001000 0000002d    16         C:\objs\srcdebug.obj:(.text)
+ 140001000 00000000     0                 callfoo
+ 140001030 00000010    16         libother.a(other.o):(.text)
+ 140001030 00000000     0                 callbar
+ 140002000 00000200  4096 .idata
+ 140002120 00000008     8         libbar.a(d000001.o):(.idata$5)
+ 140002120 00000000     0                 __imp_bar
+`
+
+func TestParseMapFile(t *testing.T) {
+	tdir := t.TempDir()
+	for _, tc := range []struct {
+		name, content string
+		want          map[string]string
+	}{
+		{"msvc.map", msvcMap, map[string]string{
+			"__guard_fids_count": "<absolute>",
+			"callfoo":            "srcdebug.obj",
+			"callbar":            "other.obj",
+			"__imp_bar":          "libbar:bar.dll",
+		}},
+		{"lld.map", lldMap, map[string]string{
+			"callfoo":   `C:\objs\srcdebug.obj`,
+			"callbar":   "libother.a(other.o)",
+			"__imp_bar": "libbar.a(d000001.o)",
+		}},
+	} {
+		mf := filepath.Join(tdir, tc.name)
+		if err := os.WriteFile(mf, []byte(tc.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseMapFile(mf)
+		if err != nil {
+			t.Fatalf("parseMapFile(%s): %v", tc.name, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("parseMapFile(%s):\ngot  %v\nwant %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMapFile(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	mf := filepath.Join(t.TempDir(), "lld.map")
+	if err := os.WriteFile(mf, []byte(lldMap), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-mapfile="+mf, "-watch=callfoo,callbar", op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	want := "Map file cross-check (" + mf + "):\n" +
+		" \"callbar\": O0 " + op + ", but linker chose libother.a(other.o)\n" +
+		" \"callfoo\": O0 " + op + ", matches linker\n" +
+		" \"__imp_bar\": from libbar.a(d000001.o), not defined by any analyzed object, referenced\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+
+	// Any of several definitions can match the linker's choice.
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.defs["frob"] = []definfo{{objidx: 0, secidx: 1}, {objidx: 1, secidx: 1}}
+	var sb strings.Builder
+	s.checkMap(&sb, map[string]string{"frob": `C:\lib\b.obj`})
+	if got, want := sb.String(), " \"frob\": O1 b.o, matches linker\n"; got != want {
+		t.Errorf("checkMap: got %q want %q", got, want)
+	}
+}
+
+func TestDumperSelection(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	missing := filepath.Join(t.TempDir(), "no-such-objdump")
+	for _, tc := range []struct {
+		flag, env string
+		ok        bool
+	}{
+		{"", "", true},
+		{"", missing, false},
+		{missing, "", false},
+		// The flag takes precedence over the environment.
+		{DefaultDumper, missing, true},
+	} {
+		args := []string{op}
+		if tc.flag != "" {
+			args = append([]string{"-objdump=" + tc.flag}, args...)
+		}
+		cmd := exec.Command(exe, args...)
+		cmd.Env = append(os.Environ(), dumperEnv+"="+tc.env)
+		t.Logf("cmd: %+v env %s\n", cmd, tc.env)
+		b, err := cmd.CombinedOutput()
+		if tc.ok && err != nil {
+			t.Errorf("run error: %v\n%s", err, b)
+		}
+		if !tc.ok {
+			if err == nil || !strings.Contains(string(b), "can't use objdump program \""+missing+"\"") {
+				t.Errorf("got %v, wanted clear error:\n%s", err, b)
+			}
+			if strings.Contains(string(b), "Objects:") {
+				t.Errorf("analysis ran without a dumper:\n%s", b)
+			}
+		}
+	}
+}
+
+func TestResolveDumper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake dumper script needs a Unix shell")
+	}
+	defer func(d string) { DefaultDumper = d }(DefaultDumper)
+	DefaultDumper = "llvm-objdump-14"
+	t.Setenv(dumperEnv, "")
+
+	// Only llvm-objdump-17 is available.
+	bindir := t.TempDir()
+	script := "#!/bin/sh\necho fake LLVM version 17.0.0\n"
+	if err := os.WriteFile(filepath.Join(bindir, "llvm-objdump-17"), []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bindir)
+	if err := resolveDumper(); err != nil {
+		t.Fatalf("resolveDumper: %v", err)
+	}
+	if dumper != "llvm-objdump-17" || dumperVersion != "fake LLVM version 17.0.0" {
+		t.Errorf("got dumper %q version %q", dumper, dumperVersion)
+	}
+
+	// With nothing available, all the candidates are listed.
+	t.Setenv("PATH", t.TempDir())
+	err := resolveDumper()
+	if err == nil {
+		t.Fatalf("resolveDumper succeeded with %q", dumper)
+	}
+	for _, c := range []string{"llvm-objdump-14:", "llvm-objdump:", "llvm-objdump-18:", "llvm-objdump-15:"} {
+		if !strings.Contains(err.Error(), c) {
+			t.Errorf("error doesn't mention %s: %v", c, err)
+		}
+	}
+}
+
+func TestGNUDump(t *testing.T) {
+	// Output captured from GNU objdump -htr; the flavor is detected
+	// from the output.
+	exe := buildTool(t)
+	sd := filepath.Join("testdata", "srcdebug.gnudump.txt")
+	fd := filepath.Join("testdata", "filesym.gnudump.txt")
+	cmd := exec.Command(exe, "-format-version=2", "-from-dump="+sd+","+fd, "-watch=callfoo")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	checkGolden(t, string(b), "Objects:", filepath.Join("testdata", "gnudump.golden"))
+}
+
+func TestGNUObjdump(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	gnu, err := exec.LookPath("objdump")
+	if err != nil {
+		t.Skipf("no GNU objdump: %v", err)
+	}
+	if b, err := exec.Command(gnu, "-f", op).CombinedOutput(); err != nil || !strings.Contains(string(b), "pe-x86-64") {
+		t.Skipf("GNU objdump can't read COFF objects: %v\n%s", err, b)
+	}
+
+	// The references and def/ref masks should be the same for both
+	// flavors, including for local symbols, which GNU objdump shows
+	// with an addend.
+	report := func(args ...string) string {
+		cmd := exec.Command(exe, append(args, "-no-excerpts",
+			op, filepath.Join("testdata", "sample.o"))...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		out := string(b)
+		if i := strings.Index(out, "Refs:"); i != -1 {
+			out = out[i:]
+		}
+		out, _, _ = strings.Cut(out, "\n\n")
+		return out
+	}
+	for _, sel := range []string{"-watch=callfoo", "-watch=printf", "-all"} {
+		want := report(sel)
+		if got := report(sel, "-objdump="+gnu, "-dumper-flavor=gnu"); got != want {
+			t.Errorf("%s: GNU objdump report:\n%s\nwant:\n%s", sel, got, want)
+		}
+	}
+}
+
+func TestDumpbin(t *testing.T) {
+	exe := buildTool(t)
+	sd := filepath.Join("testdata", "srcdebug.dumpbin.txt")
+	fd := filepath.Join("testdata", "filesym.dumpbin.txt")
+	cmd := exec.Command(exe, "-format-version=2", "-from-dump="+sd+","+fd, "-watch=callfoo")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	checkGolden(t, string(b), "Objects:", filepath.Join("testdata", "dumpbin.golden"))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake dumper script needs a Unix shell")
+	}
+	// A fake dumpbin that prints its banner when run without
+	// arguments and replays captured output otherwise; it is
+	// recognized by name.
+	sdabs, err := filepath.Abs(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := filepath.Join(t.TempDir(), "dumpbin")
+	script := "#!/bin/sh\nif [ $# = 0 ]; then echo 'Microsoft (R) COFF/PE Dumper Version 14.29.30146.0'; exit 0; fi\ncat " + sdabs + "\n"
+	if err := os.WriteFile(fake, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(exe, "-format-version=2", "-objdump="+fake, "-watch=callfoo", filepath.Join("testdata", "srcdebug.o"))
+	t.Logf("cmd: %+v\n", cmd)
+	b, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"Dumper: " + fake + " (Microsoft (R) COFF/PE Dumper Version 14.29.30146.0)\n",
+		"Def/ref breakdown:\n \"bar\":  refbase refimp (",
+		" \"callfoo\":  defbase (",
+		" \"foo\":  refimp (",
+		"note: no excerpts for watched symbols with dumpbin\n",
+	} {
+		if !containsReport(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestNative(t *testing.T) {
+	exe := buildTool(t)
+	run := func(env []string, args ...string) string {
+		cmd := exec.Command(exe, args...)
+		cmd.Env = append(os.Environ(), env...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		return string(b)
+	}
+	inputs := []string{"-watch=callfoo",
+		filepath.Join("testdata", "srcdebug.o"),
+		filepath.Join("testdata", "filesym.o"),
+		filepath.Join("testdata", "sample.o")}
+
+	// No objdump is needed.
+	out := run([]string{"PATH=" + t.TempDir(), dumperEnv + "="}, append([]string{"-format-version=2"}, inputs...)...)
+	for _, want := range []string{
+		" O1: testdata/filesym.o some/very/long/directory/name/for/testing/foo_source_file.c\n",
+		" \"callfoo\":  defbase (",
+		"note: no excerpts for watched symbols: disassembly needs an objdump program, and none is usable\n",
+	} {
+		if !containsReport(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	// The results should be the same as with objdump.
+	checkDumper(t, inputs[2])
+	report := func(out string) string {
+		out = out[strings.Index(out, "Objects:"):]
+		out, _, _ = strings.Cut(out, "\nexcerpts from")
+		return out
+	}
+	want := report(run(nil, inputs...))
+	if got := report(run(nil, append([]string{"-dumper-flavor=native"}, inputs...)...)); got != want {
+		t.Errorf("built-in reader output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// fakeDumper is a Dumper that replays canned dumps, keyed by file.
+type fakeDumper struct {
+	dumps map[string]string
+}
+
+func (fd *fakeDumper) Full(file string, sections []string) (io.Reader, error) {
+	d, ok := fd.dumps[file]
+	if !ok {
+		return nil, fmt.Errorf("no dump for %s", file)
+	}
+	return strings.NewReader(d), nil
+}
+
+func (fd *fakeDumper) Disasm(file string, withsrc bool) (io.Reader, error) {
+	return nil, fmt.Errorf("no disassembly for %s", file)
+}
+
+func TestFakeDumper(t *testing.T) {
+	defer cleanup()
+	defer func(f string) { flavor = f }(flavor)
+	flavor = flavorLLVM
+	dump, err := os.ReadFile(filepath.Join("testdata", "srcdebug.dump.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file just needs to exist and not be an image.
+	op := filepath.Join("testdata", "srcdebug.o")
+	s := newState([]string{op}, []string{op})
+	s.dumper = &fakeDumper{dumps: map[string]string{op: string(dump)}}
+	if err := s.pass1(op); err != nil {
+		t.Fatalf("pass1: %v", err)
+	}
+	s.pass2()
+	if err := s.pass3(op); err != nil {
+		t.Fatalf("pass3: %v", err)
+	}
+	for sym, want := range map[string]defrefmask{
+		"bar": refbase | refimp,
+		"foo": refimp,
+	} {
+		if got := s.defref[sym]; got != want {
+			t.Errorf("%s: got%s want%s", sym, got, want)
+		}
+	}
+	if rl := s.refs["__imp_bar"]; len(rl) != 1 || fmt.Sprint(rl[0].offsets()) != "[29]" {
+		t.Errorf("__imp_bar refs: got %+v", rl)
+	}
+}
+
+func TestReadobj(t *testing.T) {
+	// Output captured from llvm-readobj's JSON output style.
+	exe := buildTool(t)
+	sd := filepath.Join("testdata", "srcdebug.readobj.txt")
+	fd := filepath.Join("testdata", "filesym.readobj.txt")
+	cmd := exec.Command(exe, "-format-version=2", "-from-dump="+sd+","+fd, "-watch=callfoo")
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	checkGolden(t, string(b), "Objects:", filepath.Join("testdata", "readobj.golden"))
+
+	// Live, the report should match that from llvm-objdump.
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	readobj := strings.Replace(DefaultDumper, "objdump", "readobj", 1)
+	if _, err := exec.LookPath(readobj); err != nil {
+		t.Skipf("no %s: %v", readobj, err)
+	}
+	report := func(args ...string) string {
+		cmd := exec.Command(exe, append(args, "-watch=callfoo", op,
+			filepath.Join("testdata", "sample.o"))...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		out := string(b)
+		return out[strings.Index(out, "Objects:"):]
+	}
+	want := report()
+	if got := report("-dumper-flavor=readobj"); got != want {
+		t.Errorf("llvm-readobj report:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBackendFallback(t *testing.T) {
+	defer cleanup()
+	defer func(f string) { flavor = f }(flavor)
+	flavor = flavorLLVM
+	dump, err := os.ReadFile(filepath.Join("testdata", "srcdebug.dump.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The built-in reader can't make sense of this file, so the
+	// next backend is used for it.
+	op := filepath.Join("testdata", "srcdebug.s")
+	s := newState([]string{op}, []string{op})
+	chain, err := newBackends(backendAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain[1] = &backend{name: "fake", flavor: flavorLLVM, resolved: true,
+		dumper: &fakeDumper{dumps: map[string]string{op: string(dump)}}}
+	s.chain = chain[:2]
+	if err := s.pass1(op); err != nil {
+		t.Fatalf("pass1: %v", err)
+	}
+	s.pass2()
+	if err := s.pass3(op); err != nil {
+		t.Fatalf("pass3: %v", err)
+	}
+	if got, want := s.defref["bar"], refbase|refimp; got != want {
+		t.Errorf("bar: got%s want%s", got, want)
+	}
+	if want := " O0: " + op + " [read with fake backend]"; !strings.Contains(s.String(), want) {
+		t.Errorf("report missing %q:\n%s", want, s.String())
+	}
+}
+
+func TestDumperStderr(t *testing.T) {
+	exe := buildTool(t)
+	checkDumper(t, filepath.Join("testdata", "srcdebug.o"))
+	bogus := filepath.Join(t.TempDir(), "bogus.o")
+	if err := os.WriteFile(bogus, []byte("not an object\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-backend=llvm", bogus)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("bogus object accepted:\n%s", b)
+	}
+	if want := "\n\t" + DefaultDumper + ": error: '" + bogus + "': "; !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a Unix shell")
+	}
+	_, err = exec.Command("sh", "-c", "for i in 1 2 3 4 5 6 7; do echo line$i >&2; done; exit 1").Output()
+	want := "\n\tline1\n\tline2\n\tline3\n\tline4\n\tline5\n\t... (2 more lines)"
+	if got := stderrSummary(err); got != want {
+		t.Errorf("stderrSummary: got %q want %q", got, want)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("slow dumper script needs a Unix shell")
+	}
+	exe := buildTool(t)
+	slow := filepath.Join(t.TempDir(), "slow-objdump")
+	script := "#!/bin/sh\n[ \"$1\" = --version ] && echo slow && exit 0\nexec sleep 30\n"
+	if err := os.WriteFile(slow, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	sp := filepath.Join("testdata", "sample.o")
+	fp := filepath.Join("testdata", "filesym.o")
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command(exe, append([]string{"-objdump=" + slow}, args...)...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		return string(b), err
+	}
+
+	out, err := run("-timeout=100ms", sp)
+	if err == nil {
+		t.Fatalf("slow dumper not reported:\n%s", out)
+	}
+	want := regexp.MustCompile(`reading testdata/sample.o \(pass1\): killed by -timeout after \S+: ` +
+		regexp.QuoteMeta(slow+" -h -t -r ") + `.* ` + regexp.QuoteMeta(sp))
+	if !want.MatchString(out) {
+		t.Errorf("output doesn't match %s:\n%s", want, out)
+	}
+
+	// With -continue-on-error, each object gets its own error.
+	out, err = run("-deadline=300ms", "-continue-on-error", sp, fp)
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"error: reading " + sp + " (pass1): killed by -deadline after ",
+		"error: reading " + fp + " (pass1): -deadline of 300ms exceeded\n",
+		" O0: " + sp + " [skipped: pass1 failed] \n",
+		" O1: " + fp + " [skipped: pass1 failed] \n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestARM64(t *testing.T) {
+	exe := buildTool(t)
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, args...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		return string(b)
+	}
+	op := filepath.Join("testdata", "arm64.o")
+	out := run("-watch=bar,__imp_bar", op)
+	for _, want := range []string{
+		" \"bar\":  refbase refimp\n",
+		" \"foo\":  refimp\n",
+		// The adrp/ldr pair is one reference, at the adrp.
+		"   0: O=0 S=0 [0x14]\n",
+		"   0: O=0 S=0 [0x10]\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "[arm64]") {
+		t.Errorf("machine tagged for a single architecture:\n%s", out)
+	}
+
+	if out := run("-format-version=2", op); !strings.Contains(out, "Machine: arm64\n") {
+		t.Errorf("output missing machine:\n%s", out)
+	}
+
+	// Mixed architectures are refused, unless allowed.
+	sp := filepath.Join("testdata", "srcdebug.o")
+	cmd := exec.Command(exe, op, sp)
+	b, err := cmd.CombinedOutput()
+	want := "inputs are for more than one architecture (use -allow-mixed-arch to analyze them anyway):\n" +
+		"\tamd64: testdata/srcdebug.o\n\tarm64: testdata/arm64.o\n"
+	if err == nil || !strings.Contains(string(b), want) {
+		t.Errorf("mixed inputs: got error %v, output:\n%s\nwant %q", err, b, want)
+	}
+	out = run("-format-version=2", "-allow-mixed-arch", "-watch=bar", op, sp)
+	if strings.Contains(out, "Machine:") {
+		t.Errorf("single machine shown for mixed inputs:\n%s", out)
+	}
+	for _, want := range []string{
+		" O0: testdata/arm64.o [arm64] ",
+		" O1: testdata/srcdebug.o [amd64] ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	// Excerpts, and the same results with objdump.
+	checkDumper(t, op)
+	out = run("-backend=llvm", "-watch=bar,__imp_bar", op)
+	for _, want := range []string{
+		"=-= ref O0 off=0x10:\n",
+		"=-= ref O0 off=0x14:\n",
+		"   0: O=0 S=0 [0x14]\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "off=0x18") {
+		t.Errorf("excerpt for the low half of an adrp/ldr pair:\n%s", out)
+	}
+}
+
+func TestDumpMachine(t *testing.T) {
+	for _, tc := range []struct {
+		dump, want string
+	}{
+		{"srcdebug.dump.txt", "amd64"},
+		{"srcdebug.gnudump.txt", "amd64"},
+		{"srcdebug.dumpbin.txt", "amd64"},
+		{"srcdebug.readobj.txt", "amd64"},
+	} {
+		b, err := os.ReadFile(filepath.Join("testdata", tc.dump))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := dumpMachine(string(b)); got != tc.want {
+			t.Errorf("dumpMachine(%s) = %q, want %q", tc.dump, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		dump, want string
+	}{
+		{"testdata/arm64.o:\tfile format coff-arm64\n", "arm64"},
+		{"FILE HEADER VALUES\n            AA64 machine (ARM64)\n", "arm64"},
+		{"\"Format\":\"COFF-ARM64\",\n", "arm64"},
+		{"x.o:\tfile format pe-i386\n", "386"},
+		{"no header here\n", ""},
+	} {
+		if got := dumpMachine(tc.dump); got != tc.want {
+			t.Errorf("dumpMachine(%q) = %q, want %q", tc.dump, got, tc.want)
+		}
+	}
+}
+
+func TestI386(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "i386.o")
+	cmd := exec.Command(exe, "-watch=CreateFileA,localstd",
+		"-implib="+filepath.Join("testdata", "i386.lib"), op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	out := string(b)
+	for _, want := range []string{
+		// One entry per function, whatever the decoration.
+		" \"CreateFileA\":  refbase refimp [kernel32.dll]\n",
+		" \"printf\":  refimp [kernel32.dll]\n",
+		" \"fastimp\":  refimp [not in import libs]\n",
+		" \"localstd\":  defbase\n",
+		// Watching by undecorated name.
+		" \"__imp__CreateFileA@4\":\n   0: O=0 S=0 [0x4]\n",
+		" 0: \"_localstd@8\" obj=0 sec=1 val=0x26\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestUndecorate(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"_CreateFileA@4", "CreateFileA"},
+		{"_printf", "printf"},
+		{"@fastfn@8", "fastfn"},
+		{"_GetTickCount@@0", "GetTickCount"},
+		{"@fastfn@@8", "fastfn"},
+		{"_", ""},
+		{"@", "@"},
+		{"_foo@bar", "foo@bar"},
+		{"?foo@@YAXXZ", "?foo@@YAXXZ"},
+		{"plain", "plain"},
+	} {
+		if got := undecorate(tc.in); got != tc.want {
+			t.Errorf("undecorate(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// symtabLines returns the symbol table lines (less aux records) of
+// the objdump output in testdata file dump.
+func symtabLines(t *testing.T, dump string) []string {
+	b, err := os.ReadFile(filepath.Join("testdata", dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tab, _ := strings.Cut(string(b), "SYMBOL TABLE:\n")
+	tab, _, _ = strings.Cut(tab, "\n\n")
+	var res []string
+	for _, line := range strings.Split(tab, "\n") {
+		if !isAuxLine(line) {
+			res = append(res, line)
+		}
+	}
+	return res
+}
+
+func TestParseSymLine(t *testing.T) {
+	// The same object as dumped by different objdumps.
+	var want []symline
+	for _, line := range symtabLines(t, "srcdebug.dump.txt") {
+		sl, err := parseSymLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, sl)
+	}
+	for _, dump := range []string{"srcdebug.llvm18.dump.txt", "srcdebug.gnudump.txt"} {
+		var got []symline
+		for _, line := range symtabLines(t, dump) {
+			sl, err := parseSymLine(line)
+			if err != nil {
+				t.Fatalf("%s: %v", dump, err)
+			}
+			got = append(got, sl)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v\nwant %+v", dump, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		line string
+		want symline
+		ok   bool
+	}{
+		{"[ 0](sec  1)(fl 0x00)(ty   0)(scl   3) (nx 1) 0x00000000 .text", symline{1, 0, ".text"}, true},
+		{"[ 16](sec 1)(fl 0x0)(ty 0)(scl 2) (nx 0) 0x12 callbar", symline{1, 0x12, "callbar"}, true},
+		{"[16] (sec -2) (fl 0x00) (ty 0) (scl 103) (nx 0) 0x0000000000000000 .file", symline{-2, 0, ".file"}, true},
+		{"[ 7](sec  0)(fl 0x00)(ty   0)(scl   2) (nx 0) 0x00000000 weird)name", symline{0, 0, "weird)name"}, true},
+		{"[ 7](fl 0x00)(ty   0)(scl   2) (nx 0) 0x00000000 nosec", symline{}, false},
+		{"[ 7](sec  0)(fl 0x00)(ty   0)(scl   2) (nx 0) 0x00000000", symline{}, false},
+		{"[ 7](sec  x)(fl 0x00)(ty   0)(scl   2) (nx 0) 0x0 foo", symline{}, false},
+		{"garbage", symline{}, false},
+	} {
+		got, err := parseSymLine(tc.line)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseSymLine(%q) error %v, want ok=%v", tc.line, err, tc.ok)
+		} else if tc.ok && got != tc.want {
+			t.Errorf("parseSymLine(%q) = %+v, want %+v", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestParseSecLine(t *testing.T) {
+	for _, tc := range []struct {
+		line  string
+		idx   int
+		name  string
+		size  int
+		valid bool
+	}{
+		{"  0 .text          0000002d 0000000000000000 TEXT", 0, ".text", 0x2d, true},
+		{"  3 .debug_info      0000008a 0000000000000000 DATA, DEBUG", 3, ".debug_info", 0x8a, true},
+		{"  0 .text         0000002d  0000000000000000  0000000000000000  0000012c  2**2", 0, ".text", 0x2d, true},
+		{"  1 .data 00000000", 1, ".data", 0, true},
+		{"                  CONTENTS, ALLOC, LOAD, RELOC, READONLY, CODE", 0, "", 0, false},
+		{"  1 .data", 0, "", 0, false},
+		{"  1 .data zz", 0, "", 0, false},
+	} {
+		idx, name, size, ok := parseSecLine(tc.line)
+		if ok != tc.valid || idx != tc.idx || name != tc.name || size != tc.size {
+			t.Errorf("parseSecLine(%q) = %d, %q, %#x, %v", tc.line, idx, name, size, ok)
+		}
+	}
+}
+
+func TestSymtabLayouts(t *testing.T) {
+	exe := buildTool(t)
+	report := func(dump string) string {
+		cmd := exec.Command(exe, "-watch=callfoo", "-from-dump", filepath.Join("testdata", dump))
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		out := string(b)
+		out = out[strings.Index(out, "Sections:"):]
+		return strings.ReplaceAll(out, dump, "DUMP")
+	}
+	want := report("srcdebug.dump.txt")
+	if got := report("srcdebug.llvm18.dump.txt"); got != want {
+		t.Errorf("LLVM 18 layout:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLongSectionNames(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "srcdebug.o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite the "/N" section names in the "//" base64 form.
+	nsects := int(binary.LittleEndian.Uint16(content[2:]))
+	for i := 0; i < nsects; i++ {
+		sn := content[20+i*40 : 28+i*40]
+		if sn[0] != '/' {
+			continue
+		}
+		off, err := strconv.Atoi(string(bytes.TrimRight(sn[1:], "\x00")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b64 := make([]byte, 6)
+		for j := 5; j >= 0; j-- {
+			b64[j] = base64Digits[off%64]
+			off /= 64
+		}
+		copy(sn, "//"+string(b64))
+	}
+	patched := filepath.Join(t.TempDir(), "srcdebug.o")
+	if err := os.WriteFile(patched, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{".text", ".data", ".bss", ".debug_info", ".debug_abbrev", ".debug_aranges", ".debug_line"}
+	for _, op := range []string{filepath.Join("testdata", "srcdebug.o"), patched} {
+		f, _, err := openCOFF(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, sect := range f.Sections {
+			got = append(got, sect.Name)
+		}
+		f.Close()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: section names %q, want %q", op, got, want)
+		}
+	}
+
+	// A bad base64 digit is an error, not a crash.
+	copy(content[20+3*40:], "//AA*AAA")
+	if err := os.WriteFile(patched, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := openCOFF(patched); err == nil || !strings.Contains(err.Error(), "bad name") {
+		t.Errorf("openCOFF with bad section name: got error %v", err)
+	}
+}
+
+func TestSectionAux(t *testing.T) {
+	exe := buildTool(t)
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, args...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		return string(b)
+	}
+	op := filepath.Join("testdata", "comdat.o")
+	want := []string{
+		" O0: 0 \".text\" 0xc\n",
+		" O0: 3 \".text\" 0x6 comdat=ANY checksum=0xede50bb8\n",
+		" O0: 4 \".rdata\" 0x6 comdat=EXACT_MATCH checksum=0x672d789d\n",
+		" O0: 5 \".xdata\" 0x4 comdat=ASSOCIATIVE(3) checksum=0xb8bc6765\n",
+	}
+	check := func(out string) {
+		t.Helper()
+		for _, w := range want {
+			if !strings.Contains(out, w) {
+				t.Errorf("output missing %q:\n%s", w, out)
+			}
+		}
+		if strings.Contains(out, "warning:") {
+			t.Errorf("unexpected warning:\n%s", out)
+		}
+	}
+	check(run(op))
+	checkDumper(t, op)
+	check(run("-backend=llvm", op))
+
+	// A relocation count that doesn't match what was read is flagged.
+	dump, err := os.ReadFile(filepath.Join("testdata", "srcdebug.dump.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.dump.txt")
+	dump = bytes.Replace(dump, []byte("scnlen 0x2d nreloc 3"), []byte("scnlen 0x2d nreloc 4"), 1)
+	if err := os.WriteFile(bad, dump, 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := run("-from-dump", bad)
+	if w := "warning: " + bad + ": read 3 relocations for .text, but its section header says 4\n"; !strings.Contains(out, w) {
+		t.Errorf("output missing %q:\n%s", w, out)
+	}
+}
+
+func TestParseAuxSecDumpbin(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want secaux
+		ok   bool
+	}{
+		{"    Section length   2D, #relocs    3, #linenums    0, checksum 384FBF0B",
+			secaux{nreloc: 3, checksum: 0x384fbf0b}, true},
+		{"    Section length    6, #relocs    1, #linenums    0, checksum EDE50BB8, selection    2 (pick any)",
+			secaux{nreloc: 1, checksum: 0xede50bb8, selection: 2}, true},
+		{"    Section length    4, #relocs    0, #linenums    0, checksum B8BC6765, selection    5 (pick associative Section 0x4)",
+			secaux{checksum: 0xb8bc6765, number: 4, selection: 5}, true},
+		{"    Section length   1A, #relocs   12, #linenums    0, checksum        0",
+			secaux{nreloc: 0x12}, true},
+		{"    testdata\\srcdebug.s", secaux{}, false},
+	} {
+		got, ok := parseAuxSecDumpbin(tc.line)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("parseAuxSecDumpbin(%q) = %+v, %v, want %+v, %v", tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	inputs := []string{
+		filepath.Join("testdata", "srcdebug.o"),
+		filepath.Join("testdata", "filesym.o"),
+		filepath.Join("testdata", "sample.o"),
+	}
+	checkDumper(t, inputs[0])
+	exe := buildTool(t)
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, append([]string{"-backend=llvm", "-watch=callfoo"}, args...)...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		return string(b)
+	}
+	want := run(inputs...)
+	if got := run(append([]string{"-batch=2"}, inputs...)...); got != want {
+		t.Errorf("-batch=2 output:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A bad object fails its batch, but doesn't keep the others in
+	// it from being read.
+	bad := filepath.Join(t.TempDir(), "bad.o")
+	if err := os.WriteFile(bad, []byte("not an object"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := run("-batch=4", "-continue-on-error", "-v=1", inputs[0], bad, inputs[1], inputs[2])
+	for _, want := range []string{
+		"batch of 4 objects failed, dumping them one at a time",
+		"error: reading " + bad + " (pass1): ",
+		" O0: " + inputs[0] + " \n",
+		" O1: " + bad + " [skipped: pass1 failed] \n",
+		" \"callfoo\":  defbase\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	// The built-in reader can't batch, and says so.
+	out = run("-backend=native", "-batch=2", inputs[0])
+	if want := "warning: -batch has no effect with the native backend"; !strings.Contains(out, want) {
+		t.Errorf("output missing %q:\n%s", want, out)
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	out := "\na.o:\tfile format coff-x86-64\n\nSYMBOL TABLE:\n" +
+		"\nb.o:\tnot a header\n" +
+		"\nb.o:     file format pe-x86-64\n\nSYMBOL TABLE:\n"
+	var a, b strings.Builder
+	if err := splitBatch(strings.NewReader(out), []string{"a.o", "b.o"}, []io.Writer{&a, &b}); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{a.String(), b.String()}
+	want := []string{
+		"\na.o:\tfile format coff-x86-64\n\nSYMBOL TABLE:\n\nb.o:\tnot a header\n",
+		"\nb.o:     file format pe-x86-64\n\nSYMBOL TABLE:\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if err := splitBatch(strings.NewReader(out), []string{"a.o", "c.o"}, []io.Writer{&a, &b}); err == nil {
+		t.Errorf("missing file not reported")
+	}
+}
+
+func TestDumpStream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake dumper script needs a Unix shell")
+	}
+	script := filepath.Join(t.TempDir(), "fake-objdump")
+	run := func(body string) io.Reader {
+		if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body), 0777); err != nil {
+			t.Fatal(err)
+		}
+		od := &objdumpDumper{name: "fake", argv: []string{script}}
+		r, err := od.run("x.o")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	perr := errors.New("parse error")
+	failParse := func(io.Reader) error { return perr }
+
+	// A parse error is reported, with the rest of the output drained
+	// and the dumper reaped.
+	r := run("seq 100000\n")
+	if err := readStream(r, nil, failParse); err != perr {
+		t.Errorf("got error %v, want %v", err, perr)
+	}
+	if ds := r.(*dumpStream); !ds.done || ds.cmd.ProcessState == nil {
+		t.Errorf("dumper not reaped")
+	}
+
+	// A failure of the dumper takes precedence over the parse error.
+	r = run("seq 100000\necho oops >&2\nexit 2\n")
+	want := "running fake on x.o: exit status 2\n\toops"
+	if err := readStream(r, nil, failParse); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	// Output is passed through intact.
+	var sb strings.Builder
+	r = run("echo hello\n")
+	if err := readStream(r, &sb, func(r io.Reader) error { return nil }); err != nil || sb.String() != "hello\n" {
+		t.Errorf("got %q, %v", sb.String(), err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("slow dumper script needs a Unix shell, and SIGINT")
+	}
+	exe := buildTool(t)
+	dir := t.TempDir()
+	pidfile := filepath.Join(dir, "pid")
+	slow := filepath.Join(dir, "slow-objdump")
+	script := "#!/bin/sh\n[ \"$1\" = --version ] && echo slow && exit 0\necho $$ > " + pidfile + "\nexec sleep 30\n"
+	if err := os.WriteFile(slow, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	sp := filepath.Join("testdata", "sample.o")
+	cmd := exec.Command(exe, "-objdump="+slow, sp)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var pid []byte
+	for i := 0; i < 100; i++ {
+		if pid, _ = os.ReadFile(pidfile); len(pid) != 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(pid) == 0 {
+		cmd.Process.Kill()
+		t.Fatalf("dumper not started")
+	}
+	cmd.Process.Signal(os.Interrupt)
+	err := cmd.Wait()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 130 {
+		t.Errorf("got %v, want exit status 130", err)
+	}
+	if want := "interrupted while reading " + sp + " (pass1)\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("output missing %q:\n%s", want, out.String())
+	}
+	// The dumper should have been killed.
+	if err := exec.Command("kill", "-0", strings.TrimSpace(string(pid))).Run(); err == nil {
+		t.Errorf("dumper still running")
+	}
+}
+
+func TestKeepTemp(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake dumper script needs a Unix shell")
+	}
+	exe := buildTool(t)
+	dir := t.TempDir()
+	// A dump with a bad symbol table line.
+	dump, err := os.ReadFile(filepath.Join("testdata", "srcdebug.dump.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(dump), "\n")
+	badline := 0
+	for i, line := range lines {
+		if line == "SYMBOL TABLE:" {
+			badline = i + 2
+			lines[i+1] = "[garbage"
+			break
+		}
+	}
+	bad := filepath.Join(dir, "bad.dump.txt")
+	if err := os.WriteFile(bad, []byte(strings.Join(lines, "\n")), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fake := filepath.Join(dir, "fake-objdump")
+	script := "#!/bin/sh\n[ \"$1\" = --version ] && echo fake && exit 0\necho warning >&2\ncat " + bad + "\n"
+	if err := os.WriteFile(fake, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, args...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err == nil {
+			t.Fatalf("bad dump accepted:\n%s", b)
+		}
+		return string(b)
+	}
+
+	kt := filepath.Join(dir, "kt")
+	op := filepath.Join("testdata", "srcdebug.o")
+	out := run("-objdump="+fake, "-keep-temp="+kt, op)
+	saved := filepath.Join(kt, "srcdebug.o.pass1.txt")
+	if want := fmt.Sprintf("bad line [garbage in symtab (at line %d of %s)", badline, saved); !strings.Contains(out, want) {
+		t.Errorf("output missing %q:\n%s", want, out)
+	}
+	if b, err := os.ReadFile(saved); err != nil || string(b) != strings.Join(lines, "\n") {
+		t.Errorf("saved dump wrong (%v):\n%s", err, b)
+	}
+	if b, err := os.ReadFile(filepath.Join(kt, "srcdebug.o.pass1.stderr.txt")); err != nil || string(b) != "warning\n" {
+		t.Errorf("saved stderr wrong (%v): %q", err, b)
+	}
+
+	// Likewise for -from-dump, where the dump is the input, and
+	// without -keep-temp, when there is no saved dump to refer to.
+	out = run("-from-dump=" + bad)
+	if want := fmt.Sprintf("bad line [garbage in symtab (at line %d of %s)", badline, bad); !strings.Contains(out, want) {
+		t.Errorf("output missing %q:\n%s", want, out)
+	}
+	out = run("-objdump="+fake, op)
+	if want := "bad line [garbage in symtab\n"; !strings.Contains(out, want) {
+		t.Errorf("output missing %q:\n%s", want, out)
+	}
+}
+
+func TestELF(t *testing.T) {
+	exe := buildTool(t)
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, args...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		return string(b)
+	}
+	op := filepath.Join("testdata", "elf.o")
+	out := run("-format-version=2", "-all", op)
+	for _, want := range []string{
+		"Machine: amd64-elf\n",
+		" O0: " + op + " elf.c\n",
+		" O0: 1 \".text\" 0x17\n",
+		" \"callbar\":  defbase (",
+		" \"barptr\":  defbase (",
+		// Two references from .text, one from .data.
+		"   0: O=0 S=.text,.data [0x7/PLT32 0xe/REX_GOTPCRELX 0x0/64] " + op + "\n",
+		" \"foo\":  refbase (",
+	} {
+		if !containsReport(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	// Local symbols aren't recorded.
+	if strings.Contains(out, "helper") {
+		t.Errorf("local symbol recorded:\n%s", out)
+	}
+
+	// ELF and COFF objects for the same architecture aren't mixed.
+	sp := filepath.Join("testdata", "sample.o")
+	b, err := exec.Command(exe, op, sp).CombinedOutput()
+	if want := "\tamd64-elf: " + op + "\n\tamd64: " + sp + "\n"; err == nil || !strings.Contains(string(b), want) {
+		t.Errorf("mixed inputs: got error %v, output:\n%s\nwant %q", err, b, want)
+	}
+
+	// Excerpts come from objdump as usual.
+	checkDumper(t, op)
+	out = run("-watch=bar", op)
+	if want := "=-= ref O0 off=0xe:\n"; !strings.Contains(out, want) {
+		t.Errorf("output missing %q:\n%s", want, out)
+	}
+}
+
+func TestLongDumpLine(t *testing.T) {
+	defer func(f string, m int) { flavor, maxDumpLine = f, m }(flavor, maxDumpLine)
+	flavor = flavorLLVM
+	long := strings.Repeat("x", 2<<20)
+	dump := "\nx.o:\tfile format coff-x86-64\n\nSYMBOL TABLE:\n" +
+		"[ 0](sec  0)(fl 0x00)(ty   0)(scl   2) (nx 0) 0x00000000 " + long + "\n" +
+		"[ 1](sec  0)(fl 0x00)(ty   0)(scl   2) (nx 0) 0x00000000 __imp_foo\n\n"
+	op := filepath.Join("testdata", "sample.o")
+
+	// Lines of a few megabytes are fine...
+	s := newState([]string{op}, []string{op})
+	if err := s.readSymbolNames(strings.NewReader(dump)); err != nil {
+		t.Fatalf("readSymbolNames: %v", err)
+	}
+	if err := s.digest(strings.NewReader(dump)); err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	if !s.all["__imp_foo"] || len(s.refs["__imp_foo"]) != 1 {
+		t.Errorf("symbol after long line missed: all %v refs %v", s.all["__imp_foo"], s.refs["__imp_foo"])
+	}
+
+	// ... but past the limit, the line is reported rather than
+	// the rest of the dump being silently dropped.
+	maxDumpLine = 1 << 20
+	s = newState([]string{op}, []string{op})
+	want := "line 5 of dump is longer than 1048576 bytes"
+	if err := s.readSymbolNames(strings.NewReader(dump)); err == nil || err.Error() != want {
+		t.Errorf("readSymbolNames: got error %v, want %q", err, want)
+	}
+	if err := s.digest(strings.NewReader(dump)); err == nil || err.Error() != want {
+		t.Errorf("digest: got error %v, want %q", err, want)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	op := filepath.Join("testdata", "sample.o")
+	s := newState([]string{op}, []string{op})
+	s.defref["_errno"] = refimp
+	s.refs["__imp__errno"] = reflist{{objidx: 0, relocs: relocsAt(0x5b8, 0x5e6)}}
+	s.defref["a<b>&\"c"] = defbase
+	s.refs["a<b>&\"c"] = reflist{{objidx: 0, secidx: 1, def: true}}
+	// Plenty of symbols, as for an -all run on a large object set.
+	for i := 0; i < 10000; i++ {
+		x := fmt.Sprintf("sym%05d", i)
+		s.defref[x] = refbase
+		s.refs[x] = reflist{{objidx: 0, relocs: relocsAt(i)}}
+	}
+	out := filepath.Join(t.TempDir(), "report.html")
+	if err := s.writeHTML(out); err != nil {
+		t.Fatalf("writeHTML: %v", err)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := string(content)
+	for _, want := range []string{
+		`<tr id="_errno"><td class="sym"><a href="#_errno">_errno</a>`,
+		`<div class="refs" id="__imp__errno">__imp__errno:<ul>`,
+		`<a href="#O0">O0</a> S=UNDEF [0x5b8 0x5e6] testdata/sample.o</li>`,
+		`<tr id="a&lt;b&gt;&amp;&#34;c">`,
+		`<tr id="sym09999">`,
+	} {
+		if !strings.Contains(b, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+	if strings.Contains(b, "a<b>") {
+		t.Errorf("symbol name not escaped in report")
+	}
+	if len(b) > 5<<20 {
+		t.Errorf("report for 10000 symbols is %d bytes", len(b))
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+
+	cmd := exec.Command(exe, "-o=-", op)
+	want, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "sub")
+	rep := filepath.Join(dir, "report.txt")
+	cmd = exec.Command(exe, "-o="+rep, op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if len(b) != 0 {
+		t.Errorf("unexpected output on stdout with -o:\n%s", b)
+	}
+	got, err := os.ReadFile(rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("report file differs from stdout report:\n%s", got)
+	}
+
+	// A failed run leaves no report, complete or otherwise.
+	rep2 := filepath.Join(dir, "report2.txt")
+	cmd = exec.Command(exe, "-o="+rep2, op, filepath.Join("testdata", "nonexistent.o"))
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("run with missing input succeeded")
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 {
+		var names []string
+		for _, e := range ents {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files left in report directory: %v", names)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			filepath.Join("testdata", "breakdown.tmpl"),
+			"__acrt_iob_func\trefimp\n_errno\trefimp\n",
+		},
+		{
+			filepath.Join("testdata", "refs.tmpl"),
+			"__imp___acrt_iob_func testdata/sample.o S=0 0xa6 0xe8 0xa69\n" +
+				"__imp__errno testdata/sample.o S=0 0x5b8 0x5e6 0x636 0x678 0x698 0x6c6\n",
+		},
+		{
+			`{{range .Sections}}{{.Index}}:{{.Name}}={{hex .Size}} {{end}}`,
+			"0:.text=0x17df 1:.data=0x54 2:.bss=0x40024 3:.rdata=0x118 4:.xdata=0x3a4 ",
+		},
+		{
+			`{{len .Objects}} {{(index .Objects 0).Provenance}}`,
+			"1 test.cgo2.c",
+		},
+		{
+			`{{range .Refs}}{{.Sym}}{{range .Refs}} {{.Types}}{{end}}{{"\n"}}{{end}}`,
+			"__imp___acrt_iob_func [REL32 REL32 REL32]\n" +
+				"__imp__errno [REL32 REL32 REL32 REL32 REL32 REL32]\n",
+		},
+	}
+	for _, tc := range tests {
+		cmd := exec.Command(exe, "-format="+tc.format, op)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("run error: %v", err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("-format=%s: got:\n%s\nwant:\n%s", tc.format, b, tc.want)
+		}
+	}
+
+	// Mistakes in the template are reported before anything is
+	// read.
+	cmd := exec.Command(exe, "-format={{range .Breakdown}}\n{{.Sym}", filepath.Join("testdata", "nonexistent.o"))
+	b, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("run with bad template succeeded")
+	}
+	want := "bad -format: template: -format:2: "
+	if !strings.HasPrefix(string(b), want) {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
+func TestBrief(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, append(args, op)...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("run error: %v", err)
+		}
+		return string(b)
+	}
+	full := run("-watch=bar")
+	brief := run("-brief", "-watch=bar")
+
+	// The breakdown is as in the full report, and the excerpts
+	// follow it.
+	want := "Def/ref breakdown:\n \"bar\":  refbase refimp\n \"foo\":  refimp\n"
+	if !strings.HasPrefix(brief, want) {
+		t.Errorf("-brief output doesn't start with the breakdown:\n%s", brief)
+	}
+	i := strings.Index(full, want)
+	if i == -1 {
+		t.Fatalf("full output missing breakdown:\n%s", full)
+	}
+	// The full report has an extra empty line after the state.
+	if rest := strings.Replace(full[i:], want+"\n", want, 1); rest != brief {
+		t.Errorf("-brief output differs from the end of the full report:\ngot:\n%s\nwant:\n%s", brief, rest)
+	}
+
+	if got := run("-brief", "-no-excerpts", "-watch=bar"); got != want {
+		t.Errorf("-brief -no-excerpts: got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestByObject(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	sp := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+
+	cmd := exec.Command(exe, "-by-object", "-watch=callfoo", op, sp)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "Objects:\n" +
+		" O0: testdata/srcdebug.o \n" +
+		"  Sections:\n" +
+		"   0 \".text\" 0x2d\n" +
+		"   1 \".data\" 0x0\n" +
+		"   2 \".bss\" 0x0\n" +
+		"  Defs:\n" +
+		"   \"callfoo\" sec=1 val=0x0\n" +
+		"  Refs:\n" +
+		"   \"__imp_bar\" S=0 [0x1d]\n" +
+		"   \"__imp_foo\" S=0 [0x7]\n" +
+		"   \"bar\" S=0 [0x24]\n" +
+		" O1: testdata/sample.o \n" +
+		"  Sections:\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+	want = "  Refs:\n" +
+		"   \"__imp___acrt_iob_func\" S=0 [0xa6 0xe8 0xa69]\n" +
+		"   \"__imp__errno\" S=0 [0x5b8 0x5e6 0x636 0x678 0x698 0x6c6]\n" +
+		"Def/ref breakdown:\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+	for _, s := range []string{"\nSections:", "\nDefs:", "\nRefs:"} {
+		if strings.Contains(string(b), s) {
+			t.Errorf("output has symbol-first section %q:\n%s", s, b)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	defer func(v string) { *sortflag = v }(*sortflag)
+	op := filepath.Join("testdata", "sample.o")
+	s := newState([]string{op, op, op}, []string{op, op, op})
+	s.defref = map[string]defrefmask{
+		"a": refimp,
+		"b": defbase | refbase,
+		"c": refimp,
+		"d": defbase,
+	}
+	s.refs = map[string]reflist{
+		"__imp_a": {{objidx: 0, relocs: relocsAt(1)}},
+		"b": {
+			{objidx: 0, def: true, relocs: relocsAt(1, 2)},
+			{objidx: 1, relocs: relocsAt(1)},
+		},
+		"__imp_c": {
+			{objidx: 1, relocs: relocsAt(1)},
+			{objidx: 2, relocs: relocsAt(1)},
+		},
+		"d": {{objidx: 2, def: true}},
+	}
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{sortName, []string{"a", "b", "c", "d"}},
+		{sortNrefs, []string{"b", "c", "a", "d"}},
+		{sortNobjs, []string{"b", "c", "a", "d"}},
+		{sortCategory, []string{"d", "b", "a", "c"}},
+	}
+	for _, tc := range tests {
+		*sortflag = tc.sort
+		if got := s.breakdownSyms(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("-sort=%s: breakdown order %v, want %v", tc.sort, got, tc.want)
+		}
+		if got := s.refGroups(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("-sort=%s: Refs order %v, want %v", tc.sort, got, tc.want)
+		}
+	}
+	// Ties are broken alphabetically.
+	s.refs["__imp_a"] = append(s.refs["__imp_a"], refinfo{objidx: 1, relocs: relocsAt(2, 3)})
+	*sortflag = sortNrefs
+	if got, want := s.breakdownSyms(), []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("-sort=nrefs with ties: got %v, want %v", got, want)
+	}
+}
+
+func TestColor(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	run := func(env []string, args ...string) string {
+		cmd := exec.Command(exe, append(args, "-watch=bar", op)...)
+		cmd.Env = append(os.Environ(), env...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("run error: %v", err)
+		}
+		return string(b)
+	}
+	plain := run(nil, "-color=never")
+	if strings.Contains(plain, "\x1b[") {
+		t.Errorf("-color=never output has escape sequences:\n%q", plain)
+	}
+	// Not a terminal, so no color.
+	if got := run(nil); got != plain {
+		t.Errorf("-color=auto output to a pipe differs from -color=never:\n%q", got)
+	}
+	colored := run([]string{"NO_COLOR=1"}, "-color=always")
+	want := "\x1b[33m \"foo\":  refimp\x1b[0m\n"
+	if !strings.Contains(colored, want) {
+		t.Errorf("-color=always output missing %q:\n%q", want, colored)
+	}
+	want = "\x1b[1m \"bar\":  refbase refimp\x1b[0m\n"
+	if !strings.Contains(colored, want) {
+		t.Errorf("-color=always output missing %q:\n%q", want, colored)
+	}
+	if got := regexp.MustCompile("\x1b\\[[0-9]*m").ReplaceAllString(colored, ""); got != plain {
+		t.Errorf("-color=always output without escapes differs from -color=never:\n%s", got)
+	}
+
+	// Never in a report file.
+	rep := filepath.Join(t.TempDir(), "report.txt")
+	run(nil, "-color=always", "-o="+rep)
+	if b, err := os.ReadFile(rep); err != nil {
+		t.Fatal(err)
+	} else if string(b) != plain {
+		t.Errorf("-color=always -o report differs from -color=never:\n%q", b)
+	}
+
+	defer func(v bool) { useColor = v }(useColor)
+	useColor = true
+	s := newState(nil, nil)
+	for drm, want := range map[defrefmask]string{
+		defbase | refimp:           ansiRed,
+		defbase | defimp | refimp:  ansiRed,
+		refimp:                     ansiYellow,
+		defimp | refimp | dsameobj: ansiGreen,
+		defbase | refbase:          "",
+	} {
+		s.defref["x"] = drm
+		if got := s.symColor("x"); got != want {
+			t.Errorf("color for%s: got %q, want %q", drm, got, want)
+		}
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	bl := filepath.Join(t.TempDir(), "baseline.txt")
+	if err := os.WriteFile(bl, []byte("foo\trefimp\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-ndjson", "-watch=callfoo", "-baseline="+bl, op)
+	t.Logf("cmd: %+v\n", cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != baselineDiffExit {
+		t.Fatalf("got %v, wanted exit status %d", err, baselineDiffExit)
+	}
+	want := `{"kind":"object","obj":0,"name":"testdata/srcdebug.o","label":"testdata/srcdebug.o","machine":"amd64",` +
+		`"sections":[{"idx":0,"name":".text","size":45},{"idx":1,"name":".data","size":0},{"idx":2,"name":".bss","size":0}],` +
+		`"defs":[{"sym":"callfoo","sec":1,"sec_name":".text","value":0}]}
+{"kind":"symbol","sym":"bar","categories":["refbase","refimp"],"nrefs":2,"nobjs":1}
+{"kind":"symbol","sym":"callfoo","categories":["defbase"],"nrefs":0,"nobjs":0}
+{"kind":"symbol","sym":"foo","categories":["refimp"],"nrefs":1,"nobjs":1}
+{"kind":"summary","objects":1,"analyzed":1,"symbols":3,"defbase":1,"refbase":1,"defimp":0,"refimp":2,"sameobj":0,"refimp_only":1,"refs":3,"exit":3,"format_version":1}
+`
+	if string(b) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b, want)
+	}
+	// The rest of the report goes to stderr.
+	for _, w := range []string{"Baseline differences", "excerpts from"} {
+		if !strings.Contains(stderr.String(), w) {
+			t.Errorf("stderr missing %q:\n%s", w, stderr.String())
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	sp := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+
+	cmd := exec.Command(exe, "-format-version=2", "-watch=callfoo", op, sp)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "Summary:\n" +
+		" objects: 2 (2 analyzed)\n" +
+		" symbols: 5\n" +
+		" defbase: 1 refbase: 1 defimp: 0 refimp: 4 sameobj: 0\n" +
+		" refimp only: 3\n" +
+		" references: 12\n" +
+		"Objects:\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+}
+
+func TestRelocsTSV(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	want := "objidx\tobject\tsection\toffset\ttype\tsymbol\n" +
+		"0\ttestdata/srcdebug.o\t.text\t0x7\tIMAGE_REL_AMD64_REL32\t__imp_foo\n" +
+		"0\ttestdata/srcdebug.o\t.text\t0x1d\tIMAGE_REL_AMD64_REL32\t__imp_bar\n" +
+		"0\ttestdata/srcdebug.o\t.text\t0x24\tIMAGE_REL_AMD64_REL32\tbar\n"
+	for _, backend := range []string{"native", "llvm"} {
+		tsv := filepath.Join(t.TempDir(), "relocs.tsv")
+		cmd := exec.Command(exe, "-backend="+backend, "-relocs-tsv="+tsv, "-watch=bar", op)
+		t.Logf("cmd: %+v\n", cmd)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		got, err := os.ReadFile(tsv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("-backend=%s: got:\n%s\nwant:\n%s", backend, got, want)
+		}
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	db := filepath.Join(t.TempDir(), "results.db")
+	// The second run with label "a" replaces the rows of the first.
+	for _, label := range []string{"a", "b", "a"} {
+		cmd := exec.Command(exe, "-sqlite="+db, "-run-label="+label, "-watch=bar", op)
+		t.Logf("cmd: %+v\n", cmd)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+	}
+	d, err := sql.Open("sqlite", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	queries := []string{
+		"SELECT run, COUNT(*) FROM refs GROUP BY run",
+		"SELECT COUNT(*) FROM objects",
+		"SELECT symbol, categories FROM breakdown WHERE run = 'a'",
+		"SELECT reloctype FROM refs WHERE run = 'b' AND symbol = '__imp_foo'",
+	}
+	var sb strings.Builder
+	for _, q := range queries {
+		rows, err := d.Query(q)
+		if err != nil {
+			t.Fatalf("querying %q: %v", q, err)
+		}
+		cols, _ := rows.Columns()
+		for rows.Next() {
+			vals := make([]string, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range vals {
+				ptrs[i] = &vals[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				t.Fatalf("querying %q: %v", q, err)
+			}
+			sb.WriteString(strings.Join(vals, "|") + "\n")
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("querying %q: %v", q, err)
+		}
+		rows.Close()
+	}
+	want := "a|3\nb|3\n2\nbar|refbase refimp\nfoo|refimp\nIMAGE_REL_AMD64_REL32\n"
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type graphml struct {
+		Nodes []struct {
+			ID   string `xml:"id,attr"`
+			Data []data `xml:"data"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+			Data   []data `xml:"data"`
+		} `xml:"graph>edge"`
+	}
+	kind := func(d []data) string {
+		for _, kv := range d {
+			if kv.Key == "kind" {
+				return kv.Value
+			}
+		}
+		return ""
+	}
+	tests := []struct {
+		watched bool
+		nodes   string
+		edges   string
+	}{
+		{false,
+			"o0:object s:__imp_bar:import s:__imp_foo:import s:bar:symbol s:foo:symbol",
+			"o0-references->s:__imp_bar o0-references->s:__imp_foo o0-references->s:bar s:__imp_bar-thunk-of->s:bar s:__imp_foo-thunk-of->s:foo"},
+		{true,
+			"o0:object s:__imp_bar:import s:bar:symbol",
+			"o0-references->s:__imp_bar o0-references->s:bar s:__imp_bar-thunk-of->s:bar"},
+	}
+	for _, tc := range tests {
+		gf := filepath.Join(t.TempDir(), "g.graphml")
+		cmd := exec.Command(exe, "-graphml="+gf, fmt.Sprintf("-graphml-watched=%v", tc.watched), "-watch=bar", op)
+		t.Logf("cmd: %+v\n", cmd)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		b, err := os.ReadFile(gf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var g graphml
+		if err := xml.Unmarshal(b, &g); err != nil {
+			t.Fatalf("parsing GraphML: %v\n%s", err, b)
+		}
+		var nodes, edges []string
+		for _, n := range g.Nodes {
+			nodes = append(nodes, n.ID+":"+kind(n.Data))
+		}
+		for _, e := range g.Edges {
+			edges = append(edges, e.Source+"-"+kind(e.Data)+"->"+e.Target)
+		}
+		if got := strings.Join(nodes, " "); got != tc.nodes {
+			t.Errorf("watched=%v: nodes got %q want %q", tc.watched, got, tc.nodes)
+		}
+		if got := strings.Join(edges, " "); got != tc.edges {
+			t.Errorf("watched=%v: edges got %q want %q", tc.watched, got, tc.edges)
+		}
+	}
+
+	if got, want := xmlEscape(`??$f@V<A&B>@@"`), "??$f@V&lt;A&amp;B&gt;@@&#34;"; got != want {
+		t.Errorf("xmlEscape: got %q want %q", got, want)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	exe := buildTool(t)
+	op1 := filepath.Join("testdata", "srcdebug.o")
+	op2 := filepath.Join("testdata", "filesym.o")
+	checkDumper(t, op1)
+
+	idx := filepath.Join(t.TempDir(), "index.txt")
+	cmd := exec.Command(exe, "-index="+idx, "-watch=callfoo,bar", op1, op2)
+	t.Logf("cmd: %+v\n", cmd)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	got, err := os.ReadFile(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "__imp_bar\t0:r 1:r\n" +
+		"__imp_foo\t0:r\n" +
+		"bar\t0:r\n" +
+		"callfoo\t0:d\n"
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestOnlyExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		drm  defrefmask
+		want bool
+	}{
+		{"refimp", refimp, true},
+		{"refimp && !defimp", refimp, true},
+		{"refimp && !defimp", refimp | defimp, false},
+		{"defbase && refimp", defbase | refbase | refimp, true},
+		{"defbase || defimp && refimp", defbase, true},
+		{"(defbase || defimp) && refimp", defbase, false},
+		{"!!sameobj", dsameobj | defbase | defimp, true},
+	}
+	for _, tc := range tests {
+		e, err := parseOnly(tc.expr)
+		if err != nil {
+			t.Errorf("parseOnly(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := e(tc.drm); got != tc.want {
+			t.Errorf("%q on%s: got %v want %v", tc.expr, tc.drm, got, tc.want)
+		}
+	}
+	bad := []struct {
+		expr, err string
+	}{
+		{"refimp && bogus", `unknown category "bogus" (valid: defbase, defimp, refbase, refimp, sameobj, splitobj)`},
+		{"refimp &&", "unexpected end of expression"},
+		{"(refimp", "missing )"},
+		{"refimp defimp", `unexpected "defimp"`},
+		{"refimp & defimp", `unexpected "& defimp"`},
+	}
+	for _, tc := range bad {
+		_, err := parseOnly(tc.expr)
+		want := fmt.Sprintf("bad -only %q: %s", tc.expr, tc.err)
+		if err == nil || err.Error() != want {
+			t.Errorf("parseOnly(%q): got error %v want %s", tc.expr, err, want)
+		}
+	}
+}
+
+func TestOnly(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"refimp && !refbase", "Def/ref breakdown:\n \"foo\":  refimp\n"},
+		{"refbase", "Def/ref breakdown:\n \"bar\":  refbase refimp\n"},
+		{"defimp", "Def/ref breakdown:\n"},
+	}
+	for _, tc := range tests {
+		cmd := exec.Command(exe, "-brief", "-no-excerpts", "-only="+tc.expr, "-watch=bar", op)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("-only=%q: got:\n%s\nwant:\n%s", tc.expr, b, tc.want)
+		}
+	}
+
+	// The refs of dropped symbols go too.
+	cmd := exec.Command(exe, "-no-excerpts", "-only=refimp && !refbase", "-watch=bar", op)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	if strings.Contains(string(b), "bar") {
+		t.Errorf("-only: dropped symbol in report:\n%s", b)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	exe := buildTool(t)
+	op1 := filepath.Join("testdata", "srcdebug.o")
+	op2 := filepath.Join("testdata", "filesym.o")
+	op3 := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op1)
+
+	// Columns line up in the Objects, Sections, Defs and Def/ref
+	// breakdown sections, and the offsets for _errno wrap.
+	cmd := exec.Command(exe, "-format-version=2", "-no-excerpts", "-refs-width=50", "-watch=callfoo,bar", op1, op2, op3)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	checkGolden(t, string(b), "Objects:", filepath.Join("testdata", "layout.golden"))
+
+	// With no wrapping, offset lists stay on one line. Each offset
+	// is given with its relocation type.
+	cmd = exec.Command(exe, "-format-version=2", "-no-excerpts", "-refs-width=0", op3)
+	b, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	want := "   0: O=0 S=.text [0x5b8/REL32 0x5e6/REL32 0x636/REL32 0x678/REL32 0x698/REL32 0x6c6/REL32] testdata/sample.o\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("output missing %q:\n%s", want, b)
+	}
+}
+
+func TestWrapList(t *testing.T) {
+	items := []string{"0x10", "0x20", "0x30", "0x40"}
+	tests := []struct {
+		width int
+		want  string
+	}{
+		{0, "  0: [0x10 0x20 0x30 0x40] x.o"},
+		{30, "  0: [0x10 0x20 0x30 0x40] x.o"},
+		{20, "  0: [0x10 0x20 0x30\n      0x40] x.o"},
+		{12, "  0: [0x10\n      0x20\n      0x30\n      0x40] x.o"},
+		{1, "  0: [0x10\n      0x20\n      0x30\n      0x40] x.o"},
+	}
+	for _, tc := range tests {
+		if got := wrapList("  0: ", items, " x.o", tc.width); got != tc.want {
+			t.Errorf("width %d: got %q want %q", tc.width, got, tc.want)
+		}
+	}
+}
+
+func TestTextFormats(t *testing.T) {
+	rows := " O0:\ta.o\tx.c\n O10:\tlonger.o\ty.c\n"
+	relocs := []relocinfo{
+		{off: 0x10, typ: "IMAGE_REL_AMD64_REL32"},
+		{off: 0x20, typ: "IMAGE_REL_AMD64_ADDR64"},
+		{off: 0x30},
+	}
+	tests := []struct {
+		version string
+		table   string
+		offsets string
+	}{
+		{"1",
+			" O0: a.o x.c\n O10: longer.o y.c\n",
+			"  0: [0x10 0x20 0x30] x.o"},
+		{"2",
+			" O0:  a.o      x.c\n O10: longer.o y.c\n",
+			"  0: [0x10/REL32\n      0x20/ADDR64\n      0x30] x.o"},
+	}
+	*refswidthflag = 20
+	defer func() { *refswidthflag = 100 }()
+	for _, tc := range tests {
+		f, err := parseFormatVersion(tc.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strconv.Itoa(f.version()); got != tc.version {
+			t.Errorf("version %s: got version %s", tc.version, got)
+		}
+		sb := &strings.Builder{}
+		tw := f.newTable(sb)
+		io.WriteString(tw, rows)
+		tw.Flush()
+		if got := sb.String(); got != tc.table {
+			t.Errorf("version %s: table got %q want %q", tc.version, got, tc.table)
+		}
+		if got := f.offsets("  0: ", relocs, " x.o"); got != tc.offsets {
+			t.Errorf("version %s: offsets got %q want %q", tc.version, got, tc.offsets)
+		}
+	}
+	if f, err := parseFormatVersion("latest"); err != nil || f.version() != formatLatest {
+		t.Errorf("latest: got %v, %v", f, err)
+	}
+	if _, err := parseFormatVersion("3"); err == nil {
+		t.Errorf("version 3: no error")
+	}
+}
+
+func TestShortRelocType(t *testing.T) {
+	for _, tc := range []struct {
+		typ, want string
+	}{
+		{"IMAGE_REL_AMD64_REL32", "REL32"},
+		{"IMAGE_REL_I386_DIR32", "DIR32"},
+		{"IMAGE_REL_ARM64_PAGEBASE_REL21", "PAGEBASE_REL21"},
+		{"R_X86_64_PLT32", "PLT32"},
+		{"REL32", "REL32"},
+		{"", ""},
+	} {
+		if got := (relocinfo{typ: tc.typ}).shortType(); got != tc.want {
+			t.Errorf("%q: got %q want %q", tc.typ, got, tc.want)
+		}
+	}
+}
+
+func TestBreakdownCounts(t *testing.T) {
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.refs["__imp__errno"] = reflist{
+		{objidx: 0, relocs: []relocinfo{{off: 1}, {off: 2}}},
+		{objidx: 1, relocs: []relocinfo{{off: 3}}},
+	}
+	s.refs["bar"] = reflist{{objidx: 0, relocs: []relocinfo{{off: 4}}}}
+	s.refs["__imp_bar"] = reflist{{objidx: 1, relocs: []relocinfo{{off: 5}, {off: 6}}}}
+	s.refs["callfoo"] = reflist{{objidx: 0, def: true}}
+	counts := s.formRefCounts()
+	defer func() { textfmt = formatV1Text{} }()
+	for _, tc := range []struct {
+		f    textFormat
+		x    string
+		want string
+	}{
+		{formatV1Text{}, "_errno", ""},
+		{formatV2Text{}, "_errno", " (objs=2 relocs=3)"},
+		{formatV2Text{}, "bar", " (bar: objs=1 relocs=1, __imp_bar: objs=1 relocs=2)"},
+		{formatV2Text{}, "callfoo", " (objs=0 relocs=0)"},
+	} {
+		textfmt = tc.f
+		if got := s.countsTag(tc.x, counts); got != tc.want {
+			t.Errorf("v%d %s: got %q want %q", tc.f.version(), tc.x, got, tc.want)
+		}
+	}
+}
+
+func TestFormatVersionHeader(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-no-excerpts"}, "state: "},
+		{[]string{"-no-excerpts", "-format-version=1"}, "state: "},
+		{[]string{"-no-excerpts", "-format-version=2"}, "Format version: 2\nstate: "},
+		{[]string{"-brief", "-format-version=2"}, "Format version: 2\nDef/ref breakdown:\n"},
+	} {
+		cmd := exec.Command(exe, append(tc.args, op)...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		if !strings.HasPrefix(string(b), tc.want) {
+			t.Errorf("%v: output doesn't start with %q:\n%s", tc.args, tc.want, b)
+		}
+	}
+}
+
+func TestFormatV1Baseline(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+
+	// The golden files hold the reports written by the first version
+	// of the tool (the baseline commit) for these runs, which
+	// version 1 of the layout reproduces byte for byte.
+	for _, tc := range []struct {
+		args   []string
+		golden string
+	}{
+		{[]string{"-i=testdata/sample.o", "-watch=foo,bar,_errno"}, "v1.sample.golden"},
+		{[]string{"-i=testdata/sample.o,testdata/srcdebug.o"}, "v1.pair.golden"},
+		{[]string{"-all", "-i=testdata/srcdebug.o", "-watch=callfoo"}, "v1.all.golden"},
+	} {
+		want, err := os.ReadFile(filepath.Join("testdata", tc.golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, extra := range [][]string{nil, {"-format-version=1"}, {"-backend=llvm"}} {
+			args := append(append([]string(nil), extra...), tc.args...)
+			cmd := exec.Command(exe, args...)
+			t.Logf("cmd: %+v\n", cmd)
+			b, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+			if string(b) != string(want) {
+				t.Errorf("%v: output does not match %s:\ngot:\n%s\nwant:\n%s", args, tc.golden, b, want)
+			}
+		}
+	}
+}
+
+func TestSectionNames(t *testing.T) {
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.objidx = 0
+	s.newSection(".text", 0x10, 0)
+	s.newSection(".idata$5", 0x8, 1)
+	s.objidx = 1
+	s.newSection(".data", 0x4, 0)
+	s.newSection(".text", 0x20, 1)
+	tests := []struct {
+		obj, sec int
+		want     string
+	}{
+		{0, 1, ".text"},
+		{0, 2, ".idata$5"},
+		{1, 1, ".data"},
+		{1, 2, ".text"},
+		{1, 3, "3"},
+		{0, 0, "UNDEF"},
+		{0, -1, "ABS"},
+		{0, -2, "DEBUG"},
+	}
+	for _, tc := range tests {
+		if got := s.secName(tc.obj, tc.sec); got != tc.want {
+			t.Errorf("secName(%d, %d): got %q want %q", tc.obj, tc.sec, got, tc.want)
+		}
+	}
+	if si := s.symSection(1, 2); si == nil || si.size != 0x20 {
+		t.Errorf("symSection(1, 2): got %+v", si)
+	}
+
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "comdat.o")
+	checkDumper(t, op)
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, append(args, "-no-excerpts", "-watch=caller,inlfn,strconst", op)...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		return string(b)
+	}
+	want := []string{
+		" 0: \"caller\"   obj=0 sec=.text  val=0x0\n",
+		" 1: \"inlfn\"    obj=0 sec=.text  val=0x0 comdat=ANY\n",
+		" 2: \"strconst\" obj=0 sec=.rdata val=0x0 comdat=EXACT_MATCH\n",
+		"  *0: O=0 S=.rdata [] testdata/comdat.o\n",
+	}
+	out := run("-format-version=2")
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("output missing %q:\n%s", w, out)
+		}
+	}
+	// Version 1 still has the numbers.
+	out = run()
+	if w := " 1: \"inlfn\" obj=0 sec=4 val=0x0 comdat=ANY\n"; !strings.Contains(out, w) {
+		t.Errorf("output missing %q:\n%s", w, out)
+	}
+}
+
+func TestXrefs(t *testing.T) {
+	exe := buildTool(t)
+	op1 := filepath.Join("testdata", "srcdebug.o")
+	op2 := filepath.Join("testdata", "filesym.o")
+	checkDumper(t, op1)
+
+	want := "Xrefs:\n" +
+		" \"__imp_bar\":\n  O0 callbar\n  O1 f\n" +
+		" \"__imp_foo\":\n  O0 callfoo\n" +
+		"Def/ref breakdown:\n"
+	for _, backend := range []string{"native", "llvm"} {
+		cmd := exec.Command(exe, "-backend="+backend, "-xrefs", "-no-excerpts", op1, op2)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		if !strings.Contains(string(b), want) {
+			t.Errorf("-backend=%s: output missing %q:\n%s", backend, want, b)
+		}
+	}
+
+	// Relocations outside any function go to the section.
+	s := newState([]string{"a.o"}, []string{"a.o"})
+	s.newSection(".text", 0x40, 0)
+	s.newSection(".rdata", 0x10, 1)
+	*xrefsflag = true
+	defer func() { *xrefsflag = false }()
+	defs := make(map[string]struct{})
+	for _, sym := range []struct {
+		name          string
+		secnum, value int
+	}{
+		{".text", 1, 0}, {"f", 1, 0x8}, {"g", 1, 0x20}, {"table", 2, 0},
+	} {
+		if err := s.addSymbol(sym.name, sym.secnum, sym.value, defs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		r    relocinfo
+		want string
+	}{
+		{relocinfo{off: 0x4, sect: ".text"}, ".text"},
+		{relocinfo{off: 0x8, sect: ".text"}, "f"},
+		{relocinfo{off: 0x1f, sect: ".text"}, "f"},
+		{relocinfo{off: 0x30, sect: ".text"}, "g"},
+		{relocinfo{off: 0x4, sect: ".rdata"}, ".rdata"},
+	} {
+		if got := s.refFunction(0, tc.r); got != tc.want {
+			t.Errorf("refFunction(%+v): got %q want %q", tc.r, got, tc.want)
+		}
+	}
+}
+
+func TestSplitDefs(t *testing.T) {
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.defs["__imp_foo"] = []definfo{{objidx: 0}}
+	s.defs["foo"] = []definfo{{objidx: 1}}
+	s.defs["__imp_bar"] = []definfo{{objidx: 1}}
+	s.defs["bar"] = []definfo{{objidx: 1}}
+	s.markDefPairs()
+	if got, want := s.defref["foo"], dsplitobj; got != want {
+		t.Errorf("foo: got%s want%s", got, want)
+	}
+	if got, want := s.defref["bar"], dsameobj; got != want {
+		t.Errorf("bar: got%s want%s", got, want)
+	}
+	var sb strings.Builder
+	s.writeSplitDefs(&sb)
+	want := "Split definitions:\n" +
+		" \"__imp_foo\": O0 a.o, \"foo\": O1 b.o\n"
+	if sb.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+	// Running it again (as after a -load merge) doesn't duplicate pairs.
+	s.markDefPairs()
+	if len(s.splitdefs) != 1 {
+		t.Errorf("got %d split pairs after rerun, want 1", len(s.splitdefs))
+	}
+}
+
+func TestCheckResolved(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "i386.o")
+	checkDumper(t, op)
+
+	// Unresolved imports make the run fail with -check-resolved.
+	cmd := exec.Command(exe, "-check-resolved", "-no-excerpts", op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != unresolvedExit {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("got %v, wanted exit status %d", err, unresolvedExit)
+	}
+	want := "Unresolved imports:\n" +
+		" \"__imp_@fastimp@4\": O0 testdata/i386.o\n" +
+		" \"__imp__CreateFileA@4\": O0 testdata/i386.o\n" +
+		" \"__imp__printf\": O0 testdata/i386.o\n" +
+		"Unresolved base symbols:\n" +
+		" \"_CreateFileA@4\": O0 testdata/i386.o\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("got:\n%s\nwant to contain:\n%s", b, want)
+	}
+
+	// Only watched symbols are listed with -watch.
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.refs["__imp_foo"] = reflist{{objidx: 1, relocs: []relocinfo{{off: 1}}}, {objidx: 0, relocs: []relocinfo{{off: 2}}}}
+	s.refs["__imp_bar"] = reflist{{objidx: 0, relocs: []relocinfo{{off: 3}}}}
+	s.refs["__imp_baz"] = reflist{{objidx: 0, relocs: []relocinfo{{off: 4}}}}
+	s.defref["foo"] = refimp
+	s.defref["bar"] = refimp
+	s.defref["baz"] = refimp | defimp
+	defer func() { watched = nil }()
+	watched = map[string]bool{"foo": true, "__imp_foo": true, "baz": true, "__imp_baz": true}
+	imps, bases := s.unresolved()
+	if len(imps) != 1 || imps[0].sym != "__imp_foo" || len(bases) != 0 {
+		t.Fatalf("got %v %v, want only __imp_foo", imps, bases)
+	}
+	if got := imps[0].objs; len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Errorf("__imp_foo objects: got %v, want [0 1]", got)
+	}
+}
+
+func TestUnusedImpDefs(t *testing.T) {
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.addSection(secinfo{objidx: 0, idx: 1, name: ".idata$5"})
+	s.addSection(secinfo{objidx: 1, idx: 1, name: ".idata$5"})
+	// __imp_foo is used only by the object defining it, which
+	// doesn't count; __imp_bar is used via bar, and __imp_baz
+	// directly.
+	for i, sym := range []string{"__imp_foo", "__imp_bar", "__imp_baz"} {
+		s.defs[sym] = []definfo{{objidx: 0, secidx: 1, value: 8 * i}}
+		s.refs[sym] = reflist{{objidx: 0, secidx: 1, relocs: []relocinfo{{off: 4}}, def: true}}
+	}
+	s.defs["__imp_foo"] = append(s.defs["__imp_foo"], definfo{objidx: 1, secidx: 1, value: 0x10})
+	s.refs["bar"] = reflist{{objidx: 1, relocs: []relocinfo{{off: 1}}}}
+	s.refs["__imp_baz"] = append(s.refs["__imp_baz"], refinfo{objidx: 1, relocs: []relocinfo{{off: 2}}})
+
+	var sb strings.Builder
+	s.writeUnusedImpDefs(&sb)
+	want := "Unused import definitions:\n" +
+		" \"__imp_foo\": O0 sec=1 val=0x0 a.o\n" +
+		" \"__imp_foo\": O1 sec=1 val=0x10 b.o\n"
+	if sb.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+	if got := s.summary().UnusedImpDefs; got != 1 {
+		t.Errorf("summary: got %d unused import defs, want 1", got)
+	}
+}
+
+func TestWatchRE(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	// A pattern matching bar (and so __imp_bar) gives the same
+	// report, excerpts included, as watching bar.
+	run := func(flag string) string {
+		cmd := exec.Command(exe, flag, op)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		return string(b)
+	}
+	if got, want := run("-watchre=^b.r$"), run("-watch=bar"); got != want {
+		t.Errorf("-watchre report:\n%s\ndiffers from -watch report:\n%s", got, want)
+	}
+
+	// A bad pattern is an error.
+	cmd := exec.Command(exe, "-watchre=foo,(", op)
+	b, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(b), `bad -watchre pattern "("`) {
+		t.Errorf("bad pattern: got %v\n%s", err, b)
+	}
+
+	// Patterns aren't anchored.
+	defer func() { watchres = nil }()
+	watchres = []*regexp.Regexp{regexp.MustCompile("Nt")}
+	for _, tc := range []struct {
+		sym  string
+		want bool
+	}{
+		{"NtClose", true},
+		{"__imp_NtClose", true},
+		{"ZwNtThing", true},
+		{"CreateFileA", false},
+	} {
+		if got := watchedName(tc.sym); got != tc.want {
+			t.Errorf("watchedName(%q): got %v want %v", tc.sym, got, tc.want)
+		}
+	}
+}
+
+func TestExcludeSym(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+
+	cmd := exec.Command(exe, "-all", "-no-excerpts", "-v=1", "-watch=bar",
+		"-excludesym=bar,callfoo", `-excludesymre=^\$,^\.`, op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	out := string(b)
+	for _, want := range []string{
+		"warning: bar is both watched and excluded; excluding it\n",
+		"Def/ref breakdown:\n \"bar\":  refimp\n \"callbar\":  defbase\n \"foo\":  refimp\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if !regexp.MustCompile(`(?m)^O0 testdata/srcdebug.o: excluded \d+ symbols$`).MatchString(out) {
+		t.Errorf("no count of excluded symbols:\n%s", out)
+	}
+	for _, notwant := range []string{" \"bar\":\n", "\"callfoo\"", "\".text\":"} {
+		if strings.Contains(out, notwant) {
+			t.Errorf("output has excluded %q:\n%s", notwant, out)
+		}
+	}
+
+	// A bad pattern is an error.
+	cmd = exec.Command(exe, "-excludesymre=[", op)
+	if b, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(b), `bad -excludesymre pattern "["`) {
+		t.Errorf("bad pattern: got %v\n%s", err, b)
+	}
+}
+
+func TestNormalizeDecorations(t *testing.T) {
+	s := newState([]string{"a.o"}, []string{"a.o"})
+	s.machines[0] = "amd64"
+	s.all["__imp__GetTickCount@0"] = true
+	s.pass2()
+
+	// Without -normalize-decorations, only the raw base name is
+	// paired on amd64.
+	if !s.isInterestingSym("_GetTickCount@0") || s.isInterestingSym("GetTickCount") {
+		t.Errorf("unnormalized: got %v %v, want true false",
+			s.isInterestingSym("_GetTickCount@0"), s.isInterestingSym("GetTickCount"))
+	}
+	if x, _ := s.symKey("__imp__GetTickCount@0", 0); x != "_GetTickCount@0" {
+		t.Errorf("unnormalized symKey: got %q", x)
+	}
+
+	defer func(v bool) { *normalizedecorationsflag = v }(*normalizedecorationsflag)
+	*normalizedecorationsflag = true
+	// The undecorated alias is paired too, and all three forms are
+	// one breakdown symbol.
+	if !s.isInterestingSym("GetTickCount") {
+		t.Errorf("normalized: undecorated alias not interesting")
+	}
+	for _, sym := range []string{"__imp__GetTickCount@0", "_GetTickCount@0", "GetTickCount"} {
+		s.refs[sym] = reflist{{objidx: 0, relocs: []relocinfo{{off: 1}}}}
+		s.maskAddRef(sym, 0)
+	}
+	if len(s.defref) != 1 || s.defref["GetTickCount"] != refbase|refimp {
+		t.Errorf("breakdown: got %v", s.defref)
+	}
+	vl := s.variants()["GetTickCount"]
+	if got, want := variantsTag(vl), " {_GetTickCount@0 __imp__GetTickCount@0}"; got != want {
+		t.Errorf("variants: got %q want %q", got, want)
+	}
+}
+
+func TestImpPrefix(t *testing.T) {
+	defer setupImpPrefixes("")
+	if err := setupImpPrefixes("__imp_,__imp_aux_"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		sname string
+		base  string
+		imp   bool
+	}{
+		{"__imp_CreateFileA", "CreateFileA", true},
+		{"__imp_aux_CreateFileA", "CreateFileA", true},
+		{"CreateFileA", "CreateFileA", false},
+		{"__imp_", "__imp_", false},
+	} {
+		if base, imp := cutImp(tc.sname); base != tc.base || imp != tc.imp {
+			t.Errorf("cutImp(%q): got %q %v want %q %v", tc.sname, base, imp, tc.base, tc.imp)
+		}
+	}
+	if got, want := impNames("X"), []string{"__imp_X", "__imp_aux_X"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("impNames: got %v want %v", got, want)
+	}
+	if err := setupImpPrefixes("__imp_,"); err == nil {
+		t.Errorf("empty prefix accepted")
+	}
+
+	// With a different prefix, only symbols with it are import
+	// symbols.
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	cmd := exec.Command(exe, "-format-version=2", "-no-excerpts", "-imp-prefix=__imp_fo", op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	want := "Refs:\n \"__imp_foo\":\n   0: O=0 S=.text [0x7/REL32] testdata/srcdebug.o\nDef/ref breakdown:\n \"o\":  refimp ("
+	if !containsReport(string(b), want) {
+		t.Errorf("got:\n%s\nwant to contain:\n%s", b, want)
+	}
+}
+
+func TestDemangleMSVC(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"?Foo@@YAXXZ", "Foo"},
+		{"?Bar@Foo@@QEAAXXZ", "Foo::Bar"},
+		{"?Bar@Foo@Ns@@QEAAXXZ", "Ns::Foo::Bar"},
+		{"??0Foo@@QEAA@XZ", "Foo::Foo"},
+		{"??1Foo@Ns@@QEAA@XZ", "Ns::Foo::~Foo"},
+		{"?Get@0@@YAXXZ", "Get::Get"},
+		{"??_7Foo@@6B@", ""},
+		{"??$max@H@@YAHHH@Z", ""},
+		{"?broken", ""},
+		{"plain", ""},
+	} {
+		got, ok := demangleMSVC(tc.in)
+		if ok != (tc.want != "") || got != tc.want {
+			t.Errorf("demangleMSVC(%q) = %q, %v, want %q", tc.in, got, ok, tc.want)
+		}
+	}
+}
+
+func TestDemangle(t *testing.T) {
+	s := newState([]string{"a.o"}, []string{"a.o"})
+	s.defs["_ZN3FooC1Ev"] = []definfo{{objidx: 0}}
+	s.refs["__imp_?Bar@Foo@@QEAAXXZ"] = reflist{{objidx: 0}}
+	s.refs["plain"] = reflist{{objidx: 0}}
+	defer func() { demangled = nil }()
+	s.setupDemangled()
+	if got, want := demangledTag("__imp_?Bar@Foo@@QEAAXXZ"), " (__imp_Foo::Bar)"; got != want {
+		t.Errorf("MSVC: got %q want %q", got, want)
+	}
+	if got := demangledTag("plain"); got != "" {
+		t.Errorf("plain: got %q", got)
+	}
+	found := false
+	for _, p := range itaniumDemanglers {
+		if _, err := exec.LookPath(p); err == nil {
+			found = true
+		}
+	}
+	if !found {
+		t.Skipf("no Itanium demangler")
+	}
+	if got, want := demangledTag("_ZN3FooC1Ev"), " (Foo::Foo())"; got != want {
+		t.Errorf("Itanium: got %q want %q", got, want)
+	}
+}
+
+func TestRefKinds(t *testing.T) {
+	for _, tc := range []struct {
+		sym, typ, sect string
+		want           refKind
+	}{
+		{"__imp_foo", "IMAGE_REL_AMD64_REL32", ".text", refICall},
+		{"foo", "IMAGE_REL_AMD64_REL32", ".text", refCall},
+		{"foo", "IMAGE_REL_AMD64_ADDR64", ".data", refAddr},
+		{"__imp_foo", "IMAGE_REL_AMD64_ADDR64", ".text", refAddr},
+		{"__imp__foo", "IMAGE_REL_I386_DIR32", ".text", refICall},
+		{"_foo", "IMAGE_REL_I386_DIR32", ".text", refAddr},
+		{"__imp_foo", "IMAGE_REL_ARM64_PAGEBASE_REL21", ".text", refICall},
+		{"foo", "IMAGE_REL_ARM64_PAGEBASE_REL21", ".text", refAddr},
+		{"foo", "IMAGE_REL_ARM64_BRANCH26", ".text", refCall},
+		{"foo", "IMAGE_REL_AMD64_ADDR32NB", ".xdata", refAddr},
+		{"foo", "IMAGE_REL_AMD64_SECREL", ".debug_info", refOther},
+		{"bar", "R_X86_64_REX_GOTPCRELX", ".text", refICall},
+	} {
+		if got := refKindOf(tc.sym, tc.typ, tc.sect); got != tc.want {
+			t.Errorf("refKindOf(%q, %q, %q): got %s want %s", tc.sym, tc.typ, tc.sect, got, tc.want)
+		}
+	}
+
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "refkinds.o")
+	checkDumper(t, op)
+	for _, backend := range []string{"native", "llvm"} {
+		cmd := exec.Command(exe, "-backend="+backend, "-ref-kinds", "-format-version=2", op)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		for _, want := range []string{
+			"[0x7/REL32:call 0x0/ADDR64:addr]",
+			" \"foo\": refbase refimp (foo: objs=1 relocs=2, __imp_foo: objs=1 relocs=1) {call=1 icall=1 addr=1}\n",
+			"Address-taken imports:\n" +
+				" \"__imp_bar\": O0 .data+0x8 ADDR64 testdata/refkinds.o\n" +
+				" \"__imp_bar\": O0 .text+0xd ADDR64 testdata/refkinds.o\n" +
+				" \"foo\": O0 .data+0x0 ADDR64 testdata/refkinds.o\n",
+		} {
+			if !containsReport(string(b), want) {
+				t.Errorf("-backend=%s: output missing %q:\n%s", backend, want, b)
+			}
+		}
+	}
+}
+
+func TestSuspectSlots(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "impslots.o")
+	checkDumper(t, op)
+	want := "Suspicious import slots:\n" +
+		" \"__imp_inbss\": O0 sec=3 val=0x0 testdata/impslots.o: in uninitialized section .bss\n" +
+		" \"__imp_incode\": O0 sec=1 val=0x0 testdata/impslots.o: in code section .text\n" +
+		" \"__imp_short\": O0 sec=2 val=0x8 testdata/impslots.o: only 4 bytes left in .data (size 0xc) for a 8-byte slot\n"
+	for _, backend := range []string{"native", "llvm"} {
+		cmd := exec.Command(exe, "-backend="+backend, op)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		if !strings.Contains(string(b), want) {
+			t.Errorf("-backend=%s: output missing %q:\n%s", backend, want, b)
+		}
+	}
+
+	// i386 slots are 4 bytes.
+	s := newState([]string{"a.o"}, []string{"a.o"})
+	s.machines[0] = "386"
+	s.addSection(secinfo{objidx: 0, idx: 0, name: ".idata$5", size: 8})
+	for _, tc := range []struct {
+		value int
+		want  string
+	}{
+		{4, ""},
+		{6, "only 2 bytes left in .idata$5 (size 0x8) for a 4-byte slot"},
+		{0x10, "value beyond the end of .idata$5 (size 0x8)"},
+	} {
+		if got := s.checkSlot(definfo{objidx: 0, secidx: 1, value: tc.value}); got != tc.want {
+			t.Errorf("value 0x%x: got %q want %q", tc.value, got, tc.want)
+		}
+	}
+	// Sections that weren't read can't be checked.
+	if got := s.checkSlot(definfo{objidx: 0, secidx: 2}); got != "" {
+		t.Errorf("unread section: got %q want \"\"", got)
+	}
+}
+
+func TestComdatDefs(t *testing.T) {
+	exe := buildTool(t)
+	// comdat.o defines inlfn in a COMDAT, nocomdat.o in an ordinary
+	// section; both define strconst in an EXACT_MATCH COMDAT.
+	op1 := filepath.Join("testdata", "comdat.o")
+	op2 := filepath.Join("testdata", "nocomdat.o")
+	checkDumper(t, op1)
+	for _, backend := range []string{"native", "llvm"} {
+		cmd := exec.Command(exe, "-backend="+backend, "-no-excerpts", "-watch=inlfn,strconst", op1, op2)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		for _, want := range []string{
+			" 0: \"__imp_foo\" obj=1 sec=5 val=0x0 comdat=ANY\n" +
+				" 1: \"inlfn\" obj=0 sec=4 val=0x0 comdat=ANY\n" +
+				" 2: \"inlfn\" obj=1 sec=1 val=0x0\n",
+			"  O1 sec=1 val=0x0 testdata/nocomdat.o (not comdat)\n",
+			" \"inlfn\":  defbase [duplicate defs]\n",
+			" \"strconst\":  defbase [comdat defs]\n",
+		} {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("-backend=%s: output missing %q:\n%s", backend, want, b)
+			}
+		}
+	}
+
+	// Without aux records, duplicates can't be judged.
+	s := newState([]string{"a.o", "b.o"}, []string{"a.o", "b.o"})
+	s.addSection(secinfo{objidx: 0, idx: 0, name: ".text"})
+	s.addSection(secinfo{objidx: 1, idx: 0, name: ".text", haveAux: true, comdatSelection: 2})
+	dl := []definfo{{objidx: 0, secidx: 1}, {objidx: 1, secidx: 1}}
+	if got := s.dupStatus(dl); got != dupUnknown {
+		t.Errorf("dupStatus: got %d want %d", got, dupUnknown)
+	}
+	if got := comdatString(s.defComdat(dl[0])); got != "unknown" {
+		t.Errorf("defComdat: got %q want \"unknown\"", got)
+	}
+}
+
+func TestMultiDefs(t *testing.T) {
+	exe := buildTool(t)
+	op1 := filepath.Join("testdata", "comdat.o")
+	op2 := filepath.Join("testdata", "nocomdat.o")
+	checkDumper(t, op1)
+	run := func(watch string) (string, int) {
+		cmd := exec.Command(exe, "-fail-on-dupdefs", "-no-excerpts", "-watch="+watch, op1, op2)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return string(b), ee.ExitCode()
+		} else if err != nil {
+			t.Fatalf("run error: %v\n%s", err, b)
+		}
+		return string(b), 0
+	}
+
+	out, status := run("inlfn,strconst")
+	want := "Multiply-defined symbols:\n" +
+		" \"inlfn\": conflict\n" +
+		"  O0 sec=4 val=0x0 testdata/comdat.o (ANY)\n" +
+		"  O1 sec=1 val=0x0 testdata/nocomdat.o (not comdat)\n" +
+		" \"strconst\": link-compatible (COMDAT)\n" +
+		"  O0 sec=5 val=0x0 testdata/comdat.o (EXACT_MATCH)\n" +
+		"  O1 sec=4 val=0x0 testdata/nocomdat.o (EXACT_MATCH)\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("output missing %q:\n%s", want, out)
+	}
+	if status != dupDefsExit {
+		t.Errorf("with a conflict: got status %d want %d", status, dupDefsExit)
+	}
+	// COMDAT duplicates are fine.
+	if _, status := run("strconst"); status != 0 {
+		t.Errorf("COMDAT duplicates only: got status %d want 0", status)
+	}
+}
+
+func TestWatchedMissing(t *testing.T) {
+	universe := map[string]bool{"_errno": true, "__acrt_iob_func": true, "printf": true, "var": true, "a": true}
+	for _, tc := range []struct {
+		sym  string
+		want []string
+	}{
+		{"_erno", []string{"_errno"}},
+		{"__acrt", []string{"__acrt_iob_func"}},
+		{"bar", []string{"var"}},
+		{"foo", nil},
+	} {
+		if got := nearMisses(tc.sym, universe); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("nearMisses(%q): got %q want %q", tc.sym, got, tc.want)
+		}
+	}
+
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+	cmd := exec.Command(exe, "-watch=_erno,printf", op)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	for _, want := range []string{
+		"warning: watched symbols not defined or referred to by any object:\n\t_erno (did you mean _errno?)\n",
+		"\nno excerpts for watched symbol \"_erno\": not defined or referred to by any object\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), "no excerpts for watched symbol \"printf\"") {
+		t.Errorf("unexpected note for printf:\n%s", b)
+	}
+}
+
+func TestRefSections(t *testing.T) {
+	// Relocation lists for sections sharing a name go to those
+	// sections in order.
+	s := newState([]string{"a.o"}, []string{"a.o"})
+	s.newSection(".text$mn", 0x10, 2)
+	s.newSection(".data", 0x8, 3)
+	s.newSection(".text$mn", 0x20, 4)
+	for _, want := range []int{3, 5, 0} {
+		if got := s.relocSecnum(".text$mn"); got != want {
+			t.Errorf("relocSecnum(.text$mn): got %d want %d", got, want)
+		}
+	}
+	ri := refinfo{relocs: []relocinfo{
+		{off: 0x4, sect: ".text$mn", secnum: 5},
+		{off: 0x0, sect: ".data", secnum: 4},
+		{off: 0x9, sect: ".text$mn", secnum: 5},
+	}}
+	if got, _ := s.refSections(&ri); !reflect.DeepEqual(got, []int{5, 4}) {
+		t.Errorf("refSections: got %v want [5 4]", got)
+	}
+	if got, want := s.refSecName(&ri), ".text$mn,.data"; got != want {
+		t.Errorf("refSecName: got %q want %q", got, want)
+	}
+
+	// The section numbers survive -save and -load.
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "srcdebug.o")
+	checkDumper(t, op)
+	saved := filepath.Join(t.TempDir(), "saved.json")
+	cmd := exec.Command(exe, "-no-excerpts", "-save="+saved, op)
+	t.Logf("cmd: %+v\n", cmd)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("run error: %v\n%s", err, b)
+	}
+	ss, err := readSavedState(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = newState(nil, nil)
+	s.merge(ss, saved)
+	rl := s.refs["__imp_foo"]
+	if len(rl) != 1 {
+		t.Fatalf("after -load: got %d refs to __imp_foo, want 1", len(rl))
+	}
+	if got, _ := s.refSections(&rl[0]); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("after -load: sections %v, want [1]", got)
+	}
+}
+
+func TestSpecialSymbols(t *testing.T) {
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "sample.o")
+	checkDumper(t, op)
+	for _, backend := range []string{"native", "llvm"} {
+		cmd := exec.Command(exe, "-backend="+backend, "-all", "-no-excerpts", op)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		out := string(b)
+		want := "Special symbols:\n" +
+			" \".file\": O0 debug val=0x0 " + op + "\n" +
+			" \"@feat.00\": O0 absolute val=0x0 " + op + "\n"
+		if !strings.Contains(out, want) {
+			t.Errorf("-backend=%s: output missing %q:\n%s", backend, want, out)
+		}
+		// Not definitions, nor references.
+		for _, bad := range []string{"\"@feat.00\"\tobj=", "\"@feat.00\" obj=", "\"@feat.00\":  ", " \"@feat.00\":\n"} {
+			if strings.Contains(out, bad) {
+				t.Errorf("-backend=%s: output contains %q:\n%s", backend, bad, out)
+			}
+		}
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	want := []directive{
+		{"DEFAULTLIB", "LIBCMT"},
+		{"EXPORT", "spaced name,DATA"},
+		{"ALTERNATENAME", "a=b"},
+		{"NODEFAULTLIB", ""},
+	}
+	const str = ` /DEFAULTLIB:"LIBCMT" -export:"spaced name",DATA /alternatename:a=b /NODEFAULTLIB ` + "\x00"
+	u := utf16.Encode([]rune(str))
+	wide := []byte{0xff, 0xfe}
+	for _, c := range u {
+		wide = append(wide, byte(c), byte(c>>8))
+	}
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"ascii", []byte(str)},
+		{"utf-8", append([]byte("\xef\xbb\xbf"), str...)},
+		{"utf-16", wide},
+	} {
+		if got := parseDirectives(tc.data); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q want %q", tc.name, got, want)
+		}
+	}
+
+	// dumpbin /DIRECTIVES output.
+	s := newState([]string{"a.obj"}, []string{"a.obj"})
+	dumpbin := "Dump of file a.obj\n\nFile Type: COFF OBJECT\n\n   Linker Directives\n   -----------------\n" +
+		"   /DEFAULTLIB:\"LIBCMT\"\n   /DEFAULTLIB:\"OLDNAMES\"\n\n  Summary\n"
+	if err := s.digestDirectives(strings.NewReader(dumpbin)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.defaultLibs(), []string{"LIBCMT", "OLDNAMES"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dumpbin: got default libs %q want %q", got, want)
+	}
+
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "directives.o")
+	dump := filepath.Join("testdata", "directives.dump.txt")
+	checkDumper(t, op)
+	for _, args := range [][]string{
+		{"-backend=native", op},
+		{"-backend=llvm", op},
+		{"-from-dump=" + dump},
+	} {
+		cmd := exec.Command(exe, append([]string{"-no-excerpts", "-libpath=testdata"}, args...)...)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		for _, want := range []string{
+			"Linker directives:\n" +
+				" O0: /DEFAULTLIB:ucrt\n" +
+				" O0: /DEFAULTLIB:oldnames.lib\n" +
+				" O0: /EXPORT:foo\n" +
+				" O0: /EXPORT:\"spaced name,data\"\n" +
+				" O0: /ALTERNATENAME:baz=qux\n",
+			// ucrt.lib is found with -libpath; oldnames.lib isn't.
+			" \"_errno\":  refimp [ucrtbase.dll]\n",
+			" \"bar\":  refimp [not in import libs]\n",
+		} {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("%v: output missing %q:\n%s", args, want, b)
+			}
+		}
+	}
+}
+
+func TestExports(t *testing.T) {
+	for _, tc := range []struct {
+		arg  string
+		want export
+	}{
+		{"foo", export{name: "foo", sym: "foo"}},
+		{"foo,DATA", export{name: "foo", sym: "foo", data: true}},
+		{"bar=impl,@3,NONAME", export{name: "bar", sym: "impl"}},
+		{"fwd=kernel32.Sleep", export{name: "fwd", sym: "kernel32.Sleep"}},
+	} {
+		if got := parseExport(tc.arg); got != tc.want {
+			t.Errorf("parseExport(%q): got %+v want %+v", tc.arg, got, tc.want)
+		}
+	}
+
+	// Canned dumps with .drectve contents appended; exports.dump.txt
+	// is from exports.s. O1 imports foo, which O0 exports.
+	exe := buildTool(t)
+	d0 := filepath.Join("testdata", "directives.dump.txt")
+	d1 := filepath.Join("testdata", "exports.dump.txt")
+	cmd := exec.Command(exe, "-no-excerpts", "-from-dump="+d0+","+d1)
+	t.Logf("cmd: %+v\n", cmd)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("run: %s\n", b)
+		t.Fatalf("run error: %v", err)
+	}
+	for _, want := range []string{
+		"Exports:\n" +
+			" \"alias\" (= \"impl\"): O1 " + d1 + ": defined\n" +
+			" \"foo\": O0 " + d0 + ": defined, imported within the set by O1\n" +
+			" \"fwd\" (= \"kernel32.Sleep\"): O1 " + d1 + ": forwarded to kernel32.Sleep\n" +
+			" \"missing\": O1 " + d1 + ": not defined by the exporting object\n" +
+			" \"spaced name\" DATA: O0 " + d0 + ": not defined by the exporting object\n",
+		" \"foo\":  defbase refimp exported\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	// alias.o refers to __imp_oldname, whose alternate name
+	// __imp_midname has alternate name __imp_newname, which it
+	// defines; to __imp_viaimplib, with alternate name __imp__errno
+	// from ucrt.lib; and to __imp_nowhere, whose alternate name is
+	// defined nowhere.
+	exe := buildTool(t)
+	op := filepath.Join("testdata", "alias.o")
+	checkDumper(t, op)
+	for _, backend := range []string{"native", "llvm"} {
+		cmd := exec.Command(exe, "-no-excerpts", "-format-version=2", "-backend="+backend,
+			"-implib="+filepath.Join("testdata", "ucrt.lib"), op)
+		t.Logf("cmd: %+v\n", cmd)
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("run: %s\n", b)
+			t.Fatalf("run error: %v", err)
+		}
+		out := string(b)
+		for _, want := range []string{
+			"Alternate names:\n" +
+				" \"__imp_nowhere\" -> \"__imp_gone\": unresolved\n" +
+				" \"__imp_oldname\" -> \"__imp_midname\" -> \"__imp_newname\": defined in O0 " + op + "\n" +
+				" \"__imp_viaimplib\" -> \"__imp__errno\": in ucrtbase.dll\n",
+			" \"nowhere\":    refimp [not in import libs] (",
+			" \"oldname\":    defimp refimp (",
+			" \"viaimplib\":  refimp [ucrtbase.dll] (",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: output missing %q:\n%s", backend, want, out)
+			}
+		}
+		for _, notwant := range []string{
+			" \"__imp_oldname\": O0",
+			"Unused import definitions:",
+		} {
+			if strings.Contains(out, notwant) {
+				t.Errorf("%s: output has %q:\n%s", backend, notwant, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Support for reading the output of "dumpbin /HEADERS /SYMBOLS
+// /RELOCATIONS", for machines where the MSVC tools are all there is.
+// Unlike objdump, dumpbin lists the relocations for each section
+// along with its header, ahead of the symbol table, so relocations
+// are held until the symbol table has been read.
+
+var (
+	// SECTION HEADER #1
+	dbsechdrre = regexp.MustCompile(`^SECTION HEADER #([0-9A-F]+)$`)
+	//    .text name
+	// /4 (.debug_info) name
+	dbsecnamere = regexp.MustCompile(`^\s*(\S+)(?: \((\S+)\))? name$`)
+	//       2D size of raw data
+	dbsecsizere = regexp.MustCompile(`^\s*([0-9A-F]+) size of raw data$`)
+	// RELOCATIONS #1
+	dbrelhdrre = regexp.MustCompile(`^RELOCATIONS #([0-9A-F]+)$`)
+	// 00E 00000000 SECT1  notype ()    External     | callfoo
+	dbsymre = regexp.MustCompile(`^[0-9A-F]+ ([0-9A-F]+) (SECT[0-9A-F]+|UNDEF|ABS|DEBUG)\s.*\| (\S+)`)
+)
+
+// dbreloc is a relocation read from dumpbin output.
+type dbreloc struct {
+	sect   string
+	secnum int
+	off    string
+	typ    string
+	sym    string
+	line   string
+}
+
+func (s *state) digestDumpbin(content string) error {
+	s.scanner = s.newScanner(strings.NewReader(content))
+	secnames := make(map[int]string)
+	var relocs []dbreloc
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		var err error
+		if m := dbsechdrre.FindStringSubmatch(line); len(m) != 0 {
+			err = s.readSectionDumpbin(m[1], secnames)
+		} else if m := dbrelhdrre.FindStringSubmatch(line); len(m) != 0 {
+			relocs, err = s.readRelocationsDumpbin(m[1], secnames, relocs)
+		} else if line == "COFF SYMBOL TABLE" {
+			err = s.readSymtabDumpbin()
+		} else if strings.TrimSpace(line) == "Linker Directives" {
+			err = s.readDirectivesDumpbin()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.scanErr(); err != nil {
+		return err
+	}
+	for _, r := range relocs {
+		if err := s.addReloc(r.sect, r.secnum, r.off, r.typ, r.sym, r.line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSectionDumpbin reads the header for section snum (in hex,
+// numbered from 1), recording it if it is one of the sections of
+// interest to pass3.
+func (s *state) readSectionDumpbin(snum string, secnames map[int]string) error {
+	var sindex int
+	if n, err := fmt.Sscanf(snum, "%x", &sindex); n != 1 || err != nil {
+		return fmt.Errorf("can't parse section number %s", snum)
+	}
+	sname := ""
+	ssiz := -1
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			break
+		}
+		if m := dbsecnamere.FindStringSubmatch(line); len(m) != 0 {
+			sname = m[1]
+			if m[2] != "" {
+				sname = m[2]
+			}
+		} else if m := dbsecsizere.FindStringSubmatch(line); len(m) != 0 {
+			if n, err := fmt.Sscanf(m[1], "%x", &ssiz); n != 1 || err != nil {
+				return fmt.Errorf("can't parse sec size in line %s in section header", line)
+			}
+		}
+	}
+	if sname == "" || ssiz == -1 {
+		return fmt.Errorf("incomplete header for section #%s", snum)
+	}
+	secnames[sindex] = sname
+	if pass3Sections[sname] {
+		s.newSection(sname, ssiz, sindex-1)
+	}
+	return nil
+}
+
+// readRelocationsDumpbin reads the relocations for section snum,
+// appending those for sections of interest to relocs.
+func (s *state) readRelocationsDumpbin(snum string, secnames map[int]string, relocs []dbreloc) ([]dbreloc, error) {
+	var sindex int
+	if n, err := fmt.Sscanf(snum, "%x", &sindex); n != 1 || err != nil {
+		return nil, fmt.Errorf("can't parse section number %s", snum)
+	}
+	skip := !pass3Sections[secnames[sindex]]
+	// skip preamble, ending with a line of dashes
+	for s.scanner.Scan() {
+		if strings.HasPrefix(strings.TrimSpace(s.scanner.Text()), "---") {
+			break
+		}
+	}
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			break
+		}
+		if skip {
+			continue
+		}
+		// offset, type, applied to, symbol index, symbol name
+		f := strings.Fields(line)
+		if len(f) < 4 {
+			return nil, fmt.Errorf("bad line %s in relocs", line)
+		}
+		s.countReloc(secnames[sindex])
+		relocs = append(relocs, dbreloc{sect: secnames[sindex], secnum: sindex, off: f[0], typ: f[1], sym: f[len(f)-1], line: line})
+	}
+	return relocs, nil
+}
+
+func (s *state) readSymtabDumpbin() error {
+	defs := make(map[string]struct{})
+	// Source file name from the aux record(s) of the first .file
+	// symbol.
+	srcfile := ""
+	infilesym := false
+	// section of the last symbol, for section-definition aux records
+	lastsec := 0
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if strings.HasPrefix(line, " ") {
+			// aux record
+			if infilesym {
+				srcfile += strings.TrimSpace(line)
+			} else if a, ok := parseAuxSecDumpbin(line); ok {
+				if a.number == 0 {
+					a.number = lastsec
+				}
+				s.setSectionAux(lastsec, a)
+			}
+			continue
+		}
+		infilesym = false
+		if line == "" {
+			break
+		}
+		m := dbsymre.FindStringSubmatch(line)
+		if len(m) == 0 {
+			return fmt.Errorf("bad line %s in symtab", line)
+		}
+		var value int
+		if n, err := fmt.Sscanf(m[1], "%x", &value); n != 1 || err != nil {
+			return fmt.Errorf("can't parse value in line %s in symtab", line)
+		}
+		var secidx int
+		switch m[2] {
+		case "UNDEF":
+			secidx = 0
+		case "ABS":
+			secidx = secAbsolute
+		case "DEBUG":
+			secidx = secDebug
+		default:
+			if n, err := fmt.Sscanf(m[2], "SECT%x", &secidx); n != 1 || err != nil {
+				return fmt.Errorf("can't parse sec idx in line %s in symtab", line)
+			}
+		}
+		sname := m[3]
+		lastsec = secidx
+		if sname == ".file" && srcfile == "" {
+			infilesym = true
+		}
+		if err := s.addSymbol(sname, secidx, value, defs); err != nil {
+			return err
+		}
+	}
+	s.finishSymtab(srcfile, defs)
+	return nil
+}
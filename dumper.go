@@ -0,0 +1,555 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flavors of objdump output.
+const (
+	flavorAuto    = "auto"
+	flavorLLVM    = "llvm"
+	flavorGNU     = "gnu"
+	flavorDumpbin = "dumpbin"
+	flavorReadobj = "readobj"
+	// objects read directly, see native.go
+	flavorNative = "native"
+)
+
+// flavor is the flavor of output expected from the dumper: per
+// -dumper-flavor, or as determined from its --version output. When
+// -dumper-flavor is "auto", captured output is also checked (see
+// detectFlavor).
+var flavor string
+
+// pass3SectionList lists the sections whose relocations pass3 is
+// interested in.
+var pass3SectionList = []string{".text", ".data", ".bss", ".rdata", ".xdata", ".idata$5"}
+
+var pass3Sections = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, sn := range pass3SectionList {
+		m[sn] = true
+	}
+	return m
+}()
+
+// detectFlavor returns the flavor of objdump output, based on the
+// first few lines ("file format pe-x86-64" from GNU objdump versus
+// "file format coff-x86-64" from llvm-objdump, "Dump of file" from
+// dumpbin, or the JSON file summary from llvm-readobj), or "" if that
+// can't be determined.
+func detectFlavor(content string) string {
+	for i, line := range strings.SplitN(content, "\n", 8) {
+		if i == 7 {
+			break
+		}
+		if strings.HasPrefix(line, "Dump of file ") {
+			return flavorDumpbin
+		}
+		if strings.HasPrefix(line, `"File":`) {
+			return flavorReadobj
+		}
+		if j := strings.Index(line, "file format "); j != -1 {
+			ff := line[j+len("file format "):]
+			switch {
+			case strings.HasPrefix(ff, "pe-"), strings.HasPrefix(ff, "pei-"):
+				return flavorGNU
+			case strings.HasPrefix(ff, "coff-"), strings.HasPrefix(ff, "COFF-"):
+				return flavorLLVM
+			}
+		}
+	}
+	return ""
+}
+
+// DefaultDumper is the objdump program used when neither -objdump nor
+// $WINIMPSYM_OBJDUMP says otherwise. If it can't be found, other
+// likely llvm-objdump names are tried (see dumperCandidates).
+var DefaultDumper = "llvm-objdump-14"
+
+// dumperEnv is the environment variable consulted for the dumper when
+// -objdump is not given.
+const dumperEnv = "WINIMPSYM_OBJDUMP"
+
+var (
+	// dumper is the objdump program in use (for display), as selected
+	// by resolveDumper; dumperArgv is the command to run it.
+	dumper     string
+	dumperArgv []string
+	// dumperVersion is the first line of its --version output
+	dumperVersion string
+)
+
+// dumperCandidates returns the commands to try, in order, when looking
+// for an objdump program to use by default. With -dumper-flavor=readobj,
+// llvm-readobj is looked for first.
+func dumperCandidates() [][]string {
+	var res [][]string
+	if flavor == flavorReadobj {
+		res = append(res, []string{strings.Replace(DefaultDumper, "objdump", "readobj", 1)}, []string{"llvm-readobj"})
+		for v := 20; v >= 14; v-- {
+			res = append(res, []string{fmt.Sprintf("llvm-readobj-%d", v)})
+		}
+	}
+	res = append(res, []string{DefaultDumper}, []string{"llvm-objdump"})
+	for v := 20; v >= 14; v-- {
+		name := fmt.Sprintf("llvm-objdump-%d", v)
+		if name != DefaultDumper {
+			res = append(res, []string{name})
+		}
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		res = append(res, []string{"xcrun", "llvm-objdump"})
+	case "windows":
+		res = append(res, []string{"dumpbin"})
+	}
+	return res
+}
+
+// isReadobj returns true if the program prog looks like llvm-readobj.
+func isReadobj(prog string) bool {
+	return strings.Contains(filepath.Base(prog), "readobj")
+}
+
+// isDumpbin returns true if the program prog looks like dumpbin.
+func isDumpbin(prog string) bool {
+	base := strings.ToLower(filepath.Base(strings.ReplaceAll(prog, "\\", "/")))
+	return strings.HasPrefix(base, "dumpbin")
+}
+
+// probeDumper checks that the command argv exists and runs, returning
+// the first line of its --version output (or for dumpbin, which has
+// no such option, of the banner it prints when run without arguments).
+func probeDumper(argv []string) (string, error) {
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return "", err
+	}
+	args := []string{"--version"}
+	if flavor == flavorDumpbin || isDumpbin(argv[0]) {
+		args = nil
+	}
+	cmd := exec.CommandContext(runCtx, argv[0], append(argv[1:], args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		if args == nil {
+			return "", fmt.Errorf("running: %v%s", err, stderrSummary(err))
+		}
+		return "", fmt.Errorf("running --version: %v%s", err, stderrSummary(err))
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", nil
+}
+
+// resolveDumper selects the objdump program used by all passes. An
+// explicit choice (-objdump, then $WINIMPSYM_OBJDUMP) must work;
+// otherwise the first working candidate is used.
+func resolveDumper() error {
+	explicit := *objdumpflag
+	if explicit == "" {
+		explicit = os.Getenv(dumperEnv)
+	}
+	if explicit != "" {
+		ver, err := probeDumper([]string{explicit})
+		if err != nil {
+			return fmt.Errorf("can't use objdump program %q (use -objdump or $%s to select one): %v", explicit, dumperEnv, err)
+		}
+		dumper, dumperArgv, dumperVersion = explicit, []string{explicit}, ver
+		setFlavorFor(explicit, ver)
+		return nil
+	}
+	var tried []string
+	for _, argv := range dumperCandidates() {
+		name := strings.Join(argv, " ")
+		ver, err := probeDumper(argv)
+		if err != nil {
+			tried = append(tried, fmt.Sprintf("  %s: %v", name, err))
+			continue
+		}
+		dumper, dumperArgv, dumperVersion = name, argv, ver
+		setFlavorFor(argv[0], ver)
+		verb(1, "using objdump program %q (%s)", dumper, dumperVersion)
+		return nil
+	}
+	return fmt.Errorf("no objdump program found (use -objdump or $%s to select one); tried:\n%s", dumperEnv, strings.Join(tried, "\n"))
+}
+
+// setFlavorFor sets the flavor of output expected from dumper program
+// prog with version string ver, unless -dumper-flavor says otherwise.
+func setFlavorFor(prog, ver string) {
+	switch *flavorflag {
+	case flavorAuto:
+	case flavorReadobj:
+		if !isReadobj(prog) {
+			// Fall back on objdump's text output.
+			fmt.Fprintf(os.Stderr, "note: no llvm-readobj found, using %s\n", prog)
+			flavor = flavorLLVM
+		}
+		return
+	default:
+		return
+	}
+	switch {
+	case isDumpbin(prog):
+		flavor = flavorDumpbin
+	case isReadobj(prog):
+		flavor = flavorReadobj
+	case strings.Contains(ver, "GNU"):
+		flavor = flavorGNU
+	}
+}
+
+// Dumper produces the text dumps of object files that the analysis
+// works from.
+type Dumper interface {
+	// Full returns a dump of the section headers, symbol table and
+	// relocations of file, limited to the specified sections where
+	// the dumper allows.
+	Full(file string, sections []string) (io.Reader, error)
+	// Disasm returns a disassembly of file with line numbers and
+	// relocations, interleaved with source code if withsrc is set.
+	Disasm(file string, withsrc bool) (io.Reader, error)
+}
+
+// errNoDisasm is returned by Dumper.Disasm when the dumper can't
+// disassemble.
+var errNoDisasm = errors.New("no disassembler available")
+
+// objdumpDumper is the Dumper that runs an objdump program (or
+// dumpbin or llvm-readobj).
+type objdumpDumper struct {
+	name   string
+	argv   []string
+	flavor string
+	// program used for disassembly, if not the dumper itself
+	disasmArgv []string
+}
+
+// newObjdumpDumper returns a Dumper for the program selected by
+// resolveDumper. llvm-readobj can't disassemble, so the llvm-objdump
+// alongside it is used for that.
+func newObjdumpDumper() *objdumpDumper {
+	od := &objdumpDumper{name: dumper, argv: dumperArgv, flavor: flavor}
+	if flavor == flavorReadobj {
+		od.disasmArgv = append([]string{strings.Replace(dumperArgv[0], "readobj", "objdump", 1)}, dumperArgv[1:]...)
+	}
+	return od
+}
+
+// disasmName returns the name of the program d disassembles with, for
+// display.
+func disasmName(d Dumper) string {
+	if od, ok := d.(*objdumpDumper); ok {
+		if od.disasmArgv != nil {
+			return strings.Join(od.disasmArgv, " ")
+		}
+		return od.name
+	}
+	return "objdump"
+}
+
+// readobjArgs selects llvm-readobj's JSON output.
+var readobjArgs = []string{"--elf-output-style=JSON"}
+
+func (od *objdumpDumper) Full(file string, sections []string) (io.Reader, error) {
+	return od.run(file, od.fullArgs(sections)...)
+}
+
+// FullBatch implements batchDumper. dumpbin and llvm-readobj don't
+// mark the output for each file in a way that can be relied on, so
+// only objdump can do this.
+func (od *objdumpDumper) FullBatch(files []string, sections []string) (io.Reader, error) {
+	if od.flavor != flavorLLVM && od.flavor != flavorGNU {
+		return nil, errNoBatch
+	}
+	return od.runFiles(files, od.fullArgs(sections)...)
+}
+
+// fullArgs returns the dumper arguments for Full.
+func (od *objdumpDumper) fullArgs(sections []string) []string {
+	args := []string{
+		"-h", // section headers
+		"-t", // symbols
+		"-r", // relocations
+	}
+	switch od.flavor {
+	case flavorGNU:
+		// GNU objdump's -j drops symbols from other sections
+		// (including undefined ones) from the symbol table, so
+		// sections are filtered as the output is read instead.
+	case flavorDumpbin:
+		// dumpbin takes a single /SECTION, so likewise.
+		args = []string{"/HEADERS", "/SYMBOLS", "/RELOCATIONS"}
+	case flavorReadobj:
+		// llvm-readobj has no section filtering.
+		args = append(readobjArgs, "--sections", "--symbols", "--relocs", "--expand-relocs")
+	default:
+		for _, sn := range sections {
+			args = append(args, "--section="+sn)
+		}
+	}
+	return args
+}
+
+func (od *objdumpDumper) Disasm(file string, withsrc bool) (io.Reader, error) {
+	if od.flavor == flavorDumpbin {
+		return nil, errNoDisasm
+	}
+	asm := "-d" // assembly
+	if withsrc {
+		asm = "-S" // assembly interleaved with source
+	}
+	if od.disasmArgv != nil {
+		if _, err := exec.LookPath(od.disasmArgv[0]); err != nil {
+			return nil, errNoDisasm
+		}
+		d := &objdumpDumper{name: disasmName(od), argv: od.disasmArgv, flavor: flavorLLVM}
+		return d.Disasm(file, withsrc)
+	}
+	return od.run(file,
+		"-l", // line numbers
+		asm,
+		"-r") // relocations
+}
+
+// run runs the dumper with the specified arguments on infile,
+// returning its output.
+func (od *objdumpDumper) run(infile string, args ...string) (io.Reader, error) {
+	return od.runFiles([]string{infile}, args...)
+}
+
+// runFiles is run for one or more input files. The output is read as
+// the dumper produces it, rather than being collected first.
+func (od *objdumpDumper) runFiles(infiles []string, args ...string) (io.Reader, error) {
+	argv := append(od.argv[1:len(od.argv):len(od.argv)], args...)
+	for _, f := range infiles {
+		argv = append(argv, dumperPath(f))
+	}
+	ds := &dumpStream{name: od.name, what: infiles[0]}
+	if len(infiles) > 1 {
+		ds.what = fmt.Sprintf("%d files", len(infiles))
+	}
+	ds.ctx, ds.cancel = context.WithCancel(runCtx)
+	if *timeoutflag > 0 {
+		ds.ctx, ds.cancel = context.WithTimeout(runCtx, *timeoutflag)
+	}
+	ds.start = time.Now()
+	ds.cmd = exec.CommandContext(ds.ctx, od.argv[0], argv...)
+	// Don't wait long for any children left holding the output open.
+	ds.cmd.WaitDelay = time.Second
+	ds.cmd.Stderr = &ds.stderr
+	var err error
+	if ds.stdout, err = ds.cmd.StdoutPipe(); err == nil {
+		err = ds.cmd.Start()
+	}
+	if err != nil {
+		ds.cancel()
+		return nil, fmt.Errorf("running %s on %s: %v", od.name, ds.what, err)
+	}
+	return ds, nil
+}
+
+// dumpStream is the output of a run of the dumper. Reading it to the
+// end waits for the dumper to exit, and if it failed, returns an error
+// saying so in place of io.EOF. Close kills the dumper if it is still
+// running.
+type dumpStream struct {
+	name, what string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	start      time.Time
+	cmd        *exec.Cmd
+	stdout     io.ReadCloser
+	stderr     bytes.Buffer
+	done       bool
+	err        error
+}
+
+func (ds *dumpStream) Read(p []byte) (int, error) {
+	if ds.done {
+		return 0, ds.result()
+	}
+	n, err := ds.stdout.Read(p)
+	if err == io.EOF {
+		ds.wait()
+		err = ds.result()
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (ds *dumpStream) Close() error {
+	if !ds.done {
+		ds.cancel()
+		ds.wait()
+	}
+	return nil
+}
+
+// wait waits for the dumper to exit, recording the error if it
+// failed.
+func (ds *dumpStream) wait() {
+	defer ds.cancel()
+	ds.done = true
+	err := ds.cmd.Wait()
+	if err == nil {
+		return
+	}
+	if interrupted.Load() {
+		ds.err = fmt.Errorf("interrupted: %s", cmdline(ds.cmd.Args))
+		return
+	}
+	if ds.ctx.Err() == context.DeadlineExceeded || runCtx.Err() != nil {
+		limit := "-timeout"
+		if runCtx.Err() != nil {
+			limit = "-deadline"
+		}
+		ds.err = fmt.Errorf("killed by %s after %v: %s", limit,
+			time.Since(ds.start).Round(time.Millisecond), cmdline(ds.cmd.Args))
+		return
+	}
+	ds.err = fmt.Errorf("running %s on %s: %v%s", ds.name, ds.what, err, summarizeStderr(ds.stderr.Bytes()))
+}
+
+// result returns the error to report once the output has been read.
+func (ds *dumpStream) result() error {
+	if ds.err != nil {
+		return ds.err
+	}
+	return io.EOF
+}
+
+// runCtx bounds all runs of the dumper; it has the -deadline, if any.
+var runCtx = context.Background()
+
+// cmdline returns a command line for display, quoting arguments where
+// needed.
+func cmdline(args []string) string {
+	q := make([]string, len(args))
+	for i, a := range args {
+		q[i] = a
+		if a == "" || strings.ContainsAny(a, " \t\"'") {
+			q[i] = strconv.Quote(a)
+		}
+	}
+	return strings.Join(q, " ")
+}
+
+// Limits on how much of a failed command's stderr is shown.
+const (
+	maxStderrLines = 5
+	maxStderrLine  = 200
+)
+
+// stderrSummary returns the start of the stderr output captured for a
+// command that failed with err, one indented line per line of output,
+// or "" if there was none.
+func stderrSummary(err error) string {
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		return ""
+	}
+	return summarizeStderr(ee.Stderr)
+}
+
+// summarizeStderr does the work of stderrSummary for stderr output
+// stderr.
+func summarizeStderr(stderr []byte) string {
+	lines := strings.Split(strings.TrimRight(string(stderr), "\r\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return ""
+	}
+	var sb strings.Builder
+	for i, line := range lines {
+		if i == maxStderrLines {
+			fmt.Fprintf(&sb, "\n\t... (%d more lines)", len(lines)-i)
+			break
+		}
+		line = strings.TrimRight(line, "\r")
+		if len(line) > maxStderrLine {
+			line = line[:maxStderrLine] + "..."
+		}
+		sb.WriteString("\n\t" + line)
+	}
+	return sb.String()
+}
+
+// readDump returns the contents of a dump returned by a Dumper.
+func readDump(r io.Reader, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	return io.ReadAll(r)
+}
+
+// readStream reads the dump r with parse, copying it to tee if that
+// isn't nil. Whatever parse leaves unread is then drained, so that a
+// dumper that failed partway is reported as such; that takes
+// precedence over a parse error, which the failure likely caused.
+func readStream(r io.Reader, tee io.Writer, parse func(io.Reader) error) error {
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	if tee != nil {
+		r = io.TeeReader(r, tee)
+	}
+	perr := parse(r)
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	return perr
+}
+
+// maxDumpLine is the longest line of dumper output that can be read.
+// Lines of over a megabyte turn up now and then (C++ template
+// instantiations make for very long symbol names).
+var maxDumpLine = 256 << 20
+
+// newScanner returns a scanner for the lines of dump r, counting them
+// in s.lineno. Only complete lines are counted: when a line is too
+// long, the scanner hands back what it has of it before stopping.
+func (s *state) newScanner(r io.Reader) *bufio.Scanner {
+	s.lineno = 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxDumpLine)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		adv, tok, err := bufio.ScanLines(data, atEOF)
+		if tok != nil && adv > len(tok) {
+			s.lineno++
+		}
+		return adv, tok, err
+	})
+	return sc
+}
+
+// scanErr returns the error, if any, that stopped s.scanner.
+func (s *state) scanErr() error {
+	err := s.scanner.Err()
+	if err == bufio.ErrTooLong {
+		return fmt.Errorf("line %d of dump is longer than %d bytes", s.lineno+1, maxDumpLine)
+	}
+	return err
+}
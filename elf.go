@@ -0,0 +1,256 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Support for ELF objects (the host objects of a linux cgo build, for
+// instance), read directly with debug/elf, so that the def/ref
+// analysis can be compared with that for Windows objects. There are no
+// import symbols, so it is -all and -watch that make symbols
+// interesting. Only global and weak symbols are recorded: local ones
+// (static functions and the like) are often defined by more than one
+// object under the same name.
+
+const elfmag = "\x7fELF"
+
+// elfMachines maps ELF machine types to GOARCH-style names.
+var elfMachines = map[elf.Machine]string{
+	elf.EM_386:     "386",
+	elf.EM_X86_64:  "amd64",
+	elf.EM_ARM:     "arm",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_RISCV:   "riscv64",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_S390:    "s390x",
+}
+
+// elfMachine returns the machine name for an ELF object with the
+// specified header: the GOARCH-style name with "-elf" appended, so that
+// ELF and COFF objects are told apart, or "" if the machine isn't
+// known.
+func elfMachine(hdr []byte) string {
+	if len(hdr) < 20 {
+		return ""
+	}
+	var bo binary.ByteOrder = binary.LittleEndian
+	if elf.Data(hdr[elf.EI_DATA]) == elf.ELFDATA2MSB {
+		bo = binary.BigEndian
+	}
+	m, ok := elfMachines[elf.Machine(bo.Uint16(hdr[18:]))]
+	if !ok {
+		return ""
+	}
+	return m + "-elf"
+}
+
+// isELF reports whether infile is an ELF file.
+func isELF(infile string) bool {
+	f, err := os.Open(infile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var buf [len(elfmag)]byte
+	n, _ := f.Read(buf[:])
+	return bytes.Equal(buf[:n], []byte(elfmag))
+}
+
+// isELFSection reports whether ELF section sn is one of those recorded
+// in pass3: .text, .data, .bss and .rodata, along with the sections
+// for individual functions and variables (".text.foo" and so on).
+func isELFSection(sn string) bool {
+	for _, base := range []string{".text", ".data", ".bss", ".rodata"} {
+		if sn == base || strings.HasPrefix(sn, base+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// openELF opens the ELF object infile, returning it along with the
+// names of the global and weak symbols in its symbol table, indexed as
+// for relocations (so that [0] is for the null symbol); the entries
+// for other symbols are "". The name of the source file, if there is
+// a symbol for it, is returned too.
+func openELF(infile string) (*elf.File, []string, string, error) {
+	f, err := elf.Open(infile)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if f.Type != elf.ET_REL {
+		f.Close()
+		return nil, nil, "", fmt.Errorf("%s: not an ELF relocatable object", infile)
+	}
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		f.Close()
+		return nil, nil, "", fmt.Errorf("%s: %v", infile, err)
+	}
+	names := make([]string, len(syms)+1)
+	srcfile := ""
+	for i, sym := range syms {
+		switch bind := elf.ST_BIND(sym.Info); {
+		case elf.ST_TYPE(sym.Info) == elf.STT_FILE:
+			if srcfile == "" {
+				srcfile = sym.Name
+			}
+		case bind == elf.STB_GLOBAL || bind == elf.STB_WEAK:
+			names[i+1] = sym.Name
+		}
+	}
+	return f, names, srcfile, nil
+}
+
+// pass1ELF is pass1 for ELF objects.
+func (s *state) pass1ELF(infile string) error {
+	f, names, _, err := openELF(infile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, sname := range names {
+		if sname != "" && s.isInterestingSym(sname) {
+			s.all[sname] = true
+		}
+	}
+	return nil
+}
+
+// readObjectELF is pass3 for ELF objects.
+func (s *state) readObjectELF(infile string) error {
+	f, names, srcfile, err := openELF(infile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for k, sect := range f.Sections {
+		if sect.Flags&elf.SHF_ALLOC != 0 && isELFSection(sect.Name) {
+			s.newSection(sect.Name, int(sect.Size), k-1)
+		}
+	}
+
+	syms, _ := f.Symbols()
+	defs := make(map[string]struct{})
+	for i, sym := range syms {
+		if names[i+1] == "" {
+			continue
+		}
+		secidx := int(sym.Section)
+		if sym.Section == elf.SHN_ABS {
+			secidx = secAbsolute
+		}
+		if err := s.addSymbol(names[i+1], secidx, int(sym.Value), defs); err != nil {
+			return err
+		}
+	}
+	s.finishSymtab(srcfile, defs)
+
+	for _, rs := range f.Sections {
+		if rs.Type != elf.SHT_RELA && rs.Type != elf.SHT_REL {
+			continue
+		}
+		if int(rs.Info) >= len(f.Sections) {
+			return fmt.Errorf("%s: bad target section %d for %s", infile, rs.Info, rs.Name)
+		}
+		target := f.Sections[rs.Info]
+		if target.Flags&elf.SHF_ALLOC == 0 || !isELFSection(target.Name) {
+			continue
+		}
+		relocs, err := readELFRelocs(f, rs)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", infile, rs.Name, err)
+		}
+		for _, r := range relocs {
+			if int(r.sym) >= len(names) {
+				return fmt.Errorf("%s: bad symbol index %d in %s relocation at 0x%x", infile, r.sym, target.Name, r.off)
+			}
+			sname := names[r.sym]
+			if sname == "" {
+				// A section or local symbol.
+				continue
+			}
+			styp := elfRelocTypeName(f.Machine, r.typ)
+			line := fmt.Sprintf("%016x %s %s", r.off, styp, sname)
+			if err := s.addReloc(target.Name, int(rs.Info), fmt.Sprintf("%x", r.off), styp, sname, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// elfReloc is a relocation read from an ELF object.
+type elfReloc struct {
+	off uint64
+	sym uint32
+	typ uint32
+}
+
+// readELFRelocs reads the relocations in ELF section rs (of type
+// SHT_REL or SHT_RELA).
+func readELFRelocs(f *elf.File, rs *elf.Section) ([]elfReloc, error) {
+	data, err := rs.Data()
+	if err != nil {
+		return nil, err
+	}
+	var res []elfReloc
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var rel elfReloc
+		if f.Class == elf.ELFCLASS64 {
+			var e elf.Rela64
+			if rs.Type == elf.SHT_REL {
+				err = binary.Read(r, f.ByteOrder, &e.Off)
+				if err == nil {
+					err = binary.Read(r, f.ByteOrder, &e.Info)
+				}
+			} else {
+				err = binary.Read(r, f.ByteOrder, &e)
+			}
+			rel = elfReloc{off: e.Off, sym: elf.R_SYM64(e.Info), typ: elf.R_TYPE64(e.Info)}
+		} else {
+			var e elf.Rela32
+			if rs.Type == elf.SHT_REL {
+				err = binary.Read(r, f.ByteOrder, &e.Off)
+				if err == nil {
+					err = binary.Read(r, f.ByteOrder, &e.Info)
+				}
+			} else {
+				err = binary.Read(r, f.ByteOrder, &e)
+			}
+			rel = elfReloc{off: uint64(e.Off), sym: elf.R_SYM32(e.Info), typ: elf.R_TYPE32(e.Info)}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("truncated relocations")
+		}
+		res = append(res, rel)
+	}
+	return res, nil
+}
+
+// elfRelocTypeName returns the name of ELF relocation type typ for the
+// specified machine, as objdump would show it.
+func elfRelocTypeName(m elf.Machine, typ uint32) string {
+	switch m {
+	case elf.EM_X86_64:
+		return elf.R_X86_64(typ).String()
+	case elf.EM_386:
+		return elf.R_386(typ).String()
+	case elf.EM_AARCH64:
+		return elf.R_AARCH64(typ).String()
+	case elf.EM_ARM:
+		return elf.R_ARM(typ).String()
+	}
+	return fmt.Sprintf("0x%x", typ)
+}
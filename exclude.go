@@ -0,0 +1,107 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Support for leaving symbols out of the analysis altogether
+// (-excludesym, -excludesymre), mostly to keep the junk out of the
+// report with -all: compiler-generated locals, .weak. aliases and the
+// like. An excluded symbol never makes it into s.all, s.refs or the
+// breakdown, even if it is watched (a warning is given for such a
+// conflict). Names are matched exactly, and patterns as Go regular
+// expressions against the whole name, with no implicit anchoring or
+// __imp_ companion logic.
+
+var excluded map[string]bool
+
+// excluderes is the compiled -excludesymre patterns.
+var excluderes []*regexp.Regexp
+
+// warnedExclude records the watched symbols already warned about for
+// being excluded.
+var warnedExclude map[string]bool
+
+// setupExcluded populates the excluded set and patterns from the
+// -excludesym and -excludesymre flags. It must be called after
+// setupWatched.
+func setupExcluded() error {
+	excluded = make(map[string]bool)
+	excluderes = nil
+	warnedExclude = make(map[string]bool)
+	if *excludesymflag != "" {
+		for _, sym := range strings.Split(*excludesymflag, ",") {
+			excluded[sym] = true
+		}
+	}
+	if *excludesymreflag != "" {
+		for _, p := range strings.Split(*excludesymreflag, ",") {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("bad -excludesymre pattern %q: %v", p, err)
+			}
+			excluderes = append(excluderes, re)
+		}
+	}
+	syms := make([]string, 0, len(excluded))
+	for sym := range excluded {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		if watchedName(sym) {
+			warnExcludedWatched(sym)
+		}
+	}
+	return nil
+}
+
+// isExcluded reports whether sname is excluded from the analysis.
+func isExcluded(sname string) bool {
+	if excluded[sname] {
+		return true
+	}
+	for _, re := range excluderes {
+		if re.MatchString(sname) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnExcludedWatched warns (once) that watched symbol sname is
+// excluded.
+func warnExcludedWatched(sname string) {
+	if warnedExclude[sname] {
+		return
+	}
+	warnedExclude[sname] = true
+	fmt.Fprintf(os.Stderr, "warning: %s is both watched and excluded; excluding it\n", sname)
+}
+
+// noteExcluded records that the current object has excluded symbol
+// sname.
+func (s *state) noteExcluded(sname string) {
+	if s.excludedSyms[s.objidx] == nil {
+		s.excludedSyms[s.objidx] = make(map[string]bool)
+	}
+	s.excludedSyms[s.objidx][sname] = true
+}
+
+// reportExcluded reports, at verbosity 1, the number of symbols
+// excluded from each object.
+func (s *state) reportExcluded() {
+	for k := range s.objs {
+		if n := len(s.excludedSyms[k]); n != 0 {
+			verb(1, "O%d %s: excluded %d symbols", k, s.labels[k], n)
+		}
+	}
+}
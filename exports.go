@@ -0,0 +1,224 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for checking /EXPORT directives. Each export in an object's
+// .drectve section is checked against the symbols the object defines:
+// one naming a symbol the object doesn't define is suspicious (often a
+// macro gone wrong), unless it forwards to another DLL. An exported
+// symbol that other objects in the set refer to through __imp_X is
+// half of an import/export pair within the set, which the Go linker
+// has to handle specially. Exports are listed with their status under
+// "Exports", and breakdown lines for exported symbols are tagged
+// "exported".
+
+// export is an /EXPORT directive of an object.
+type export struct {
+	objidx int
+	// name is the name exported, sym the symbol it is for (the same
+	// unless given as name=sym)
+	name string
+	sym  string
+	data bool
+	// defined is whether the exporting object defines sym
+	defined bool
+}
+
+// parseExport returns the export described by the argument arg of an
+// /EXPORT directive: name[=sym][,@ordinal[,NONAME]][,DATA|,PRIVATE].
+func parseExport(arg string) export {
+	parts := strings.Split(arg, ",")
+	e := export{name: parts[0], sym: parts[0]}
+	if n, sym, ok := strings.Cut(parts[0], "="); ok {
+		e.name, e.sym = n, sym
+	}
+	for _, p := range parts[1:] {
+		if strings.EqualFold(p, "DATA") {
+			e.data = true
+		}
+	}
+	return e
+}
+
+// forwarded reports whether e forwards to a symbol of another DLL
+// (name=dll.sym).
+func (e export) forwarded() bool {
+	return e.sym != e.name && strings.Contains(e.sym, ".")
+}
+
+// noteObjSym records that the current object defines symbol sname,
+// for checkExports.
+func (s *state) noteObjSym(sname string) {
+	if s.objSyms == nil {
+		s.objSyms = make(map[string]bool)
+	}
+	s.objSyms[sname] = true
+}
+
+// checkExports records the exports of the current object, checking
+// each against the symbols the object defines. Where decorations are
+// stripped, an export of foo matches a definition of _foo or _foo@4.
+func (s *state) checkExports() {
+	var keys map[string]bool
+	if s.normalizes(s.objidx) {
+		keys = make(map[string]bool)
+		for sname := range s.objSyms {
+			keys[undecorate(sname)] = true
+		}
+	}
+	for _, d := range s.directives[s.objidx] {
+		if d.name != "EXPORT" || d.arg == "" {
+			continue
+		}
+		e := parseExport(d.arg)
+		e.objidx = s.objidx
+		e.defined = s.objSyms[e.sym] || keys[undecorate(e.sym)]
+		s.exports = append(s.exports, e)
+	}
+}
+
+// exportKey returns the breakdown symbol for the symbol exported by e.
+func (s *state) exportKey(e export) string {
+	x, _ := s.symKey(e.sym, e.objidx)
+	return x
+}
+
+// exportedSyms returns the set of breakdown symbols exported by some
+// object.
+func (s *state) exportedSyms() map[string]bool {
+	res := make(map[string]bool)
+	for _, e := range s.exports {
+		if e.defined {
+			res[s.exportKey(e)] = true
+		}
+	}
+	return res
+}
+
+// exportTag returns the breakdown annotation for symbol X, following
+// the mask, if some object exports it.
+func exportTag(x string, exported map[string]bool) string {
+	if exported[x] {
+		return " exported"
+	}
+	return ""
+}
+
+// impRefObjs returns, for each breakdown symbol X, the objects that
+// refer to __imp_X, sorted.
+func (s *state) impRefObjs() map[string][]int {
+	res := make(map[string][]int)
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			x, imp := s.symKey(sname, ri.objidx)
+			if !imp || ri.def {
+				continue
+			}
+			res[x] = append(res[x], ri.objidx)
+		}
+	}
+	for x, objs := range res {
+		sort.Ints(objs)
+		res[x] = dedupInts(objs)
+	}
+	return res
+}
+
+// dedupInts returns sorted list l without duplicates.
+func dedupInts(l []int) []int {
+	var res []int
+	for i, v := range l {
+		if i == 0 || v != l[i-1] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// definers returns the objects defining breakdown symbol x (other
+// than as __imp_x), sorted.
+func (s *state) definers(x string) []int {
+	var res []int
+	for sname, dl := range s.defs {
+		for _, di := range dl {
+			if k, imp := s.symKey(sname, di.objidx); k == x && !imp {
+				res = append(res, di.objidx)
+			}
+		}
+	}
+	sort.Ints(res)
+	return dedupInts(res)
+}
+
+// objList formats a list of objects as in the report: "O1 O3".
+func objList(objs []int) string {
+	l := make([]string, len(objs))
+	for i, o := range objs {
+		l[i] = fmt.Sprintf("O%d", o)
+	}
+	return strings.Join(l, " ")
+}
+
+// exportStatus describes how export e resolves, given the objects
+// referring to each symbol through __imp_X.
+func (s *state) exportStatus(e export, imprefs map[string][]int) string {
+	x := s.exportKey(e)
+	var st string
+	switch {
+	case e.defined:
+		st = "defined"
+	case e.forwarded():
+		return "forwarded to " + e.sym
+	default:
+		st = "not defined by the exporting object"
+		if objs := s.definers(x); len(objs) != 0 {
+			st += " (defined in " + objList(objs) + ")"
+		}
+	}
+	var importers []int
+	for _, o := range imprefs[x] {
+		if o != e.objidx {
+			importers = append(importers, o)
+		}
+	}
+	if len(importers) != 0 {
+		st += ", imported within the set by " + objList(importers)
+	}
+	return st
+}
+
+// writeExports writes the list of /EXPORT directives in s, if any,
+// with their status, to w.
+func (s *state) writeExports(w io.Writer) {
+	if len(s.exports) == 0 {
+		return
+	}
+	exps := append([]export(nil), s.exports...)
+	sort.SliceStable(exps, func(i, j int) bool {
+		if exps[i].name != exps[j].name {
+			return exps[i].name < exps[j].name
+		}
+		return exps[i].objidx < exps[j].objidx
+	})
+	imprefs := s.impRefObjs()
+	fmt.Fprintf(w, "Exports:\n")
+	for _, e := range exps {
+		name := fmt.Sprintf("%q", e.name)
+		if e.sym != e.name {
+			name += fmt.Sprintf(" (= %q)", e.sym)
+		}
+		if e.data {
+			name += " DATA"
+		}
+		fmt.Fprintf(w, " %s: O%d %s: %s\n", name, e.objidx, s.labels[e.objidx], s.exportStatus(e, imprefs))
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Support for the layouts of the text report (-format-version), so
+// that scripts reading the report aren't broken by changes to it.
+//
+// Version 1 is the original layout, with fields separated by single
+// spaces, offset lists on one line and sections given by number; it is
+// the default. For the inputs and flags the first version of the tool
+// took, the report is the one it wrote, byte for byte: it has no
+// Dumper, Machine or Summary blocks, gives only the sidecar path as an
+// object's provenance (not the source file from a .file symbol), names
+// objects by their paths rather than their labels (so not after each
+// reference, or in excerpt headers), gives the section of the symbol
+// rather than of the reference as S= in Refs, lists just the symbols
+// referred to directly there (each followed by its import symbols),
+// and notes watched symbols with no excerpts on stderr. Sections for
+// later features appear when there is something to report.
+//
+// Version 2 has what version 1 leaves out, and lines up the columns
+// of the Objects, Sections, Defs and Def/ref breakdown sections, wraps
+// long offset lists at -refs-width columns, gives sections by name
+// (sec=.text rather than sec=1) and the type of each relocation along
+// with its offset (0x9b/REL32), ends each Def/ref breakdown line with
+// the reference counts of its symbol ("(objs=14 relocs=37)"), and
+// starts the report with a "Format version: 2" line.
+//
+// The report code writes the columns of a section separated by tabs to
+// a table from the format, and offset lists through the format's
+// offsets method.
+
+const (
+	formatV1 = 1
+	formatV2 = 2
+	// the latest version, for -format-version=latest
+	formatLatest = formatV2
+)
+
+// textFormat is a layout of the text report.
+type textFormat interface {
+	// version returns the -format-version for the layout.
+	version() int
+	// newTable returns a writer for a section whose columns are
+	// separated by tabs; the section is complete once it is flushed.
+	newTable(w io.Writer) table
+	// offsets formats the offsets of a list of relocations,
+	// preceded by prefix and followed by suffix.
+	offsets(prefix string, relocs []relocinfo, suffix string) string
+	// section formats the section with symbol-table number num and
+	// name name, for the sec= field of Defs and the S= field of
+	// Refs.
+	section(num int, name string) string
+	// counts says whether the Def/ref breakdown lines end with the
+	// reference counts of their symbols.
+	counts() bool
+	// original says whether this is the original layout, for which
+	// the report is the one the first version of the tool wrote,
+	// byte for byte.
+	original() bool
+}
+
+// table is a writer for the columns of a section of the report.
+type table interface {
+	io.Writer
+	Flush() error
+}
+
+// textfmt is the layout in use.
+var textfmt textFormat = formatV1Text{}
+
+// parseFormatVersion returns the layout for the -format-version value v.
+func parseFormatVersion(v string) (textFormat, error) {
+	switch v {
+	case "1":
+		return formatV1Text{}, nil
+	case "2", "latest":
+		return formatV2Text{width: *refswidthflag}, nil
+	}
+	return nil, fmt.Errorf("bad -format-version %q: expected 1, 2 or latest", v)
+}
+
+// writeFormatHeader writes the line giving the layout version, for
+// layouts that have one, to w.
+func writeFormatHeader(w io.Writer) {
+	if v := textfmt.version(); v != formatV1 {
+		fmt.Fprintf(w, "Format version: %d\n", v)
+	}
+}
+
+// formatV1Text is the original layout.
+type formatV1Text struct{}
+
+func (formatV1Text) version() int { return formatV1 }
+
+func (formatV1Text) newTable(w io.Writer) table { return spaceTable{w} }
+
+func (formatV1Text) offsets(prefix string, relocs []relocinfo, suffix string) string {
+	if *refkindsflag {
+		items := make([]string, len(relocs))
+		for i, r := range relocs {
+			items[i] = fmt.Sprintf("0x%x:%s", r.off, r.kind)
+		}
+		return prefix + "[" + strings.Join(items, " ") + "]" + suffix
+	}
+	vals := make([]int, len(relocs))
+	for i, r := range relocs {
+		vals[i] = r.off
+	}
+	return prefix + hexlist(vals) + suffix
+}
+
+func (formatV1Text) section(num int, name string) string { return strconv.Itoa(num) }
+
+func (formatV1Text) counts() bool { return false }
+
+func (formatV1Text) original() bool { return true }
+
+// spaceTable is a table that separates columns with a single space.
+type spaceTable struct {
+	w io.Writer
+}
+
+func (st spaceTable) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(st.w, strings.ReplaceAll(string(p), "\t", " ")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (spaceTable) Flush() error { return nil }
+
+// formatV2Text is the layout with aligned columns, and offset lists
+// wrapped at width columns (if positive).
+type formatV2Text struct {
+	width int
+}
+
+func (formatV2Text) version() int { return formatV2 }
+
+// newTable returns a tabwriter, with columns separated by at least one
+// space.
+func (formatV2Text) newTable(w io.Writer) table {
+	return tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+}
+
+// offsets gives each offset with the short form of the relocation
+// type (if known), as in 0x9b/REL32, and with -ref-kinds the kind of
+// reference, as in 0x9b/REL32:icall.
+func (f formatV2Text) offsets(prefix string, relocs []relocinfo, suffix string) string {
+	items := make([]string, len(relocs))
+	for i, r := range relocs {
+		items[i] = fmt.Sprintf("0x%x", r.off)
+		if r.typ != "" {
+			items[i] += "/" + r.shortType()
+		}
+		if *refkindsflag {
+			items[i] += ":" + r.kind.String()
+		}
+	}
+	return wrapList(prefix, items, suffix, f.width)
+}
+
+func (formatV2Text) section(num int, name string) string { return name }
+
+func (formatV2Text) counts() bool { return true }
+
+func (formatV2Text) original() bool { return false }
+
+// wrapList formats a list of items in brackets, separated by spaces,
+// preceded by prefix and followed by suffix, wrapping the list so that
+// lines are no wider than width (if possible, and if width is
+// positive). Continuation lines are indented to line up with the first
+// item.
+func wrapList(prefix string, items []string, suffix string, width int) string {
+	if width <= 0 {
+		return prefix + "[" + strings.Join(items, " ") + "]" + suffix
+	}
+	sb := &strings.Builder{}
+	sb.WriteString(prefix)
+	sb.WriteString("[")
+	indent := strings.Repeat(" ", len(prefix)+1)
+	col := len(prefix) + 1
+	for i, h := range items {
+		if i != 0 {
+			need := col + 1 + len(h)
+			if i == len(items)-1 {
+				need++ // for the "]"
+			}
+			if need > width {
+				sb.WriteString("\n")
+				sb.WriteString(indent)
+				col = len(indent)
+			} else {
+				sb.WriteString(" ")
+				col++
+			}
+		}
+		sb.WriteString(h)
+		col += len(h)
+	}
+	sb.WriteString("]")
+	sb.WriteString(suffix)
+	return sb.String()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Support for Go object files (as written by the Go compiler and
+// assembler, and found as _go_.o in Go package archives), so that
+// both halves of a cgo build can be analyzed together. The symbols
+// are listed with "go tool nm"; there are no relocation offsets for
+// these objects, and no excerpts.
+
+// goObjectHeader returns the header line of infile if it is a Go
+// object, and "" if not.
+func goObjectHeader(infile string) string {
+	f, err := os.Open(infile)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var buf [128]byte
+	n, _ := io.ReadFull(f, buf[:])
+	hdr := buf[:n]
+	if !bytes.HasPrefix(hdr, []byte(goobjmag)) {
+		return ""
+	}
+	if i := bytes.IndexByte(hdr, '\n'); i != -1 {
+		hdr = hdr[:i]
+	}
+	return string(hdr)
+}
+
+// goObjectArch returns the GOARCH from a Go object header line, such
+// as "go object windows amd64 go1.20 X:none".
+func goObjectArch(hdr string) string {
+	f := strings.Fields(hdr)
+	if len(f) < 4 {
+		return ""
+	}
+	return f[3]
+}
+
+// goNm returns the "go tool nm" output for Go object infile, read in
+// the specified pass.
+func (s *state) goNm(infile, pass string) ([]byte, error) {
+	gonm := &objdumpDumper{name: "go tool nm", argv: []string{"go", "tool", "nm"}}
+	return s.runDumper(infile, "go nm", func() (io.Reader, error) {
+		r, err := gonm.run(infile)
+		if err != nil {
+			return nil, err
+		}
+		return s.keepTemp(s.objidx, pass, r)
+	})
+}
+
+// nmsym is a symbol listed by "go tool nm".
+type nmsym struct {
+	value int
+	typ   byte
+	name  string
+}
+
+// parseNmLine parses a line of "go tool nm" output, such as
+//
+//	46b T main.callimp
+//	    U __imp_foo
+//
+// Names may contain spaces.
+func parseNmLine(line string) (nmsym, error) {
+	rest := strings.TrimLeft(line, " ")
+	if strings.HasPrefix(rest, "U ") {
+		return nmsym{typ: 'U', name: rest[2:]}, nil
+	}
+	f := strings.SplitN(rest, " ", 3)
+	if len(f) != 3 || len(f[1]) != 1 {
+		return nmsym{}, fmt.Errorf("bad line %q in go tool nm output", line)
+	}
+	v, err := strconv.ParseUint(f[0], 16, 64)
+	if err != nil {
+		return nmsym{}, fmt.Errorf("bad value in line %q in go tool nm output", line)
+	}
+	return nmsym{value: int(v), typ: f[1][0], name: f[2]}, nil
+}
+
+// goSecidx returns the section index to record for a Go symbol of
+// the specified nm type: 0 for undefined symbols, -1 for those that
+// are neither defs nor refs.
+func goSecidx(typ byte) int {
+	switch typ {
+	case 'U':
+		return 0
+	case 'T', 't':
+		return 1
+	case 'D', 'd':
+		return 2
+	case 'B', 'b':
+		return 3
+	case 'R', 'r':
+		return 4
+	}
+	return -1
+}
+
+// readGoSymbols reads the symbols of Go object infile with "go tool
+// nm". In pass1 (when defs is nil) the interesting symbols are added
+// to the set of all symbols; in pass3 they are recorded as defs and
+// refs.
+func (s *state) readGoSymbols(infile string, defs map[string]struct{}) error {
+	pass := "pass3"
+	if defs == nil {
+		pass = "pass1"
+	}
+	out, err := s.goNm(infile, pass)
+	if err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 64<<10), maxDumpLine)
+	for sc.Scan() {
+		if sc.Text() == "" {
+			continue
+		}
+		sym, err := parseNmLine(sc.Text())
+		if err != nil {
+			return err
+		}
+		secidx := goSecidx(sym.typ)
+		if sym.name == "" || secidx < 0 || !s.isInterestingSym(sym.name) {
+			continue
+		}
+		if defs == nil {
+			s.all[sym.name] = true
+			continue
+		}
+		if err := s.addSymbol(sym.name, secidx, sym.value, defs); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading go tool nm output: %v", err)
+	}
+	if defs != nil {
+		s.finishSymtab("", defs)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Support for writing the import dependencies as a GraphML document
+// (-graphml), for tools such as yEd or Gephi. There are three kinds of
+// node: objects ("o<N>"), symbols and import symbols ("s:<name>"); and
+// three kinds of edge: "defines" and "references" from an object to a
+// symbol, and "thunk-of" from an import symbol __imp_X to X. Nodes and
+// edges carry GraphML data: for objects the path, provenance, number
+// of sections and their total size; for symbols the category (of the
+// base symbol, as in the Def/ref breakdown) and the number of
+// references and referring objects; for references the number of
+// relocations. With -graphml-watched only watched symbols (and the
+// objects that define or refer to them) are included.
+//
+// The document is written out as it goes rather than built up first.
+
+// graphmlKeys are the GraphML data keys: id, domain, name and type.
+var graphmlKeys = [][4]string{
+	{"kind", "all", "kind", "string"},
+	{"label", "node", "label", "string"},
+	{"path", "node", "path", "string"},
+	{"prov", "node", "provenance", "string"},
+	{"nsects", "node", "nsects", "int"},
+	{"sectsize", "node", "sectsize", "long"},
+	{"cats", "node", "categories", "string"},
+	{"nrefs", "all", "nrefs", "int"},
+	{"nobjs", "node", "nobjs", "int"},
+}
+
+// graphmlWriter writes a GraphML document.
+type graphmlWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// printf writes to the document, remembering the first error.
+func (gw *graphmlWriter) printf(format string, args ...interface{}) {
+	if gw.err == nil {
+		_, gw.err = fmt.Fprintf(gw.w, format, args...)
+	}
+}
+
+// xmlEscape returns v escaped for use in XML text or attribute values.
+func xmlEscape(v string) string {
+	sb := &strings.Builder{}
+	xml.EscapeText(sb, []byte(v))
+	return sb.String()
+}
+
+// data writes a data element with the specified key and value.
+func (gw *graphmlWriter) data(key string, v interface{}) {
+	gw.printf("   <data key=%q>%s</data>\n", key, xmlEscape(fmt.Sprint(v)))
+}
+
+// node writes a node with the specified id and kind, followed by the
+// data given as alternating keys and values.
+func (gw *graphmlWriter) node(id, kind string, kv ...interface{}) {
+	gw.printf("  <node id=\"%s\">\n", xmlEscape(id))
+	gw.data("kind", kind)
+	for i := 0; i+1 < len(kv); i += 2 {
+		gw.data(kv[i].(string), kv[i+1])
+	}
+	gw.printf("  </node>\n")
+}
+
+// edge writes an edge of the specified kind, followed by the data
+// given as alternating keys and values.
+func (gw *graphmlWriter) edge(src, dst, kind string, kv ...interface{}) {
+	gw.printf("  <edge source=\"%s\" target=\"%s\">\n", xmlEscape(src), xmlEscape(dst))
+	gw.data("kind", kind)
+	for i := 0; i+1 < len(kv); i += 2 {
+		gw.data(kv[i].(string), kv[i+1])
+	}
+	gw.printf("  </edge>\n")
+}
+
+// writeGraphML writes the GraphML document for s to the specified
+// file. If watchedOnly is set, only watched symbols are included.
+func (s *state) writeGraphML(path string, watchedOnly bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.emitGraphML(f, watchedOnly); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *state) emitGraphML(w io.Writer, watchedOnly bool) error {
+	type objsym struct {
+		objidx int
+		sym    string
+	}
+	// base symbol for each symbol, and the symbols to include
+	base := make(map[string]string)
+	imps := make(map[string]bool)
+	keep := func(sym string, objidx int) bool {
+		x, imp := s.symKey(sym, objidx)
+		if watchedOnly && !s.isWatched(sym, objidx) && !watchedName(x) {
+			return false
+		}
+		if _, ok := base[sym]; !ok {
+			base[sym] = x
+			imps[sym] = imp
+		}
+		return true
+	}
+	nrelocs := make(map[objsym]int)
+	nrefs := make(map[string]int)
+	nobjs := make(map[string]int)
+	for sym, rl := range s.refs {
+		for _, ri := range rl {
+			if (ri.def && len(ri.relocs) == 0) || !keep(sym, ri.objidx) {
+				continue
+			}
+			k := objsym{ri.objidx, sym}
+			if _, ok := nrelocs[k]; !ok {
+				nobjs[sym]++
+			}
+			nrelocs[k] += len(ri.relocs)
+			nrefs[sym] += len(ri.relocs)
+		}
+	}
+	var defs []objsym
+	for sym, dl := range s.defs {
+		for _, di := range dl {
+			if keep(sym, di.objidx) {
+				defs = append(defs, objsym{di.objidx, sym})
+			}
+		}
+	}
+	// import symbols bring in their base symbols
+	for sym, x := range base {
+		if imps[sym] {
+			if _, ok := base[x]; !ok {
+				base[x] = x
+			}
+		}
+	}
+	objs := make([]bool, len(s.objs))
+	for _, d := range defs {
+		objs[d.objidx] = true
+	}
+	for k := range nrelocs {
+		objs[k.objidx] = true
+	}
+	nsects := make([]int, len(s.objs))
+	sectsize := make([]int, len(s.objs))
+	for _, sn := range s.sects {
+		if sn.objidx < len(s.objs) {
+			nsects[sn.objidx]++
+			sectsize[sn.objidx] += sn.size
+		}
+	}
+
+	gw := &graphmlWriter{w: bufio.NewWriter(w)}
+	gw.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	gw.printf("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	for _, k := range graphmlKeys {
+		gw.printf(" <key id=%q for=%q attr.name=%q attr.type=%q/>\n", k[0], k[1], k[2], k[3])
+	}
+	gw.printf(" <graph id=\"winimpsym\" edgedefault=\"directed\">\n")
+	for i := range s.objs {
+		if !objs[i] {
+			continue
+		}
+		gw.node(fmt.Sprintf("o%d", i), "object", "label", s.labels[i], "path", s.objs[i],
+			"prov", s.objProv(i).String(), "nsects", nsects[i], "sectsize", sectsize[i])
+	}
+	syms := make([]string, 0, len(base))
+	for sym := range base {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		x := base[sym]
+		kind := "symbol"
+		if imps[sym] {
+			kind = "import"
+		}
+		gw.node("s:"+sym, kind, "label", sym, "cats", strings.TrimSpace(s.defref[x].String()),
+			"nrefs", nrefs[sym], "nobjs", nobjs[sym])
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].objidx != defs[j].objidx {
+			return defs[i].objidx < defs[j].objidx
+		}
+		return defs[i].sym < defs[j].sym
+	})
+	for _, d := range defs {
+		gw.edge(fmt.Sprintf("o%d", d.objidx), "s:"+d.sym, "defines")
+	}
+	refs := make([]objsym, 0, len(nrelocs))
+	for k := range nrelocs {
+		refs = append(refs, k)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].objidx != refs[j].objidx {
+			return refs[i].objidx < refs[j].objidx
+		}
+		return refs[i].sym < refs[j].sym
+	})
+	for _, k := range refs {
+		gw.edge(fmt.Sprintf("o%d", k.objidx), "s:"+k.sym, "references", "nrefs", nrelocs[k])
+	}
+	for _, sym := range syms {
+		if imps[sym] {
+			gw.edge("s:"+sym, "s:"+base[sym], "thunk-of")
+		}
+	}
+	gw.printf(" </graph>\n")
+	gw.printf("</graphml>\n")
+	if gw.err != nil {
+		return gw.err
+	}
+	return gw.w.Flush()
+}
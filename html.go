@@ -0,0 +1,214 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+)
+
+// Support for writing the report as a single self-contained HTML page
+// (-html), for attaching to issues. The page has the Objects listing
+// and the Def/ref breakdown as a table that can be sorted (by clicking
+// a column heading) and filtered; the references to each symbol and
+// its import symbol are in an expandable list under its row. Each
+// symbol has an anchor named after it, so that "report.html#__imp_foo"
+// links to it. There is no script or style from elsewhere, and the
+// page is kept plain enough that a browser copes with an -all run over
+// a large object set.
+
+// htmlReport is the data for the HTML report template.
+type htmlReport struct {
+	Dumper  string
+	Machine string
+	Objects []htmlObject
+	Syms    []htmlSym
+}
+
+type htmlObject struct {
+	Idx   int
+	Label string
+	Tags  string
+	Prov  string
+}
+
+// htmlSym is a row of the Def/ref breakdown.
+type htmlSym struct {
+	Name  string
+	Cats  string
+	DLL   string
+	NRefs int
+	// refs to the symbol and (if any) its import symbol
+	Refs []htmlRefs
+}
+
+type htmlRefs struct {
+	Name string
+	Refs []htmlRef
+}
+
+type htmlRef struct {
+	Obj     int
+	Label   string
+	Sec     string
+	Offsets string
+	Def     bool
+}
+
+// htmlData collects the data for the HTML report for s.
+func (s *state) htmlData() *htmlReport {
+	rep := &htmlReport{Machine: s.machine()}
+	if dumper != "" {
+		rep.Dumper = fmt.Sprintf("%s (%s)", dumper, dumperVersion)
+	}
+	mixed := s.mixedMachines() != nil
+	for i := range s.objs {
+		rep.Objects = append(rep.Objects, htmlObject{
+			Idx:   i,
+			Label: s.labels[i],
+			Tags:  strings.TrimSpace(s.objTags(i, mixed)),
+			Prov:  s.objProv(i).String(),
+		})
+	}
+	for _, x := range s.breakdownSyms() {
+		hs := htmlSym{
+			Name: x,
+			Cats: strings.TrimSpace(s.defref[x].String()),
+			DLL:  strings.Trim(s.dllTag(x), " []"),
+		}
+		for _, sname := range append([]string{x}, impNames(x)...) {
+			rl, ok := s.refs[sname]
+			if !ok {
+				continue
+			}
+			hr := htmlRefs{Name: sname}
+			for _, ri := range rl {
+				offs := make([]string, len(ri.relocs))
+				for j, r := range ri.relocs {
+					offs[j] = fmt.Sprintf("0x%x", r.off)
+				}
+				hr.Refs = append(hr.Refs, htmlRef{
+					Obj:     ri.objidx,
+					Label:   s.labels[ri.objidx],
+					Sec:     s.refSecName(&ri),
+					Offsets: strings.Join(offs, " "),
+					Def:     ri.def,
+				})
+				if !ri.def {
+					hs.NRefs++
+				}
+			}
+			hs.Refs = append(hs.Refs, hr)
+		}
+		rep.Syms = append(rep.Syms, hs)
+	}
+	return rep
+}
+
+// writeHTML writes the HTML report for s to the specified file.
+func (s *state) writeHTML(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := htmlTemplate.Execute(f, s.htmlData()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>winimpsym report</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+th.sort { cursor: pointer; background: #eee; }
+td.sym, .refs { font-family: monospace; }
+tr:target, div:target { background: #ffd; }
+details { margin: 0; }
+summary { cursor: pointer; }
+.def { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>winimpsym report</h1>
+{{if .Dumper}}<p>Dumper: {{.Dumper}}</p>{{end}}
+{{if .Machine}}<p>Machine: {{.Machine}}</p>{{end}}
+<h2>Objects</h2>
+<table>
+<tr><th></th><th>Object</th><th>Tags</th><th>Provenance</th></tr>
+{{range .Objects}}<tr id="O{{.Idx}}"><td>O{{.Idx}}</td><td>{{.Label}}</td><td>{{.Tags}}</td><td>{{.Prov}}</td></tr>
+{{end}}</table>
+<h2>Def/ref breakdown</h2>
+<p><input id="filter" type="search" placeholder="Filter symbols" size="40"> <span id="count"></span></p>
+<table id="breakdown">
+<thead><tr><th class="sort">Symbol</th><th class="sort">Categories</th><th class="sort">DLL</th><th class="sort">Referencing objects</th></tr></thead>
+<tbody>
+{{range .Syms}}{{$sym := .Name}}<tr id="{{.Name}}"><td class="sym"><a href="#{{.Name}}">{{.Name}}</a>{{if .Refs}}
+<details><summary>refs</summary>{{range .Refs}}
+<div class="refs"{{if ne .Name $sym}} id="{{.Name}}"{{end}}>{{.Name}}:<ul>{{range .Refs}}
+<li{{if .Def}} class="def"{{end}}><a href="#O{{.Obj}}">O{{.Obj}}</a> S={{.Sec}} [{{.Offsets}}] {{.Label}}{{if .Def}} (def){{end}}</li>{{end}}
+</ul></div>{{end}}</details>{{end}}</td><td>{{.Cats}}</td><td>{{.DLL}}</td><td>{{.NRefs}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+(function() {
+  var table = document.getElementById("breakdown");
+  var tbody = table.tBodies[0];
+  var rows = Array.prototype.slice.call(tbody.rows);
+  var filter = document.getElementById("filter");
+  var count = document.getElementById("count");
+  function cell(row, i) {
+    var c = row.cells[i];
+    return i == 0 ? c.firstChild.textContent : c.textContent;
+  }
+  function update() {
+    var q = filter.value.toLowerCase(), n = 0;
+    rows.forEach(function(row) {
+      var text = cell(row, 0) + " " + cell(row, 1) + " " + cell(row, 2);
+      var show = q == "" || text.toLowerCase().indexOf(q) >= 0;
+      row.style.display = show ? "" : "none";
+      if (show) n++;
+    });
+    count.textContent = n + " of " + rows.length + " symbols";
+  }
+  var ths = table.tHead.rows[0].cells;
+  Array.prototype.forEach.call(ths, function(th, i) {
+    th.addEventListener("click", function() {
+      var dir = th.dataset.dir == "asc" ? -1 : 1;
+      Array.prototype.forEach.call(ths, function(h) { delete h.dataset.dir; });
+      th.dataset.dir = dir > 0 ? "asc" : "desc";
+      rows.sort(function(a, b) {
+        var x = cell(a, i), y = cell(b, i);
+        if (i == 3) return dir * (x - y);
+        return dir * (x < y ? -1 : x > y ? 1 : 0);
+      });
+      rows.forEach(function(row) { tbody.appendChild(row); });
+    });
+  });
+  filter.addEventListener("input", update);
+  function reveal() {
+    var id = decodeURIComponent(location.hash.slice(1));
+    var el = id && document.getElementById(id);
+    if (!el) return;
+    var d = el.closest("details");
+    if (d) d.open = true;
+    el.scrollIntoView();
+  }
+  window.addEventListener("hashchange", reveal);
+  update();
+  reveal();
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,282 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Support for linked PE images (executables and DLLs) as inputs. For
+// these we don't have relocations to look at; instead we read the
+// import directory (and delay-load import directory) and treat each
+// imported function as a reference to the corresponding import
+// symbol from a pseudo-object for the image.
+
+type peimport struct {
+	// DLL the import comes from
+	dll string
+	// imported symbol name (empty for import by ordinal)
+	name string
+	// ordinal, if imported by ordinal
+	ordinal int
+	// delay-load import
+	delay bool
+}
+
+// sym returns the base symbol name used to represent the import in
+// the analysis. Imports by ordinal become "DLL#ordinal".
+func (pi peimport) sym() string {
+	if pi.name == "" {
+		return fmt.Sprintf("%s#%d", pi.dll, pi.ordinal)
+	}
+	return pi.name
+}
+
+// isImage returns true if the specified file is a PE image (as opposed
+// to a COFF object file or something else entirely).
+func isImage(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var dosHdr [0x40]byte
+	if _, err := io.ReadFull(f, dosHdr[:]); err != nil {
+		return false, nil
+	}
+	if dosHdr[0] != 'M' || dosHdr[1] != 'Z' {
+		return false, nil
+	}
+	peoff := int64(binary.LittleEndian.Uint32(dosHdr[0x3c:]))
+	var sig [4]byte
+	if _, err := f.ReadAt(sig[:], peoff); err != nil {
+		return false, nil
+	}
+	return string(sig[:]) == "PE\x00\x00", nil
+}
+
+const (
+	dirImport      = 1
+	dirDelayImport = 13
+)
+
+// peImage wraps a pe.File with helpers for reading data at RVAs.
+type peImage struct {
+	f         *pe.File
+	is64      bool
+	imageBase uint64
+	dirs      []pe.DataDirectory
+}
+
+func (pim *peImage) section(rva uint32) *pe.Section {
+	for _, sect := range pim.f.Sections {
+		if rva >= sect.VirtualAddress && rva < sect.VirtualAddress+sect.VirtualSize {
+			return sect
+		}
+	}
+	return nil
+}
+
+// readAt returns the contents of the image from rva to the end of the
+// section containing it.
+func (pim *peImage) readAt(rva uint32) ([]byte, error) {
+	sect := pim.section(rva)
+	if sect == nil {
+		return nil, fmt.Errorf("rva 0x%x not in any section", rva)
+	}
+	data, err := sect.Data()
+	if err != nil {
+		return nil, err
+	}
+	off := rva - sect.VirtualAddress
+	if int(off) >= len(data) {
+		return nil, fmt.Errorf("rva 0x%x beyond section %s data", rva, sect.Name)
+	}
+	return data[off:], nil
+}
+
+func (pim *peImage) cstring(rva uint32) (string, error) {
+	b, err := pim.readAt(rva)
+	if err != nil {
+		return "", err
+	}
+	for i := range b {
+		if b[i] == 0 {
+			return string(b[:i]), nil
+		}
+	}
+	return string(b), nil
+}
+
+// thunks reads the import lookup table at rva, appending an entry for
+// each imported symbol to res.
+func (pim *peImage) thunks(rva uint32, dll string, delay bool, res []peimport) ([]peimport, error) {
+	b, err := pim.readAt(rva)
+	if err != nil {
+		return nil, err
+	}
+	tsz := 4
+	ordflag := uint64(1) << 31
+	if pim.is64 {
+		tsz = 8
+		ordflag = uint64(1) << 63
+	}
+	for off := 0; off+tsz <= len(b); off += tsz {
+		var v uint64
+		if pim.is64 {
+			v = binary.LittleEndian.Uint64(b[off:])
+		} else {
+			v = uint64(binary.LittleEndian.Uint32(b[off:]))
+		}
+		if v == 0 {
+			return res, nil
+		}
+		pi := peimport{dll: dll, delay: delay}
+		if v&ordflag != 0 {
+			pi.ordinal = int(v & 0xffff)
+		} else {
+			// skip the two-byte hint
+			name, err := pim.cstring(uint32(v) + 2)
+			if err != nil {
+				return nil, err
+			}
+			pi.name = name
+		}
+		res = append(res, pi)
+	}
+	return nil, fmt.Errorf("unterminated import lookup table for %s", dll)
+}
+
+// dataDirs returns the first n of the data directories dd. debug/pe
+// accepts headers claiming more than the 16 it keeps.
+func dataDirs(dd []pe.DataDirectory, n uint32) []pe.DataDirectory {
+	if n < uint32(len(dd)) {
+		return dd[:n]
+	}
+	return dd
+}
+
+// readImageImports returns the regular and delay-load imports of the
+// specified PE image.
+func readImageImports(path string) ([]peimport, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pim := &peImage{f: f}
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		pim.imageBase = uint64(oh.ImageBase)
+		pim.dirs = dataDirs(oh.DataDirectory[:], oh.NumberOfRvaAndSizes)
+	case *pe.OptionalHeader64:
+		pim.is64 = true
+		pim.imageBase = oh.ImageBase
+		pim.dirs = dataDirs(oh.DataDirectory[:], oh.NumberOfRvaAndSizes)
+	default:
+		return nil, fmt.Errorf("no optional header")
+	}
+
+	var res []peimport
+	if len(pim.dirs) > dirImport && pim.dirs[dirImport].VirtualAddress != 0 {
+		b, err := pim.readAt(pim.dirs[dirImport].VirtualAddress)
+		if err != nil {
+			return nil, fmt.Errorf("reading import directory: %v", err)
+		}
+		// IMAGE_IMPORT_DESCRIPTOR
+		const descsz = 20
+		for off := 0; off+descsz <= len(b); off += descsz {
+			d := b[off : off+descsz]
+			oft := binary.LittleEndian.Uint32(d[0:])
+			nameRVA := binary.LittleEndian.Uint32(d[12:])
+			ft := binary.LittleEndian.Uint32(d[16:])
+			if nameRVA == 0 {
+				break
+			}
+			dll, err := pim.cstring(nameRVA)
+			if err != nil {
+				return nil, err
+			}
+			if oft == 0 {
+				oft = ft
+			}
+			if res, err = pim.thunks(oft, dll, false, res); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(pim.dirs) > dirDelayImport && pim.dirs[dirDelayImport].VirtualAddress != 0 {
+		b, err := pim.readAt(pim.dirs[dirDelayImport].VirtualAddress)
+		if err != nil {
+			return nil, fmt.Errorf("reading delay import directory: %v", err)
+		}
+		// IMAGE_DELAYLOAD_DESCRIPTOR
+		const descsz = 32
+		for off := 0; off+descsz <= len(b); off += descsz {
+			d := b[off : off+descsz]
+			attrs := binary.LittleEndian.Uint32(d[0:])
+			nameRVA := binary.LittleEndian.Uint32(d[4:])
+			intRVA := binary.LittleEndian.Uint32(d[16:])
+			if nameRVA == 0 {
+				break
+			}
+			// Old-style (VC6) descriptors hold VAs rather than RVAs.
+			if attrs&1 == 0 {
+				nameRVA -= uint32(pim.imageBase)
+				intRVA -= uint32(pim.imageBase)
+			}
+			dll, err := pim.cstring(nameRVA)
+			if err != nil {
+				return nil, err
+			}
+			if res, err = pim.thunks(intRVA, dll, true, res); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// readImage records the imports and sections of the PE image being
+// treated as object s.objidx.
+func (s *state) readImage(infile string) error {
+	imps, err := readImageImports(infile)
+	if err != nil {
+		return err
+	}
+	f, err := pe.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for k, sect := range f.Sections {
+		s.addSection(secinfo{
+			objidx: s.objidx,
+			name:   sect.Name,
+			size:   int(sect.VirtualSize),
+			idx:    k,
+		})
+	}
+	sort.SliceStable(imps, func(i, j int) bool {
+		return imps[i].sym() < imps[j].sym()
+	})
+	s.imports[s.objidx] = imps
+	for _, pi := range imps {
+		isym := impprefs[0] + pi.sym()
+		s.all[isym] = true
+		ri := refinfo{
+			objidx: s.objidx,
+		}
+		s.refs[isym] = append(s.refs[isym], ri)
+		s.maskAddRef(isym, s.objidx)
+		s.dllmap[pi.sym()] = pi.dll
+	}
+	return nil
+}
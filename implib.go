@@ -0,0 +1,159 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Support for reading import libraries, so as to map import symbols
+// to the DLLs they refer to. Two flavors of import library are
+// handled: those made up of "short" import objects (as produced by
+// the MSVC librarian and llvm-dlltool), and the "long" form produced
+// by GNU dlltool, in which each import is a regular COFF object
+// with .idata$N sections.
+
+const shortImportHdrSz = 20
+
+// isShortImport returns true if the archive member data is a short
+// import object: 0x0000/0xFFFF signature, version 0.
+func isShortImport(data []byte) bool {
+	return len(data) >= shortImportHdrSz &&
+		binary.LittleEndian.Uint16(data[0:]) == 0 &&
+		binary.LittleEndian.Uint16(data[2:]) == 0xffff &&
+		binary.LittleEndian.Uint16(data[4:]) == 0
+}
+
+// readShortImport returns the symbol name and DLL name from a short
+// import object.
+func readShortImport(data []byte) (string, string, error) {
+	names := data[shortImportHdrSz:]
+	parts := bytes.SplitN(names, []byte{0}, 3)
+	if len(parts) < 3 {
+		return "", "", fmt.Errorf("malformed short import object")
+	}
+	return string(parts[0]), string(parts[1]), nil
+}
+
+// readImportLib returns a map from (base) symbol name to DLL name for
+// the imports described by the import library implib. i386 names are
+// undecorated, as in the def/ref breakdown.
+func readImportLib(implib string) (map[string]string, error) {
+	members, err := readArchive(implib)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+
+	// For long-form import libraries, each function member references
+	// a "_head_X" symbol, defined in a head member that refers (via
+	// a .idata$2 relocation) to the "X_iname" symbol of a tail
+	// member, whose .idata$7 contents are the DLL name.
+	inames := make(map[string]string)
+	heads := make(map[string]string)
+	funcs := make(map[string]string)
+
+	for _, m := range members {
+		data := m.data
+		if data == nil {
+			return nil, fmt.Errorf("%s: thin import libraries not supported", implib)
+		}
+		if isShortImport(data) {
+			sym, dll, err := readShortImport(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s(%s): %v", implib, m.name, err)
+			}
+			if binary.LittleEndian.Uint16(data[6:]) == pe.IMAGE_FILE_MACHINE_I386 || *normalizedecorationsflag {
+				sym = undecorate(sym)
+			}
+			res[sym] = dll
+			continue
+		}
+		if !isCOFFHeader(data) {
+			continue
+		}
+		f, err := pe.NewFile(bytes.NewReader(data))
+		if err != nil {
+			// Not something we can make sense of; skip.
+			continue
+		}
+		var head string
+		var imps []string
+		for _, sym := range f.Symbols {
+			if sym.SectionNumber <= 0 {
+				if strings.HasPrefix(sym.Name, "_head_") {
+					head = sym.Name
+				}
+				continue
+			}
+			sect := f.Sections[sym.SectionNumber-1]
+			switch {
+			case strings.HasPrefix(sym.Name, imppref):
+				imp := sym.Name[len(imppref):]
+				if f.Machine == pe.IMAGE_FILE_MACHINE_I386 || *normalizedecorationsflag {
+					imp = undecorate(imp)
+				}
+				imps = append(imps, imp)
+			case strings.HasSuffix(sym.Name, "_iname") && sect.Name == ".idata$7":
+				sdata, err := sect.Data()
+				if err == nil && int(sym.Value) < len(sdata) {
+					dll := sdata[sym.Value:]
+					if i := bytes.IndexByte(dll, 0); i != -1 {
+						dll = dll[:i]
+					}
+					inames[sym.Name] = string(dll)
+				}
+			case strings.HasPrefix(sym.Name, "_head_"):
+				if iname := headIname(f); iname != "" {
+					heads[sym.Name] = iname
+				}
+			}
+		}
+		if head != "" {
+			for _, imp := range imps {
+				funcs[imp] = head
+			}
+		}
+	}
+	for sym, head := range funcs {
+		if dll, ok := inames[heads[head]]; ok {
+			res[sym] = dll
+		}
+	}
+	return res, nil
+}
+
+// isImportLib returns true if the archive lib is an import library,
+// that is, one giving the DLLs of some symbols. Libraries that can't
+// be read aren't, so that reading them as inputs reports the problem.
+func isImportLib(lib string) bool {
+	m, err := readImportLib(lib)
+	return err == nil && len(m) != 0
+}
+
+// headIname returns the name of the "X_iname" symbol targeted by the
+// .idata$2 relocations in a long-form import library head object.
+func headIname(f *pe.File) string {
+	for _, sect := range f.Sections {
+		if sect.Name != ".idata$2" {
+			continue
+		}
+		for _, r := range sect.Relocs {
+			if int(r.SymbolTableIndex) >= len(f.COFFSymbols) {
+				continue
+			}
+			rsym := &f.COFFSymbols[r.SymbolTableIndex]
+			rn, err := rsym.FullName(f.StringTable)
+			if err == nil && strings.HasSuffix(rn, "_iname") {
+				return rn
+			}
+		}
+	}
+	return ""
+}
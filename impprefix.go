@@ -0,0 +1,73 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Support for alternative import prefixes (-imp-prefix). By default
+// an import symbol is __imp_X; some toolchains (and thunk schemes)
+// use other prefixes, and -imp-prefix takes a comma-separated list of
+// them to use instead. Every check for an import symbol goes through
+// the helpers here. The symbols for the imports of images are named
+// with the first prefix; import libraries always use imppref, as
+// their format does.
+
+// impprefs is the import prefixes in use, in the order given.
+var impprefs = []string{imppref}
+
+// setupImpPrefixes sets the import prefixes from the -imp-prefix
+// value v.
+func setupImpPrefixes(v string) error {
+	impprefs = []string{imppref}
+	if v == "" {
+		return nil
+	}
+	impprefs = nil
+	for _, p := range strings.Split(v, ",") {
+		if p == "" {
+			return fmt.Errorf("bad -imp-prefix: empty prefix")
+		}
+		impprefs = append(impprefs, p)
+	}
+	return nil
+}
+
+// impPrefix returns the import prefix sname starts with, or "" if it
+// isn't an import symbol. If several match (__imp_aux_ and __imp_,
+// say), the longest wins.
+func impPrefix(sname string) string {
+	res := ""
+	for _, p := range impprefs {
+		if strings.HasPrefix(sname, p) && len(sname) > len(p) && len(p) > len(res) {
+			res = p
+		}
+	}
+	return res
+}
+
+// isImpSym reports whether sname is an import symbol.
+func isImpSym(sname string) bool {
+	return impPrefix(sname) != ""
+}
+
+// cutImp returns sname without its import prefix, and whether it had
+// one.
+func cutImp(sname string) (string, bool) {
+	p := impPrefix(sname)
+	return sname[len(p):], p != ""
+}
+
+// impNames returns the names of the import symbols for base symbol x,
+// one for each prefix.
+func impNames(x string) []string {
+	res := make([]string, len(impprefs))
+	for i, p := range impprefs {
+		res[i] = p + x
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for checking import slots. A proper definition of __imp_X
+// is a pointer-sized datum in a data section: .idata$5 for an import
+// library member, or .data or .rdata for a pseudo-import set up by
+// hand. Definitions in code or in .bss, in some other section, or too
+// close to the end of their section to hold a pointer, are listed
+// under "Suspicious import slots". Definitions in sections that
+// weren't read (see pass3SectionList) can't be checked.
+
+// suspectSlot is a suspicious definition of an import symbol, and
+// what is wrong with it.
+type suspectSlot struct {
+	sym    string
+	def    definfo
+	reason string
+}
+
+// ptrSize returns the size of a pointer for the machine of object
+// objidx.
+func (s *state) ptrSize(objidx int) int {
+	switch s.machines[objidx] {
+	case "386", "arm":
+		return 4
+	}
+	return 8
+}
+
+// isSlotSection reports whether a section named sname can hold an
+// import slot.
+func isSlotSection(sname string) bool {
+	for _, p := range []string{".idata$", ".data", ".rdata"} {
+		if strings.HasPrefix(sname, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSlot returns what is wrong with definition di of an import
+// symbol, or "" if nothing is (or it can't be checked).
+func (s *state) checkSlot(di definfo) string {
+	si := s.symSection(di.objidx, di.secidx)
+	if si == nil {
+		return ""
+	}
+	switch {
+	case isCodeSection(si.name):
+		return "in code section " + si.name
+	case strings.HasPrefix(si.name, ".bss"):
+		return "in uninitialized section " + si.name
+	case !isSlotSection(si.name):
+		return "in unexpected section " + si.name
+	}
+	if left, need := si.size-di.value, s.ptrSize(di.objidx); left < need {
+		if left < 0 {
+			return fmt.Sprintf("value beyond the end of %s (size 0x%x)", si.name, si.size)
+		}
+		return fmt.Sprintf("only %d bytes left in %s (size 0x%x) for a %d-byte slot", left, si.name, si.size, need)
+	}
+	return ""
+}
+
+// suspectSlots returns the suspicious import symbol definitions in s,
+// sorted by symbol and object.
+func (s *state) suspectSlots() []suspectSlot {
+	var res []suspectSlot
+	for sname, dl := range s.defs {
+		if !isImpSym(sname) {
+			continue
+		}
+		for _, di := range dl {
+			if why := s.checkSlot(di); why != "" {
+				res = append(res, suspectSlot{sname, di, why})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].sym != res[j].sym {
+			return res[i].sym < res[j].sym
+		}
+		return res[i].def.objidx < res[j].def.objidx
+	})
+	return res
+}
+
+// writeSuspectSlots writes the list of suspicious import symbol
+// definitions in s, if any, to w.
+func (s *state) writeSuspectSlots(w io.Writer) {
+	sss := s.suspectSlots()
+	if len(sss) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Suspicious import slots:\n")
+	for _, ss := range sss {
+		fmt.Fprintf(w, " %q: O%d sec=%s val=0x%x %s: %s\n", ss.sym, ss.def.objidx,
+			s.secField(ss.def.objidx, ss.def.secidx), ss.def.value, s.labels[ss.def.objidx], ss.reason)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Support for writing linker /INCLUDE directives for the import
+// symbols no object defines (-emit-include), for putting together
+// small repro links. There is a line for each import symbol __imp_X
+// referred to whose base symbol is refimp but not defimp, giving the
+// import symbol as the objects name it (so with the i386 decorations):
+//
+//	/INCLUDE:__imp__errno
+//
+// or with -emit-include-format=pragma
+//
+//	#pragma comment(linker, "/INCLUDE:__imp__errno")
+//
+// Lines are sorted; if there are no such symbols the file is empty.
+
+const (
+	includeLink   = "link"
+	includePragma = "pragma"
+)
+
+// checkIncludeFormat checks the -emit-include-format value f.
+func checkIncludeFormat(f string) error {
+	switch f {
+	case includeLink, includePragma:
+		return nil
+	}
+	return fmt.Errorf("bad -emit-include-format %q: expected %s or %s", f, includeLink, includePragma)
+}
+
+// unresolvedImports returns the import symbols, sorted, whose base
+// symbols are referred to through them but not defined as imports.
+func (s *state) unresolvedImports() []string {
+	var syms []string
+	for sname, rl := range s.refs {
+		if !isImpSym(sname) || len(rl) == 0 {
+			continue
+		}
+		x, _ := s.symKey(sname, rl[0].objidx)
+		if drm := s.defref[x]; drm&refimp != 0 && drm&defimp == 0 {
+			syms = append(syms, sname)
+		}
+	}
+	sort.Strings(syms)
+	return syms
+}
+
+// writeInclude writes the /INCLUDE directives for s, in format f, to
+// the specified file.
+func (s *state) writeInclude(path, f string) error {
+	sb := &strings.Builder{}
+	for _, sym := range s.unresolvedImports() {
+		if f == includePragma {
+			fmt.Fprintf(sb, "#pragma comment(linker, \"/INCLUDE:%s\")\n", sym)
+		} else {
+			fmt.Fprintf(sb, "/INCLUDE:%s\n", sym)
+		}
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0666)
+}
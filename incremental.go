@@ -0,0 +1,183 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Support for incremental analysis (-state). Running the dumper is by
+// far the most expensive part of the analysis, so we record its output
+// for each object, along with the object's size, modification time and
+// hash. On a later run, objects that are unchanged have their recorded
+// output replayed rather than running the dumper again; since the
+// replayed output is digested exactly as fresh output would be, the
+// report is the same as for a from-scratch run.
+
+type cacheEntry struct {
+	// file holding the object when the entry was recorded
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	Hash    string    `json:"hash"`
+	// dumper output, keyed by output flavor and kind of dump
+	Output map[string]string `json:"output"`
+}
+
+type dumpCache struct {
+	// entries keyed by object name
+	Entries map[string]*cacheEntry `json:"entries"`
+	// names of objects looked up in this run
+	used map[string]bool
+	// number of objects for which recorded output was reused
+	hits int
+}
+
+// dcache is the cache loaded from the -state file, if any.
+var dcache *dumpCache
+
+// loadDumpCache reads the state file at path. A missing file results in
+// an empty cache.
+func loadDumpCache(path string) (*dumpCache, error) {
+	dc := &dumpCache{
+		Entries: make(map[string]*cacheEntry),
+		used:    make(map[string]bool),
+	}
+	content, err := readText(path)
+	if os.IsNotExist(err) {
+		return dc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, dc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if dc.Entries == nil {
+		dc.Entries = make(map[string]*cacheEntry)
+	}
+	return dc, nil
+}
+
+// entry returns the cache entry for object oname (held in file),
+// discarding any recorded output if the object has changed since it
+// was recorded.
+func (dc *dumpCache) entry(oname, file string) (*cacheEntry, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	ce := dc.Entries[oname]
+	if !dc.used[oname] {
+		dc.used[oname] = true
+		// Archive members are extracted afresh each run, so their
+		// modification times never match; fall back on the hash.
+		if ce == nil || ce.Size != fi.Size() || !ce.ModTime.Equal(fi.ModTime()) {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			hash := fmt.Sprintf("%x", sha256.Sum256(content))
+			if ce == nil || ce.Hash != hash {
+				ce = &cacheEntry{Output: make(map[string]string)}
+			}
+			ce.Size, ce.ModTime, ce.Hash = fi.Size(), fi.ModTime(), hash
+		}
+		if len(ce.Output) != 0 {
+			dc.hits++
+		}
+		dc.Entries[oname] = ce
+	}
+	ce.File = file
+	return ce, nil
+}
+
+// prune drops entries for objects that no longer exist, along with a
+// note to stderr for each.
+func (dc *dumpCache) prune() {
+	names := make([]string, 0, len(dc.Entries))
+	for k := range dc.Entries {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, oname := range names {
+		if dc.used[oname] || objectExists(oname) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "note: pruning state for %s (no longer exists)\n", oname)
+		delete(dc.Entries, oname)
+	}
+}
+
+// objectExists returns true if the named object still exists; for
+// archive members ("lib.a(x.o)") this is the archive.
+func objectExists(oname string) bool {
+	if _, err := os.Stat(oname); err == nil {
+		return true
+	}
+	if i := strings.LastIndex(oname, "("); i > 0 && strings.HasSuffix(oname, ")") {
+		if _, err := os.Stat(oname[:i]); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the cache to the specified state file.
+func (dc *dumpCache) save(path string) error {
+	b, err := json.Marshal(dc)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0666)
+}
+
+// runDumper calls run to dump infile (the file for the current
+// object), returning its output. When -state is in effect, output
+// recorded for an unchanged object is returned instead; kind says
+// what sort of dump it is.
+func (s *state) runDumper(infile, kind string, run func() (io.Reader, error)) ([]byte, error) {
+	var ce *cacheEntry
+	key := flavor + " " + kind
+	if dcache != nil {
+		var err error
+		if ce, err = dcache.entry(s.objs[s.objidx], infile); err != nil {
+			return nil, err
+		}
+		if out, ok := ce.Output[key]; ok {
+			return []byte(out), nil
+		}
+	}
+	out, err := readDump(run())
+	if err != nil {
+		return nil, err
+	}
+	if ce != nil {
+		ce.Output[key] = string(out)
+	}
+	return out, nil
+}
+
+// openDump is runDumper for output to be read as it is produced: the
+// reader returned by run is passed through as is, unless -state needs
+// the whole of it to record.
+func (s *state) openDump(infile, kind string, run func() (io.Reader, error)) (io.Reader, error) {
+	if dcache == nil {
+		return run()
+	}
+	out, err := s.runDumper(infile, kind, run)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(out), nil
+}
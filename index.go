@@ -0,0 +1,72 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Support for writing a symbol index (-index): one line per symbol
+// defined or referred to, giving the objects that define ("d") or
+// refer to ("r") it, for example
+//
+//	__imp__errno	3:r 17:r 22:d
+//
+// A symbol and its import symbol get separate lines. Lines are sorted
+// by symbol, and the entries on a line by object (with "d" before "r"
+// for an object doing both).
+
+// writeIndex writes the symbol index for s to the specified file.
+func (s *state) writeIndex(path string) error {
+	roles := make(map[string]map[int]string)
+	add := func(sym string, objidx int, role string) {
+		if roles[sym] == nil {
+			roles[sym] = make(map[int]string)
+		}
+		if !strings.Contains(roles[sym][objidx], role) {
+			roles[sym][objidx] += role
+		}
+	}
+	for sym, dl := range s.defs {
+		for _, di := range dl {
+			add(sym, di.objidx, "d")
+		}
+	}
+	for sym, rl := range s.refs {
+		for _, ri := range rl {
+			if !ri.def || len(ri.relocs) != 0 {
+				add(sym, ri.objidx, "r")
+			}
+		}
+	}
+	syms := make([]string, 0, len(roles))
+	for sym := range roles {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	sb := &strings.Builder{}
+	for _, sym := range syms {
+		objs := make([]int, 0, len(roles[sym]))
+		for oi := range roles[sym] {
+			objs = append(objs, oi)
+		}
+		sort.Ints(objs)
+		var entries []string
+		for _, oi := range objs {
+			r := roles[sym][oi]
+			if strings.Contains(r, "d") {
+				entries = append(entries, fmt.Sprintf("%d:d", oi))
+			}
+			if strings.Contains(r, "r") {
+				entries = append(entries, fmt.Sprintf("%d:r", oi))
+			}
+		}
+		fmt.Fprintf(sb, "%s\t%s\n", sym, strings.Join(entries, " "))
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0666)
+}
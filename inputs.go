@@ -0,0 +1,832 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxListLine is the longest line we'll accept when reading a list of
+// input files (as opposed to the 64k bufio.Scanner default).
+const maxListLine = 16 * 1024 * 1024
+
+// gzipmag is the magic number that starts a gzip stream.
+const gzipmag = "\x1f\x8b"
+
+// readText returns the contents of the specified text artifact (list,
+// captured dump, sidecar, etc), transparently decompressing it if it
+// is gzip-compressed (has a .gz suffix or starts with gzip magic).
+func readText(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") && !bytes.HasPrefix(content, []byte(gzipmag)) {
+		return content, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad gzip data: %v", path, err)
+	}
+	defer zr.Close()
+	content, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad gzip data: %v", path, err)
+	}
+	return content, nil
+}
+
+// listMode says how readPathList divides a list of paths into
+// entries.
+type listMode int
+
+const (
+	// entries separated by whitespace or newlines, with lines
+	// starting with '#' ignored (the list on stdin)
+	listFields listMode = iota
+	// one entry per line, with lines starting with '#' ignored (@file
+	// response files)
+	listLines
+	// one entry per line, with trailing whitespace (including CRs
+	// from CRLF line endings) ignored and environment variables such
+	// as $WORK expanded; each path named must exist (-ifile)
+	listEnvLines
+	// entries separated by whitespace, with double quotes grouping,
+	// in UTF-8 or UTF-16 (link.exe response files, -linkrsp)
+	listQuoted
+)
+
+// stdinList is the path readPathList takes to mean stdin.
+const stdinList = "-"
+
+// readPathList reads a list of paths from file path (or stdin, for
+// stdinList), divided into entries according to mode. Blank lines are
+// ignored. Errors for a file already name it, so callers needn't.
+func readPathList(path string, mode listMode) ([]string, error) {
+	var content []byte
+	var err error
+	if path == stdinList {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = readText(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitPathList(path, content, mode)
+}
+
+// splitPathList divides content, the contents of list file path, into
+// entries according to mode.
+func splitPathList(path string, content []byte, mode listMode) ([]string, error) {
+	if mode == listQuoted {
+		return splitResponseFile(decodeResponseFile(content)), nil
+	}
+	var res []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), maxListLine)
+	lno := 0
+	for scanner.Scan() {
+		lno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || (mode != listEnvLines && strings.HasPrefix(line, "#")) {
+			continue
+		}
+		switch mode {
+		case listFields:
+			res = append(res, strings.Fields(line)...)
+		case listLines:
+			res = append(res, line)
+		case listEnvLines:
+			p := os.ExpandEnv(strings.TrimRightFunc(scanner.Text(), unicode.IsSpace))
+			if _, err := os.Stat(p); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lno, err)
+			}
+			res = append(res, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if path == stdinList {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return res, nil
+}
+
+// expandInputs expands any "@file" response file references in the
+// list of inputs, preserving the order in which entries appear.
+func expandInputs(inputs []string) ([]string, error) {
+	res := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		if !strings.HasPrefix(in, "@") {
+			res = append(res, in)
+			continue
+		}
+		rspfile := in[1:]
+		rsp, err := readPathList(rspfile, listLines)
+		if err != nil {
+			return nil, fmt.Errorf("reading response file: %v", err)
+		}
+		res = append(res, rsp...)
+	}
+	return res, nil
+}
+
+// hasMeta reports whether path contains any of the magic characters
+// recognized by filepath.Match.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
+
+// expandGlobs expands any glob patterns in the list of inputs. Single
+// star patterns are handled with filepath.Glob, and patterns containing
+// a "**" path element (matching zero or more directories) with a
+// directory walk. The matches for each pattern are sorted, so that
+// object indices are stable from run to run; a pattern that matches
+// nothing is an error.
+func expandGlobs(inputs []string) ([]string, error) {
+	res := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		if !hasMeta(in) {
+			res = append(res, in)
+			continue
+		}
+		var matches []string
+		var err error
+		if strings.Contains(in, "**") {
+			matches, err = globRecursive(in)
+		} else {
+			matches, err = filepath.Glob(in)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("expanding pattern %q: %v", in, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("pattern %q matches no files", in)
+		}
+		sort.Strings(matches)
+		res = append(res, matches...)
+	}
+	return res, nil
+}
+
+// globRecursive expands a pattern in which "**" path elements match
+// zero or more directories.
+func globRecursive(pattern string) ([]string, error) {
+	psegs := strings.Split(filepath.ToSlash(pattern), "/")
+	// Walk from the longest prefix of the pattern free of meta chars.
+	k := 0
+	for k < len(psegs) && !hasMeta(psegs[k]) {
+		k++
+	}
+	root := strings.Join(psegs[:k], "/")
+	if root == "" {
+		root = "."
+		if strings.HasPrefix(pattern, "/") {
+			root = "/"
+		}
+	}
+	psegs = psegs[k:]
+	for _, seg := range psegs {
+		if seg == "**" {
+			continue
+		}
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return nil, err
+		}
+	}
+	var res []string
+	err := filepath.WalkDir(filepath.FromSlash(root), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(root), path)
+		if err != nil {
+			return err
+		}
+		if matchSegments(psegs, strings.Split(filepath.ToSlash(rel), "/")) {
+			res = append(res, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// matchSegments matches a slash-split path against a slash-split
+// pattern, where a "**" pattern element matches any number of path
+// elements.
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := filepath.Match(pat[0], name[0]); !ok || err != nil {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// listFlag is a flag.Value accumulating lists of values across
+// repeated uses of a flag. Splitting of each value into list elements
+// is deferred until after flag parsing (see elems), since the
+// separator is itself configurable.
+type listFlag []string
+
+func (lf *listFlag) String() string {
+	return strings.Join(*lf, " ")
+}
+
+func (lf *listFlag) Set(v string) error {
+	*lf = append(*lf, v)
+	return nil
+}
+
+// elems returns the list elements from all uses of the flag, split
+// at sep; empty elements are dropped.
+func (lf *listFlag) elems(sep string) []string {
+	var res []string
+	for _, v := range *lf {
+		for _, e := range strings.Split(v, sep) {
+			if e != "" {
+				res = append(res, e)
+			}
+		}
+	}
+	return res
+}
+
+// stdinName is the name used in reports for an object read from stdin.
+const stdinName = "<stdin>"
+
+// stdinObject copies an object streamed on stdin (-stdin) to a file in
+// the temp dir, since the dumper needs a path, and returns the path.
+func stdinObject() (string, error) {
+	td, err := tempDir()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(filepath.Join(td, "stdin.o"))
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, os.Stdin)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	if n == 0 {
+		f.Close()
+		return "", fmt.Errorf("no data")
+	}
+	return f.Name(), f.Close()
+}
+
+// collectInputs returns the list of input files to analyze, based on
+// the command line flags (or stdin, if no inputs were given).
+func collectInputs() []string {
+	var infiles []string
+	var err error
+	// Files named on the command line come after any -i values.
+	if *isepflag == "" {
+		usage("-isep must not be empty")
+	}
+	given := append(inputsflag.elems(*isepflag), flag.Args()...)
+	if len(given) != 0 && *ifileflag != "" {
+		usage("-i (or input file arguments) and -ifile are mutually exclusive")
+	}
+	if len(given) != 0 {
+		infiles, err = expandInputs(given)
+		if err != nil {
+			fatal("%v", err)
+		}
+	} else if *ifileflag != "" {
+		if infiles, err = readPathList(*ifileflag, listEnvLines); err != nil {
+			fatal("%v", err)
+		}
+	} else if *dirflag == "" && *gopkgflag == "" && *sysoflag == "" &&
+		*linkrspflag == "" && *loadflag == "" {
+		// Don't sit waiting on a terminal for input that isn't coming.
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			usage("supply input files with -i option or on stdin")
+		}
+		if infiles, err = readPathList(stdinList, listFields); err != nil {
+			fatal("reading input list from stdin: %v", err)
+		}
+		if len(infiles) == 0 {
+			usage("supply input files with -i option or on stdin")
+		}
+	}
+	if infiles, err = expandGlobs(infiles); err != nil {
+		fatal("%v", err)
+	}
+	if *linkrspflag != "" {
+		found, implibs, err := readLinkResponseFile(*linkrspflag)
+		if err != nil {
+			fatal("reading linker response file: %v", err)
+		}
+		infiles = append(infiles, found...)
+		if len(implibs) != 0 {
+			if *implibflag != "" {
+				implibs = append([]string{*implibflag}, implibs...)
+			}
+			*implibflag = strings.Join(implibs, ",")
+		}
+	}
+	if *dirflag != "" {
+		var re *regexp.Regexp
+		if *dirpatflag != "" {
+			if re, err = regexp.Compile(*dirpatflag); err != nil {
+				fatal("bad -dirpattern: %v", err)
+			}
+		}
+		found, err := findObjects(*dirflag, re)
+		if err != nil {
+			fatal("searching %s: %v", *dirflag, err)
+		}
+		if len(found) == 0 {
+			fatal("no object files found in %s", *dirflag)
+		}
+		infiles = append(infiles, found...)
+	}
+	if *sysoflag != "" {
+		found, err := sysoObjects(*sysoflag)
+		if err != nil {
+			fatal("searching %s: %v", *sysoflag, err)
+		}
+		infiles = append(infiles, found...)
+	}
+	if *gopkgflag != "" {
+		found, err := goPkgObjects(*gopkgflag)
+		if err != nil {
+			fatal("%v", err)
+		}
+		infiles = append(infiles, found...)
+	}
+	return infiles
+}
+
+// sysoMachines maps GOARCH values to the COFF machine types of
+// matching .syso files.
+var sysoMachines = map[string]uint16{
+	"386":   pe.IMAGE_FILE_MACHINE_I386,
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm":   pe.IMAGE_FILE_MACHINE_ARMNT,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+// sysoObjects returns the .syso files found in the directory tree
+// rooted at dir that are COFF objects for the target architecture
+// ($GOARCH, or the host architecture), sorted by path. Others (for
+// example ELF .syso files for other GOOS values) are skipped with a
+// notice.
+func sysoObjects(dir string) ([]string, error) {
+	goarch := os.Getenv("GOARCH")
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	want, ok := sysoMachines[goarch]
+	if !ok {
+		return nil, fmt.Errorf("no COFF machine type for GOARCH=%s", goarch)
+	}
+	found, err := findObjects(dir, regexp.MustCompile(`\.syso$`))
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, path := range found {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var hdr [4]byte
+		n, _ := io.ReadFull(f, hdr[:])
+		f.Close()
+		switch {
+		case !isCOFFHeader(hdr[:n]):
+			fmt.Fprintf(os.Stderr, "note: skipping %s: not a COFF object\n", path)
+		case binary.LittleEndian.Uint16(hdr[:]) != want:
+			fmt.Fprintf(os.Stderr, "note: skipping %s: not for GOARCH=%s\n", path, goarch)
+		default:
+			res = append(res, path)
+		}
+	}
+	return res, nil
+}
+
+// goPkgObjects builds the specified Go package (and its dependencies)
+// with "go build -work -x -a", and returns the cgo-generated host
+// objects from the build's work directory, sorted by path. The work
+// directory is removed on exit unless -keepwork is in effect.
+func goPkgObjects(pkg string) ([]string, error) {
+	gotool := filepath.Join(runtime.GOROOT(), "bin", "go")
+	doing = "building " + pkg
+	defer func() { doing = "" }()
+	cmd := exec.CommandContext(runCtx, gotool, "build", "-work", "-x", "-a",
+		"-o", os.DevNull, pkg)
+	out, err := cmd.CombinedOutput()
+	work := parseWorkDir(out)
+	if work != "" {
+		if *keepworkflag {
+			fmt.Fprintf(os.Stderr, "WORK=%s\n", work)
+		} else {
+			addCleanup(work)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("go build %s failed: %v\n%s", pkg, err, out)
+	}
+	if work == "" {
+		return nil, fmt.Errorf("could not locate WORK dir in go build output")
+	}
+	// Host objects live in the per-action b001, b002, ... dirs. Skip
+	// the objects that cgo only uses for its dynamic import check.
+	bdirs, err := filepath.Glob(filepath.Join(work, "b[0-9][0-9][0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, bdir := range bdirs {
+		objs, err := findObjects(bdir, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			switch filepath.Base(obj) {
+			case "_cgo_.o", "_cgo_main.o":
+				continue
+			}
+			if filepath.Ext(obj) == ".o" {
+				res = append(res, obj)
+			}
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no cgo host objects found building %s", pkg)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// parseWorkDir extracts the work directory from the "WORK=..." line
+// emitted by "go build -work".
+func parseWorkDir(out []byte) string {
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "WORK=") {
+			return line[len("WORK="):]
+		}
+	}
+	return ""
+}
+
+// isObjectFile returns true if the file name has one of the suffixes
+// we expect for object files.
+func isObjectFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".o", ".obj", ".syso":
+		return true
+	}
+	return false
+}
+
+// findObjects walks the directory tree rooted at dir and returns the
+// object files within it (restricted to those whose slash-separated
+// path matches re, if non-nil), sorted by path. Symbolic links to
+// directories are followed, taking care not to revisit a directory
+// we've already seen.
+func findObjects(dir string, re *regexp.Regexp) ([]string, error) {
+	var res []string
+	visited := make(map[string]bool)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		rdir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if rdir, err = filepath.Abs(rdir); err != nil {
+			return err
+		}
+		if visited[rdir] {
+			return nil
+		}
+		visited[rdir] = true
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, ent := range ents {
+			path := filepath.Join(dir, ent.Name())
+			isdir := ent.IsDir()
+			if ent.Type()&fs.ModeSymlink != 0 {
+				fi, err := os.Stat(path)
+				if err != nil {
+					// dangling link
+					continue
+				}
+				isdir = fi.IsDir()
+			}
+			if isdir {
+				if err := walk(path); err != nil {
+					return err
+				}
+				continue
+			}
+			if !isObjectFile(path) {
+				continue
+			}
+			if re != nil && !re.MatchString(filepath.ToSlash(path)) {
+				continue
+			}
+			res = append(res, path)
+		}
+		return nil
+	}
+	if err := walk(dir); err != nil {
+		return nil, err
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// expandArchives expands any archives in the list of inputs into their
+// constituent members, returning a list of object names (for reporting)
+// and a parallel list of files to hand to the dumper. Members are
+// extracted to a temporary directory, except in the case of thin
+// archives, where the member files can be used directly.
+func expandArchives(inputs []string) ([]string, []string, error) {
+	objs := make([]string, 0, len(inputs))
+	files := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		isar, err := isArchive(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isar {
+			objs = append(objs, in)
+			files = append(files, in)
+			continue
+		}
+		members, err := readArchive(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		var exdir string
+		var skipped []string
+		seen := make(map[string]int)
+		for _, m := range members {
+			if why := skipReason(m); why != "" {
+				verb(2, "%s: skipping member %s: %s", in, m.name, why)
+				skipped = append(skipped, m.name)
+				continue
+			}
+			// Members with duplicate names are common; tag the
+			// second and subsequent ones with an ordinal.
+			seen[m.name]++
+			mname := m.name
+			if n := seen[m.name]; n > 1 {
+				mname = fmt.Sprintf("%s#%d", m.name, n)
+			}
+			objs = append(objs, fmt.Sprintf("%s(%s)", in, mname))
+			if m.path != "" {
+				files = append(files, m.path)
+				continue
+			}
+			if exdir == "" {
+				td, err := tempDir()
+				if err != nil {
+					return nil, nil, err
+				}
+				if exdir, err = os.MkdirTemp(td, "ar"); err != nil {
+					return nil, nil, err
+				}
+			}
+			// Extract into a separate subdir per member, so as to keep
+			// the member's own name (which may contain slashes).
+			mdir := filepath.Join(exdir, fmt.Sprintf("%d", len(files)))
+			mfile := filepath.Join(mdir, filepath.Base(m.name))
+			if err := os.MkdirAll(mdir, 0777); err != nil {
+				return nil, nil, err
+			}
+			if err := os.WriteFile(mfile, m.data, 0666); err != nil {
+				return nil, nil, fmt.Errorf("extracting %s from %s: %v", m.name, in, err)
+			}
+			files = append(files, mfile)
+		}
+		if len(skipped) != 0 {
+			verb(1, "%s: skipped %d non-COFF members: %s", in,
+				len(skipped), strings.Join(skipped, " "))
+		}
+	}
+	return objs, files, nil
+}
+
+// dupinput records an input that was dropped because it duplicates
+// another input.
+type dupinput struct {
+	// name of the dropped input
+	name string
+	// name of the input it duplicates
+	orig string
+	// object indices assigned to orig
+	objidxs []int
+	// "same path" or "same content"
+	reason string
+}
+
+// dedupPaths removes inputs that refer to the same file as an earlier
+// input (comparing cleaned absolute paths).
+func dedupPaths(inputs []string) ([]string, []dupinput) {
+	var dups []dupinput
+	res := make([]string, 0, len(inputs))
+	seen := make(map[string]string)
+	for _, in := range inputs {
+		key := filepath.Clean(in)
+		if abs, err := filepath.Abs(in); err == nil {
+			key = abs
+		}
+		if orig, ok := seen[key]; ok {
+			dups = append(dups, dupinput{name: in, orig: orig, reason: "same path"})
+			continue
+		}
+		seen[key] = in
+		res = append(res, in)
+	}
+	return res, dups
+}
+
+// dedupContent removes objects whose contents are identical to those
+// of an earlier object.
+func dedupContent(objs, files []string) ([]string, []string, []dupinput, error) {
+	var dups []dupinput
+	robjs := make([]string, 0, len(objs))
+	rfiles := make([]string, 0, len(files))
+	seen := make(map[[sha256.Size]byte]int)
+	for k := range files {
+		content, err := os.ReadFile(files[k])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sum := sha256.Sum256(content)
+		if oidx, ok := seen[sum]; ok {
+			dups = append(dups, dupinput{name: objs[k], orig: robjs[oidx],
+				objidxs: []int{oidx}, reason: "same content"})
+			continue
+		}
+		seen[sum] = len(robjs)
+		robjs = append(robjs, objs[k])
+		rfiles = append(rfiles, files[k])
+	}
+	return robjs, rfiles, dups, nil
+}
+
+// resolveDups fills in the object indices for inputs collapsed by
+// dedupPaths, now that archives have been expanded into objs.
+func resolveDups(dups []dupinput, objs []string) {
+	for i := range dups {
+		d := &dups[i]
+		if d.objidxs != nil {
+			continue
+		}
+		for k, o := range objs {
+			if o == d.orig || strings.HasPrefix(o, d.orig+"(") {
+				d.objidxs = append(d.objidxs, k)
+			}
+		}
+	}
+}
+
+// filterObjects drops objects for which the reject function returns
+// a non-empty reason, reporting each in verbose mode.
+func filterObjects(objs, files []string, reject func(obj, file string) string) ([]string, []string) {
+	robjs := make([]string, 0, len(objs))
+	rfiles := make([]string, 0, len(files))
+	for k := range objs {
+		if why := reject(objs[k], files[k]); why != "" {
+			verb(1, "skipping %s: %s", objs[k], why)
+			continue
+		}
+		robjs = append(robjs, objs[k])
+		rfiles = append(rfiles, files[k])
+	}
+	return robjs, rfiles
+}
+
+// matchObject returns true if re matches either the name of the object
+// or its provenance info.
+func matchObject(re *regexp.Regexp, obj, file string) bool {
+	if re.MatchString(obj) {
+		return true
+	}
+	pi := pathinfo(file)
+	return (pi.Path != "" && re.MatchString(pi.Path)) ||
+		(pi.Pkg != "" && re.MatchString(pi.Pkg))
+}
+
+// resolveObjects determines the final list of objects to analyze,
+// returning object names (for reporting), the corresponding files to
+// hand to the dumper, and a record of inputs collapsed as duplicates.
+func resolveObjects() ([]string, []string, []dupinput) {
+	var objs, files []string
+	var pdups []dupinput
+	var err error
+	if *stdinflag {
+		if len(inputsflag) != 0 || flag.NArg() != 0 || *ifileflag != "" ||
+			*dirflag != "" || *sysoflag != "" || *gopkgflag != "" ||
+			*linkrspflag != "" || *fromdumpflag != "" {
+			usage("-stdin can't be combined with other inputs")
+		}
+		file, err := stdinObject()
+		if err != nil {
+			fatal("reading object from stdin: %v", err)
+		}
+		objs, files = []string{stdinName}, []string{file}
+	} else if *fromdumpflag != "" {
+		objs = strings.Split(*fromdumpflag, ",")
+		files = objs
+		if *fromdisasmflag != "" && len(strings.Split(*fromdisasmflag, ",")) != len(objs) {
+			usage("-from-disasm list must be parallel to -from-dump list")
+		}
+	} else {
+		var infiles []string
+		infiles, pdups = dedupPaths(collectInputs())
+		if objs, files, err = expandArchives(infiles); err != nil {
+			fatal("%v", err)
+		}
+	}
+	if *objmatchflag != "" {
+		re, err := regexp.Compile(*objmatchflag)
+		if err != nil {
+			fatal("bad -objmatch: %v", err)
+		}
+		objs, files = filterObjects(objs, files, func(obj, file string) string {
+			if matchObject(re, obj, file) {
+				return ""
+			}
+			return fmt.Sprintf("does not match -objmatch %q", *objmatchflag)
+		})
+		if len(objs) == 0 {
+			fatal("no inputs left after applying -objmatch %q", *objmatchflag)
+		}
+	}
+	if *objexcludeflag != "" {
+		re, err := regexp.Compile(*objexcludeflag)
+		if err != nil {
+			fatal("bad -objexclude: %v", err)
+		}
+		n := len(objs)
+		objs, files = filterObjects(objs, files, func(obj, file string) string {
+			if matchObject(re, obj, file) {
+				return fmt.Sprintf("matches -objexclude %q", *objexcludeflag)
+			}
+			return ""
+		})
+		verb(1, "excluded %d of %d inputs with -objexclude", n-len(objs), n)
+		if len(objs) == 0 {
+			fatal("no inputs left after applying -objexclude %q", *objexcludeflag)
+		}
+	}
+	var cdups []dupinput
+	if *dedupcontentflag {
+		if objs, files, cdups, err = dedupContent(objs, files); err != nil {
+			fatal("%v", err)
+		}
+	}
+	resolveDups(pdups, objs)
+	return objs, files, append(pdups, cdups...)
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+)
+
+// Handling of interrupts. The first Ctrl-C cancels runCtx, which kills
+// any dumper (or go build) that is running; the run then ends at the
+// first chance, saying what it was doing, removing its scratch files
+// and exiting with status 130. A second Ctrl-C exits at once.
+
+// interrupted is set once the run has been interrupted.
+var interrupted atomic.Bool
+
+// doing says what the run is doing, for the message when interrupted.
+var doing string
+
+// handleInterrupts sets up the handling of interrupts, returning a
+// context, derived from ctx, that is cancelled by one.
+func handleInterrupts(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		interrupted.Store(true)
+		cancel()
+		<-c
+		fmt.Fprintf(os.Stderr, "interrupted again, exiting\n")
+		os.Exit(130)
+	}()
+	return ctx
+}
+
+// exitInterrupted ends an interrupted run.
+func exitInterrupted() {
+	if doing != "" {
+		fmt.Fprintf(os.Stderr, "interrupted while %s\n", doing)
+	} else {
+		fmt.Fprintf(os.Stderr, "interrupted\n")
+	}
+	cleanup()
+	os.Exit(130)
+}
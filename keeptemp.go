@@ -0,0 +1,92 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Support for -keep-temp, which saves the output of each run of the
+// dumper in a directory, as <object>.<pass>.txt (and the stderr output,
+// if any, as <object>.<pass>.stderr.txt), so that a parse error can be
+// looked into; the error says where in the saved output it was. The
+// saved output can be fed back in with -from-dump.
+
+// keepName returns the base name for the -keep-temp files for object k
+// and pass. Objects with the same base name are told apart by their
+// O-number.
+func (s *state) keepName(k int, pass string) string {
+	base := filepath.Base(s.objs[k])
+	if s.keepNames == nil {
+		s.keepNames = make(map[string]int)
+	}
+	if other, ok := s.keepNames[base]; ok && other != k {
+		base = fmt.Sprintf("%s.O%d", base, k)
+	} else {
+		s.keepNames[base] = k
+	}
+	return filepath.Join(*keeptempflag, base+"."+pass)
+}
+
+// keepTemp returns dump r, the output of a run of the dumper for object
+// k in the specified pass, copied to a file in the -keep-temp
+// directory as it is read. Without -keep-temp, r is returned as is.
+func (s *state) keepTemp(k int, pass string, r io.Reader) (io.Reader, error) {
+	if *keeptempflag == "" {
+		return r, nil
+	}
+	name := s.keepName(k, pass)
+	f, err := os.Create(name + ".txt")
+	if err != nil {
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		return nil, fmt.Errorf("-keep-temp: %v", err)
+	}
+	s.dumpSource[k] = f.Name()
+	return &keptStream{Reader: io.TeeReader(r, f), src: r, f: f, name: name}, nil
+}
+
+// keptStream is a dump being copied to a -keep-temp file.
+type keptStream struct {
+	io.Reader
+	src  io.Reader
+	f    *os.File
+	name string
+}
+
+// Close closes the underlying stream and the file, saving the stderr
+// output of the dumper alongside if there was any.
+func (ks *keptStream) Close() error {
+	if c, ok := ks.src.(io.Closer); ok {
+		c.Close()
+	}
+	if ds, ok := ks.src.(*dumpStream); ok && ds.stderr.Len() != 0 {
+		os.WriteFile(ks.name+".stderr.txt", ds.stderr.Bytes(), 0666)
+	}
+	return ks.f.Close()
+}
+
+// withSource returns parse, with any error it returns for the current
+// object saying where to find the dump text it was reading (a
+// -keep-temp file, or the -from-dump input) and the line it had
+// reached.
+func (s *state) withSource(parse func(io.Reader) error) func(io.Reader) error {
+	return func(r io.Reader) error {
+		s.lineno = 0
+		err := parse(r)
+		src, ok := s.dumpSource[s.objidx]
+		if err == nil || !ok {
+			return err
+		}
+		if s.lineno == 0 {
+			return fmt.Errorf("%v (dump in %s)", err, src)
+		}
+		return fmt.Errorf("%v (at line %d of %s)", err, s.lineno, src)
+	}
+}
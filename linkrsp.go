@@ -0,0 +1,120 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// Support for reading the object and library inputs from an MSVC
+// linker (link.exe) response file.
+
+// decodeResponseFile converts the raw contents of a response file to
+// a string, handling UTF-16 (little or big endian, as indicated by the
+// BOM) and UTF-8 with or without a BOM.
+func decodeResponseFile(content []byte) string {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(content, []byte{0xff, 0xfe}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(content, []byte{0xfe, 0xff}):
+		order = binary.BigEndian
+	default:
+		return string(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+	}
+	content = content[2:]
+	u := make([]uint16, 0, len(content)/2)
+	for i := 0; i+1 < len(content); i += 2 {
+		u = append(u, order.Uint16(content[i:]))
+	}
+	return string(utf16.Decode(u))
+}
+
+// splitResponseFile splits response file text into arguments. Arguments
+// are separated by whitespace (including newlines, so arguments may
+// be continued across lines freely); double quotes group characters
+// into a single argument and are removed. Backslashes are not escape
+// characters, since they appear in paths.
+func splitResponseFile(text string) []string {
+	var res []string
+	var sb strings.Builder
+	inarg, quoted := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inarg = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\r' || r == '\n'):
+			if inarg {
+				res = append(res, sb.String())
+				sb.Reset()
+				inarg = false
+			}
+		default:
+			sb.WriteRune(r)
+			inarg = true
+		}
+	}
+	if inarg {
+		res = append(res, sb.String())
+	}
+	return res
+}
+
+// isLinkerOption returns true if the response file argument is a linker
+// option ("/OUT:x.exe", "-nologo") rather than an input. A leading
+// slash followed by a path (as in "/tmp/x.o") is not an option.
+func isLinkerOption(arg string) bool {
+	if strings.HasPrefix(arg, "-") {
+		return true
+	}
+	if !strings.HasPrefix(arg, "/") {
+		return false
+	}
+	opt := arg[1:]
+	if i := strings.IndexByte(opt, ':'); i != -1 {
+		opt = opt[:i]
+	}
+	return !strings.ContainsAny(opt, `/\`)
+}
+
+// readLinkResponseFile returns the object and archive (library)
+// inputs listed in the specified link.exe response file, and
+// separately the import libraries (kernel32.lib and the like), whose
+// symbols are to be mapped to DLLs as with -implib rather than
+// analyzed. Linker options are ignored; other entries that don't look
+// like objects or libraries are skipped with a warning.
+func readLinkResponseFile(rspfile string) ([]string, []string, error) {
+	args, err := readPathList(rspfile, listQuoted)
+	if err != nil {
+		return nil, nil, err
+	}
+	var res, implibs []string
+	for _, arg := range args {
+		if isLinkerOption(arg) {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(arg)) {
+		case ".lib", ".a":
+			if isImportLib(arg) {
+				verb(1, "%s: using %s as an import library", rspfile, arg)
+				implibs = append(implibs, arg)
+				continue
+			}
+			res = append(res, arg)
+		case ".o", ".obj", ".syso":
+			res = append(res, arg)
+		default:
+			fmt.Fprintf(os.Stderr, "warning: %s: skipping %q (not an object or library)\n", rspfile, arg)
+		}
+	}
+	return res, implibs, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// machineName returns the GOARCH-style name for COFF machine type m,
+// or the type in hex if there isn't one.
+func machineName(m uint16) string {
+	for arch, mt := range sysoMachines {
+		if mt == m {
+			return arch
+		}
+	}
+	return fmt.Sprintf("0x%x", m)
+}
+
+// formatMachines maps the object file format names printed by
+// objdump and llvm-readobj (lowercased) to machine names.
+var formatMachines = map[string]string{
+	"coff-x86-64":       "amd64",
+	"pe-x86-64":         "amd64",
+	"coff-i386":         "386",
+	"pe-i386":           "386",
+	"coff-arm64":        "arm64",
+	"pe-aarch64-little": "arm64",
+	"coff-arm":          "arm",
+	"pe-arm-little":     "arm",
+}
+
+var (
+	// testdata/arm64.o:	file format coff-arm64
+	fileformatre = regexp.MustCompile(`file format (\S+)`)
+	// "Format":"COFF-ARM64",
+	roformatre = regexp.MustCompile(`"Format":"([^"]+)"`)
+	//             AA64 machine (ARM64)
+	dbmachinere = regexp.MustCompile(`(?m)^\s*([0-9A-F]+) machine `)
+)
+
+// dumpMachine returns the machine name found in the header of a
+// symbol table dump, or "" if there is none.
+func dumpMachine(content string) string {
+	if m := dbmachinere.FindStringSubmatch(content); len(m) != 0 {
+		if v, err := strconv.ParseUint(m[1], 16, 16); err == nil {
+			return machineName(uint16(v))
+		}
+		return ""
+	}
+	m := fileformatre.FindStringSubmatch(content)
+	if len(m) == 0 {
+		m = roformatre.FindStringSubmatch(content)
+	}
+	if len(m) == 0 {
+		return ""
+	}
+	if name, ok := formatMachines[strings.ToLower(m[1])]; ok {
+		return name
+	}
+	return m[1]
+}
+
+// fileMachine returns the machine name from the header of the COFF
+// object or PE image infile (or Go or ELF object), or "" if it isn't one of the machines
+// known (reading the object proper will report any problem). Only the
+// header is read.
+func fileMachine(infile string) string {
+	f, err := os.Open(infile)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var hdr [0x40]byte
+	if n, _ := f.ReadAt(hdr[:], 0); n < 8 {
+		return ""
+	}
+	if bytes.HasPrefix(hdr[:], []byte(goobjmag)) {
+		return goObjectArch(goObjectHeader(infile))
+	}
+	if bytes.HasPrefix(hdr[:], []byte(elfmag)) {
+		return elfMachine(hdr[:])
+	}
+	var m uint16
+	switch {
+	case hdr[0] == 'M' && hdr[1] == 'Z':
+		var pehdr [6]byte
+		if _, err := f.ReadAt(pehdr[:], int64(binary.LittleEndian.Uint32(hdr[0x3c:]))); err != nil ||
+			string(pehdr[:4]) != "PE\x00\x00" {
+			return ""
+		}
+		m = binary.LittleEndian.Uint16(pehdr[4:])
+	case binary.LittleEndian.Uint16(hdr[0:]) == 0 && binary.LittleEndian.Uint16(hdr[2:]) == 0xffff:
+		// bigobj (or import) header
+		m = binary.LittleEndian.Uint16(hdr[6:])
+	default:
+		m = binary.LittleEndian.Uint16(hdr[0:])
+	}
+	for arch, mt := range sysoMachines {
+		if mt == m {
+			return arch
+		}
+	}
+	return ""
+}
+
+// mixedMachines returns a line for each of the machine types of the
+// inputs, listing the objects for it, if there is more than one, or
+// nil if there isn't.
+func (s *state) mixedMachines() []string {
+	byMachine := make(map[string][]string)
+	for k := range s.objs {
+		if m := s.machines[k]; m != "" {
+			byMachine[m] = append(byMachine[m], s.labels[k])
+		}
+	}
+	if len(byMachine) < 2 {
+		return nil
+	}
+	var res []string
+	for m, objs := range byMachine {
+		res = append(res, m+": "+strings.Join(objs, ", "))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// checkMachines ends the run if the inputs are for more than one
+// architecture, unless -allow-mixed-arch was given.
+func (s *state) checkMachines() {
+	if mixed := s.mixedMachines(); mixed != nil && !*mixedarchflag {
+		fatal("inputs are for more than one architecture (use -allow-mixed-arch to analyze them anyway):\n\t%s",
+			strings.Join(mixed, "\n\t"))
+	}
+}
+
+// machine returns the machine type of the inputs, if they are all
+// for the same one.
+func (s *state) machine() string {
+	res := ""
+	for k := range s.objs {
+		if m := s.machines[k]; m != "" {
+			if res != "" && m != res {
+				return ""
+			}
+			res = m
+		}
+	}
+	return res
+}
+
+// isPairLow reports whether relocation type styp is the second half
+// of an ARM64 adrp/add or adrp/ldr pair. The pair refers to a symbol
+// only once, so only the adrp (PAGEBASE_REL21) half is recorded.
+func isPairLow(styp string) bool {
+	return strings.HasSuffix(styp, "PAGEOFFSET_12A") ||
+		strings.HasSuffix(styp, "PAGEOFFSET_12L")
+}
+
+// undecorate strips the i386 C decorations from symbol name x: the
+// leading underscore of cdecl and stdcall names, the "@N" (or "@@N")
+// argument size suffix of stdcall names, and both "@"s of fastcall
+// "@x@N" names. Other names (C++ ones, for example) are returned
+// unchanged.
+func undecorate(x string) string {
+	if strings.HasPrefix(x, "@") {
+		if i := strings.LastIndexByte(x, '@'); i > 0 && isDigits(x[i+1:]) {
+			return strings.TrimSuffix(x[1:i], "@")
+		}
+		return x
+	}
+	if !strings.HasPrefix(x, "_") {
+		return x
+	}
+	x = x[1:]
+	if i := strings.LastIndexByte(x, '@'); i > 0 && isDigits(x[i+1:]) {
+		x = strings.TrimSuffix(x[:i], "@")
+	}
+	return x
+}
+
+// normalizes reports whether the C decorations are stripped from the
+// symbols of object objidx for pairing __imp_X with X: always for
+// i386 objects, and for all objects with -normalize-decorations.
+func (s *state) normalizes(objidx int) bool {
+	return *normalizedecorationsflag || s.machines[objidx] == "386"
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// symKey returns the name under which symbol sname of object objidx
+// appears in the def/ref breakdown, and whether it is an import
+// symbol: the import prefix is stripped, and for i386 objects (or
+// with -normalize-decorations) so are the C decorations, so that
+// "__imp__CreateFileA@4" and "_CreateFileA@4" are both "CreateFileA".
+// The raw names are kept in the Defs and Refs listings.
+func (s *state) symKey(sname string, objidx int) (string, bool) {
+	x, imp := cutImp(sname)
+	if s.normalizes(objidx) {
+		x = undecorate(x)
+	}
+	return x, imp
+}
+
+// variants returns, for each breakdown symbol X with decorations
+// stripped, the raw names under which it was defined or referred to,
+// sorted, other than X and __imp_X themselves.
+func (s *state) variants() map[string][]string {
+	res := make(map[string][]string)
+	add := func(sname string, objidx int) {
+		x, _ := s.symKey(sname, objidx)
+		if base, _ := cutImp(sname); base == x && (sname == x || isImpSym(sname)) {
+			return
+		}
+		for _, v := range res[x] {
+			if v == sname {
+				return
+			}
+		}
+		res[x] = append(res[x], sname)
+	}
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			add(sname, ri.objidx)
+		}
+	}
+	for sname, dl := range s.defs {
+		for _, di := range dl {
+			add(sname, di.objidx)
+		}
+	}
+	for _, vl := range res {
+		sort.Strings(vl)
+	}
+	return res
+}
+
+// variantsTag returns the raw names vl of a breakdown symbol as shown
+// at the end of its line, with the v2 layout or -normalize-decorations.
+func variantsTag(vl []string) string {
+	if len(vl) == 0 || (!*normalizedecorationsflag && textfmt.version() < 2) {
+		return ""
+	}
+	return " {" + strings.Join(vl, " ") + "}"
+}
+
+// isWatched reports whether symbol sname of object objidx is on the
+// watch list, either as is or (where decorations are stripped)
+// undecorated.
+func (s *state) isWatched(sname string, objidx int) bool {
+	if watchedName(sname) {
+		return true
+	}
+	if !s.normalizes(objidx) {
+		return false
+	}
+	x, _ := s.symKey(sname, objidx)
+	return watchedName(x)
+}
+
+// watchedName reports whether sname is on the watch list or matches a
+// -watchre pattern; __imp_X matches if X does.
+func watchedName(sname string) bool {
+	if watched[sname] {
+		return true
+	}
+	base, _ := cutImp(sname)
+	for _, re := range watchres {
+		if re.MatchString(sname) || re.MatchString(base) {
+			return true
+		}
+	}
+	return false
+}
+
+// watching reports whether any symbols are watched.
+func watching() bool {
+	return len(watched) != 0 || len(watchres) != 0
+}
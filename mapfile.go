@@ -0,0 +1,165 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Support for cross-checking the analysis against a linker map file
+// (-mapfile), which records the object the linker actually took each
+// symbol from. Two dialects are handled:
+//
+// link.exe (and lld-link /map), with a "Publics by Value" table:
+//
+//	  Address         Publics by Value              Rva+Base               Lib:Object
+//	 0001:00000010       main                       0000000140001010 f   main.obj
+//	 0002:00000120       __imp_CreateFileA          0000000140002120     kernel32:KERNEL32.dll
+//
+// and lld-link /lldmap, where symbols follow the input section they
+// are defined in:
+//
+//	 Address  Size     Align Out     In      Symbol
+//	 00001000 0000001b    16         main.obj:(.text)
+//	 00001000 00000000     0                 main
+
+// 0001:00000010       main                       0000000140001010 f   main.obj
+var msvcmapre = regexp.MustCompile(`^\s*[0-9a-fA-F]{4}:[0-9a-fA-F]{8}\s+(\S+)\s+[0-9a-fA-F]+\s+(?:[fi]\s+)*(\S+)\s*$`)
+
+// 00001000 0000001b    16         main.obj:(.text)
+var lldmapre = regexp.MustCompile(`^\s*[0-9a-fA-F]+\s+[0-9a-fA-F]+\s+\d+\s+(.*\S)\s*$`)
+
+// parseMapFile returns a map from symbol name to the object (as named
+// in the map file) that the linker took it from.
+func parseMapFile(path string) (map[string]string, error) {
+	content, err := readText(path)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	const (
+		dnone = iota
+		dmsvc
+		dlld
+	)
+	dialect := dnone
+	curobj := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.Contains(line, "Publics by Value") || strings.Contains(line, "Static symbols"):
+			dialect = dmsvc
+			continue
+		case strings.Contains(line, "Address") && strings.Contains(line, "Align") &&
+			strings.HasSuffix(strings.TrimSpace(line), "Symbol"):
+			dialect = dlld
+			continue
+		}
+		switch dialect {
+		case dmsvc:
+			if m := msvcmapre.FindStringSubmatch(line); m != nil {
+				if _, ok := res[m[1]]; !ok {
+					res[m[1]] = m[2]
+				}
+			}
+		case dlld:
+			m := lldmapre.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			rest := m[1]
+			if i := strings.LastIndex(rest, ":("); i != -1 && strings.HasSuffix(rest, ")") {
+				// input section
+				curobj = rest[:i]
+			} else if strings.HasPrefix(rest, ".") && !strings.Contains(rest, " ") {
+				// output section
+				curobj = ""
+			} else if curobj != "" {
+				res[rest] = curobj
+			}
+		}
+	}
+	if dialect == dnone {
+		return nil, fmt.Errorf("%s: unrecognized map file format", path)
+	}
+	return res, nil
+}
+
+// mapObjectBase returns the object file base name for an object named
+// in a map file ("lib:x.obj", "lib.a(x.o)", "C:\dir\x.obj") or in our
+// own object list, for comparison purposes. The extension is dropped,
+// since different tools name the same object x.o or x.obj.
+func mapObjectBase(obj string) string {
+	if i := strings.LastIndex(obj, "("); i != -1 && strings.HasSuffix(obj, ")") {
+		obj = obj[i+1 : len(obj)-1]
+		// duplicate member names are numbered "x.o#2"
+		if j := strings.LastIndex(obj, "#"); j != -1 {
+			obj = obj[:j]
+		}
+	}
+	if i := strings.LastIndexAny(obj, `/\:`); i != -1 {
+		obj = obj[i+1:]
+	}
+	obj = strings.TrimSuffix(obj, filepath.Ext(obj))
+	return strings.ToLower(obj)
+}
+
+// checkMap writes a report comparing the defining object recorded for
+// each symbol in our analysis with the object the linker chose per
+// mapsyms, followed by the import symbols the linker took from objects
+// that were not analyzed. For a symbol defined by several objects, the
+// one the linker chose is shown if it is among them.
+func (s *state) checkMap(w io.Writer, mapsyms map[string]string) {
+	defs := make([]string, 0, len(s.defs))
+	for k := range s.defs {
+		defs = append(defs, k)
+	}
+	sort.Strings(defs)
+	for _, k := range defs {
+		di, ok := s.def(k)
+		if !ok {
+			continue
+		}
+		mobj, ok := mapsyms[k]
+		match := false
+		if ok {
+			for _, d := range s.defs[k] {
+				if mapObjectBase(mobj) == mapObjectBase(s.objs[d.objidx]) {
+					di, match = d, true
+					break
+				}
+			}
+		}
+		switch {
+		case !ok:
+			fmt.Fprintf(w, " %q: O%d %s, not in map\n", k, di.objidx, s.labels[di.objidx])
+		case match:
+			fmt.Fprintf(w, " %q: O%d %s, matches linker\n", k, di.objidx, s.labels[di.objidx])
+		default:
+			fmt.Fprintf(w, " %q: O%d %s, but linker chose %s\n", k, di.objidx, s.labels[di.objidx], mobj)
+		}
+	}
+	msyms := make([]string, 0, len(mapsyms))
+	for k := range mapsyms {
+		if isImpSym(k) {
+			if _, ok := s.defs[k]; !ok {
+				msyms = append(msyms, k)
+			}
+		}
+	}
+	sort.Strings(msyms)
+	for _, k := range msyms {
+		seen := ""
+		if _, ok := s.refs[k]; ok {
+			seen = ", referenced"
+		}
+		fmt.Fprintf(w, " %q: from %s, not defined by any analyzed object%s\n", k, mapsyms[k], seen)
+	}
+}
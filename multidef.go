@@ -0,0 +1,84 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Support for reporting base symbols (the thunk X, or the function X
+// itself, as opposed to the slot __imp_X) defined by more than one
+// object. These are listed under "Multiply-defined symbols" with each
+// definition and, going by the COMDAT selections of their sections,
+// whether the linker can pick one of them or will report a multiple
+// definition. With -fail-on-dupdefs, the run exits with status
+// dupDefsExit if there is any such conflict.
+
+// dupDefsExit is the exit status used with -fail-on-dupdefs when some
+// base symbol has conflicting definitions.
+const dupDefsExit = 5
+
+// multiDef is a base symbol defined by more than one object, and how
+// its definitions get on (dupComdat and so on).
+type multiDef struct {
+	sym    string
+	status int
+}
+
+// multiDefs returns the base symbols defined by more than one object
+// in s, sorted by symbol.
+func (s *state) multiDefs() []multiDef {
+	var res []multiDef
+	for sname, dl := range s.defs {
+		if isImpSym(sname) {
+			continue
+		}
+		if st := s.dupStatus(dl); st != dupNone {
+			res = append(res, multiDef{sname, st})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].sym < res[j].sym })
+	return res
+}
+
+// dupStatusString describes dupStatus result st for the report.
+func dupStatusString(st int) string {
+	switch st {
+	case dupComdat:
+		return "link-compatible (COMDAT)"
+	case dupConflict:
+		return "conflict"
+	}
+	return "compatibility unknown"
+}
+
+// hasDupConflicts reports whether some base symbol in s has
+// conflicting definitions.
+func (s *state) hasDupConflicts() bool {
+	for _, md := range s.multiDefs() {
+		if md.status == dupConflict {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMultiDefs writes the list of base symbols defined by more than
+// one object in s, if any, to w.
+func (s *state) writeMultiDefs(w io.Writer) {
+	mds := s.multiDefs()
+	if len(mds) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Multiply-defined symbols:\n")
+	for _, md := range mds {
+		fmt.Fprintf(w, " %q: %s\n", md.sym, dupStatusString(md.status))
+		for _, di := range s.defs[md.sym] {
+			fmt.Fprintf(w, "  %s\n", s.defLine(di))
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Built-in reader for COFF objects, used with -dumper-flavor=native
+// or when no objdump program can be found. This reads the section
+// headers, symbol table and relocations directly with debug/pe, so
+// no external tool is needed except for excerpts of watched symbols,
+// which need a disassembler.
+
+// storage classes of section and .file symbols
+const (
+	imageSymClassStatic = 3
+	imageSymClassFile   = 103
+)
+
+// relocTypeNames holds relocation type names, indexed by type, for
+// the machines whose relocation types are named in diagnostics.
+var relocTypeNames = map[uint16][]string{
+	pe.IMAGE_FILE_MACHINE_AMD64: {
+		"IMAGE_REL_AMD64_ABSOLUTE",
+		"IMAGE_REL_AMD64_ADDR64",
+		"IMAGE_REL_AMD64_ADDR32",
+		"IMAGE_REL_AMD64_ADDR32NB",
+		"IMAGE_REL_AMD64_REL32",
+		"IMAGE_REL_AMD64_REL32_1",
+		"IMAGE_REL_AMD64_REL32_2",
+		"IMAGE_REL_AMD64_REL32_3",
+		"IMAGE_REL_AMD64_REL32_4",
+		"IMAGE_REL_AMD64_REL32_5",
+		"IMAGE_REL_AMD64_SECTION",
+		"IMAGE_REL_AMD64_SECREL",
+		"IMAGE_REL_AMD64_SECREL7",
+		"IMAGE_REL_AMD64_TOKEN",
+		"IMAGE_REL_AMD64_SREL32",
+		"IMAGE_REL_AMD64_PAIR",
+		"IMAGE_REL_AMD64_SSPAN32",
+	},
+	pe.IMAGE_FILE_MACHINE_ARM64: {
+		"IMAGE_REL_ARM64_ABSOLUTE",
+		"IMAGE_REL_ARM64_ADDR32",
+		"IMAGE_REL_ARM64_ADDR32NB",
+		"IMAGE_REL_ARM64_BRANCH26",
+		"IMAGE_REL_ARM64_PAGEBASE_REL21",
+		"IMAGE_REL_ARM64_REL21",
+		"IMAGE_REL_ARM64_PAGEOFFSET_12A",
+		"IMAGE_REL_ARM64_PAGEOFFSET_12L",
+		"IMAGE_REL_ARM64_SECREL",
+		"IMAGE_REL_ARM64_SECREL_LOW12A",
+		"IMAGE_REL_ARM64_SECREL_HIGH12A",
+		"IMAGE_REL_ARM64_SECREL_LOW12L",
+		"IMAGE_REL_ARM64_TOKEN",
+		"IMAGE_REL_ARM64_SECTION",
+		"IMAGE_REL_ARM64_ADDR64",
+		"IMAGE_REL_ARM64_BRANCH19",
+		"IMAGE_REL_ARM64_BRANCH14",
+		"IMAGE_REL_ARM64_REL32",
+	},
+	pe.IMAGE_FILE_MACHINE_I386: {
+		0x00: "IMAGE_REL_I386_ABSOLUTE",
+		0x01: "IMAGE_REL_I386_DIR16",
+		0x02: "IMAGE_REL_I386_REL16",
+		0x06: "IMAGE_REL_I386_DIR32",
+		0x07: "IMAGE_REL_I386_DIR32NB",
+		0x09: "IMAGE_REL_I386_SEG12",
+		0x0a: "IMAGE_REL_I386_SECTION",
+		0x0b: "IMAGE_REL_I386_SECREL",
+		0x0c: "IMAGE_REL_I386_TOKEN",
+		0x0d: "IMAGE_REL_I386_SECREL7",
+		0x14: "IMAGE_REL_I386_REL32",
+	},
+}
+
+// relocTypeName returns the name of relocation type typ for the
+// specified machine, as objdump would show it.
+func relocTypeName(machine, typ uint16) string {
+	if names := relocTypeNames[machine]; int(typ) < len(names) && names[typ] != "" {
+		return names[typ]
+	}
+	return fmt.Sprintf("0x%x", typ)
+}
+
+// openCOFF opens the COFF object infile, returning it along with the
+// (full) names of the entries in its symbol table, indexed as for
+// relocations; entries for aux records are "".
+func openCOFF(infile string) (*pe.File, []string, error) {
+	content, err := os.ReadFile(infile)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, longNames, err := hideLongSectionNames(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", infile, err)
+	}
+	f, err := pe.NewFile(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", infile, err)
+	}
+	for i, name := range longNames {
+		f.Sections[i].Name = name
+	}
+	if f.OptionalHeader != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: not a COFF object", infile)
+	}
+	names := make([]string, len(f.COFFSymbols))
+	for i := 0; i < len(f.COFFSymbols); i++ {
+		sym := &f.COFFSymbols[i]
+		n, err := sym.FullName(f.StringTable)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("%s: symbol %d: %v", infile, i, err)
+		}
+		names[i] = n
+		i += int(sym.NumberOfAuxSymbols)
+	}
+	return f, names, nil
+}
+
+// Section names longer than 8 characters are stored as "/N", N being
+// the decimal offset of the name in the string table, which debug/pe
+// resolves. For offsets too large for that, "//" followed by the
+// offset in six base64 digits is used; debug/pe rejects these.
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// hideLongSectionNames returns a copy of the COFF object content with
+// the "//" section names blanked out, so that debug/pe will accept it,
+// along with the names they stand for, keyed by section index.
+func hideLongSectionNames(content []byte) ([]byte, map[int]string, error) {
+	const fileHdrSz, secHdrSz = 20, 40
+	if len(content) < fileHdrSz {
+		// Let debug/pe complain.
+		return content, nil, nil
+	}
+	nsects := int(binary.LittleEndian.Uint16(content[2:]))
+	strtab := int(binary.LittleEndian.Uint32(content[8:])) +
+		int(binary.LittleEndian.Uint32(content[12:]))*pe.COFFSymbolSize
+	hdrs := fileHdrSz + int(binary.LittleEndian.Uint16(content[16:]))
+	var names map[int]string
+	for i := 0; i < nsects; i++ {
+		h := hdrs + i*secHdrSz
+		if h+8 > len(content) {
+			break
+		}
+		sn := content[h : h+8]
+		if sn[0] != '/' || sn[1] != '/' {
+			continue
+		}
+		off := 0
+		for _, c := range bytes.TrimRight(sn[2:], "\x00") {
+			d := strings.IndexByte(base64Digits, c)
+			if d == -1 {
+				return nil, nil, fmt.Errorf("section %d: bad name %q", i+1, sn)
+			}
+			off = off*64 + d
+		}
+		if strtab+off >= len(content) {
+			return nil, nil, fmt.Errorf("section %d: name offset %d beyond string table", i+1, off)
+		}
+		name := content[strtab+off:]
+		if j := bytes.IndexByte(name, 0); j != -1 {
+			name = name[:j]
+		}
+		if names == nil {
+			names = make(map[int]string)
+			content = append([]byte(nil), content...)
+			sn = content[h : h+8]
+		}
+		names[i] = string(name)
+		copy(sn, make([]byte, 8))
+	}
+	return content, names, nil
+}
+
+// auxFileName returns the file name held in the aux records of .file
+// symbol i. debug/pe doesn't preserve the contents of aux records, so
+// they are read from the file.
+func auxFileName(infile string, f *pe.File, i int) (string, error) {
+	content, err := os.ReadFile(infile)
+	if err != nil {
+		return "", err
+	}
+	off := int(f.PointerToSymbolTable) + (i+1)*pe.COFFSymbolSize
+	end := off + int(f.COFFSymbols[i].NumberOfAuxSymbols)*pe.COFFSymbolSize
+	if end > len(content) {
+		return "", fmt.Errorf("%s: truncated symbol table", infile)
+	}
+	name := content[off:end]
+	if j := bytes.IndexByte(name, 0); j != -1 {
+		name = name[:j]
+	}
+	return string(name), nil
+}
+
+// pass1Native is pass1 for the built-in reader.
+func (s *state) pass1Native(infile string) error {
+	f, names, err := openCOFF(infile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, sname := range names {
+		if sname != "" && s.isInterestingSym(sname) {
+			s.all[sname] = true
+		}
+	}
+	return nil
+}
+
+// readObjectNative does the work of pass3 for the built-in reader.
+func (s *state) readObjectNative(infile string) error {
+	f, names, err := openCOFF(infile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for k, sect := range f.Sections {
+		if pass3Sections[sect.Name] {
+			s.newSection(sect.Name, int(sect.Size), k)
+		}
+	}
+	if sect := f.Section(drectveSection); sect != nil {
+		data, err := sect.Data()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", infile, drectveSection, err)
+		}
+		s.addDirectives(data)
+	}
+
+	defs := make(map[string]struct{})
+	srcfile := ""
+	for i := 0; i < len(f.COFFSymbols); i++ {
+		sym := &f.COFFSymbols[i]
+		naux := int(sym.NumberOfAuxSymbols)
+		if i+naux >= len(f.COFFSymbols) {
+			return fmt.Errorf("%s: truncated aux records for symbol %d", infile, i)
+		}
+		if sym.StorageClass == imageSymClassFile && srcfile == "" {
+			if srcfile, err = auxFileName(infile, f, i); err != nil {
+				return err
+			}
+		}
+		if sym.StorageClass == imageSymClassStatic && naux != 0 && sym.Value == 0 &&
+			sym.SectionNumber > 0 && int(sym.SectionNumber) <= len(f.Sections) &&
+			names[i] == f.Sections[sym.SectionNumber-1].Name {
+			ad, err := f.COFFSymbolReadSectionDefAux(i)
+			if err != nil {
+				return fmt.Errorf("%s: symbol %d: %v", infile, i, err)
+			}
+			s.setSectionAux(int(sym.SectionNumber), secaux{nreloc: int(ad.NumRelocs),
+				checksum: ad.Checksum, number: int(ad.SecNum), selection: int(ad.Selection)})
+		}
+		if err := s.addSymbol(names[i], int(sym.SectionNumber), int(sym.Value), defs); err != nil {
+			return err
+		}
+		i += naux
+	}
+	s.finishSymtab(srcfile, defs)
+
+	for k, sect := range f.Sections {
+		if !pass3Sections[sect.Name] {
+			continue
+		}
+		for _, r := range sect.Relocs {
+			s.countReloc(sect.Name)
+			if r.Type == 0 {
+				// IMAGE_REL_*_ABSOLUTE is a no-op.
+				continue
+			}
+			if int(r.SymbolTableIndex) >= len(names) || names[r.SymbolTableIndex] == "" {
+				return fmt.Errorf("%s: bad symbol index %d in %s relocation at 0x%x", infile, r.SymbolTableIndex, sect.Name, r.VirtualAddress)
+			}
+			sname := names[r.SymbolTableIndex]
+			styp := relocTypeName(f.Machine, r.Type)
+			line := fmt.Sprintf("%016x %s %s", r.VirtualAddress, styp, sname)
+			if err := s.addReloc(sect.Name, k+1, fmt.Sprintf("%x", r.VirtualAddress), styp, sname, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for streaming results as newline-delimited JSON (-ndjson),
+// for use as a pipeline stage. Each line is a JSON object with a
+// "kind" field saying what it is:
+//
+//	{"kind":"object","obj":0,"name":"obj1.o",...,"sections":[...],"defs":[...]}
+//	{"kind":"symbol","sym":"foo","categories":["refimp"],"nrefs":3,"nobjs":2}
+//	{"kind":"summary","objects":1,"analyzed":1,"symbols":1,...,"refs":3,"exit":0,"format_version":1}
+//
+// An object record is written as soon as the object has been read (or
+// skipped), a symbol record for each line of the Def/ref breakdown
+// once all objects have been read, and a summary record, with the exit
+// status the run ends with, last of all. The rest of the report
+// (excerpts, map file cross-check, baseline differences) goes to
+// stderr.
+
+type ndjsonSection struct {
+	Idx  int    `json:"idx"`
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+type ndjsonDef struct {
+	Sym     string `json:"sym"`
+	Sec     int    `json:"sec"`
+	SecName string `json:"sec_name"`
+	Value   int    `json:"value"`
+}
+
+type ndjsonObject struct {
+	Kind     string          `json:"kind"`
+	Obj      int             `json:"obj"`
+	Name     string          `json:"name"`
+	Label    string          `json:"label"`
+	Prov     string          `json:"prov,omitempty"`
+	Machine  string          `json:"machine,omitempty"`
+	Skipped  string          `json:"skipped,omitempty"`
+	Sections []ndjsonSection `json:"sections,omitempty"`
+	Defs     []ndjsonDef     `json:"defs,omitempty"`
+}
+
+type ndjsonSymbol struct {
+	Kind       string   `json:"kind"`
+	Sym        string   `json:"sym"`
+	Categories []string `json:"categories"`
+	DLL        string   `json:"dll,omitempty"`
+	NRefs      int      `json:"nrefs"`
+	NObjs      int      `json:"nobjs"`
+}
+
+type ndjsonObjTotals struct {
+	Obj     int `json:"obj"`
+	ImpRefs int `json:"imprefs"`
+	ImpDefs int `json:"impdefs"`
+	Relocs  int `json:"relocs"`
+	Size    int `json:"size"`
+}
+
+type ndjsonSummary struct {
+	Kind          string `json:"kind"`
+	Objects       int    `json:"objects"`
+	Analyzed      int    `json:"analyzed"`
+	Symbols       int    `json:"symbols"`
+	Defbase       int    `json:"defbase"`
+	Refbase       int    `json:"refbase"`
+	Defimp        int    `json:"defimp"`
+	Refimp        int    `json:"refimp"`
+	Sameobj       int    `json:"sameobj"`
+	RefimpOnly    int    `json:"refimp_only"`
+	Refs          int    `json:"refs"`
+	Exit          int    `json:"exit"`
+	FormatVersion int    `json:"format_version"`
+	// with -object-totals
+	ObjectTotals []ndjsonObjTotals `json:"object_totals,omitempty"`
+}
+
+// ndjsonWriter writes the records for -ndjson.
+type ndjsonWriter struct {
+	w   io.Writer
+	enc *json.Encoder
+	// number of sections already reported
+	nsects int
+}
+
+// ndjson is the writer for -ndjson, if it was given.
+var ndjson *ndjsonWriter
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// write writes record v, flushing it through so that the reader sees
+// it at once.
+func (nw *ndjsonWriter) write(v interface{}) error {
+	if err := nw.enc.Encode(v); err != nil {
+		return err
+	}
+	if f, ok := nw.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// object writes the record for object k, just read (or skipped). The
+// sections read since the last record are those of object k, as are
+// the definitions passed to finishSymtab.
+func (nw *ndjsonWriter) object(s *state, k int) error {
+	rec := ndjsonObject{
+		Kind:    "object",
+		Obj:     k,
+		Name:    s.objs[k],
+		Label:   s.labels[k],
+		Prov:    s.objProv(k).String(),
+		Machine: s.machines[k],
+		Skipped: s.skipped[k],
+	}
+	for _, sn := range s.sects[nw.nsects:] {
+		if sn.objidx == k {
+			rec.Sections = append(rec.Sections, ndjsonSection{Idx: sn.idx, Name: sn.name, Size: sn.size})
+		}
+	}
+	nw.nsects = len(s.sects)
+	if rec.Skipped == "" {
+		syms := make([]string, 0, len(s.objDefs))
+		for k := range s.objDefs {
+			syms = append(syms, k)
+		}
+		sort.Strings(syms)
+		for _, sym := range syms {
+			if di, ok := s.defIn(sym, k); ok {
+				rec.Defs = append(rec.Defs, ndjsonDef{Sym: sym, Sec: di.secidx, SecName: s.secName(k, di.secidx), Value: di.value})
+			}
+		}
+	}
+	s.objDefs = nil
+	return nw.write(rec)
+}
+
+// symbols writes the records for the Def/ref breakdown.
+func (nw *ndjsonWriter) symbols(s *state) error {
+	counts := s.refCounts()
+	for _, x := range s.breakdownSyms() {
+		rec := ndjsonSymbol{
+			Kind:       "symbol",
+			Sym:        x,
+			Categories: strings.Fields(s.defref[x].String()),
+			DLL:        strings.Trim(s.dllTag(x), " []"),
+			NRefs:      counts[x].nrefs,
+			NObjs:      counts[x].nobjs,
+		}
+		if err := nw.write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summary writes the summary record, for a run ending with the
+// specified exit status.
+func (nw *ndjsonWriter) summary(s *state, status int) error {
+	sum := s.summary()
+	var totals []ndjsonObjTotals
+	if *objtotalsflag {
+		for _, t := range s.objectTotals() {
+			totals = append(totals, ndjsonObjTotals(t))
+		}
+	}
+	return nw.write(ndjsonSummary{
+		Kind:          "summary",
+		Objects:       sum.Objects,
+		Analyzed:      sum.Analyzed,
+		Symbols:       sum.Symbols,
+		Defbase:       sum.Defbase,
+		Refbase:       sum.Refbase,
+		Defimp:        sum.Defimp,
+		Refimp:        sum.Refimp,
+		Sameobj:       sum.Sameobj,
+		RefimpOnly:    sum.RefimpOnly,
+		Refs:          sum.Refs,
+		Exit:          status,
+		FormatVersion: textfmt.version(),
+		ObjectTotals:  totals,
+	})
+}
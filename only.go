@@ -0,0 +1,198 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Support for narrowing the results to the symbols whose category
+// matches a boolean expression (-only), such as "refimp && !defimp".
+// An expression is made of the category names of the Def/ref
+// breakdown (defbase, refbase, defimp, refimp, sameobj), "!", "&&",
+// "||" and parentheses, with the usual precedence. Symbols that don't
+// match are dropped, along with their import symbols, once all objects
+// have been read, so that every form of output (the report, -format,
+// -ndjson symbol and summary records, -html and the other files)
+// covers just the matching ones; the same goes for the baseline
+// compared against with -baseline and the import symbols from the map
+// file given with -mapfile. Results written with -save are not
+// narrowed.
+
+// maskExpr is a parsed -only expression.
+type maskExpr func(drm defrefmask) bool
+
+// onlyParser parses a -only expression.
+type onlyParser struct {
+	toks []string
+	pos  int
+}
+
+// tokenizeOnly splits a -only expression into tokens.
+func tokenizeOnly(expr string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '!' || c == '(' || c == ')':
+			toks = append(toks, expr[i:i+1])
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			toks = append(toks, expr[i:i+2])
+			i += 2
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+			j := i
+			for j < len(expr) && (expr[j] >= 'a' && expr[j] <= 'z' || expr[j] >= 'A' && expr[j] <= 'Z' || expr[j] == '_') {
+				j++
+			}
+			toks = append(toks, expr[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q", expr[i:])
+		}
+	}
+	return toks, nil
+}
+
+// parseOnly parses the -only expression expr.
+func parseOnly(expr string) (maskExpr, error) {
+	toks, err := tokenizeOnly(expr)
+	if err != nil {
+		return nil, fmt.Errorf("bad -only %q: %v", expr, err)
+	}
+	p := &onlyParser{toks: toks}
+	e, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad -only %q: %v", expr, err)
+	}
+	return e, nil
+}
+
+func (p *onlyParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *onlyParser) or() (maskExpr, error) {
+	x, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var y maskExpr
+		if y, err = p.and(); err == nil {
+			l := x
+			x = func(drm defrefmask) bool { return l(drm) || y(drm) }
+		}
+	}
+	return x, err
+}
+
+func (p *onlyParser) and() (maskExpr, error) {
+	x, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var y maskExpr
+		if y, err = p.unary(); err == nil {
+			l := x
+			x = func(drm defrefmask) bool { return l(drm) && y(drm) }
+		}
+	}
+	return x, err
+}
+
+func (p *onlyParser) unary() (maskExpr, error) {
+	tok := p.peek()
+	p.pos++
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "!":
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(drm defrefmask) bool { return !x(drm) }, nil
+	case "(":
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return x, nil
+	}
+	m, ok := maskNames[tok]
+	if !ok {
+		names := make([]string, 0, len(maskNames))
+		for k := range maskNames {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown category %q (valid: %s)", tok, strings.Join(names, ", "))
+	}
+	return func(drm defrefmask) bool { return drm&m != 0 }, nil
+}
+
+// applyOnly drops the symbols whose base symbol doesn't match e.
+func (s *state) applyOnly(e maskExpr) {
+	keep := func(sym string, objidx int) bool {
+		x, _ := s.symKey(sym, objidx)
+		drm, ok := s.defref[x]
+		return ok && e(drm)
+	}
+	for sym, rl := range s.refs {
+		if len(rl) != 0 && !keep(sym, rl[0].objidx) {
+			delete(s.refs, sym)
+		}
+	}
+	for sym, dl := range s.defs {
+		if !keep(sym, dl[0].objidx) {
+			delete(s.defs, sym)
+		}
+	}
+	for x, drm := range s.defref {
+		if !e(drm) {
+			delete(s.defref, x)
+		}
+	}
+}
+
+// onlyBaseline returns the entries of baseline that match e.
+func onlyBaseline(baseline map[string]defrefmask, e maskExpr) map[string]defrefmask {
+	res := make(map[string]defrefmask)
+	for k, drm := range baseline {
+		if e(drm) {
+			res[k] = drm
+		}
+	}
+	return res
+}
+
+// onlyMapSyms returns the entries of mapsyms other than import symbols
+// __imp_X with X dropped by applyOnly.
+func (s *state) onlyMapSyms(mapsyms map[string]string) map[string]string {
+	res := make(map[string]string)
+	for k, obj := range mapsyms {
+		if x, ok := cutImp(k); ok {
+			if _, ok := s.defref[undecorate(x)]; !ok {
+				if _, ok := s.defref[x]; !ok {
+					continue
+				}
+			}
+		}
+		res[k] = obj
+	}
+	return res
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Where the report goes (-o). Errors and progress messages go to
+// stderr regardless. A report file is written under a temporary name
+// and renamed into place once complete, so that a failed run doesn't
+// leave half a report behind.
+
+// reportw is the writer for the report.
+var reportw io.Writer = os.Stdout
+
+// reportFile is a report being written to a file.
+type reportFile struct {
+	f    *os.File
+	w    *bufio.Writer
+	path string
+}
+
+// openReport arranges for the report to be written to path, or to
+// stdout if path is "" or "-".
+func openReport(path string) (*reportFile, error) {
+	if path == "" || path == "-" {
+		return nil, nil
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	// Removed by cleanup if the run fails.
+	addCleanup(f.Name())
+	rf := &reportFile{f: f, w: bufio.NewWriter(f), path: path}
+	reportw = rf.w
+	return rf, nil
+}
+
+// finish completes the report, moving it into place.
+func (rf *reportFile) finish() error {
+	if rf == nil {
+		return nil
+	}
+	err := rf.w.Flush()
+	if err == nil {
+		err = rf.f.Chmod(0644)
+	}
+	if cerr := rf.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(rf.f.Name(), rf.path)
+	}
+	reportw = os.Stdout
+	return err
+}
@@ -0,0 +1,312 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Support for saving analysis results (-save) and merging previously
+// saved results into a new run (-load), so that expensive inputs such
+// as the mingw CRT archives need only be analyzed once.
+
+type savedObject struct {
+	Name    string     `json:"name"`
+	File    string     `json:"file"`
+	Prov    provenance `json:"prov"`
+	Machine string     `json:"machine,omitempty"`
+	Go      bool       `json:"go,omitempty"`
+}
+
+type savedSection struct {
+	Obj  int    `json:"obj"`
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	Idx  int    `json:"idx"`
+	// from the section-definition aux record
+	Aux       bool   `json:"aux,omitempty"`
+	Relocs    int    `json:"relocs,omitempty"`
+	Checksum  uint32 `json:"checksum,omitempty"`
+	Comdat    int    `json:"comdat,omitempty"`
+	AssocSect int    `json:"assoc,omitempty"`
+}
+
+type savedDef struct {
+	Obj   int `json:"obj"`
+	Sec   int `json:"sec"`
+	Value int `json:"value"`
+}
+
+type savedRef struct {
+	Obj     int   `json:"obj"`
+	Sec     int   `json:"sec"`
+	Offsets []int `json:"offsets,omitempty"`
+	Def     bool  `json:"def,omitempty"`
+	// the relocations at Offsets, with their types and sections
+	// (missing from files written by older versions)
+	Relocs []savedReloc `json:"relocs,omitempty"`
+}
+
+type savedReloc struct {
+	Off  int    `json:"off"`
+	Type string `json:"type"`
+	Sect string `json:"sect"`
+	// symbol-table section number of Sect (missing if not known)
+	Secnum int `json:"secnum,omitempty"`
+}
+
+type savedImport struct {
+	Obj     int    `json:"obj"`
+	DLL     string `json:"dll"`
+	Name    string `json:"name,omitempty"`
+	Ordinal int    `json:"ordinal,omitempty"`
+	Delay   bool   `json:"delay,omitempty"`
+}
+
+// savedState is the JSON form of the analysis state.
+type savedState struct {
+	Objects  []savedObject       `json:"objects"`
+	Sections []savedSection      `json:"sections"`
+	Defs     map[string]savedDef `json:"defs"`
+	// further definitions of symbols defined by more than one
+	// object (missing from files written by older versions)
+	DupDefs map[string][]savedDef `json:"dup_defs,omitempty"`
+	Refs    map[string][]savedRef `json:"refs"`
+	Imports []savedImport         `json:"imports,omitempty"`
+	DLLs    map[string]string     `json:"dlls,omitempty"`
+	// absolute and debug symbols (missing from files written by
+	// older versions)
+	Specials map[string][]savedDef `json:"specials,omitempty"`
+	// linker directives for each object, as written in the report
+	// (missing from files written by older versions)
+	Directives map[int][]string `json:"directives,omitempty"`
+	// the /EXPORT directives, checked (likewise)
+	Exports []savedExport `json:"exports,omitempty"`
+}
+
+type savedExport struct {
+	Obj     int    `json:"obj"`
+	Name    string `json:"name"`
+	Sym     string `json:"sym"`
+	Data    bool   `json:"data,omitempty"`
+	Defined bool   `json:"defined"`
+}
+
+// save writes the analysis state to the specified file as JSON.
+func (s *state) save(path string) error {
+	ss := savedState{
+		Defs: make(map[string]savedDef),
+		Refs: make(map[string][]savedRef),
+		DLLs: s.dllmap,
+	}
+	for i := range s.objs {
+		ss.Objects = append(ss.Objects,
+			savedObject{Name: s.objs[i], File: s.files[i], Prov: s.prov[i],
+				Machine: s.machines[i], Go: s.goObjects[i]})
+	}
+	for _, sn := range s.sects {
+		ss.Sections = append(ss.Sections,
+			savedSection{Obj: sn.objidx, Name: sn.name, Size: sn.size, Idx: sn.idx,
+				Aux: sn.haveAux, Relocs: sn.relocCount, Checksum: sn.checksum,
+				Comdat: sn.comdatSelection, AssocSect: sn.associatedSection})
+	}
+	for k, dl := range s.defs {
+		ss.Defs[k] = savedDef{Obj: dl[0].objidx, Sec: dl[0].secidx, Value: dl[0].value}
+		for _, di := range dl[1:] {
+			if ss.DupDefs == nil {
+				ss.DupDefs = make(map[string][]savedDef)
+			}
+			ss.DupDefs[k] = append(ss.DupDefs[k], savedDef{Obj: di.objidx, Sec: di.secidx, Value: di.value})
+		}
+	}
+	for k, ds := range s.directives {
+		if ss.Directives == nil {
+			ss.Directives = make(map[int][]string)
+		}
+		for _, d := range ds {
+			ss.Directives[k] = append(ss.Directives[k], d.String())
+		}
+	}
+	for _, e := range s.exports {
+		ss.Exports = append(ss.Exports, savedExport{Obj: e.objidx, Name: e.name, Sym: e.sym, Data: e.data, Defined: e.defined})
+	}
+	for _, sp := range s.specials {
+		if ss.Specials == nil {
+			ss.Specials = make(map[string][]savedDef)
+		}
+		ss.Specials[sp.sym] = append(ss.Specials[sp.sym], savedDef{Obj: sp.objidx, Sec: sp.secidx, Value: sp.value})
+	}
+	for k, rl := range s.refs {
+		srl := make([]savedRef, 0, len(rl))
+		for _, ri := range rl {
+			sr := savedRef{Obj: ri.objidx, Sec: ri.secidx,
+				Offsets: ri.offsets(), Def: ri.def}
+			for _, r := range ri.relocs {
+				sr.Relocs = append(sr.Relocs, savedReloc{Off: r.off, Type: r.typ, Sect: r.sect, Secnum: r.secnum})
+			}
+			srl = append(srl, sr)
+		}
+		ss.Refs[k] = srl
+	}
+	oidxs := make([]int, 0, len(s.imports))
+	for k := range s.imports {
+		oidxs = append(oidxs, k)
+	}
+	sort.Ints(oidxs)
+	for _, oidx := range oidxs {
+		for _, pi := range s.imports[oidx] {
+			ss.Imports = append(ss.Imports, savedImport{Obj: oidx, DLL: pi.dll,
+				Name: pi.name, Ordinal: pi.ordinal, Delay: pi.delay})
+		}
+	}
+	b, err := json.MarshalIndent(&ss, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0666)
+}
+
+// readSavedState reads a state file written by save.
+func readSavedState(path string) (*savedState, error) {
+	content, err := readText(path)
+	if err != nil {
+		return nil, err
+	}
+	ss := &savedState{}
+	if err := json.Unmarshal(content, ss); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, ri := range ss.Refs {
+		for _, r := range ri {
+			if r.Obj < 0 || r.Obj >= len(ss.Objects) {
+				return nil, fmt.Errorf("%s: bad object index %d", path, r.Obj)
+			}
+		}
+	}
+	for _, di := range ss.Defs {
+		if di.Obj < 0 || di.Obj >= len(ss.Objects) {
+			return nil, fmt.Errorf("%s: bad object index %d", path, di.Obj)
+		}
+	}
+	for _, dl := range ss.DupDefs {
+		for _, di := range dl {
+			if di.Obj < 0 || di.Obj >= len(ss.Objects) {
+				return nil, fmt.Errorf("%s: bad object index %d", path, di.Obj)
+			}
+		}
+	}
+	return ss, nil
+}
+
+// merge adds the objects from a saved state to s, renumbering them to
+// follow the objects already present. Loaded objects have no file
+// associated with them (the originals may no longer exist), so no
+// excerpts are produced for them. Definitions are added to any
+// already in s; those that conflict with an existing one are also
+// recorded in s.conflicts.
+func (s *state) merge(ss *savedState, from string) {
+	base := len(s.objs)
+	for k, so := range ss.Objects {
+		if so.Machine != "" {
+			s.machines[base+k] = so.Machine
+		}
+		if so.Go {
+			s.goObjects[base+k] = true
+		}
+		s.objs = append(s.objs, so.Name)
+		s.files = append(s.files, "")
+		s.prov = append(s.prov, so.Prov)
+	}
+	s.labels = objLabels(s.objs)
+	for _, sn := range ss.Sections {
+		s.addSection(secinfo{objidx: sn.Obj + base,
+			name: sn.Name, size: sn.Size, idx: sn.Idx,
+			haveAux: sn.Aux, relocCount: sn.Relocs, checksum: sn.Checksum,
+			comdatSelection: sn.Comdat, associatedSection: sn.AssocSect})
+	}
+	dnames := make([]string, 0, len(ss.Defs))
+	for k := range ss.Defs {
+		dnames = append(dnames, k)
+	}
+	sort.Strings(dnames)
+	for _, k := range dnames {
+		sd := ss.Defs[k]
+		if di, ok := s.def(k); ok {
+			s.conflicts = append(s.conflicts,
+				fmt.Sprintf("%q defined in O%d and O%d (from %s)",
+					k, di.objidx, sd.Obj+base, from))
+		}
+		s.defs[k] = append(s.defs[k], definfo{objidx: sd.Obj + base, secidx: sd.Sec, value: sd.Value})
+		for _, sd := range ss.DupDefs[k] {
+			s.defs[k] = append(s.defs[k], definfo{objidx: sd.Obj + base, secidx: sd.Sec, value: sd.Value})
+		}
+	}
+	for k, srl := range ss.Refs {
+		for _, sr := range srl {
+			ri := refinfo{objidx: sr.Obj + base, secidx: sr.Sec, def: sr.Def}
+			for _, r := range sr.Relocs {
+				ri.relocs = append(ri.relocs, relocinfo{off: r.Off, typ: r.Type, sect: r.Sect, secnum: r.Secnum, kind: refKindOf(k, r.Type, r.Sect)})
+			}
+			if len(sr.Relocs) == 0 {
+				for _, off := range sr.Offsets {
+					ri.relocs = append(ri.relocs, relocinfo{off: off})
+				}
+			}
+			s.refs[k] = append(s.refs[k], ri)
+		}
+		s.all[k] = true
+	}
+	snames := make([]string, 0, len(ss.Specials))
+	for k := range ss.Specials {
+		snames = append(snames, k)
+	}
+	sort.Strings(snames)
+	for _, k := range snames {
+		for _, sd := range ss.Specials[k] {
+			s.specials = append(s.specials, special{k, sd.Obj + base, sd.Sec, sd.Value})
+		}
+	}
+	for k, dl := range ss.Directives {
+		for _, d := range dl {
+			s.directives[k+base] = append(s.directives[k+base], parseDirectives([]byte(d))...)
+		}
+	}
+	for _, se := range ss.Exports {
+		s.exports = append(s.exports, export{objidx: se.Obj + base, name: se.Name, sym: se.Sym, data: se.Data, defined: se.Defined})
+	}
+	for _, si := range ss.Imports {
+		oidx := si.Obj + base
+		s.imports[oidx] = append(s.imports[oidx], peimport{dll: si.DLL,
+			name: si.Name, ordinal: si.Ordinal, delay: si.Delay})
+	}
+	for k, dll := range ss.DLLs {
+		if _, ok := s.dllmap[k]; !ok {
+			s.dllmap[k] = dll
+		}
+	}
+	s.computeDefref()
+}
+
+// computeDefref recomputes the def/ref breakdown from scratch, based
+// on the defs and refs collected for all objects.
+func (s *state) computeDefref() {
+	s.defref = make(map[string]defrefmask)
+	for k, dl := range s.defs {
+		for _, di := range dl {
+			s.maskAddDef(k, di.objidx)
+		}
+	}
+	for k, rl := range s.refs {
+		for _, ri := range rl {
+			if !ri.def {
+				s.maskAddRef(k, ri.objidx)
+			}
+		}
+	}
+	s.markDefPairs()
+}
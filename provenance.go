@@ -0,0 +1,118 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// provenance records where an object came from, as described by a
+// sidecar file written alongside the object by whatever produced it.
+type provenance struct {
+	// path to the object (or its source) in the original build
+	Path string `json:"path"`
+	// package the object belongs to
+	Pkg string `json:"pkg"`
+	// command used to produce the object
+	Cmd string `json:"cmd"`
+	// fromSym is set if Path is the source file named by the
+	// object's .file symbol, there being no sidecar
+	fromSym bool
+}
+
+func (pi provenance) String() string {
+	res := pi.Path
+	if pi.Pkg != "" {
+		res += " pkg=" + pi.Pkg
+	}
+	if pi.Cmd != "" {
+		res += fmt.Sprintf(" cmd=%q", pi.Cmd)
+	}
+	return res
+}
+
+// sidecar describes a provenance file kept next to an object: the
+// extension that replaces the object's own, and the format of the
+// file ("pn" for a text file with a "pn: <path>" line, "json" for a
+// JSON-encoded provenance struct).
+type sidecar struct {
+	ext    string
+	format string
+}
+
+// parseSidecars parses a comma-separated list of ext:format sidecar
+// specifications.
+func parseSidecars(spec string) ([]sidecar, error) {
+	var res []sidecar
+	if spec == "" {
+		return nil, nil
+	}
+	for _, sc := range strings.Split(spec, ",") {
+		ext, format, ok := strings.Cut(sc, ":")
+		if !ok || ext == "" {
+			return nil, fmt.Errorf("bad sidecar spec %q (want ext:format)", sc)
+		}
+		switch format {
+		case "pn", "json":
+		default:
+			return nil, fmt.Errorf("unknown sidecar format %q in %q", format, sc)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		res = append(res, sidecar{ext: ext, format: format})
+	}
+	return res, nil
+}
+
+// sidecars is the parsed form of -sidecar.
+var sidecars []sidecar
+
+// pathinfo returns provenance info for an object, read from the first
+// sidecar file (per -sidecar) found next to the object, possibly
+// gzip-compressed. Missing or unreadable sidecars result in empty
+// provenance.
+func pathinfo(infile string) provenance {
+	// Only the final element's extension is replaced, whichever
+	// separators the path uses.
+	dir, file := filepath.Split(infile)
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, sc := range sidecars {
+		scfile := dir + base + sc.ext
+		if scfile == infile {
+			continue
+		}
+		content, err := readText(scfile)
+		if os.IsNotExist(err) {
+			content, err = readText(scfile + ".gz")
+		}
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "warning: sidecar: %v\n", err)
+			}
+			continue
+		}
+		switch sc.format {
+		case "pn":
+			for _, line := range strings.Split(string(content), "\n") {
+				if strings.HasPrefix(line, "pn: ") {
+					return provenance{Path: strings.TrimRight(line[4:], "\r")}
+				}
+			}
+		case "json":
+			var pi provenance
+			if err := json.Unmarshal(content, &pi); err != nil {
+				verb(1, "ignoring malformed sidecar %s: %v", scfile, err)
+				continue
+			}
+			return pi
+		}
+	}
+	return provenance{}
+}
@@ -0,0 +1,200 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Support for the JSON output of "llvm-readobj --elf-output-style=JSON
+// --sections --symbols --relocs --expand-relocs", in which symbol
+// values, section numbers and the like come through as typed fields.
+// As of LLVM 14 the output for COFF objects is not well-formed JSON as
+// a whole: the file summary isn't wrapped in an object, and each
+// section's relocations are introduced by a "Section (N) name {" text
+// line. The objects describing sections, symbols and relocations are
+// well-formed though, so each of those is decoded separately.
+
+type roSection struct {
+	Section struct {
+		Number int
+		Name   struct {
+			Value string
+		}
+		RawDataSize int
+	}
+}
+
+type roSymbol struct {
+	Symbol struct {
+		Name    string
+		Value   int
+		Section struct {
+			RawValue int
+		}
+		StorageClass struct {
+			RawValue int
+		}
+		AuxFileRecord *struct {
+			FileName string
+		}
+		AuxSectionDef *struct {
+			RelocationCount int
+			Checksum        uint32
+			Number          int
+			Selection       roEnum
+		}
+	}
+}
+
+// roEnum is an enumerated value, shown as a plain number if it has
+// no name, and otherwise as {"Value":name,"RawValue":number}.
+type roEnum struct {
+	RawValue int
+}
+
+func (e *roEnum) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &e.RawValue); err == nil {
+		return nil
+	}
+	var v struct {
+		RawValue int
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	e.RawValue = v.RawValue
+	return nil
+}
+
+type roReloc struct {
+	Relocation struct {
+		Offset int
+		Type   struct {
+			Value string
+		}
+		Symbol string
+	}
+}
+
+// Section (1) .text {
+var rosecre = regexp.MustCompile(`Section \((\d+)\) (\S+) \{$`)
+
+// decodeAt decodes into v the JSON value starting at the first
+// occurrence of marker in content, returning false if there is none.
+func decodeAt(content, marker string, v interface{}) (bool, error) {
+	i := strings.Index(content, marker)
+	if i == -1 {
+		return false, nil
+	}
+	if err := json.NewDecoder(strings.NewReader(content[i:])).Decode(v); err != nil {
+		return false, fmt.Errorf("decoding llvm-readobj output: %v", err)
+	}
+	return true, nil
+}
+
+// readobjSymbols returns the symbols in llvm-readobj output.
+func readobjSymbols(content string) ([]roSymbol, error) {
+	var syms struct {
+		Symbols []roSymbol
+	}
+	if _, err := decodeAt(content, `{"Symbols":`, &syms); err != nil {
+		return nil, err
+	}
+	return syms.Symbols, nil
+}
+
+// pass1Readobj is pass1 for llvm-readobj output.
+func (s *state) pass1Readobj(content string) error {
+	syms, err := readobjSymbols(content)
+	if err != nil {
+		return err
+	}
+	for _, sym := range syms {
+		if sname := sym.Symbol.Name; s.isInterestingSym(sname) {
+			s.all[sname] = true
+		}
+	}
+	return nil
+}
+
+func (s *state) digestReadobj(content string) error {
+	var secs struct {
+		Sections []roSection
+	}
+	if _, err := decodeAt(content, `{"Sections":`, &secs); err != nil {
+		return err
+	}
+	for _, sec := range secs.Sections {
+		sname := sec.Section.Name.Value
+		if pass3Sections[sname] {
+			s.newSection(sname, sec.Section.RawDataSize, sec.Section.Number-1)
+		}
+	}
+
+	syms, err := readobjSymbols(content)
+	if err != nil {
+		return err
+	}
+	defs := make(map[string]struct{})
+	srcfile := ""
+	for _, sym := range syms {
+		ss := &sym.Symbol
+		if ss.StorageClass.RawValue == imageSymClassFile && srcfile == "" && ss.AuxFileRecord != nil {
+			srcfile = ss.AuxFileRecord.FileName
+		}
+		if ad := ss.AuxSectionDef; ad != nil {
+			s.setSectionAux(ss.Section.RawValue, secaux{nreloc: ad.RelocationCount,
+				checksum: ad.Checksum, number: ad.Number, selection: ad.Selection.RawValue})
+		}
+		if err := s.addSymbol(ss.Name, ss.Section.RawValue, ss.Value, defs); err != nil {
+			return err
+		}
+	}
+	s.finishSymtab(srcfile, defs)
+
+	i := strings.Index(content, `{"Relocations":`)
+	if i == -1 {
+		return nil
+	}
+	skip := true
+	sname := ""
+	secnum := 0
+	for _, line := range strings.Split(content[i:], "\n") {
+		if m := rosecre.FindStringSubmatch(line); len(m) != 0 {
+			secnum, _ = strconv.Atoi(m[1])
+			sname = m[2]
+			skip = !pass3Sections[sname]
+			continue
+		}
+		if skip {
+			continue
+		}
+		const marker = `{"Relocation":`
+		for {
+			j := strings.Index(line, marker)
+			if j == -1 {
+				break
+			}
+			line = line[j:]
+			var r roReloc
+			if _, err := decodeAt(line, marker, &r); err != nil {
+				return err
+			}
+			s.countReloc(sname)
+			rl := &r.Relocation
+			desc := fmt.Sprintf("%016x %s %s", rl.Offset, rl.Type.Value, rl.Symbol)
+			if err := s.addReloc(sname, secnum, fmt.Sprintf("%x", rl.Offset), rl.Type.Value, rl.Symbol, desc); err != nil {
+				return err
+			}
+			line = line[len(marker):]
+		}
+	}
+	return nil
+}
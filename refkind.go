@@ -0,0 +1,208 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for classifying references (-ref-kinds). Each relocation
+// against a symbol of interest is classed, from its type, the section
+// it applies to and whether the symbol is an import symbol, as one of:
+//
+//	call   a PC-relative reference to X from code: a call (or jump) to
+//	       the thunk or function X
+//	icall  a reference to the import slot __imp_X from code: the usual
+//	       "call qword ptr [__imp_X]", or a load of the slot
+//	addr   an absolute address (ADDR64, DIR32, ...) or a reference
+//	       from data: the address of X or of the slot __imp_X is taken
+//	other  anything else (SECREL, SECTION and the like)
+//
+// With -ref-kinds, each offset in the Refs section is followed by its
+// kind, and each line of the Def/ref breakdown ends with the number of
+// references of each kind. Address-taken imports, which the Go linker
+// has to give a real address to rather than call through, are always
+// listed in an "Address-taken imports" section.
+
+// refKind is the kind of a reference.
+type refKind int
+
+const (
+	refOther refKind = iota
+	refCall
+	refICall
+	refAddr
+	numRefKinds
+)
+
+var refKindNames = [numRefKinds]string{
+	refOther: "other",
+	refCall:  "call",
+	refICall: "icall",
+	refAddr:  "addr",
+}
+
+func (k refKind) String() string {
+	return refKindNames[k]
+}
+
+// absRelocTypes are the short relocation types giving the absolute
+// address (or image-relative address) of the target.
+var absRelocTypes = map[string]bool{
+	"ADDR64": true, "ADDR32": true, "ADDR32NB": true,
+	"DIR32": true, "DIR32NB": true,
+	"64": true, "32": true, "32S": true, "ABS64": true, "ABS32": true,
+}
+
+// relRelocTypes are the short relocation types giving the address of
+// the target relative to the place being relocated (or its page).
+var relRelocTypes = map[string]bool{
+	"REL32": true, "REL32_1": true, "REL32_2": true, "REL32_3": true,
+	"REL32_4": true, "REL32_5": true,
+	"BRANCH26": true, "BRANCH19": true, "BRANCH14": true,
+	"PAGEBASE_REL21": true, "REL21": true,
+	"PC32": true, "PLT32": true, "CALL26": true, "JUMP26": true,
+}
+
+// isCodeSection reports whether sect holds code.
+func isCodeSection(sect string) bool {
+	return sect == ".text" || strings.HasPrefix(sect, ".text$") || strings.HasPrefix(sect, ".text.")
+}
+
+// refKindOf returns the kind of a relocation of type styp in section
+// sect against symbol sname.
+func refKindOf(sname, styp, sect string) refKind {
+	t := relocinfo{typ: styp}.shortType()
+	imp := isImpSym(sname)
+	code := isCodeSection(sect)
+	switch {
+	case absRelocTypes[t]:
+		// There is no PC-relative addressing on i386, so calls
+		// through the slot use its absolute address.
+		if imp && code && strings.HasPrefix(styp, "IMAGE_REL_I386_") {
+			return refICall
+		}
+		return refAddr
+	case relRelocTypes[t] || strings.Contains(t, "GOT"):
+		switch {
+		case !code:
+			return refAddr
+		case imp || strings.Contains(t, "GOT"):
+			return refICall
+		case t == "PAGEBASE_REL21" || t == "REL21":
+			// adrp (or adr) of X itself, to form its address.
+			return refAddr
+		}
+		return refCall
+	}
+	return refOther
+}
+
+// kinds returns the kinds of the relocations in ri.
+func (ri *refinfo) kinds() []string {
+	res := make([]string, len(ri.relocs))
+	for i, r := range ri.relocs {
+		res[i] = r.kind.String()
+	}
+	return res
+}
+
+// kindCounts returns the number of references of each kind to each
+// base symbol (as in the Def/ref breakdown), counting those to X and
+// to __imp_X together.
+func (s *state) kindCounts() map[string][numRefKinds]int {
+	res := make(map[string][numRefKinds]int)
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			if len(ri.relocs) == 0 {
+				continue
+			}
+			x, _ := s.symKey(sname, ri.objidx)
+			c := res[x]
+			for _, r := range ri.relocs {
+				c[r.kind]++
+			}
+			res[x] = c
+		}
+	}
+	return res
+}
+
+// kindsTag returns the breakdown annotation giving the counts c of
+// each kind of reference, or "" without -ref-kinds.
+func kindsTag(c [numRefKinds]int) string {
+	if !*refkindsflag {
+		return ""
+	}
+	var parts []string
+	for _, k := range []refKind{refCall, refICall, refAddr, refOther} {
+		if c[k] != 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", k, c[k]))
+		}
+	}
+	return " {" + strings.Join(parts, " ") + "}"
+}
+
+// addrTaken is an address-taken reference to an import: a relocation
+// of kind refAddr against __imp_X, or against X where X is an import.
+type addrTaken struct {
+	sym    string
+	objidx int
+	reloc  relocinfo
+}
+
+// addrTakenImports returns the address-taken references to imports
+// in s, sorted by symbol, object and offset.
+func (s *state) addrTakenImports() []addrTaken {
+	var res []addrTaken
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			x, imp := s.symKey(sname, ri.objidx)
+			if !imp && s.defref[x]&(defimp|refimp) == 0 {
+				continue
+			}
+			for _, r := range ri.relocs {
+				if r.kind == refAddr {
+					res = append(res, addrTaken{sname, ri.objidx, r})
+				}
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.sym != b.sym {
+			return a.sym < b.sym
+		}
+		if a.objidx != b.objidx {
+			return a.objidx < b.objidx
+		}
+		if a.reloc.sect != b.reloc.sect {
+			return a.reloc.sect < b.reloc.sect
+		}
+		return a.reloc.off < b.reloc.off
+	})
+	return res
+}
+
+// writeAddrTaken writes the list of address-taken references to
+// imports in s, if any, to w.
+func (s *state) writeAddrTaken(w io.Writer) {
+	ats := s.addrTakenImports()
+	if len(ats) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Address-taken imports:\n")
+	for _, at := range ats {
+		typ := at.reloc.shortType()
+		if typ == "" {
+			typ = "?"
+		}
+		fmt.Fprintf(w, " %q: O%d %s+0x%x %s %s\n", at.sym, at.objidx,
+			at.reloc.sect, at.reloc.off, typ, s.labels[at.objidx])
+	}
+}
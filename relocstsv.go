@@ -0,0 +1,59 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Support for writing every relocation against an interesting symbol
+// as a row of a tab-separated table (-relocs-tsv), for loading into
+// other tools. The columns are the O-number, the object path, the
+// section, the offset (in hex), the relocation type and the target
+// symbol, and there is a header row naming them. Rows are sorted by
+// object, then section, then offset.
+
+// tsvReloc is a row of the -relocs-tsv table.
+type tsvReloc struct {
+	objidx int
+	r      relocinfo
+	sym    string
+}
+
+// writeRelocsTSV writes the relocation table for s to the specified
+// file.
+func (s *state) writeRelocsTSV(path string) error {
+	var rows []tsvReloc
+	for sym, rl := range s.refs {
+		for _, ri := range rl {
+			for _, r := range ri.relocs {
+				rows = append(rows, tsvReloc{ri.objidx, r, sym})
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		if a.objidx != b.objidx {
+			return a.objidx < b.objidx
+		}
+		if a.r.sect != b.r.sect {
+			return a.r.sect < b.r.sect
+		}
+		if a.r.off != b.r.off {
+			return a.r.off < b.r.off
+		}
+		return a.sym < b.sym
+	})
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "objidx\tobject\tsection\toffset\ttype\tsymbol\n")
+	for _, row := range rows {
+		fmt.Fprintf(sb, "%d\t%s\t%s\t0x%x\t%s\t%s\n", row.objidx, s.objs[row.objidx],
+			row.r.sect, row.r.off, row.r.typ, row.sym)
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0666)
+}
@@ -0,0 +1,243 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Support for writing findings as a SARIF 2.1.0 log (-sarif), for CI
+// systems that annotate builds from one. Each finding is a result,
+// located at an object, under one of these rules:
+//
+//	winimpsym/unresolved-import   __imp_X referred to, X not defimp
+//	                              (a result per referring object)
+//	winimpsym/defbase-refimp      X defined by an object and also
+//	                              referred to through __imp_X
+//	winimpsym/duplicate-imp-def   __imp_X defined by more than one
+//	                              object
+//
+// -sarif-rules picks the rules and their levels, as a comma-separated
+// list of rule[=level] (the rule without the "winimpsym/" prefix;
+// level one of error, warning, note or none). By default every rule
+// is used at its default level.
+
+// sarifRule is a kind of finding.
+type sarifRule struct {
+	id    string
+	desc  string
+	level string
+}
+
+// sarifRules are the kinds of findings, with their default levels.
+var sarifRules = []sarifRule{
+	{"unresolved-import", "Import symbol referred to but not defined", "warning"},
+	{"defbase-refimp", "Symbol defined directly but referred to through its import symbol", "warning"},
+	{"duplicate-imp-def", "Import symbol defined by more than one object", "error"},
+}
+
+const sarifRulePrefix = "winimpsym/"
+
+// parseSarifRules returns the rules selected by the -sarif-rules
+// value v, with their levels.
+func parseSarifRules(v string) ([]sarifRule, error) {
+	if v == "" {
+		return sarifRules, nil
+	}
+	var res []sarifRule
+	for _, f := range strings.Split(v, ",") {
+		id, level, hasLevel := strings.Cut(f, "=")
+		id = strings.TrimPrefix(id, sarifRulePrefix)
+		var r *sarifRule
+		for i := range sarifRules {
+			if sarifRules[i].id == id {
+				r = &sarifRules[i]
+			}
+		}
+		if r == nil {
+			return nil, fmt.Errorf("bad -sarif-rules: unknown rule %q", id)
+		}
+		sr := *r
+		if hasLevel {
+			switch level {
+			case "error", "warning", "note", "none":
+				sr.level = level
+			default:
+				return nil, fmt.Errorf("bad -sarif-rules: bad level %q for %s", level, id)
+			}
+		}
+		res = append(res, sr)
+	}
+	return res, nil
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string                `json:"name"`
+	InformationURI string                `json:"informationUri"`
+	Rules          []sarifReportingDescr `json:"rules"`
+}
+
+type sarifReportingDescr struct {
+	ID                   string        `json:"id"`
+	ShortDescription     sarifMessage  `json:"shortDescription"`
+	DefaultConfiguration sarifRuleConf `json:"defaultConfiguration"`
+}
+
+type sarifRuleConf struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifFinding is a finding for rule id, for symbol sym of object
+// objidx.
+type sarifFinding struct {
+	id     string
+	sym    string
+	objidx int
+	msg    string
+}
+
+// sarifFindings returns the findings for s, ordered by symbol and
+// object.
+func (s *state) sarifFindings() []sarifFinding {
+	var fs []sarifFinding
+	for sname, rl := range s.refs {
+		if !isImpSym(sname) || len(rl) == 0 {
+			continue
+		}
+		x, _ := s.symKey(sname, rl[0].objidx)
+		drm := s.defref[x]
+		if drm&refimp == 0 || drm&defimp != 0 {
+			continue
+		}
+		for _, ri := range rl {
+			if ri.def || len(ri.relocs) == 0 {
+				continue
+			}
+			fs = append(fs, sarifFinding{"unresolved-import", sname, ri.objidx,
+				fmt.Sprintf("%s is referred to, but no object defines it", sname)})
+		}
+	}
+	for sname, dl := range s.defs {
+		if isImpSym(sname) {
+			for _, di := range dl[1:] {
+				fs = append(fs, sarifFinding{"duplicate-imp-def", sname, di.objidx,
+					fmt.Sprintf("%s is defined here and by %s", sname, s.objs[dl[0].objidx])})
+			}
+		}
+		di := dl[0]
+		x, imp := s.symKey(sname, di.objidx)
+		if imp || s.defref[x]&(defbase|refimp) != defbase|refimp {
+			continue
+		}
+		names := impNames(sname)
+		via := names[0]
+		for _, in := range names {
+			if len(s.refs[in]) != 0 {
+				via = in
+				break
+			}
+		}
+		fs = append(fs, sarifFinding{"defbase-refimp", sname, di.objidx,
+			fmt.Sprintf("%s is defined here but referred to through %s", sname, via)})
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		if fs[i].sym != fs[j].sym {
+			return fs[i].sym < fs[j].sym
+		}
+		if fs[i].id != fs[j].id {
+			return fs[i].id < fs[j].id
+		}
+		return fs[i].objidx < fs[j].objidx
+	})
+	return fs
+}
+
+// writeSARIF writes the findings for s under the specified rules as a
+// SARIF log to the specified file.
+func (s *state) writeSARIF(path string, rules []sarifRule) error {
+	driver := sarifDriver{
+		Name:           "winimpsym",
+		InformationURI: "https://github.com/thanm/winimpsym",
+		Rules:          []sarifReportingDescr{},
+	}
+	index := make(map[string]int)
+	for i, r := range rules {
+		index[r.id] = i
+		driver.Rules = append(driver.Rules, sarifReportingDescr{
+			ID:                   sarifRulePrefix + r.id,
+			ShortDescription:     sarifMessage{r.desc},
+			DefaultConfiguration: sarifRuleConf{r.level},
+		})
+	}
+	results := []sarifResult{}
+	for _, f := range s.sarifFindings() {
+		i, ok := index[f.id]
+		if !ok {
+			continue
+		}
+		results = append(results, sarifResult{
+			RuleID:    sarifRulePrefix + f.id,
+			RuleIndex: i,
+			Level:     rules[i].level,
+			Message:   sarifMessage{f.msg},
+			Locations: []sarifLocation{{sarifPhysicalLocation{
+				sarifArtifactLocation{filepath.ToSlash(s.objs[f.objidx])}}}},
+		})
+	}
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{driver}, Results: results}},
+	}
+	b, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0666)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// Support for the auxiliary section-definition records that follow
+// the symbol for each section in the symbol table. These give the
+// section's relocation count and checksum, and for COMDAT sections
+// the selection rule (and, for associative ones, the section they go
+// with).
+
+// secaux holds the contents of a section-definition aux record.
+type secaux struct {
+	nreloc    int
+	checksum  uint32
+	number    int
+	selection int
+}
+
+// IMAGE_COMDAT_SELECT_*
+const comdatAssociative = 5
+
+var comdatNames = []string{
+	1: "NODUPLICATES",
+	2: "ANY",
+	3: "SAME_SIZE",
+	4: "EXACT_MATCH",
+	5: "ASSOCIATIVE",
+	6: "LARGEST",
+}
+
+// comdatName returns the name of COMDAT selection sel.
+func comdatName(sel int) string {
+	if sel > 0 && sel < len(comdatNames) {
+		return comdatNames[sel]
+	}
+	return fmt.Sprintf("%d", sel)
+}
+
+// AUX scnlen 0x6 nreloc 1 nlnno 0 checksum 0xede50bb8 assoc 4 comdat 2
+var auxsecre = regexp.MustCompile(`^AUX scnlen 0x[0-9a-f]+ nreloc (\d+) nlnno \d+ checksum (0x[0-9a-f]+) assoc (\d+) comdat (\d+)`)
+
+// Section length    6, #relocs    1, #linenums    0, checksum EDE50BB8, selection    2 (pick any)
+var dbauxsecre = regexp.MustCompile(`^\s+Section length\s+[0-9A-F]+, #relocs\s+([0-9A-F]+), #linenums\s+[0-9A-F]+, checksum\s+([0-9A-F]+)(?:, selection\s+(\d+) \(pick [^)]*?(?:Section (?:0x)?([0-9A-F]+))?\))?`)
+
+// parseAuxSec parses a section-definition aux record line as shown
+// by objdump, returning false if line isn't one.
+func parseAuxSec(line string) (secaux, bool) {
+	var a secaux
+	m := auxsecre.FindStringSubmatch(line)
+	if len(m) == 0 {
+		return a, false
+	}
+	fmt.Sscanf(m[1], "%d", &a.nreloc)
+	fmt.Sscanf(m[2], "0x%x", &a.checksum)
+	fmt.Sscanf(m[3], "%d", &a.number)
+	fmt.Sscanf(m[4], "%d", &a.selection)
+	return a, true
+}
+
+// parseAuxSecDumpbin is parseAuxSec for dumpbin output, in which the
+// numbers are in hex and the associated section is only shown for
+// associative COMDATs.
+func parseAuxSecDumpbin(line string) (secaux, bool) {
+	var a secaux
+	m := dbauxsecre.FindStringSubmatch(line)
+	if len(m) == 0 {
+		return a, false
+	}
+	fmt.Sscanf(m[1], "%x", &a.nreloc)
+	fmt.Sscanf(m[2], "%x", &a.checksum)
+	if m[3] != "" {
+		fmt.Sscanf(m[3], "%d", &a.selection)
+	}
+	if m[4] != "" {
+		fmt.Sscanf(m[4], "%x", &a.number)
+	}
+	return a, true
+}
+
+// setSectionAux records the aux record for section secnum (numbered
+// from 1) of the current object, if it is one of those recorded.
+func (s *state) setSectionAux(secnum int, a secaux) {
+	for i := len(s.sects) - 1; i >= 0; i-- {
+		si := &s.sects[i]
+		if si.objidx != s.objidx {
+			break
+		}
+		if si.idx != secnum-1 {
+			continue
+		}
+		si.haveAux = true
+		si.relocCount = a.nreloc
+		si.checksum = a.checksum
+		si.comdatSelection = a.selection
+		if a.selection == comdatAssociative {
+			si.associatedSection = a.number
+		}
+		return
+	}
+}
+
+// countReloc notes that a relocation was read for section sname of
+// the current object.
+func (s *state) countReloc(sname string) {
+	if s.relocsRead == nil {
+		s.relocsRead = make(map[string]int)
+	}
+	s.relocsRead[sname]++
+}
+
+// checkRelocCounts compares the number of relocations read for each
+// section of the current object against the counts in the aux
+// records, warning about any mismatch. Sections with the same name
+// (COMDATs, say) are lumped together, since relocations are listed by
+// section name.
+func (s *state) checkRelocCounts(infile string) {
+	defer func() { s.relocsRead, s.relocLists = nil, nil }()
+	want := make(map[string]int)
+	for i := len(s.sects) - 1; i >= 0; i-- {
+		si := &s.sects[i]
+		if si.objidx != s.objidx {
+			break
+		}
+		if !si.haveAux {
+			// Can't tell.
+			return
+		}
+		want[si.name] += si.relocCount
+	}
+	names := make([]string, 0, len(want))
+	for sname := range want {
+		names = append(names, sname)
+	}
+	sort.Strings(names)
+	for _, sname := range names {
+		if got := s.relocsRead[sname]; got != want[sname] {
+			fmt.Fprintf(os.Stderr, "warning: %s: read %d relocations for %s, but its section header says %d\n",
+				infile, got, sname, want[sname])
+		}
+	}
+}
+
+// auxString returns the section listing annotation for COMDAT section
+// si, or "" if it isn't one.
+func (si *secinfo) auxString() string {
+	if si.comdatSelection == 0 {
+		return ""
+	}
+	res := " comdat=" + comdatName(si.comdatSelection)
+	if si.comdatSelection == comdatAssociative {
+		res += fmt.Sprintf("(%d)", si.associatedSection-1)
+	}
+	return res + fmt.Sprintf(" checksum=0x%x", si.checksum)
+}
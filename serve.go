@@ -0,0 +1,358 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Support for exploring the results in a browser (-serve=addr). Once
+// the run is done, the results are served until the tool is
+// interrupted, from these pages:
+//
+//	/                 the Def/ref breakdown, with ?q= to search it,
+//	                  and the objects
+//	/sym/X            the definitions of and references to X and
+//	                  __imp_X, and the excerpts if X is watched
+//	/obj/N            the sections, definitions and references of
+//	                  object N
+//	/api/symbol/X     the same as /sym/X, as JSON
+//
+// Everything comes from the in-memory state; the pages use nothing
+// from elsewhere.
+
+// servedExcerpts is the excerpts for watched symbols, as in the
+// report, for the pages of watched symbols.
+var servedExcerpts string
+
+// apiSymbol is the /api/symbol response for a symbol.
+type apiSymbol struct {
+	Sym        string    `json:"sym"`
+	Categories []string  `json:"categories"`
+	DLL        string    `json:"dll,omitempty"`
+	Watched    bool      `json:"watched,omitempty"`
+	Defs       []apiDef  `json:"defs"`
+	Refs       []apiRefs `json:"refs"`
+}
+
+type apiDef struct {
+	Sym     string `json:"sym"`
+	Obj     int    `json:"obj"`
+	Label   string `json:"label"`
+	SecName string `json:"sec_name"`
+	Value   int    `json:"value"`
+}
+
+type apiRefs struct {
+	Sym  string   `json:"sym"`
+	Refs []apiRef `json:"refs"`
+}
+
+type apiRef struct {
+	Obj     int      `json:"obj"`
+	Label   string   `json:"label"`
+	SecName string   `json:"sec_name"`
+	Offsets []int    `json:"offsets"`
+	Types   []string `json:"types"`
+	Def     bool     `json:"def,omitempty"`
+}
+
+// OffsetList formats the offsets of ar with their relocation types,
+// as in "0x9b/REL32 0xa6/REL32".
+func (ar apiRef) OffsetList() string {
+	items := make([]string, len(ar.Offsets))
+	for i, off := range ar.Offsets {
+		items[i] = fmt.Sprintf("0x%x", off)
+		if ar.Types[i] != "" {
+			items[i] += "/" + ar.Types[i]
+		}
+	}
+	return strings.Join(items, " ")
+}
+
+// symbolData returns the data for base symbol x, or false if there is
+// no such symbol.
+func (s *state) symbolData(x string) (*apiSymbol, bool) {
+	drm, ok := s.defref[x]
+	if !ok {
+		return nil, false
+	}
+	as := &apiSymbol{
+		Sym:        x,
+		Categories: strings.Fields(drm.String()),
+		DLL:        strings.Trim(s.dllTag(x), " []"),
+		Defs:       []apiDef{},
+		Refs:       []apiRefs{},
+	}
+	for sname, dl := range s.defs {
+		for _, di := range dl {
+			if k, _ := s.symKey(sname, di.objidx); k != x {
+				continue
+			}
+			as.Defs = append(as.Defs, apiDef{Sym: sname, Obj: di.objidx, Label: s.labels[di.objidx],
+				SecName: s.secName(di.objidx, di.secidx), Value: di.value})
+			as.Watched = as.Watched || s.isWatched(sname, di.objidx)
+		}
+	}
+	sort.SliceStable(as.Defs, func(i, j int) bool { return as.Defs[i].Sym < as.Defs[j].Sym })
+	var snames []string
+	for sname, rl := range s.refs {
+		if len(rl) == 0 {
+			continue
+		}
+		if k, _ := s.symKey(sname, rl[0].objidx); k == x {
+			snames = append(snames, sname)
+		}
+	}
+	s.sortSyms(snames, func(sname string) string { return sname })
+	for _, sname := range snames {
+		ar := apiRefs{Sym: sname}
+		for _, ri := range s.refs[sname] {
+			ar.Refs = append(ar.Refs, apiRef{Obj: ri.objidx, Label: s.labels[ri.objidx],
+				SecName: s.refSecName(&ri), Offsets: ri.offsets(),
+				Types: ri.types(), Def: ri.def})
+			as.Watched = as.Watched || s.isWatched(sname, ri.objidx)
+		}
+		as.Refs = append(as.Refs, ar)
+	}
+	return as, true
+}
+
+// serveObject is the data for an object's page.
+type serveObject struct {
+	htmlObject
+	Sections []ReportSection
+	Defs     []apiDef
+	Refs     []serveObjRef
+}
+
+// serveObjRef is the references to a symbol from an object.
+type serveObjRef struct {
+	Sym     string
+	Base    string
+	SecName string
+	Offsets string
+	Def     bool
+}
+
+// objectData returns the data for object oi's page.
+func (s *state) objectData(oi int) *serveObject {
+	so := &serveObject{htmlObject: htmlObject{
+		Idx:   oi,
+		Label: s.labels[oi],
+		Tags:  strings.TrimSpace(s.objTags(oi, s.mixedMachines() != nil)),
+		Prov:  s.objProv(oi).String(),
+	}}
+	for _, si := range s.sects {
+		if si.objidx == oi {
+			so.Sections = append(so.Sections, ReportSection{Obj: oi, Index: si.idx, Name: si.name, Size: si.size})
+		}
+	}
+	for sname := range s.defs {
+		if di, ok := s.defIn(sname, oi); ok {
+			so.Defs = append(so.Defs, apiDef{Sym: sname, Obj: oi, Label: s.labels[oi],
+				SecName: s.secName(oi, di.secidx), Value: di.value})
+		}
+	}
+	sort.Slice(so.Defs, func(i, j int) bool { return so.Defs[i].Sym < so.Defs[j].Sym })
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			if ri.objidx != oi {
+				continue
+			}
+			x, _ := s.symKey(sname, oi)
+			so.Refs = append(so.Refs, serveObjRef{Sym: sname, Base: x,
+				SecName: s.refSecName(&ri),
+				Offsets: apiRef{Offsets: ri.offsets(), Types: ri.types()}.OffsetList(), Def: ri.def})
+		}
+	}
+	sort.Slice(so.Refs, func(i, j int) bool { return so.Refs[i].Sym < so.Refs[j].Sym })
+	return so
+}
+
+// serveHandler returns the handler for the pages for s.
+func (s *state) serveHandler() http.Handler {
+	data := s.htmlData()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query().Get("q")
+		page := struct {
+			*htmlReport
+			Query string
+		}{&htmlReport{Dumper: data.Dumper, Machine: data.Machine, Objects: data.Objects}, q}
+		for _, hs := range data.Syms {
+			if q == "" || strings.Contains(strings.ToLower(hs.Name+" "+hs.Cats+" "+hs.DLL), strings.ToLower(q)) {
+				page.Syms = append(page.Syms, hs)
+			}
+		}
+		servePage(w, serveIndexTemplate, page)
+	})
+	mux.HandleFunc("/sym/", func(w http.ResponseWriter, r *http.Request) {
+		as, ok := s.symbolData(strings.TrimPrefix(r.URL.Path, "/sym/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		page := struct {
+			*apiSymbol
+			Excerpts string
+		}{as, ""}
+		if as.Watched {
+			page.Excerpts = servedExcerpts
+		}
+		servePage(w, serveSymTemplate, page)
+	})
+	mux.HandleFunc("/obj/", func(w http.ResponseWriter, r *http.Request) {
+		oi, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/obj/"))
+		if err != nil || oi < 0 || oi >= len(s.objs) {
+			http.NotFound(w, r)
+			return
+		}
+		servePage(w, serveObjTemplate, s.objectData(oi))
+	})
+	mux.HandleFunc("/api/symbol/", func(w http.ResponseWriter, r *http.Request) {
+		as, ok := s.symbolData(strings.TrimPrefix(r.URL.Path, "/api/symbol/"))
+		if !ok {
+			http.Error(w, `{"error": "no such symbol"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(as)
+	})
+	return mux
+}
+
+// servePage executes page template t with data d, for w.
+func servePage(w http.ResponseWriter, t *template.Template, d any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serve serves the pages for s on addr until interrupted.
+func (s *state) serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s.serveHandler()}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(os.Stderr, "serving results on http://%s/ (interrupt to stop)\n", ln.Addr())
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+const serveStyle = `<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+.mono, pre { font-family: monospace; }
+.def { font-weight: bold; }
+</style>`
+
+var serveIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>winimpsym</title>
+` + serveStyle + `
+</head>
+<body>
+<h1>winimpsym</h1>
+{{if .Dumper}}<p>Dumper: {{.Dumper}}</p>{{end}}
+{{if .Machine}}<p>Machine: {{.Machine}}</p>{{end}}
+<h2>Def/ref breakdown</h2>
+<form action="/"><input name="q" type="search" value="{{.Query}}" placeholder="Search symbols" size="40"> <input type="submit" value="Search"> {{len .Syms}} symbols</form>
+<table>
+<tr><th>Symbol</th><th>Categories</th><th>DLL</th><th>Referencing objects</th></tr>
+{{range .Syms}}<tr><td class="mono"><a href="/sym/{{.Name}}">{{.Name}}</a></td><td>{{.Cats}}</td><td>{{.DLL}}</td><td>{{.NRefs}}</td></tr>
+{{end}}</table>
+<h2>Objects</h2>
+<table>
+<tr><th></th><th>Object</th><th>Tags</th><th>Provenance</th></tr>
+{{range .Objects}}<tr><td><a href="/obj/{{.Idx}}">O{{.Idx}}</a></td><td>{{.Label}}</td><td>{{.Tags}}</td><td>{{.Prov}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var serveSymTemplate = template.Must(template.New("sym").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Sym}} - winimpsym</title>
+` + serveStyle + `
+</head>
+<body>
+<p><a href="/">All symbols</a></p>
+<h1 class="mono">{{.Sym}}</h1>
+<p>Categories: {{range .Categories}}{{.}} {{end}}{{if .DLL}}<br>DLL: {{.DLL}}{{end}}</p>
+<h2>Definitions</h2>
+{{if .Defs}}<ul>{{range .Defs}}
+<li><span class="mono">{{.Sym}}</span> in <a href="/obj/{{.Obj}}">O{{.Obj}}</a> {{.Label}} sec={{.SecName}} value={{printf "0x%x" .Value}}</li>{{end}}
+</ul>{{else}}<p>None.</p>{{end}}
+<h2>References</h2>
+{{range .Refs}}<h3 class="mono">{{.Sym}}</h3>
+<ul>{{range .Refs}}
+<li{{if .Def}} class="def"{{end}}><a href="/obj/{{.Obj}}">O{{.Obj}}</a> {{.Label}} S={{.SecName}} <span class="mono">[{{.OffsetList}}]</span>{{if .Def}} (def){{end}}</li>{{end}}
+</ul>{{else}}<p>None.</p>{{end}}
+{{if .Excerpts}}<h2>Excerpts</h2>
+<pre>{{.Excerpts}}</pre>{{end}}
+</body>
+</html>
+`))
+
+var serveObjTemplate = template.Must(template.New("obj").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>O{{.Idx}} {{.Label}} - winimpsym</title>
+` + serveStyle + `
+</head>
+<body>
+<p><a href="/">All symbols</a></p>
+<h1>O{{.Idx}}: {{.Label}}</h1>
+{{if .Tags}}<p>{{.Tags}}</p>{{end}}
+{{if .Prov}}<p>Provenance: {{.Prov}}</p>{{end}}
+<h2>Sections</h2>
+<table>
+<tr><th>Index</th><th>Name</th><th>Size</th></tr>
+{{range .Sections}}<tr><td>{{.Index}}</td><td class="mono">{{.Name}}</td><td>{{printf "0x%x" .Size}}</td></tr>
+{{end}}</table>
+<h2>Definitions</h2>
+<ul>{{range .Defs}}
+<li class="mono">{{.Sym}} sec={{.SecName}} value={{printf "0x%x" .Value}}</li>{{end}}
+</ul>
+<h2>References</h2>
+<ul>{{range .Refs}}
+<li{{if .Def}} class="def"{{end}}><a class="mono" href="/sym/{{.Base}}">{{.Sym}}</a> S={{.SecName}} <span class="mono">[{{.Offsets}}]</span>{{if .Def}} (def){{end}}</li>{{end}}
+</ul>
+</body>
+</html>
+`))
@@ -0,0 +1,165 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Ordering of the Def/ref breakdown and the Refs section (-sort). By
+// default symbols are in alphabetical order; they can instead be put
+// in decreasing order of the number of references to them (counting
+// each relocation) or of the number of objects referring to them, or
+// grouped by category. Ties are broken alphabetically. The counts for
+// a symbol X include those for its import symbol __imp_X.
+
+const (
+	sortName     = "name"
+	sortNrefs    = "nrefs"
+	sortNobjs    = "nobjs"
+	sortCategory = "category"
+)
+
+func checkSortFlag(v string) error {
+	switch v {
+	case sortName, sortNrefs, sortNobjs, sortCategory:
+		return nil
+	}
+	return fmt.Errorf("bad -sort %q: expected name, nrefs, nobjs or category", v)
+}
+
+// refCounts holds the reference counts for a base symbol.
+type refCounts struct {
+	nrefs int
+	nobjs int
+}
+
+// refCounts returns the reference counts for each base symbol (as in
+// the Def/ref breakdown).
+func (s *state) refCounts() map[string]refCounts {
+	res := make(map[string]refCounts)
+	objs := make(map[string]map[int]bool)
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			if ri.def && len(ri.relocs) == 0 {
+				continue
+			}
+			x, _ := s.symKey(sname, ri.objidx)
+			rc := res[x]
+			rc.nrefs += len(ri.relocs)
+			if objs[x] == nil {
+				objs[x] = make(map[int]bool)
+			}
+			if !objs[x][ri.objidx] {
+				objs[x][ri.objidx] = true
+				rc.nobjs++
+			}
+			res[x] = rc
+		}
+	}
+	return res
+}
+
+// formRefCounts returns the reference counts for each base symbol X
+// (as in the Def/ref breakdown) by form: those for X itself, then
+// those for its import symbol __imp_X.
+func (s *state) formRefCounts() map[string][2]refCounts {
+	type form struct {
+		x   string
+		imp bool
+	}
+	res := make(map[string][2]refCounts)
+	objs := make(map[form]map[int]bool)
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			if ri.def && len(ri.relocs) == 0 {
+				continue
+			}
+			x, imp := s.symKey(sname, ri.objidx)
+			i := 0
+			if imp {
+				i = 1
+			}
+			rcs := res[x]
+			rcs[i].nrefs += len(ri.relocs)
+			f := form{x, imp}
+			if objs[f] == nil {
+				objs[f] = make(map[int]bool)
+			}
+			if !objs[f][ri.objidx] {
+				objs[f][ri.objidx] = true
+				rcs[i].nobjs++
+			}
+			res[x] = rcs
+		}
+	}
+	return res
+}
+
+// sortSyms sorts syms as called for by -sort. base returns the base
+// symbol (as in the Def/ref breakdown) for an element of syms.
+func (s *state) sortSyms(syms []string, base func(string) string) {
+	sort.Strings(syms)
+	var less func(x, y string) bool
+	switch *sortflag {
+	case sortNrefs, sortNobjs:
+		counts := s.refCounts()
+		count := func(x string) int {
+			if *sortflag == sortNrefs {
+				return counts[x].nrefs
+			}
+			return counts[x].nobjs
+		}
+		less = func(x, y string) bool { return count(x) > count(y) }
+	case sortCategory:
+		less = func(x, y string) bool { return s.defref[x] < s.defref[y] }
+	default:
+		return
+	}
+	sort.SliceStable(syms, func(i, j int) bool {
+		return less(base(syms[i]), base(syms[j]))
+	})
+}
+
+// breakdownSyms returns the symbols of the Def/ref breakdown, in
+// order.
+func (s *state) breakdownSyms() []string {
+	dr := make([]string, 0, len(s.defref))
+	for k := range s.defref {
+		dr = append(dr, k)
+	}
+	s.sortSyms(dr, func(x string) string { return x })
+	return dr
+}
+
+// refGroups returns the symbols of the Refs section, in order, with
+// import symbols omitted: each symbol X is listed there along with its
+// import symbol __imp_X (either of which may have no refs).
+func (s *state) refGroups() []string {
+	seen := make(map[string]bool)
+	var groups []string
+	for k := range s.refs {
+		x, _ := cutImp(k)
+		if !seen[x] {
+			seen[x] = true
+			groups = append(groups, x)
+		}
+	}
+	s.sortSyms(groups, func(x string) string {
+		sname := x
+		for _, in := range impNames(x) {
+			if len(s.refs[sname]) == 0 {
+				sname = in
+			}
+		}
+		if len(s.refs[sname]) == 0 {
+			return x
+		}
+		b, _ := s.symKey(sname, s.refs[sname][0].objidx)
+		return b
+	})
+	return groups
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Support for symbols with the special (negative) section numbers:
+// absolute symbols (section -1, IMAGE_SYM_ABSOLUTE), such as
+// @feat.00 and @comp.id, and debug symbols (section -2,
+// IMAGE_SYM_DEBUG), such as .file. These aren't definitions the linker
+// can resolve a reference with, so they are kept out of the Defs
+// listing and the Def/ref breakdown, and listed (when of interest, as
+// with -all) under "Special symbols" instead.
+
+const (
+	// section number of an absolute symbol
+	secAbsolute = -1
+	// section number of a debug symbol
+	secDebug = -2
+)
+
+// special is a symbol with a special section number.
+type special struct {
+	sym    string
+	objidx int
+	secidx int
+	value  int
+}
+
+// specialKind describes special section number secidx.
+func specialKind(secidx int) string {
+	switch secidx {
+	case secAbsolute:
+		return "absolute"
+	case secDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("section %d", secidx)
+}
+
+// addSpecial records symbol sname, with special section number secidx,
+// from the symbol table of the current object.
+func (s *state) addSpecial(sname string, secidx, value int) {
+	s.specials = append(s.specials, special{sname, s.objidx, secidx, value})
+}
+
+// writeSpecials writes the list of special symbols of interest in s,
+// if any, to w.
+func (s *state) writeSpecials(w io.Writer) {
+	if len(s.specials) == 0 {
+		return
+	}
+	sps := append([]special(nil), s.specials...)
+	sort.SliceStable(sps, func(i, j int) bool {
+		if sps[i].sym != sps[j].sym {
+			return sps[i].sym < sps[j].sym
+		}
+		return sps[i].objidx < sps[j].objidx
+	})
+	fmt.Fprintf(w, "Special symbols:\n")
+	for _, sp := range sps {
+		fmt.Fprintf(w, " %q: O%d %s val=0x%x %s\n", sp.sym, sp.objidx,
+			specialKind(sp.secidx), sp.value, s.labels[sp.objidx])
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Support for writing the analysis results to an SQLite database
+// (-sqlite), for querying with SQL. The tables are
+//
+//	objects(run, id, path, provenance)
+//	sections(run, obj, idx, name, size)
+//	defs(run, symbol, obj, sec, value)
+//	refs(run, symbol, obj, sec, section, offset, reloctype)
+//	breakdown(run, symbol, mask, categories)
+//
+// with one row in refs per relocation and one in breakdown per line of
+// the Def/ref breakdown. The schema is created if the database doesn't
+// have it already. Each row carries the -run-label of the run that
+// wrote it, so that the results of several runs can be kept in one
+// database; a run replaces any rows with its label, in a single
+// transaction. The database is written with a pure Go driver, so no
+// sqlite3 program or C compiler is needed.
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS objects(run TEXT, id INTEGER, path TEXT, provenance TEXT);
+CREATE TABLE IF NOT EXISTS sections(run TEXT, obj INTEGER, idx INTEGER, name TEXT, size INTEGER);
+CREATE TABLE IF NOT EXISTS defs(run TEXT, symbol TEXT, obj INTEGER, sec INTEGER, value INTEGER);
+CREATE TABLE IF NOT EXISTS refs(run TEXT, symbol TEXT, obj INTEGER, sec INTEGER, section TEXT, offset INTEGER, reloctype TEXT);
+CREATE TABLE IF NOT EXISTS breakdown(run TEXT, symbol TEXT, mask INTEGER, categories TEXT);
+`
+
+var sqliteTables = []string{"objects", "sections", "defs", "refs", "breakdown"}
+
+// sqliteRows returns the rows to record for s in each table, under the
+// specified run label.
+func (s *state) sqliteRows(run string) map[string][][]any {
+	rows := make(map[string][][]any)
+	for i := range s.objs {
+		rows["objects"] = append(rows["objects"], []any{run, i, s.objs[i], s.objProv(i).String()})
+	}
+	for _, sn := range s.sects {
+		rows["sections"] = append(rows["sections"], []any{run, sn.objidx, sn.idx, sn.name, sn.size})
+	}
+	syms := make([]string, 0, len(s.defs))
+	for k := range s.defs {
+		syms = append(syms, k)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		for _, di := range s.defs[sym] {
+			rows["defs"] = append(rows["defs"], []any{run, sym, di.objidx, di.secidx, di.value})
+		}
+	}
+	syms = syms[:0]
+	for k := range s.refs {
+		syms = append(syms, k)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		for _, ri := range s.refs[sym] {
+			for _, rel := range ri.relocs {
+				rows["refs"] = append(rows["refs"], []any{run, sym, ri.objidx, ri.secidx, rel.sect, rel.off, rel.typ})
+			}
+		}
+	}
+	for _, x := range s.breakdownSyms() {
+		drm := s.defref[x]
+		rows["breakdown"] = append(rows["breakdown"], []any{run, x, int(drm), strings.TrimSpace(drm.String())})
+	}
+	return rows
+}
+
+// writeSQLite records the results for s in the specified database,
+// under the specified run label.
+func (s *state) writeSQLite(path, run string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(runCtx, sqliteSchema); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(runCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows := s.sqliteRows(run)
+	for _, t := range sqliteTables {
+		if _, err := tx.ExecContext(runCtx, "DELETE FROM "+t+" WHERE run = ?", run); err != nil {
+			return err
+		}
+		if len(rows[t]) == 0 {
+			continue
+		}
+		ph := strings.TrimSuffix(strings.Repeat("?, ", len(rows[t][0])), ", ")
+		stmt, err := tx.PrepareContext(runCtx, "INSERT INTO "+t+" VALUES("+ph+")")
+		if err != nil {
+			return err
+		}
+		for _, row := range rows[t] {
+			if _, err := stmt.ExecContext(runCtx, row...); err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		stmt.Close()
+	}
+	return tx.Commit()
+}
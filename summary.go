@@ -0,0 +1,86 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// The Summary block at the top of the report gives the totals, for a
+// quick comparison between runs:
+//
+//	Summary:
+//	 objects: 2 (2 analyzed)
+//	 symbols: 4
+//	 defbase: 0 refbase: 1 defimp: 0 refimp: 4 sameobj: 0
+//	 refimp only: 3
+//	 references: 12
+//
+// An "unused import defs" line, giving the number of import symbols
+// defined but not referred to, follows if there are any.
+
+// summary computes the totals for the Summary block.
+func (s *state) summary() ReportSummary {
+	sum := ReportSummary{
+		Objects:  len(s.objs),
+		Analyzed: len(s.objs) - len(s.skipped),
+		Symbols:  len(s.defref),
+		DLLs:     s.dllCounts(),
+	}
+	for _, drm := range s.defref {
+		if drm&defbase != 0 {
+			sum.Defbase++
+		}
+		if drm&refbase != 0 {
+			sum.Refbase++
+		}
+		if drm&defimp != 0 {
+			sum.Defimp++
+		}
+		if drm&refimp != 0 {
+			sum.Refimp++
+		}
+		if drm&dsameobj != 0 {
+			sum.Sameobj++
+		}
+		if drm == refimp {
+			sum.RefimpOnly++
+		}
+	}
+	for _, rl := range s.refs {
+		for _, ri := range rl {
+			sum.Refs += len(ri.relocs)
+		}
+	}
+	prev := ""
+	for _, ud := range s.unusedImpDefs() {
+		if ud.sym != prev {
+			sum.UnusedImpDefs++
+		}
+		prev = ud.sym
+	}
+	return sum
+}
+
+// writeSummary writes the Summary block to w.
+func (s *state) writeSummary(w io.Writer) {
+	sum := s.summary()
+	fmt.Fprintf(w, "Summary:\n")
+	fmt.Fprintf(w, " objects: %d (%d analyzed)\n", sum.Objects, sum.Analyzed)
+	fmt.Fprintf(w, " symbols: %d\n", sum.Symbols)
+	fmt.Fprintf(w, " defbase: %d refbase: %d defimp: %d refimp: %d sameobj: %d\n",
+		sum.Defbase, sum.Refbase, sum.Defimp, sum.Refimp, sum.Sameobj)
+	fmt.Fprintf(w, " refimp only: %d\n", sum.RefimpOnly)
+	fmt.Fprintf(w, " references: %d\n", sum.Refs)
+	if sum.UnusedImpDefs != 0 {
+		fmt.Fprintf(w, " unused import defs: %d\n", sum.UnusedImpDefs)
+	}
+	if *bydllflag {
+		for _, dc := range sum.DLLs {
+			fmt.Fprintf(w, " dll %s: %d\n", dc.DLL, dc.Symbols)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parsing of the symbol and section tables printed by objdump. The
+// column layout of these drifts between versions (LLVM 14 and 18
+// differ in the spacing of the fields, and in how "(fl 0x00)" is
+// shown), so lines are split into fields rather than matched against
+// a fixed layout; symre is the fallback for lines the splitter can't
+// make sense of.
+
+// symline is a parsed symbol table line.
+type symline struct {
+	secidx int
+	value  int
+	name   string
+}
+
+// (sec  1), (fl 0x00), (scl   3) ...
+var symfieldre = regexp.MustCompile(`\(\s*([a-z]+)\s*([^()]*?)\s*\)`)
+
+// parseSymLine parses a symbol table line such as
+//
+//	[ 0](sec  1)(fl 0x00)(ty   0)(scl   3) (nx 1) 0x00000000 .text
+//
+// Only the section index, value and name are needed.
+func parseSymLine(line string) (symline, error) {
+	sl, ok := splitSymLine(line)
+	if ok {
+		return sl, nil
+	}
+	m := symre.FindStringSubmatch(line)
+	if len(m) == 0 {
+		return sl, fmt.Errorf("bad line %s in symtab", line)
+	}
+	sl = symline{name: m[3]}
+	if n, err := fmt.Sscanf(m[1], "%d", &sl.secidx); n != 1 || err != nil {
+		return sl, fmt.Errorf("can't parse sec idx in line %s in symtab", line)
+	}
+	if n, err := fmt.Sscanf(m[2], "0x%x", &sl.value); n != 1 || err != nil {
+		return sl, fmt.Errorf("can't parse value in line %s in symtab", line)
+	}
+	return sl, nil
+}
+
+// splitSymLine does the work of parseSymLine for lines made up of an
+// index in brackets, parenthesized "(key value)" fields in any order
+// and spacing, then the value and the name.
+func splitSymLine(line string) (symline, bool) {
+	var sl symline
+	if !strings.HasPrefix(line, "[") {
+		return sl, false
+	}
+	i := strings.IndexByte(line, ']')
+	if i == -1 {
+		return sl, false
+	}
+	rest := line[i+1:]
+	haveSec := false
+	for {
+		m := symfieldre.FindStringSubmatchIndex(rest)
+		if m == nil || strings.TrimSpace(rest[:m[0]]) != "" {
+			break
+		}
+		if rest[m[2]:m[3]] == "sec" {
+			var err error
+			if sl.secidx, err = strconv.Atoi(rest[m[4]:m[5]]); err != nil {
+				return sl, false
+			}
+			haveSec = true
+		}
+		rest = rest[m[1]:]
+	}
+	if !haveSec {
+		return sl, false
+	}
+	sval, name, ok := strings.Cut(strings.TrimLeft(rest, " \t"), " ")
+	if !ok {
+		return sl, false
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(sval, "0x"), 16, 64)
+	if err != nil {
+		return sl, false
+	}
+	sl.value = int(v)
+	sl.name = strings.TrimSpace(name)
+	return sl, sl.name != ""
+}
+
+// parseSecLine parses a line from a section table, such as
+//
+//	0 .text          0000002d 0000000000000000 TEXT
+//
+// returning the section index, name and size. ok is false for lines
+// that aren't section headers (GNU objdump's flags lines, say).
+func parseSecLine(line string) (sindex int, sname string, ssiz int, ok bool) {
+	f := strings.Fields(line)
+	if len(f) < 3 {
+		return 0, "", 0, false
+	}
+	var err error
+	if sindex, err = strconv.Atoi(f[0]); err != nil {
+		return 0, "", 0, false
+	}
+	siz, err := strconv.ParseUint(f[2], 16, 64)
+	if err != nil {
+		return 0, "", 0, false
+	}
+	return sindex, f[1], int(siz), true
+}
@@ -0,0 +1,260 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Support for custom output (-format): a Go text/template, given
+// inline or in a file, is executed against a Report in place of the
+// usual report. The template is parsed before any objects are read, so
+// that a mistake in it is reported at once.
+
+// Report is the data a -format template is executed against. Objects
+// and Sections are in O-number order, and Defs are sorted by symbol.
+// Refs (with each symbol followed by its import symbol) and Breakdown
+// are in the order given by -sort.
+type Report struct {
+	// Dumper is the dumper program and its version, if one was used.
+	Dumper string
+	// Machine is the machine type of the inputs, if they agree.
+	Machine   string
+	Summary   ReportSummary
+	Objects   []ReportObject
+	Sections  []ReportSection
+	Defs      []ReportDef
+	Refs      []ReportRefs
+	Breakdown []ReportSym
+	// Totals is the per-object totals, as in the Object totals
+	// section.
+	Totals []ReportObjTotals
+}
+
+// ReportSummary holds the totals shown in the Summary block.
+type ReportSummary struct {
+	// Objects is the number of objects, Analyzed the number that
+	// weren't skipped.
+	Objects  int
+	Analyzed int
+	// Symbols is the number of symbols in the Def/ref breakdown,
+	// and the rest the number in each category (RefimpOnly for
+	// those that are only refimp).
+	Symbols    int
+	Defbase    int
+	Refbase    int
+	Defimp     int
+	Refimp     int
+	Sameobj    int
+	RefimpOnly int
+	// Refs is the number of references (relocations) recorded.
+	Refs int
+	// UnusedImpDefs is the number of import symbols defined but not
+	// referred to.
+	UnusedImpDefs int
+	// DLLs is the number of breakdown symbols for each DLL, with
+	// "unknown" for those not mapped to one.
+	DLLs []ReportDLL
+}
+
+// ReportObjTotals is the totals for object Obj: the number of import
+// symbols it refers to and defines, the number of relocations recorded
+// and the total size of its sections.
+type ReportObjTotals struct {
+	Obj     int
+	ImpRefs int
+	ImpDefs int
+	Relocs  int
+	Size    int
+}
+
+// ReportDLL is the number of breakdown symbols mapped to a DLL.
+type ReportDLL struct {
+	DLL     string
+	Symbols int
+}
+
+// ReportObject is an input object.
+type ReportObject struct {
+	Index int
+	// Name is the object as given (or archive(member)), Label the
+	// name shown in the report.
+	Name  string
+	Label string
+	// Tags are the tags shown in the Objects listing, such as
+	// "[Go object]", separated by spaces.
+	Tags       string
+	Provenance string
+	Machine    string
+	// Skipped is why the object wasn't analyzed, if it wasn't.
+	Skipped string
+}
+
+// ReportSection is a section of an object.
+type ReportSection struct {
+	Obj   int
+	Index int
+	Name  string
+	Size  int
+}
+
+// ReportDef is the definition of a symbol.
+type ReportDef struct {
+	Sym string
+	Obj int
+	// Sec is the section number (as in the symbol table), SecName
+	// the section's name.
+	Sec     int
+	SecName string
+	Value   int
+	// Comdat is the COMDAT selection of the section (ANY, say), or
+	// "not comdat", or "unknown" if the section's aux record wasn't
+	// seen.
+	Comdat string
+}
+
+// ReportRefs lists the references to a symbol.
+type ReportRefs struct {
+	Sym  string
+	Refs []ReportRef
+}
+
+// ReportRef is the references to a symbol from one object (or, if Def
+// is set, the object defining it).
+type ReportRef struct {
+	Obj int
+	// Sec is the section number defining the symbol (0 for a
+	// reference), SecName the name of that section or, for a
+	// reference, of the sections the references come from.
+	Sec     int
+	SecName string
+	Offsets []int
+	// the sections the relocations at Offsets apply to
+	Sects []string
+	// the short relocation types (REL32, ADDR64, ...) for Offsets,
+	// with "" where the type isn't known
+	Types []string
+	// the kinds of reference (call, icall, addr or other) for
+	// Offsets, as with -ref-kinds
+	Kinds []string
+	Def   bool
+}
+
+// ReportSym is a line of the Def/ref breakdown, for base symbol Sym.
+// Use the "has" function to test Mask for a category.
+type ReportSym struct {
+	Sym  string
+	Mask defrefmask
+	// DLL is the DLL the import symbol resolves to, if known (or
+	// "not in import libs").
+	DLL string
+}
+
+// Categories returns the categories of rs, as in the report
+// ("defbase refimp", say).
+func (rs ReportSym) Categories() string {
+	return strings.TrimSpace(rs.Mask.String())
+}
+
+// reportTemplate is the parsed -format template, if any.
+var reportTemplate *template.Template
+
+var templateFuncs = template.FuncMap{
+	// hex formats an int, or a list of them, as in the report.
+	"hex": func(v interface{}) (string, error) {
+		switch v := v.(type) {
+		case int:
+			return fmt.Sprintf("0x%x", v), nil
+		case []int:
+			hs := make([]string, len(v))
+			for i, x := range v {
+				hs[i] = fmt.Sprintf("0x%x", x)
+			}
+			return strings.Join(hs, " "), nil
+		}
+		return "", fmt.Errorf("can't format %T", v)
+	},
+	// has reports whether a breakdown mask includes the named
+	// category (defbase, refbase, defimp, refimp, sameobj or splitobj).
+	"has": func(m defrefmask, cat string) (bool, error) {
+		bit, ok := maskNames[cat]
+		if !ok {
+			return false, fmt.Errorf("unknown category %q", cat)
+		}
+		return m&bit != 0, nil
+	},
+}
+
+// parseFormat parses a -format value: the template itself if it
+// contains "{{", and otherwise the name of a file holding it.
+func parseFormat(spec string) (*template.Template, error) {
+	name, text := "-format", spec
+	if !strings.Contains(spec, "{{") {
+		b, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		name, text = spec, string(b)
+	}
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// report returns the Report for s.
+func (s *state) report() *Report {
+	rep := &Report{Machine: s.machine(), Summary: s.summary()}
+	if dumper != "" {
+		rep.Dumper = fmt.Sprintf("%s (%s)", dumper, dumperVersion)
+	}
+	mixed := s.mixedMachines() != nil
+	for i := range s.objs {
+		rep.Objects = append(rep.Objects, ReportObject{
+			Index:      i,
+			Name:       s.objs[i],
+			Label:      s.labels[i],
+			Tags:       strings.TrimSpace(s.objTags(i, mixed)),
+			Provenance: s.objProv(i).String(),
+			Machine:    s.machines[i],
+			Skipped:    s.skipped[i],
+		})
+	}
+	for _, sn := range s.sects {
+		rep.Sections = append(rep.Sections, ReportSection{Obj: sn.objidx, Index: sn.idx, Name: sn.name, Size: sn.size})
+	}
+	defs := make([]string, 0, len(s.defs))
+	for k := range s.defs {
+		defs = append(defs, k)
+	}
+	sort.Strings(defs)
+	for _, sym := range defs {
+		for _, di := range s.defs[sym] {
+			rep.Defs = append(rep.Defs, ReportDef{Sym: sym, Obj: di.objidx, Sec: di.secidx,
+				SecName: s.secName(di.objidx, di.secidx), Value: di.value, Comdat: comdatString(s.defComdat(di))})
+		}
+	}
+	for _, x := range s.refGroups() {
+		for _, sym := range append([]string{x}, impNames(x)...) {
+			rl, ok := s.refs[sym]
+			if !ok {
+				continue
+			}
+			rr := ReportRefs{Sym: sym}
+			for _, ri := range rl {
+				rr.Refs = append(rr.Refs, ReportRef{Obj: ri.objidx, Sec: ri.secidx,
+					SecName: s.refSecName(&ri), Offsets: ri.offsets(), Sects: ri.sects(),
+					Types: ri.types(), Kinds: ri.kinds(), Def: ri.def})
+			}
+			rep.Refs = append(rep.Refs, rr)
+		}
+	}
+	for _, x := range s.breakdownSyms() {
+		rep.Breakdown = append(rep.Breakdown, ReportSym{Sym: x, Mask: s.defref[x], DLL: strings.Trim(s.dllTag(x), " []")})
+	}
+	rep.Totals = s.objectTotals()
+	return rep
+}
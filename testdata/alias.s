@@ -0,0 +1,16 @@
+	.text
+	.globl	user
+user:
+	callq	*__imp_oldname(%rip)
+	callq	*__imp_viaimplib(%rip)
+	callq	*__imp_nowhere(%rip)
+	retq
+
+	.section	.data,"dw"
+	.globl	__imp_newname
+__imp_newname:
+	.quad	0
+
+	.section	.drectve,"yn"
+	.ascii	" /ALTERNATENAME:__imp_oldname=__imp_midname /ALTERNATENAME:__imp_midname=__imp_newname"
+	.ascii	" /ALTERNATENAME:__imp_viaimplib=__imp__errno /ALTERNATENAME:__imp_nowhere=__imp_gone"
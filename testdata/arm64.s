@@ -0,0 +1,18 @@
+	.text
+	.globl	callfoo
+	.p2align	2
+callfoo:
+	adrp	x16, __imp_foo
+	ldr	x16, [x16, :lo12:__imp_foo]
+	br	x16
+
+	.globl	callbar
+	.p2align	2
+callbar:
+	stp	x29, x30, [sp, #-16]!
+	bl	bar
+	adrp	x8, __imp_bar
+	ldr	x8, [x8, :lo12:__imp_bar]
+	blr	x8
+	ldp	x29, x30, [sp], #16
+	ret
@@ -0,0 +1,19 @@
+	.section	.text,"xr",discard,inlfn
+	.globl	inlfn
+inlfn:
+	jmpq	*__imp_foo(%rip)
+
+	.section	.xdata,"dr",associative,inlfn
+	.long	1
+
+	.section	.rdata,"dr",same_contents,strconst
+	.globl	strconst
+strconst:
+	.asciz	"hello"
+
+	.text
+	.globl	caller
+caller:
+	callq	inlfn
+	callq	*__imp_bar(%rip)
+	retq
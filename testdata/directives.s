@@ -0,0 +1,9 @@
+	.text
+	.globl	foo
+foo:
+	callq	*__imp_bar(%rip)
+	callq	*__imp__errno(%rip)
+	retq
+
+	.section	.drectve,"yn"
+	.ascii	" /DEFAULTLIB:\"ucrt\" /DEFAULTLIB:oldnames.lib /EXPORT:foo -export:\"spaced name\",data /ALTERNATENAME:baz=qux"
@@ -0,0 +1,25 @@
+	.file	"elf.c"
+	.text
+	.globl	callfoo
+	.type	callfoo, @function
+callfoo:
+	jmp	foo@PLT
+
+	.globl	callbar
+	.type	callbar, @function
+callbar:
+	pushq	%rax
+	callq	bar@PLT
+	movq	bar@GOTPCREL(%rip), %rax
+	callq	*%rax
+	popq	%rax
+	retq
+
+	.type	helper, @function
+helper:
+	retq
+
+	.data
+	.globl	barptr
+barptr:
+	.quad	bar
@@ -0,0 +1,8 @@
+	.text
+	.globl	impl
+impl:
+	callq	*__imp_foo(%rip)
+	retq
+
+	.section	.drectve,"yn"
+	.ascii	" /EXPORT:alias=impl /EXPORT:fwd=kernel32.Sleep /EXPORT:missing"
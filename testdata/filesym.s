@@ -0,0 +1,6 @@
+	.file	"some/very/long/directory/name/for/testing/foo_source_file.c"
+	.text
+	.globl f
+f:
+	callq *__imp_bar(%rip)
+	retq
@@ -0,0 +1,16 @@
+	.text
+	.globl	_callfoo
+_callfoo:
+	pushl	$0
+	calll	*"__imp__CreateFileA@4"
+	pushl	$0
+	calll	"_CreateFileA@4"
+	calll	*__imp__printf
+	movl	$1, %ecx
+	calll	"@fastfn@4"
+	calll	*"__imp_@fastimp@4"
+	retl
+
+	.globl	"_localstd@8"
+"_localstd@8":
+	retl	$8
@@ -0,0 +1,22 @@
+	.text
+	.globl	__imp_incode
+__imp_incode:
+	retq
+
+	.bss
+	.globl	__imp_inbss
+__imp_inbss:
+	.zero	8
+
+	.section	.idata$5,"dr"
+	.globl	__imp_good
+__imp_good:
+	.quad	0
+
+	.data
+	.globl	__imp_data
+__imp_data:
+	.quad	0
+	.globl	__imp_short
+__imp_short:
+	.long	0
@@ -0,0 +1,14 @@
+	.text
+	.globl	inlfn
+inlfn:
+	retq
+
+	.section	.rdata,"dr",same_contents,strconst
+	.globl	strconst
+strconst:
+	.asciz	"hello"
+
+	.section	.data,"dw",discard,__imp_foo
+	.globl	__imp_foo
+__imp_foo:
+	.quad	0
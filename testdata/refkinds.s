@@ -0,0 +1,14 @@
+	.text
+	.globl	f
+f:
+	callq	*__imp_foo(%rip)
+	callq	foo
+	movabsq	$__imp_bar, %rax
+	leaq	__imp_baz(%rip), %rcx
+	retq
+
+	.data
+	.globl	fptr
+fptr:
+	.quad	foo
+	.quad	__imp_bar
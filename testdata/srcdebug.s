@@ -0,0 +1,18 @@
+	.text
+	.globl	callfoo
+callfoo:
+	subq	$40, %rsp
+	movq	__imp_foo(%rip), %rax
+	callq	*%rax
+	addq	$40, %rsp
+	retq
+
+	.globl	callbar
+callbar:
+	subq	$40, %rsp
+	movl	$1, %ecx
+	callq	*__imp_bar(%rip)
+	movl	%eax, %ecx
+	callq	bar
+	addq	$40, %rsp
+	retq
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Support for the Object totals section of the report
+// (-object-totals), which shows for each object how much import
+// traffic it is responsible for:
+//
+//	Object totals:
+//	 O3: imprefs=12 impdefs=0 relocs=37 size=0x1b3c1 foo.o
+//
+// imprefs is the number of import symbols the object refers to,
+// impdefs the number it defines, relocs the number of relocations
+// recorded against the symbols of interest, and size the total size of
+// its sections (those recorded, as in the Sections listing). Objects
+// are sorted by imprefs, most first (then by relocs). The same numbers are in the
+// object_totals array of the -ndjson summary record.
+
+// objectTotals returns the totals for each object, in order.
+func (s *state) objectTotals() []ReportObjTotals {
+	tots := make([]ReportObjTotals, len(s.objs))
+	for i := range tots {
+		tots[i].Obj = i
+	}
+	for sname, rl := range s.refs {
+		imp := isImpSym(sname)
+		for _, ri := range rl {
+			t := &tots[ri.objidx]
+			t.Relocs += len(ri.relocs)
+			if imp && !ri.def && len(ri.relocs) != 0 {
+				t.ImpRefs++
+			}
+		}
+	}
+	for sname, dl := range s.defs {
+		if isImpSym(sname) {
+			for _, di := range dl {
+				tots[di.objidx].ImpDefs++
+			}
+		}
+	}
+	for _, si := range s.sects {
+		tots[si.objidx].Size += si.size
+	}
+	sort.SliceStable(tots, func(i, j int) bool {
+		if tots[i].ImpRefs != tots[j].ImpRefs {
+			return tots[i].ImpRefs > tots[j].ImpRefs
+		}
+		return tots[i].Relocs > tots[j].Relocs
+	})
+	return tots
+}
+
+// writeObjectTotals writes the Object totals section to w.
+func (s *state) writeObjectTotals(w io.Writer) {
+	fmt.Fprintf(w, "Object totals:\n")
+	tw := textfmt.newTable(w)
+	for _, t := range s.objectTotals() {
+		fmt.Fprintf(tw, " O%d:\timprefs=%d\timpdefs=%d\trelocs=%d\tsize=0x%x\t%s\n",
+			t.Obj, t.ImpRefs, t.ImpDefs, t.Relocs, t.Size, s.labels[t.Obj])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for reporting unresolved symbols: import symbols referred
+// to but defined by no object (refimp without defimp), which the
+// linker has to find in an import library, and likewise base symbols
+// (refbase without defbase). Each is listed with the objects referring
+// to it. With -watch, only watched symbols are listed. The lists are
+// shown with the v2 layout or -check-resolved; the latter also makes
+// the run exit with status unresolvedExit if any import is unresolved.
+
+// unresolvedExit is the exit status used with -check-resolved when
+// some import symbol is unresolved.
+const unresolvedExit = 4
+
+// unresolvedSym is an unresolved symbol and the objects referring to
+// it, in order.
+type unresolvedSym struct {
+	sym  string
+	objs []int
+}
+
+// unresolved returns the unresolved import symbols and the unresolved
+// base symbols of s, each sorted by symbol.
+func (s *state) unresolved() (imps, bases []unresolvedSym) {
+	for sname, rl := range s.refs {
+		if len(rl) == 0 || (watching() && !watchedName(sname)) {
+			continue
+		}
+		x, imp := s.symKey(sname, rl[0].objidx)
+		ref, def := refbase, defbase
+		if imp {
+			ref, def = refimp, defimp
+		}
+		if drm := s.defref[x]; drm&ref == 0 || drm&def != 0 {
+			continue
+		}
+		seen := make(map[int]bool)
+		us := unresolvedSym{sym: sname}
+		for _, ri := range rl {
+			if !ri.def && len(ri.relocs) != 0 && !seen[ri.objidx] {
+				seen[ri.objidx] = true
+				us.objs = append(us.objs, ri.objidx)
+			}
+		}
+		sort.Ints(us.objs)
+		if len(us.objs) == 0 {
+			continue
+		}
+		if imp {
+			imps = append(imps, us)
+		} else {
+			bases = append(bases, us)
+		}
+	}
+	bysym := func(l []unresolvedSym) {
+		sort.Slice(l, func(i, j int) bool { return l[i].sym < l[j].sym })
+	}
+	bysym(imps)
+	bysym(bases)
+	return imps, bases
+}
+
+// showUnresolved reports whether the report includes the lists of
+// unresolved symbols.
+func showUnresolved() bool {
+	return *checkresolvedflag || textfmt.version() >= 2
+}
+
+// writeUnresolved writes the lists of unresolved symbols of s, if
+// any, to w.
+func (s *state) writeUnresolved(w io.Writer) {
+	imps, bases := s.unresolved()
+	for _, l := range []struct {
+		title string
+		syms  []unresolvedSym
+	}{
+		{"Unresolved imports", imps},
+		{"Unresolved base symbols", bases},
+	} {
+		if len(l.syms) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", l.title)
+		for _, us := range l.syms {
+			objs := make([]string, len(us.objs))
+			for i, o := range us.objs {
+				objs[i] = fmt.Sprintf("O%d %s", o, s.labels[o])
+			}
+			fmt.Fprintf(w, " %q: %s\n", us.sym, strings.Join(objs, ", "))
+		}
+	}
+}
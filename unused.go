@@ -0,0 +1,79 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Support for reporting unused import definitions: import symbols
+// __imp_X that some object defines but nothing refers to, either
+// through __imp_X or through X. These are usually import library
+// members or generated stubs pulled in for no reason. The defining
+// object's own symbol table entry for a symbol is kept in s.refs as a
+// refinfo with def set (so that relocations in that object can be
+// recorded against it); it doesn't count as a reference here.
+
+// unusedImpDef is a definition of an unused import symbol.
+type unusedImpDef struct {
+	sym string
+	def definfo
+}
+
+// referenced reports whether some object other than a defining one
+// refers to sname, or sname is an alternate name that resolved a
+// reference.
+func (s *state) referenced(sname string) bool {
+	if s.aliasUsed[sname] {
+		return true
+	}
+	for _, ri := range s.refs[sname] {
+		if !ri.def {
+			return true
+		}
+	}
+	return false
+}
+
+// unusedImpDefs returns the definitions of unused import symbols in
+// s, sorted by symbol and object.
+func (s *state) unusedImpDefs() []unusedImpDef {
+	var res []unusedImpDef
+	for sname, dl := range s.defs {
+		base, ok := cutImp(sname)
+		if !ok {
+			continue
+		}
+		if s.referenced(sname) || s.referenced(base) {
+			continue
+		}
+		for _, di := range dl {
+			res = append(res, unusedImpDef{sname, di})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].sym != res[j].sym {
+			return res[i].sym < res[j].sym
+		}
+		return res[i].def.objidx < res[j].def.objidx
+	})
+	return res
+}
+
+// writeUnusedImpDefs writes the list of unused import definitions of
+// s, if any, to w.
+func (s *state) writeUnusedImpDefs(w io.Writer) {
+	uds := s.unusedImpDefs()
+	if len(uds) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Unused import definitions:\n")
+	for _, ud := range uds {
+		fmt.Fprintf(w, " %q: O%d sec=%s val=0x%x %s\n", ud.sym, ud.def.objidx,
+			s.secField(ud.def.objidx, ud.def.secidx), ud.def.value, s.labels[ud.def.objidx])
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Support for catching mistakes in the watch list. Once all the
+// objects are read, each symbol given with -watch or -watchfile that
+// no object defines or refers to (neither X nor __imp_X) is reported
+// on stderr, along with up to three of the symbols seen in the objects'
+// symbol tables that are close to it, by edit distance or as an
+// extension of it. Likewise a -watchre pattern matching nothing. And
+// after the excerpts, a note is added for each watched symbol that
+// none were shown for.
+
+// maxSuggestions is the most near misses suggested for a watched
+// symbol.
+const maxSuggestions = 3
+
+// noteSeen records that sname is in the symbol table of some object,
+// for suggestions.
+func (s *state) noteSeen(sname string) {
+	if !watching() {
+		return
+	}
+	if s.seenSyms == nil {
+		s.seenSyms = make(map[string]bool)
+	}
+	s.seenSyms[sname] = true
+}
+
+// foundSyms returns the set of names under which symbols have been
+// defined or referred to: each symbol, its base symbol and its key in
+// the Def/ref breakdown.
+func (s *state) foundSyms() map[string]bool {
+	res := make(map[string]bool)
+	for sname, rl := range s.refs {
+		res[sname] = true
+		base, _ := cutImp(sname)
+		res[base] = true
+		for _, ri := range rl {
+			x, _ := s.symKey(sname, ri.objidx)
+			res[x] = true
+		}
+	}
+	return res
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// nearMisses returns up to maxSuggestions symbols from universe that
+// are close to sym, closest first: those within a small edit distance
+// of it (a quarter of its length, or at least 1), or that start with
+// it.
+func nearMisses(sym string, universe map[string]bool) []string {
+	limit := len(sym)/4 + 1
+	type cand struct {
+		name string
+		dist int
+	}
+	var cands []cand
+	for c := range universe {
+		if c == sym {
+			continue
+		}
+		prefix := len(sym) >= 3 && strings.HasPrefix(c, sym)
+		if !prefix && (len(c)-len(sym) > limit || len(sym)-len(c) > limit) {
+			continue
+		}
+		d := editDistance(sym, c)
+		if d > limit && !prefix {
+			continue
+		}
+		cands = append(cands, cand{c, d})
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].dist != cands[j].dist {
+			return cands[i].dist < cands[j].dist
+		}
+		return cands[i].name < cands[j].name
+	})
+	var res []string
+	for _, c := range cands {
+		if len(res) == maxSuggestions {
+			break
+		}
+		res = append(res, c.name)
+	}
+	return res
+}
+
+// suggestUniverse returns the symbols to suggest near misses from:
+// those seen in the symbol tables, and those of interest, with their
+// import prefixes stripped too (watching X covers __imp_X).
+func (s *state) suggestUniverse() map[string]bool {
+	res := make(map[string]bool)
+	add := func(sname string) {
+		res[sname] = true
+		if base, ok := cutImp(sname); ok {
+			res[base] = true
+		}
+	}
+	for sname := range s.seenSyms {
+		add(sname)
+	}
+	for sname := range s.all {
+		add(sname)
+	}
+	return res
+}
+
+// checkWatched warns, on stderr, about watched symbols that no object
+// defines or refers to, and -watchre patterns that match nothing.
+func (s *state) checkWatched() {
+	found := s.foundSyms()
+	var missing []string
+	for _, w := range watchedNames {
+		if !found[w] {
+			missing = append(missing, w)
+		}
+	}
+	if len(missing) != 0 {
+		universe := s.suggestUniverse()
+		fmt.Fprintf(os.Stderr, "warning: watched symbols not defined or referred to by any object:\n")
+		for _, w := range missing {
+			if sugg := nearMisses(w, universe); len(sugg) != 0 {
+				fmt.Fprintf(os.Stderr, "\t%s (did you mean %s?)\n", w, strings.Join(sugg, ", "))
+			} else {
+				fmt.Fprintf(os.Stderr, "\t%s\n", w)
+			}
+		}
+	}
+	for _, re := range watchres {
+		matched := false
+		for sname := range found {
+			if re.MatchString(sname) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fmt.Fprintf(os.Stderr, "warning: -watchre pattern %q matches no symbol defined or referred to by any object\n", re)
+		}
+	}
+}
+
+// noteExcerpted records that an excerpt was shown for a reference to
+// symbol sname from object objidx.
+func (s *state) noteExcerpted(sname string, objidx int) {
+	if s.excerpted == nil {
+		s.excerpted = make(map[string]bool)
+	}
+	s.excerpted[sname] = true
+	base, _ := cutImp(sname)
+	s.excerpted[base] = true
+	x, _ := s.symKey(sname, objidx)
+	s.excerpted[x] = true
+}
+
+// writeNoExcerpts writes a note to w for each watched symbol that no
+// excerpts were shown for, saying why.
+func (s *state) writeNoExcerpts(w io.Writer) {
+	found := s.foundSyms()
+	for _, sym := range watchedNames {
+		if s.excerpted[sym] {
+			continue
+		}
+		why := "no reference to it could be disassembled"
+		switch {
+		case !found[sym]:
+			why = "not defined or referred to by any object"
+		case !s.relocated(sym):
+			why = "no relocations against it"
+		}
+		fmt.Fprintf(w, "\nno excerpts for watched symbol %q: %s\n", sym, why)
+	}
+}
+
+// relocated reports whether some relocation is recorded against
+// watched symbol sym, under any of its names.
+func (s *state) relocated(sym string) bool {
+	for sname, rl := range s.refs {
+		for _, ri := range rl {
+			if len(ri.relocs) == 0 {
+				continue
+			}
+			base, _ := cutImp(sname)
+			if x, _ := s.symKey(sname, ri.objidx); sname == sym || base == sym || x == sym {
+				return true
+			}
+		}
+	}
+	return false
+}
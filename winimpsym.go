@@ -6,30 +6,127 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 // Overview: given a set of object files, look for definitions and references
 // to import symbols.
 
-const DefaultDumper = "llvm-objdump-14"
-
-var inputsflag = flag.String("i", "", "Comma-separated list of input files (omit to read from stdin)")
-var objdumpflag = flag.String("objdump", DefaultDumper, "Name of objdump program to invoke")
+var verbflag = flag.Int("v", 0, "Verbose trace output level")
+var inputsflag listFlag
+var isepflag = flag.String("isep", ",", "Separator for the list of input files given with -i")
+var objdumpflag = flag.String("objdump", "", "Name of objdump program to invoke (default $WINIMPSYM_OBJDUMP, or "+DefaultDumper+")")
+var ifileflag = flag.String("ifile", "", "File containing list of input files, one per line")
+var linkrspflag = flag.String("linkrsp", "", "MSVC linker response file whose object and library inputs are to be analyzed (import libraries are used as with -implib)")
+var stdinflag = flag.Bool("stdin", false, "Read a single object to analyze from stdin")
+var fromdumpflag = flag.String("from-dump", "", "Comma-separated list of files containing captured 'llvm-objdump -htr' output, analyzed in place of object files")
+var fromdisasmflag = flag.String("from-disasm", "", "Comma-separated list of files containing captured 'llvm-objdump -ldr' output, parallel to -from-dump, for excerpts")
+var dirflag = flag.String("dir", "", "Directory to search recursively for object files (.o, .obj, .syso)")
+var dirpatflag = flag.String("dirpattern", "", "Only pick up files matching this regexp when searching -dir")
+var sysoflag = flag.String("syso", "", "Directory to search recursively for .syso files for the target GOARCH")
+var gopkgflag = flag.String("gopkg", "", "Build the specified Go package with cgo and analyze the resulting host objects")
+var keepworkflag = flag.Bool("keepwork", false, "Don't remove the go build work directory when using -gopkg")
+var objrangeflag = flag.String("objrange", "", "Analyze only inputs N through M-1 (given as N:M) of the full list, keeping their original O-numbers")
+var dedupcontentflag = flag.Bool("dedup-content", false, "Treat inputs with identical contents as duplicates")
+var objmatchflag = flag.String("objmatch", "", "Only analyze objects whose path (or provenance) matches this regexp")
+var objexcludeflag = flag.String("objexclude", "", "Don't analyze objects whose path (or provenance) matches this regexp (applied after -objmatch)")
+var implibflag = flag.String("implib", "", "Comma-separated list of import libraries used to map import symbols to DLLs")
+var libpathflag = flag.String("libpath", "", "Comma-separated list of directories to look for the import libraries named by /DEFAULTLIB directives in, to map import symbols to DLLs as with -implib")
+var sidecarflag = flag.String("sidecar", ".txt:pn", "Comma-separated list of ext:format sidecar files to consult for object provenance (formats: pn, json)")
+var mapfileflag = flag.String("mapfile", "", "Linker map file (link.exe or lld-link format) to cross-check definitions against")
+var saveflag = flag.String("save", "", "Write analysis results as JSON to this file (for use with -load)")
+var loadflag = flag.String("load", "", "Comma-separated list of files holding results saved with -save, to be merged into this run")
+var baselineflag = flag.String("baseline", "", "Compare the Def/ref breakdown against the baseline in this file, exiting with status 3 if there are differences")
+var outflag = flag.String("o", "", "Write the report to this file rather than stdout (- for stdout)")
+var formatflag = flag.String("format", "", "Go text/template (inline, or the name of a file holding it) to write the report with, in place of the usual one")
+var briefflag = flag.Bool("brief", false, "Report only the Def/ref breakdown (and any excerpts for watched symbols)")
+var noexcerptsflag = flag.Bool("no-excerpts", false, "Don't show excerpts for watched symbols")
+var byobjectflag = flag.Bool("by-object", false, "Organize the report by object: the defs and refs of each object in turn")
+var sortflag = flag.String("sort", sortName, "Order of the Def/ref breakdown and Refs section: name, nrefs (most references first), nobjs (most referring objects first) or category")
+var colorflag = flag.String("color", colorAuto, "Color the Def/ref breakdown and Refs section by category: auto (if stdout is a terminal and NO_COLOR isn't set), always or never")
+var ndjsonflag = flag.Bool("ndjson", false, "Stream results as newline-delimited JSON records (objects as they are read, then the breakdown and a summary) in place of the report")
+var relocstsvflag = flag.String("relocs-tsv", "", "Write every relocation against an interesting symbol to this file as a tab-separated table")
+var sqliteflag = flag.String("sqlite", "", "Write the analysis results to this SQLite database, creating its tables if need be")
+var runlabelflag = flag.String("run-label", "default", "Label for this run's rows in the -sqlite database, replacing any earlier rows with the same label")
+var graphmlflag = flag.String("graphml", "", "Write the objects and symbols, and the defs and refs between them, to this file as a GraphML graph")
+var graphmlwatchedflag = flag.Bool("graphml-watched", false, "Include only watched symbols (and the objects defining or referring to them) in the -graphml graph")
+var indexflag = flag.String("index", "", "Write an index to this file: a line for each symbol giving the objects that define (d) or refer to (r) it")
+var onlyflag = flag.String("only", "", "Narrow all output to symbols whose category matches this expression, e.g. 'refimp && !defimp' (categories as in the Def/ref breakdown, with !, &&, || and parentheses)")
+var refswidthflag = flag.Int("refs-width", 100, "With -format-version=2, wrap the offset lists in the Refs section of the report at this many columns (0 for no wrapping)")
+var formatversionflag = flag.String("format-version", "1", "Layout of the report: 1 (fields separated by single spaces), 2 (aligned columns, wrapped offset lists) or latest")
+var xrefsflag = flag.Bool("xrefs", false, "Add an Xrefs section to the report, listing the functions referring to each import symbol")
+var bydllflag = flag.Bool("by-dll", false, "Group the Def/ref breakdown by the DLL of each symbol, with per-DLL counts in the summary")
+var emitdefflag = flag.String("emit-def", "", "Write the imports the objects refer to, grouped by DLL, to this file as a module-definition (.def) file")
+var emitincludeflag = flag.String("emit-include", "", "Write a linker /INCLUDE directive to this file for each import symbol the objects refer to but don't define")
+var emitincludeformatflag = flag.String("emit-include-format", includeLink, "Format of -emit-include lines: link (/INCLUDE:sym) or pragma (#pragma comment(linker, ...))")
+var sarifflag = flag.String("sarif", "", "Write the findings (unresolved imports and so on) as a SARIF 2.1.0 log to this file")
+var sarifrulesflag = flag.String("sarif-rules", "", "Comma-separated list of rule[=level] to report with -sarif (default all rules, at their default levels)")
+var serveflag = flag.String("serve", "", "Once done, serve the results for viewing in a browser at this address (such as :8080) until interrupted")
+var objtotalsflag = flag.Bool("object-totals", false, "Add an Object totals section to the report, giving the import references and definitions, relocations and section sizes of each object")
+var failondupdefsflag = flag.Bool("fail-on-dupdefs", false, "Exit with status 5 if some base symbol is defined by more than one object in a way the linker will reject (not as COMDATs it can choose between)")
+var checkresolvedflag = flag.Bool("check-resolved", false, "List unresolved symbols, exiting with status 4 if some import symbol is referred to but not defined")
+var htmlflag = flag.String("html", "", "Also write the report as a self-contained HTML page to this file")
+var writebaselineflag = flag.String("write-baseline", "", "Write the Def/ref breakdown to this file, for use with -baseline")
+var stateflag = flag.String("state", "", "State file for incremental analysis: dumper output for unchanged objects is reused from it, and it is rewritten at the end")
+var timeoutflag = flag.Duration("timeout", 0, "Time limit for each run of the dumper (0 for none)")
+var deadlineflag = flag.Duration("deadline", 0, "Time limit for reading all objects (0 for none)")
+var mixedarchflag = flag.Bool("allow-mixed-arch", false, "Analyze inputs for more than one architecture together, rather than refusing to")
+var keeptempflag = flag.String("keep-temp", "", "Directory in which to save the output of each run of the dumper (as <object>.<pass>.txt), for debugging")
+var batchflag = flag.Int("batch", 1, "Number of objects to dump with each run of objdump; only has an effect with -backend=llvm or -backend=gnu")
+var continueflag = flag.Bool("continue-on-error", false, "Report objects that can't be read (e.g. because the dumper timed out) and carry on without them")
+var backendflag = flag.String("backend", backendAuto, "How to read objects: native (built-in COFF reader), llvm (llvm-objdump) or gnu (GNU objdump); auto tries them in that order for each object")
+var flavorflag = flag.String("dumper-flavor", flavorAuto, "Flavor of objdump output: llvm, gnu, dumpbin, readobj (llvm-readobj JSON) or auto; or native to read objects directly with no objdump")
 var allsymsflag = flag.Bool("all", false, "Process all syms, not just import syms")
 var watchsymsflag = flag.String("watch", "", "Comma-separated list of additional symbols to include in analysis")
+var watchfileflag = flag.String("watchfile", "", "File containing additional symbols to watch, one per line")
+var excludesymflag = flag.String("excludesym", "", "Comma-separated list of symbols to leave out of the analysis")
+var excludesymreflag = flag.String("excludesymre", "", "Comma-separated list of regular expressions; symbols matching any of them (unanchored) are left out of the analysis")
+var normalizedecorationsflag = flag.Bool("normalize-decorations", false, "Strip C decorations (_X, X@N, @X@N) when pairing __imp_X with X for all objects, not just i386 ones, and list the raw names in the Def/ref breakdown")
+var impprefixflag = flag.String("imp-prefix", "", "Comma-separated list of import symbol prefixes to use in place of "+imppref)
+var demangleflag = flag.Bool("demangle", false, "Show C++ symbol names demangled, next to the mangled names, in the report")
+var watchreflag = flag.String("watchre", "", "Comma-separated list of regular expressions; symbols matching any of them (unanchored) are watched too")
+var refkindsflag = flag.Bool("ref-kinds", false, "Classify references as direct calls, indirect calls (through the import slot) or address-taken, showing the kind of each in the Refs section and counts of each kind in the Def/ref breakdown")
+var excerptsrcflag = flag.Bool("excerpt-source", false, "Interleave source code in excerpts for watched symbols (objects with debug info only)")
+
+func init() {
+	flag.Var(&inputsflag, "i", "Comma-separated (see -isep) list of input files or glob patterns, @file to read a list from a response file (may be repeated; omit to read from stdin)")
+}
 
 var watched map[string]bool
 
+// watchedNames is the symbols given with -watch and -watchfile, in
+// order.
+var watchedNames []string
+
+// watchres is the compiled -watchre patterns.
+var watchres []*regexp.Regexp
+
+// onlyExpr is the parsed -only expression, if any.
+var onlyExpr maskExpr
+
 // [ 0](sec  1)(fl 0x00)(ty   0)(scl   3) (nx 1) 0x00000000 .text
 var symre = regexp.MustCompile(`^\[\s*\d+\]\(sec\s+(\-?\d+)\)\(fl\s+\S+\)\(ty\s+\S+\)\(scl\s+\d+\)\s*\(nx\s+\S+\)\s+(\S+)\s+(\S+)\s*$`)
 
+// (scl 103) is C_FILE
+var filesymre = regexp.MustCompile(`\(scl\s+103\)`)
+
+// isAuxLine returns true for an auxiliary symbol record line in the
+// symbol table: "AUX ..." from llvm-objdump, or "File ..." (following
+// a C_FILE symbol) from GNU objdump.
+func isAuxLine(line string) bool {
+	return strings.HasPrefix(line, "AUX ") || strings.HasPrefix(line, "File ")
+}
+
 const imppref = "__imp_"
 
 type defrefmask uint32
@@ -41,6 +138,7 @@ const (
 	defimp                            // import symbol __imp_X is defined
 	refimp                            // import symbol __imp_X is referenced
 	dsameobj                          // defimp and defbase in same obj
+	dsplitobj                         // defimp and defbase in different objs
 )
 
 func (drm defrefmask) String() string {
@@ -60,6 +158,9 @@ func (drm defrefmask) String() string {
 	if drm&dsameobj != 0 {
 		res += " sameobj"
 	}
+	if drm&dsplitobj != 0 {
+		res += " splitobj"
+	}
 	return res
 }
 
@@ -69,13 +170,131 @@ type definfo struct {
 	value  int
 }
 
+// def returns the definition of symbol sname, or the first one if it
+// has several.
+func (s *state) def(sname string) (definfo, bool) {
+	if dl := s.defs[sname]; len(dl) != 0 {
+		return dl[0], true
+	}
+	return definfo{}, false
+}
+
+// defIn returns the definition of symbol sname by object objidx, if
+// any.
+func (s *state) defIn(sname string, objidx int) (definfo, bool) {
+	for _, di := range s.defs[sname] {
+		if di.objidx == objidx {
+			return di, true
+		}
+	}
+	return definfo{}, false
+}
+
+// writeDuplicateDefs writes the list of import symbols (from syms, in
+// order) defined by more than one object, if any, to w. Base symbols
+// are listed under "Multiply-defined symbols" instead (see
+// writeMultiDefs), along with whether the linker can choose between
+// their definitions.
+func (s *state) writeDuplicateDefs(w io.Writer, syms []string) {
+	var dups []string
+	for _, sym := range syms {
+		if isImpSym(sym) && len(s.defs[sym]) > 1 {
+			dups = append(dups, sym)
+		}
+	}
+	if len(dups) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Duplicate definitions:\n")
+	for _, sym := range dups {
+		fmt.Fprintf(w, " %q:\n", sym)
+		for _, di := range s.defs[sym] {
+			fmt.Fprintf(w, "  %s\n", s.defLine(di))
+		}
+	}
+}
+
+// defLine describes definition di for the lists of symbols defined
+// more than once: its object, section, value, the object's label and
+// the COMDAT selection of its section.
+func (s *state) defLine(di definfo) string {
+	return fmt.Sprintf("O%d sec=%s val=0x%x %s (%s)", di.objidx,
+		s.secField(di.objidx, di.secidx), di.value, s.labels[di.objidx], comdatString(s.defComdat(di)))
+}
+
 type reflist []refinfo
 
+// splitdef is an import symbol and its base symbol, defined in
+// different objects.
+type splitdef struct {
+	imp     string
+	impobj  int
+	base    string
+	baseobj int
+}
+
 type refinfo struct {
-	objidx  int
-	secidx  int
-	offsets []int
-	def     bool
+	objidx int
+	secidx int
+	relocs []relocinfo
+	def    bool
+}
+
+// relocinfo is a relocation against a symbol: its offset, type, the
+// section it applies to (by name, and by symbol-table section number,
+// or 0 if that isn't known) and the kind of reference it makes.
+type relocinfo struct {
+	off    int
+	typ    string
+	sect   string
+	secnum int
+	kind   refKind
+}
+
+// relocTypePrefixes are the prefixes of relocation type names dropped
+// to give the short form of the name.
+var relocTypePrefixes = []string{
+	"IMAGE_REL_AMD64_", "IMAGE_REL_I386_", "IMAGE_REL_ARM64_", "IMAGE_REL_ARM_",
+	"R_X86_64_", "R_386_", "R_AARCH64_",
+}
+
+// shortType returns the type of r without the machine prefix: REL32
+// for IMAGE_REL_AMD64_REL32, for example.
+func (r relocinfo) shortType() string {
+	for _, p := range relocTypePrefixes {
+		if t, ok := strings.CutPrefix(r.typ, p); ok {
+			return t
+		}
+	}
+	return r.typ
+}
+
+// offsets returns the offsets of the relocations in ri.
+func (ri *refinfo) offsets() []int {
+	offs := make([]int, len(ri.relocs))
+	for i, r := range ri.relocs {
+		offs[i] = r.off
+	}
+	return offs
+}
+
+// sects returns the names of the sections the relocations in ri apply
+// to.
+func (ri *refinfo) sects() []string {
+	sects := make([]string, len(ri.relocs))
+	for i, r := range ri.relocs {
+		sects[i] = r.sect
+	}
+	return sects
+}
+
+// types returns the short types of the relocations in ri.
+func (ri *refinfo) types() []string {
+	typs := make([]string, len(ri.relocs))
+	for i, r := range ri.relocs {
+		typs[i] = r.shortType()
+	}
+	return typs
 }
 
 type secinfo struct {
@@ -83,339 +302,1002 @@ type secinfo struct {
 	name   string
 	size   int
 	idx    int
+	// from the section-definition aux record, if haveAux
+	haveAux           bool
+	relocCount        int
+	checksum          uint32
+	comdatSelection   int
+	associatedSection int
 }
 
 type state struct {
-	// objects
+	// objects (names as given or derived from archive members)
 	objs []string
-	// path info for objects
-	paths []string
-	// section table, map
-	sects  []secinfo
-	secmap map[string]int
+	// per-object display labels, unique across objects
+	labels []string
+	// files to pass to the dumper for each object (differs from
+	// the object name for archive members)
+	files []string
+	// provenance info for objects
+	prov []provenance
+	// section table, and for each object, the position in it of
+	// each of the object's sections (by index)
+	sects    []secinfo
+	secindex map[int]map[int]int
+	// function symbols of each object, for -xrefs
+	funcs map[int][]funcsym
 	// Maps import symbol to def info.
-	defs map[string]definfo
+	defs map[string][]definfo
 	// Maps import symbol to list of ref infos.
 	refs map[string]reflist
 	// list of all interesting symbols, generated in pass 1.
 	all map[string]bool
 	// def/ref disposition for symbol X
 	defref map[string]defrefmask
-	// scanner
+	// inputs dropped as duplicates of other inputs
+	dups []dupinput
+	// definitions from loaded state (-load) that conflict with
+	// existing ones
+	conflicts []string
+	// import symbols defined in a different object from their base
+	// symbols, as found by markDefPairs
+	splitdefs []splitdef
+	// maps base symbol X to the DLL that __imp_X refers to
+	dllmap map[string]string
+	// imports for inputs that are PE images rather than objects,
+	// keyed by objidx
+	imports map[int][]peimport
+	// linker directives from the .drectve section of each object,
+	// keyed by objidx
+	directives map[int][]directive
+	// import libraries read for the DLL map (with -implib or
+	// -libpath)
+	implibs []string
+	// /EXPORT directives of the objects, and every symbol the
+	// current object defines, for checking them
+	exports []export
+	objSyms map[string]bool
+	// alternate name chains followed for referenced symbols, keyed
+	// by symbol, and the alternate names that resolved some
+	// reference
+	aliases   map[string]aliasRes
+	aliasUsed map[string]bool
+	// source of object dumps (nil with -from-dump or the built-in
+	// reader)
+	dumper Dumper
+	// backends to try for each object, and the one that was used
+	// for each, keyed by objidx
+	chain      []*backend
+	objBackend map[int]*backend
+	// scanner, and the flavor of dumper output it is reading
 	scanner *bufio.Scanner
+	flavor  string
+	// objects listed but not analyzed (outside the -objrange range,
+	// or with -continue-on-error, unreadable), with the reason
+	skipped map[int]string
+	// machine type (GOARCH-style) of each input, keyed by objidx
+	machines map[int]string
+	// inputs that are Go objects, and ELF objects, keyed by objidx
+	goObjects  map[int]bool
+	elfObjects map[int]bool
+	// files holding the dumper output read in pass1, kept for
+	// pass3, keyed by objidx
+	dumps map[int]string
+	// files holding the dumper output from -batch runs, for pass1,
+	// keyed by objidx
+	batched map[int]string
+	// file the user can see the dump of each object in (a
+	// -keep-temp file, or the -from-dump input), keyed by objidx,
+	// and the -keep-temp base names used, with the object for each
+	dumpSource map[int]string
+	keepNames  map[string]int
+	// lines read by the scanner
+	lineno int
+	// symbols defined by the last object whose symbol table was
+	// read, for -ndjson
+	objDefs map[string]struct{}
+	// undecorated names of the base symbols of interesting import
+	// symbols, from pass2
+	pairKeys map[string]bool
+	// symbols left out by -excludesym/-excludesymre, keyed by objidx
+	excludedSyms map[int]map[string]bool
+	// relocations read for each section of the current object,
+	// keyed by section name
+	relocsRead map[string]int
+	// relocation lists read for each section name of the current
+	// object, for relocSecnum
+	relocLists map[string]int
+	// symbols of interest with special section numbers (absolute or
+	// debug symbols)
+	specials []special
+	// with watched symbols, every symbol seen in a symbol table, and
+	// the names of the symbols excerpts were shown for
+	seenSyms  map[string]bool
+	excerpted map[string]bool
 	// current obj idx
 	objidx int
 }
 
-func newState(objs []string) *state {
+func newState(objs, files []string) *state {
 	return &state{
-		objs:   objs,
-		secmap: make(map[string]int),
-		defs:   make(map[string]definfo),
-		refs:   make(map[string]reflist),
-		all:    make(map[string]bool),
-		defref: make(map[string]defrefmask),
+		objs:         objs,
+		labels:       objLabels(objs),
+		files:        files,
+		secindex:     make(map[int]map[int]int),
+		funcs:        make(map[int][]funcsym),
+		defs:         make(map[string][]definfo),
+		refs:         make(map[string]reflist),
+		all:          make(map[string]bool),
+		defref:       make(map[string]defrefmask),
+		imports:      make(map[int][]peimport),
+		dllmap:       make(map[string]string),
+		directives:   make(map[int][]directive),
+		skipped:      make(map[int]string),
+		machines:     make(map[int]string),
+		goObjects:    make(map[int]bool),
+		elfObjects:   make(map[int]bool),
+		dumps:        make(map[int]string),
+		batched:      make(map[int]string),
+		dumpSource:   make(map[int]string),
+		objBackend:   make(map[int]*backend),
+		excludedSyms: make(map[int]map[string]bool),
 	}
 }
 
-func (s *state) String() string {
-	sb := &strings.Builder{}
-	fmt.Fprintf(sb, "Objects:\n")
-	for i := range s.objs {
-		fmt.Fprintf(sb, " O%d: %s %s\n", i, s.objs[i], s.paths[i])
+// parseObjRange parses an -objrange value of the form "N:M" (either
+// of which may be omitted) for a list of n inputs, returning the
+// range [N,M).
+func parseObjRange(spec string, n int) (int, int, error) {
+	los, his, ok := strings.Cut(spec, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad -objrange %q: expected N:M", spec)
+	}
+	lo, hi := 0, n
+	var err error
+	if los != "" {
+		if lo, err = strconv.Atoi(los); err != nil {
+			return 0, 0, fmt.Errorf("bad -objrange %q: %v", spec, err)
+		}
+	}
+	if his != "" {
+		if hi, err = strconv.Atoi(his); err != nil {
+			return 0, 0, fmt.Errorf("bad -objrange %q: %v", spec, err)
+		}
 	}
-	fmt.Fprintf(sb, "Sections:\n")
-	for _, sn := range s.sects {
-		fmt.Fprintf(sb, " O%d: %d %q 0x%x\n",
-			sn.objidx, sn.idx, sn.name, sn.size)
+	if lo < 0 || hi > n || lo >= hi {
+		return 0, 0, fmt.Errorf("-objrange %q out of range: have %d inputs (valid range 0:%d)", spec, n, n)
 	}
-	if len(s.defs) != 0 {
-		defs := make([]string, 0, len(s.defs))
-		for k := range s.defs {
-			defs = append(defs, k)
+	return lo, hi, nil
+}
+
+// objLabels computes display labels for the specified objects: the
+// object name, made relative to the current directory where possible,
+// and with a "#N" suffix where needed to keep labels distinct.
+func objLabels(objs []string) []string {
+	wd, _ := os.Getwd()
+	res := make([]string, len(objs))
+	seen := make(map[string]int)
+	for i, obj := range objs {
+		label := obj
+		if wd != "" && filepath.IsAbs(obj) {
+			if rel, err := filepath.Rel(wd, obj); err == nil && !strings.HasPrefix(rel, "..") {
+				label = rel
+			}
 		}
-		sort.Strings(defs)
-		fmt.Fprintf(sb, "Defs:\n")
-		for k, v := range defs {
-			di := s.defs[v]
-			fmt.Fprintf(sb, " %d: %q obj=%d sec=%d val=0x%x\n",
-				k, v, di.objidx, di.secidx, di.value)
+		seen[label]++
+		if n := seen[label]; n > 1 {
+			label = fmt.Sprintf("%s#%d", label, n)
 		}
+		res[i] = label
 	}
-	hexlist := func(vals []int) string {
-		sb := &strings.Builder{}
-		sb.WriteString("[")
-		sp := ""
-		for _, v := range vals {
-			fmt.Fprintf(sb, "%s0x%x", sp, v)
-			sp = " "
-		}
-		sb.WriteString("]")
-		return sb.String()
-	}
-	dumpref := func(sname string) {
-		fmt.Fprintf(sb, " %q:\n", sname)
-		rl := s.refs[sname]
-		for j, ri := range rl {
-			def := " "
-			if ri.def {
-				def = "*"
-			}
-			fmt.Fprintf(sb, "  %s%d: O=%d S=%d %s\n", def,
-				j, ri.objidx, ri.secidx, hexlist(ri.offsets))
-		}
-	}
-	if len(s.refs) != 0 {
-		refs := make([]string, 0, len(s.refs))
-		for k := range s.refs {
-			refs = append(refs, k)
-		}
-		sort.Strings(refs)
-		fmt.Fprintf(sb, "Refs:\n")
-		for _, v := range refs {
-			// Dump symbol first followed by import symbol.
-			if strings.HasPrefix(v, imppref) {
-				continue
+	return res
+}
+
+func (s *state) String() string {
+	sb := &strings.Builder{}
+	if !textfmt.original() {
+		if dumper != "" {
+			fmt.Fprintf(sb, "Dumper: %s (%s)\n", dumper, dumperVersion)
+		}
+		if m := s.machine(); m != "" {
+			fmt.Fprintf(sb, "Machine: %s\n", m)
+		}
+		s.writeSummary(sb)
+	}
+	if *byobjectflag {
+		s.writeObjectBlocks(sb)
+		s.writeCollapsed(sb)
+		s.writeConflicts(sb)
+	} else {
+		fmt.Fprintf(sb, "Objects:\n")
+		mixed := s.mixedMachines() != nil
+		tw := textfmt.newTable(sb)
+		for i := range s.objs {
+			fmt.Fprintf(tw, " O%d:\t%s%s\t%s\n", i, s.objName(i), s.objTags(i, mixed), s.objProvField(i))
+		}
+		tw.Flush()
+		s.writeCollapsed(sb)
+		fmt.Fprintf(sb, "Sections:\n")
+		for _, sn := range s.sects {
+			fmt.Fprintf(tw, " O%d:\t%d\t%q\t0x%x%s\n",
+				sn.objidx, sn.idx, sn.name, sn.size, sn.auxString())
+		}
+		tw.Flush()
+		s.writeConflicts(sb)
+		if len(s.defs) != 0 {
+			defs := make([]string, 0, len(s.defs))
+			for k := range s.defs {
+				defs = append(defs, k)
+			}
+			sort.Strings(defs)
+			fmt.Fprintf(sb, "Defs:\n")
+			k := 0
+			for _, v := range defs {
+				for _, di := range s.defs[v] {
+					fmt.Fprintf(tw, " %d:\t%q%s\tobj=%d\tsec=%s\tval=0x%x%s\n",
+						k, v, demangledTag(v), di.objidx, s.secField(di.objidx, di.secidx), di.value, s.comdatTag(di))
+					k++
+				}
+			}
+			tw.Flush()
+			s.writeDuplicateDefs(sb, defs)
+			s.writeSplitDefs(sb)
+		}
+		s.writeSpecials(sb)
+		dumpref := func(sname string) {
+			rl := s.refs[sname]
+			c := ""
+			if len(rl) != 0 {
+				x, _ := s.symKey(sname, rl[0].objidx)
+				c = s.symColor(x)
+			}
+			fmt.Fprintf(sb, "%s\n", colored(c, fmt.Sprintf(" %q%s:", sname, demangledTag(sname))))
+			for j, ri := range rl {
+				def := " "
+				if ri.def {
+					def = "*"
+				}
+				prefix := fmt.Sprintf("  %s%d: O=%d S=%s ", def, j, ri.objidx, s.refSecField(&ri))
+				suffix := ""
+				if !textfmt.original() {
+					suffix = " " + s.labels[ri.objidx]
+				}
+				fmt.Fprintf(sb, "%s\n", textfmt.offsets(prefix, ri.relocs, suffix))
+			}
+		}
+		if len(s.refs) != 0 {
+			fmt.Fprintf(sb, "Refs:\n")
+			for _, v := range s.refGroups() {
+				// Dump symbol first followed by import symbol.
+				if _, ok := s.refs[v]; ok {
+					dumpref(v)
+				} else if textfmt.original() {
+					continue
+				}
+				for _, iv := range impNames(v) {
+					if _, ok := s.refs[iv]; ok {
+						dumpref(iv)
+					}
+				}
 			}
-			dumpref(v)
-			iv := imppref + v
-			if _, ok := s.refs[iv]; ok {
-				dumpref(iv)
+		}
+	}
+	if len(s.imports) != 0 {
+		oidxs := make([]int, 0, len(s.imports))
+		for k := range s.imports {
+			oidxs = append(oidxs, k)
+		}
+		sort.Ints(oidxs)
+		fmt.Fprintf(sb, "Image imports:\n")
+		for _, oidx := range oidxs {
+			for _, pi := range s.imports[oidx] {
+				delay := ""
+				if pi.delay {
+					delay = " (delay)"
+				}
+				fmt.Fprintf(sb, " O%d: %q %s%s\n", oidx, pi.sym(), pi.dll, delay)
 			}
 		}
 	}
-	dr := make([]string, 0, len(s.defref))
-	for k := range s.defref {
-		dr = append(dr, k)
+	s.writeDirectives(sb)
+	s.writeExports(sb)
+	s.writeAliases(sb)
+	if *xrefsflag {
+		s.writeXrefs(sb)
+	}
+	if *objtotalsflag {
+		s.writeObjectTotals(sb)
+	}
+	s.writeBreakdown(sb)
+	s.writeUnusedImpDefs(sb)
+	s.writeAddrTaken(sb)
+	s.writeSuspectSlots(sb)
+	s.writeMultiDefs(sb)
+	if showUnresolved() {
+		s.writeUnresolved(sb)
+	}
+	return sb.String()
+}
+
+// countTrue returns the number of its arguments that are true.
+func countTrue(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
 	}
-	sort.Strings(dr)
-	fmt.Fprintf(sb, "Def/ref breakdown:\n")
-	for _, v := range dr {
-		fmt.Fprintf(sb, " %q: %s\n", v, s.defref[v])
+	return n
+}
+
+// hexlist formats a list of offsets as in the report: "[0x1 0x2]".
+func hexlist(vals []int) string {
+	sb := &strings.Builder{}
+	sb.WriteString("[")
+	sp := ""
+	for _, v := range vals {
+		fmt.Fprintf(sb, "%s0x%x", sp, v)
+		sp = " "
 	}
+	sb.WriteString("]")
 	return sb.String()
 }
 
+// writeCollapsed writes the list of inputs collapsed as duplicates,
+// if any, to w.
+func (s *state) writeCollapsed(w io.Writer) {
+	if len(s.dups) != 0 {
+		fmt.Fprintf(w, "Collapsed inputs:\n")
+		for _, d := range s.dups {
+			oids := make([]string, 0, len(d.objidxs))
+			for _, oidx := range d.objidxs {
+				oids = append(oids, fmt.Sprintf("O%d", oidx))
+			}
+			fmt.Fprintf(w, " %s => %s %s (%s)\n", d.name,
+				strings.Join(oids, ","), d.orig, d.reason)
+		}
+	}
+}
+
+// writeConflicts writes the list of conflicting definitions from
+// loaded state, if any, to w.
+func (s *state) writeConflicts(w io.Writer) {
+	if len(s.conflicts) != 0 {
+		fmt.Fprintf(w, "Conflicting definitions:\n")
+		for _, c := range s.conflicts {
+			fmt.Fprintf(w, " %s\n", c)
+		}
+	}
+}
+
+// writeBreakdown writes the Def/ref breakdown to w.
+func (s *state) writeBreakdown(w io.Writer) {
+	if *bydllflag {
+		s.writeBreakdownByDLL(w)
+		return
+	}
+	fmt.Fprintf(w, "Def/ref breakdown:\n")
+	syms := s.breakdownSyms()
+	counts := s.formRefCounts()
+	variants := s.variants()
+	kinds := s.kindCounts()
+	dkeys := s.defKeys()
+	exported := s.exportedSyms()
+	sb := &strings.Builder{}
+	tw := textfmt.newTable(sb)
+	for _, v := range syms {
+		fmt.Fprintf(tw, " %q%s:\t%s%s%s%s%s%s%s\n", v, demangledTag(v), s.defref[v], exportTag(v, exported), s.dllTag(v), s.dupTag(v, dkeys), s.countsTag(v, counts), kindsTag(kinds[v]), variantsTag(variants[v]))
+	}
+	tw.Flush()
+	// Color goes on after alignment, so as not to upset it.
+	lines := strings.SplitAfter(sb.String(), "\n")
+	for i, v := range syms {
+		line := strings.TrimSuffix(lines[i], "\n")
+		fmt.Fprintf(w, "%s\n", colored(s.symColor(v), line))
+	}
+}
+
+// countsTag returns the reference counts shown at the end of the
+// breakdown line for symbol X, if the layout has them, given the
+// counts from formRefCounts: the number of objects referring to it and
+// the number of relocations, separately for X and __imp_X if both are
+// referred to.
+func (s *state) countsTag(x string, counts map[string][2]refCounts) string {
+	if !textfmt.counts() {
+		return ""
+	}
+	rcs := counts[x]
+	switch {
+	case rcs[0].nobjs != 0 && rcs[1].nobjs != 0:
+		return fmt.Sprintf(" (%s: objs=%d relocs=%d, %s%s: objs=%d relocs=%d)",
+			x, rcs[0].nobjs, rcs[0].nrefs, impprefs[0], x, rcs[1].nobjs, rcs[1].nrefs)
+	case rcs[0].nobjs != 0:
+		return fmt.Sprintf(" (objs=%d relocs=%d)", rcs[0].nobjs, rcs[0].nrefs)
+	}
+	return fmt.Sprintf(" (objs=%d relocs=%d)", rcs[1].nobjs, rcs[1].nrefs)
+}
+
+// objTags returns the tags shown after the label of object i in the
+// Objects listing, each with a leading space. mixed says whether the
+// inputs are for more than one machine.
+func (s *state) objTags(i int, mixed bool) string {
+	tag := ""
+	if strings.HasSuffix(s.objs[i], ".syso") {
+		tag = " [syso]"
+	}
+	if s.goObjects[i] {
+		tag += " [Go object]"
+	}
+	if why, ok := s.skipped[i]; ok {
+		tag += " [skipped: " + why + "]"
+	}
+	if b := s.objBackend[i]; b != nil && len(s.chain) > 1 && b != s.chain[0] {
+		tag += " [read with " + b.name + " backend]"
+	}
+	if mixed {
+		tag += " [" + s.machines[i] + "]"
+	}
+	return tag
+}
+
+// objProv returns the provenance of object i, which is missing if
+// reading it failed.
+func (s *state) objProv(i int) provenance {
+	if i < len(s.prov) {
+		return s.prov[i]
+	}
+	return provenance{}
+}
+
+// objName returns the name of object i for the Objects listing: its
+// label, or with the original layout its path.
+func (s *state) objName(i int) string {
+	if textfmt.original() {
+		return s.objs[i]
+	}
+	return s.labels[i]
+}
+
+// objProvField returns the provenance of object i for the Objects
+// listing. The original layout has just the path from a sidecar.
+func (s *state) objProvField(i int) string {
+	pi := s.objProv(i)
+	if textfmt.original() {
+		if pi.fromSym {
+			return ""
+		}
+		return pi.Path
+	}
+	return pi.String()
+}
+
+// dllTag returns a breakdown annotation naming the DLL for symbol X, if
+// known. When import libraries have been supplied, symbols referenced
+// via __imp_X but defined neither locally nor by an import library are
+// flagged.
+func (s *state) dllTag(x string) string {
+	if dll, ok := s.dllmap[x]; ok {
+		return " [" + dll + "]"
+	}
+	drm := s.defref[x]
+	if len(s.implibs) != 0 && drm&refimp != 0 && drm&defimp == 0 {
+		return " [not in import libs]"
+	}
+	return ""
+}
+
+// readImportLibs populates the symbol to DLL map from the import
+// libraries specified with -implib.
+func (s *state) readImportLibs() error {
+	for _, implib := range strings.Split(*implibflag, ",") {
+		m, err := readImportLib(implib)
+		if err != nil {
+			return fmt.Errorf("reading import library %s: %v", implib, err)
+		}
+		for sym, dll := range m {
+			s.dllmap[sym] = dll
+		}
+		s.implibs = append(s.implibs, implib)
+	}
+	return nil
+}
+
 // pass1 looks just at the symbol table for the specified object. Here
 // the idea is to build up a list of all import symbols.
 func (s *state) pass1(infile string) error {
-	// kick off command
-	cmd := exec.Command(*objdumpflag, "-t", infile)
-	out, err := cmd.Output()
+	if *fromdumpflag != "" {
+		// Offline mode: infile holds previously captured output.
+		out, err := readText(infile)
+		if err != nil {
+			return err
+		}
+		s.machines[s.objidx] = dumpMachine(string(out))
+		s.dumpSource[s.objidx] = infile
+		return s.withSource(s.readSymbolNames)(bytes.NewReader(out))
+	}
+	// So are Go objects, ELF objects and linked images.
+	if goObjectHeader(infile) != "" {
+		s.goObjects[s.objidx] = true
+		return s.readGoSymbols(infile, nil)
+	}
+	if isELF(infile) {
+		s.elfObjects[s.objidx] = true
+		return s.pass1ELF(infile)
+	}
+	if img, err := isImage(infile); err != nil {
+		return err
+	} else if img {
+		return s.readImage(infile)
+	}
+	return s.pass1Backends(infile)
+}
+
+// pass1With does the work of pass1 for infile using backend b.
+func (s *state) pass1With(b *backend, infile string) error {
+	if b.dumper == nil {
+		return s.pass1Native(infile)
+	}
+	// Output from a -batch run is for the first backend tried, so
+	// it is used up by the first attempt.
+	bfile, batched := s.batched[s.objidx]
+	delete(s.batched, s.objidx)
+	r, err := s.openDump(infile, b.flavor+" full", func() (io.Reader, error) {
+		if batched {
+			return os.Open(bfile)
+		}
+		return b.dumper.Full(infile, pass3SectionList)
+	})
+	if err == nil {
+		r, err = s.keepTemp(s.objidx, "pass1", r)
+	}
+	if err != nil {
+		return err
+	}
+	// The full dump is taken now rather than just the symbol table,
+	// and kept in a file so that pass3 needn't run the dumper again
+	// (unless -state has it, when it isn't needed).
+	keep := bfile
+	var tee io.Writer
+	if !batched && dcache == nil {
+		f, err := newDumpFile()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		keep, tee = f.Name(), f
+	}
+	if err := readStream(r, tee, s.withSource(s.readSymbolNames)); err != nil {
+		if keep != "" {
+			os.Remove(keep)
+		}
+		return err
+	}
+	if keep != "" {
+		s.dumps[s.objidx] = keep
+	}
+	return nil
+}
+
+// newDumpFile creates a file in the scratch directory to hold dumper
+// output.
+func newDumpFile() (*os.File, error) {
+	td, err := tempDir()
 	if err != nil {
-		return fmt.Errorf("running llvm-objdump-14 on %s: %v", infile, err)
+		return nil, err
 	}
+	return os.CreateTemp(td, "dump*.txt")
+}
+
+// peekFlavor sets the flavor of the dumper output r about to be read,
+// returning a reader for all of it.
+func (s *state) peekFlavor(r io.Reader) *bufio.Reader {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4096)
+	s.setFlavor(string(head))
+	return br
+}
 
-	// process the output
-	s.scanner = bufio.NewScanner(strings.NewReader(string(out)))
+// readSymbolNames adds the interesting symbols in the symbol table
+// dump r to the set of all symbols.
+func (s *state) readSymbolNames(r io.Reader) error {
+	br := s.peekFlavor(r)
+	if s.flavor == flavorReadobj {
+		out, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		return s.pass1Readobj(string(out))
+	}
+	s.scanner = s.newScanner(br)
 	for s.scanner.Scan() {
 		line := s.scanner.Text()
 		if line == "SYMBOL TABLE:" {
 			for s.scanner.Scan() {
 				line := s.scanner.Text()
-				if strings.HasPrefix(line, "AUX ") {
+				if isAuxLine(line) {
 					continue
 				}
 				if line == "" {
 					break
 				}
-				m := symre.FindStringSubmatch(line)
-				if len(m) == 0 {
-					return fmt.Errorf("bad line %s in symtab", line)
+				sl, err := parseSymLine(line)
+				if err != nil {
+					return err
 				}
-				sname := m[3]
+				sname := sl.name
 				if !s.isInterestingSym(sname) {
 					continue
 				}
 				s.all[sname] = true
 			}
 		}
+		if line == "COFF SYMBOL TABLE" {
+			for s.scanner.Scan() {
+				line := s.scanner.Text()
+				if line == "" {
+					break
+				}
+				if strings.HasPrefix(line, " ") {
+					// aux record
+					continue
+				}
+				m := dbsymre.FindStringSubmatch(line)
+				if len(m) == 0 {
+					return fmt.Errorf("bad line %s in symtab", line)
+				}
+				if sname := m[3]; s.isInterestingSym(sname) {
+					s.all[sname] = true
+				}
+			}
+		}
 	}
-	return nil
+	return s.scanErr()
 }
 
-// Expand out set of interesting symbols from __imp_X to include X as well.
+// Expand out set of interesting symbols from __imp_X to include X as
+// well. Where decorations are stripped, X is interesting in any of its
+// decorated or undecorated forms.
 func (s *state) pass2() {
 	keys := make([]string, 0, len(s.all))
 	for k := range s.all {
 		keys = append(keys, k)
 	}
+	s.pairKeys = make(map[string]bool)
 	for _, k := range keys {
-		if strings.HasPrefix(k, imppref) {
-			x := k[len(imppref):]
-			s.all[x] = true
+		if x, ok := cutImp(k); ok {
+			if !isExcluded(x) {
+				s.all[x] = true
+				s.pairKeys[undecorate(x)] = true
+			}
 		}
 	}
 }
 
 func (s *state) pass3(infile string) error {
-	// kick off command
-	cmd := exec.Command(*objdumpflag,
-		"-h", // section headers
-		"-t", // symbols
-		"-r", // relocations
-		"--section=.text",
-		"--section=.data",
-		"--section=.bss",
-		"--section=.rdata",
-		"--section=.xdata", infile)
-	out, err := cmd.Output()
+	s.objSyms = nil
+	// Images are fully handled in pass1.
+	if _, ok := s.imports[s.objidx]; ok {
+		s.prov = append(s.prov, provenance{})
+		return nil
+	}
+	if s.goObjects[s.objidx] {
+		s.prov = append(s.prov, pathinfo(infile))
+		return s.readGoSymbols(infile, make(map[string]struct{}))
+	}
+	if s.elfObjects[s.objidx] {
+		s.prov = append(s.prov, pathinfo(infile))
+		return s.readObjectELF(infile)
+	}
+
+	// The backend that read the object in pass1, and the dump it
+	// kept, if any.
+	b := s.objBackend[s.objidx]
+	native := b != nil && b.dumper == nil
+	dfile, kept := s.dumps[s.objidx]
+	delete(s.dumps, s.objidx)
+	var r io.Reader
+	var err error
+	switch {
+	case native:
+	case kept:
+		defer os.Remove(dfile)
+		r, err = os.Open(dfile)
+	case *fromdumpflag != "":
+		// Offline mode: infile holds previously captured output.
+		var out []byte
+		out, err = readText(infile)
+		r = bytes.NewReader(out)
+	default:
+		r, err = s.openDump(infile, b.flavor+" full", func() (io.Reader, error) {
+			return b.dumper.Full(infile, pass3SectionList)
+		})
+		if err == nil {
+			r, err = s.keepTemp(s.objidx, "pass3", r)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("running llvm-objdump-14 on %s: %v", infile, err)
+		return err
 	}
 
 	// try to derive path info
-	pi := s.pathinfo(infile)
-	s.paths = append(s.paths, pi)
+	pi := pathinfo(infile)
+	s.prov = append(s.prov, pi)
 
-	// digest output
-	if err := s.digest(string(out)); err != nil {
+	if native {
+		err = s.readObjectNative(infile)
+	} else {
+		err = readStream(r, nil, s.withSource(s.digest))
+	}
+	if err == nil && !native && *fromdumpflag == "" {
+		err = s.readDirectives(infile, b)
+	}
+	if err != nil {
 		return err
 	}
-
+	s.checkExports()
+	s.checkRelocCounts(infile)
 	return nil
 }
 
-func (s *state) pathinfo(infile string) string {
-	if !strings.HasSuffix(infile, ".o") {
-		return ""
-	}
-	txtfile := infile[:len(infile)-1] + "txt"
-	if content, err := os.ReadFile(txtfile); err != nil {
-		return ""
-	} else {
-		lines := strings.Split(string(content), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "pn: ") {
-				return line[4:]
-			}
+// setFlavor sets the flavor of the dumper output content about to be
+// read.
+func (s *state) setFlavor(content string) {
+	s.flavor = flavor
+	if *flavorflag == flavorAuto {
+		if f := detectFlavor(content); f != "" {
+			s.flavor = f
 		}
 	}
-	return ""
 }
 
-func (s *state) digest(content string) error {
-	s.scanner = bufio.NewScanner(strings.NewReader(content))
+func (s *state) digest(r io.Reader) error {
+	br := s.peekFlavor(r)
+	if s.flavor == flavorDumpbin || s.flavor == flavorReadobj {
+		// These are read whole.
+		out, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		if s.flavor == flavorDumpbin {
+			return s.digestDumpbin(string(out))
+		}
+		return s.digestReadobj(string(out))
+	}
+	s.scanner = s.newScanner(br)
 	for s.scanner.Scan() {
+		// The GNU parsers can end up reading the line that starts
+		// the next table; if so it is handed back to us.
 		line := s.scanner.Text()
-		if line == "Sections:" {
-			if err := s.readSections(); err != nil {
-				return err
-			}
-		}
-		if line == "SYMBOL TABLE:" {
-			if err := s.readSymtab(); err != nil {
-				return err
+		for line != "" {
+			next := ""
+			var err error
+			switch {
+			case line == "Sections:":
+				if s.flavor == flavorGNU {
+					next, err = s.readSectionsGNU()
+				} else {
+					err = s.readSections()
+				}
+			case line == "SYMBOL TABLE:":
+				err = s.readSymtab()
+			case strings.HasPrefix(line, "RELOCATION RECORDS FOR ["):
+				next, err = s.readRelocations(line)
+			case strings.HasPrefix(line, "Contents of section "):
+				// Appended to an offline dump, see readDirectives.
+				next, err = s.readContents(line)
 			}
-		}
-		if strings.HasPrefix(line, "RELOCATION RECORDS FOR [") {
-			if err := s.readRelocations(line); err != nil {
+			if err != nil {
 				return err
 			}
+			line = next
 		}
 	}
-	return nil
+	return s.scanErr()
 }
 
 func (s *state) isInterestingSym(sname string) bool {
-	return strings.HasPrefix(sname, "__imp") ||
-		*allsymsflag || watched[sname] || s.all[sname]
+	if !isImpSym(sname) &&
+		!*allsymsflag && !s.isWatched(sname, s.objidx) && !s.all[sname] &&
+		!(s.normalizes(s.objidx) && s.pairKeys[undecorate(sname)]) {
+		return false
+	}
+	if !isExcluded(sname) {
+		return true
+	}
+	if s.isWatched(sname, s.objidx) {
+		warnExcludedWatched(sname)
+	}
+	s.noteExcluded(sname)
+	return false
 }
 
 func (s *state) readSymtab() error {
 	defs := make(map[string]struct{})
-	// [ 0](sec  1)(fl 0x00)(ty   0)(scl   3) (nx 1) 0x00000000 .text
-	symre := regexp.MustCompile(`^\[\s*\d+\]\(sec\s+(\-?\d+)\)\(fl\s+\S+\)\(ty\s+\S+\)\(scl\s+\d+\)\s*\(nx\s+\S+\)\s+(\S+)\s+(\S+)\s*$`)
+	// Source file name from the aux record(s) of the first .file
+	// symbol; long names can span multiple records.
+	srcfile := ""
+	infilesym := false
+	// section of the last symbol, for section-definition aux records
+	lastsec := 0
 	for s.scanner.Scan() {
 		line := s.scanner.Text()
-		if strings.HasPrefix(line, "AUX ") {
+		if isAuxLine(line) {
+			if infilesym && strings.HasPrefix(line, "AUX ") {
+				srcfile += line[len("AUX "):]
+			} else if a, ok := parseAuxSec(line); ok {
+				s.setSectionAux(lastsec, a)
+			}
 			continue
 		}
+		infilesym = false
 		if line == "" {
 			break
 		}
-		m := symre.FindStringSubmatch(line)
-		if len(m) == 0 {
-			return fmt.Errorf("bad line %s in sections table", line)
+		sl, err := parseSymLine(line)
+		if err != nil {
+			return err
 		}
-		var secidx int
-		if n, err := fmt.Sscanf(m[1], "%d", &secidx); n != 1 || err != nil {
-			return fmt.Errorf("can't parse sec idx in line %s in symtab", line)
+		sname := sl.name
+		lastsec = sl.secidx
+		if sname == ".file" && srcfile == "" {
+			infilesym = true
 		}
-		var value int
-		if n, err := fmt.Sscanf(m[2], "0x%x", &value); n != 1 || err != nil {
-			return fmt.Errorf("can't parse value in line %s in symtab", line)
+		// GNU objdump shows the file name in place of ".file".
+		if s.flavor == flavorGNU && srcfile == "" && filesymre.MatchString(line) {
+			srcfile = sname
+			sname = ".file"
 		}
-		sname := m[3]
-		if !s.isInterestingSym(sname) {
-			continue
+		if err := s.addSymbol(sname, sl.secidx, sl.value, defs); err != nil {
+			return err
 		}
-		def := false
-		if secidx != 0 {
-			// This is a definition.
-			di := definfo{
-				objidx: s.objidx,
-				secidx: secidx,
-				value:  value,
-			}
-			if v, ok := s.defs[sname]; ok {
-				return fmt.Errorf("internal error: collision on %q reading objidx %d, found previous def %+v", sname, s.objidx, v)
-			}
-			s.defs[sname] = di
-			def = true
-			s.maskAddDef(sname)
-			defs[sname] = struct{}{}
-		}
-		// now add reference. Can't fill in secidx until we look
-		// at relocations.
-		ri := refinfo{
+	}
+	s.finishSymtab(srcfile, defs)
+	return nil
+}
+
+// addSymbol records a def or ref for symbol sname (if interesting) from
+// the symbol table of the current object. Names of symbols defined
+// are added to defs.
+func (s *state) addSymbol(sname string, secidx, value int, defs map[string]struct{}) error {
+	if *xrefsflag {
+		s.noteFunc(sname, secidx, value)
+	}
+	s.noteSeen(sname)
+	if secidx > 0 {
+		s.noteObjSym(sname)
+	}
+	if !s.isInterestingSym(sname) {
+		return nil
+	}
+	if secidx < 0 {
+		// Absolute or debug: neither a definition nor a reference.
+		s.addSpecial(sname, secidx, value)
+		return nil
+	}
+	def := false
+	if secidx != 0 {
+		// This is a definition.
+		di := definfo{
 			objidx: s.objidx,
 			secidx: secidx,
-			def:    def,
-		}
-		sl := s.refs[sname]
-		sl = append(sl, ri)
-		s.refs[sname] = sl
-		if !def {
-			s.maskAddRef(sname)
+			value:  value,
 		}
+		// Another object may define it too (a CRT stub in several
+		// archive members, say); all the definitions are kept.
+		s.defs[sname] = append(s.defs[sname], di)
+		def = true
+		s.maskAddDef(sname, s.objidx)
+		defs[sname] = struct{}{}
 	}
-	for k := range defs {
-		if strings.HasPrefix(k, imppref) {
-			base := k[len(imppref):]
-			if _, ok := defs[base]; ok {
-				s.defref[base] = s.defref[base] | dsameobj
-			}
-		}
+	// now add reference. For a pure reference secidx stays 0;
+	// the sections the references come from are recorded with
+	// each relocation.
+	ri := refinfo{
+		objidx: s.objidx,
+		secidx: secidx,
+		def:    def,
+	}
+	sl := s.refs[sname]
+	sl = append(sl, ri)
+	s.refs[sname] = sl
+	if !def {
+		s.maskAddRef(sname, s.objidx)
 	}
 	return nil
 }
 
-func (s *state) maskAddDef(sname string) {
-	if strings.HasPrefix(sname, imppref) {
-		x := sname[len(imppref):]
-		s.defref[x] = s.defref[x] | defimp
-	} else {
-		s.defref[sname] = s.defref[sname] | defbase
+// finishSymtab wraps up after reading the symbol table of the current
+// object, given the source file named by its .file symbol (if any)
+// and the symbols it defines.
+func (s *state) finishSymtab(srcfile string, defs map[string]struct{}) {
+	s.objDefs = defs
+	// Use the source file as provenance if there was no sidecar.
+	srcfile = strings.TrimRight(srcfile, "\x00 ")
+	if srcfile != "" && s.objidx < len(s.prov) && s.prov[s.objidx].Path == "" {
+		s.prov[s.objidx].Path = srcfile
+		s.prov[s.objidx].fromSym = true
 	}
 }
 
-func (s *state) maskAddRef(sname string) {
-	if strings.HasPrefix(sname, imppref) {
-		x := sname[len(imppref):]
-		s.defref[x] = s.defref[x] | refimp
-	} else {
-		s.defref[sname] = s.defref[sname] | refbase
-	}
-}
+// markDefPairs sets the sameobj and splitobj bits of the def/ref
+// breakdown, once the definitions from all objects are in, for each
+// symbol X whose import symbol __imp_X is defined along with X itself:
+// sameobj if some object defines both, splitobj if they are defined
+// in different objects. The latter pairs are recorded in s.splitdefs.
+func (s *state) markDefPairs() {
+	s.splitdefs = nil
+	for k, dl := range s.defs {
+		base, ok := cutImp(k)
+		if !ok {
+			continue
+		}
+		for _, di := range dl {
+			x, _ := s.symKey(k, di.objidx)
+			for _, bdi := range s.defs[base] {
+				if bdi.objidx == di.objidx {
+					s.defref[x] |= dsameobj
+				} else {
+					s.defref[x] |= dsplitobj
+					s.splitdefs = append(s.splitdefs, splitdef{k, di.objidx, base, bdi.objidx})
+				}
+			}
+		}
+	}
+	sort.Slice(s.splitdefs, func(i, j int) bool {
+		a, b := s.splitdefs[i], s.splitdefs[j]
+		if a.imp != b.imp {
+			return a.imp < b.imp
+		}
+		if a.impobj != b.impobj {
+			return a.impobj < b.impobj
+		}
+		return a.baseobj < b.baseobj
+	})
+}
+
+// writeSplitDefs writes the list of import symbols defined in a
+// different object from their base symbols, if any, to w.
+func (s *state) writeSplitDefs(w io.Writer) {
+	if len(s.splitdefs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Split definitions:\n")
+	for _, sd := range s.splitdefs {
+		fmt.Fprintf(w, " %q: O%d %s, %q: O%d %s\n", sd.imp, sd.impobj, s.labels[sd.impobj],
+			sd.base, sd.baseobj, s.labels[sd.baseobj])
+	}
+}
+
+func (s *state) maskAddDef(sname string, objidx int) {
+	if x, imp := s.symKey(sname, objidx); imp {
+		s.defref[x] = s.defref[x] | defimp
+	} else {
+		s.defref[x] = s.defref[x] | defbase
+	}
+}
+
+func (s *state) maskAddRef(sname string, objidx int) {
+	if x, imp := s.symKey(sname, objidx); imp {
+		s.defref[x] = s.defref[x] | refimp
+	} else {
+		s.defref[x] = s.defref[x] | refbase
+	}
+}
 
-func (s *state) readRelocations(rline string) error {
+// readRelocations reads the relocations for a section. If the table
+// is ended by the header for the next one rather than a blank line (as
+// with some versions of GNU objdump), that line is returned.
+func (s *state) readRelocations(rline string) (string, error) {
 	// Determine section.
 	secre := regexp.MustCompile(`RELOCATION RECORDS FOR \[(\S+)\]:$`)
 	m := secre.FindStringSubmatch(rline)
 	if len(m) == 0 {
-		return fmt.Errorf("bad relocations line %s", rline)
+		return "", fmt.Errorf("bad relocations line %s", rline)
 	}
+	sname := m[1]
+	// GNU objdump is run without section filtering (see pass3).
+	skip := s.flavor == flavorGNU && !pass3Sections[sname]
+	secnum := s.relocSecnum(sname)
 	// skip preamble
 	s.scanner.Scan()
 	// read the relocs
@@ -423,145 +1305,471 @@ func (s *state) readRelocations(rline string) error {
 	for s.scanner.Scan() {
 		line := s.scanner.Text()
 		if line == "" {
-			return nil
+			return "", nil
+		}
+		if strings.HasPrefix(line, "RELOCATION RECORDS FOR [") {
+			return line, nil
+		}
+		if skip {
+			continue
 		}
 		m := relre.FindStringSubmatch(line)
 		if len(m) == 0 {
-			return fmt.Errorf("bad line %s in relocs", line)
+			return "", fmt.Errorf("bad line %s in relocs", line)
 		}
+		s.countReloc(sname)
 		soff := m[1]
-		//styp := m[2]
+		styp := m[2]
 		sval := m[3]
-		if !s.isInterestingSym(sval) {
-			continue
+		if s.flavor == flavorGNU {
+			// GNU objdump adds any addend, as in
+			// "printf-0x00000000000000c0".
+			sval = gnuAddendRe.ReplaceAllString(sval, "")
 		}
-		var off int
-		if n, err := fmt.Sscanf(soff, "%x", &off); n != 1 || err != nil {
-			return fmt.Errorf("can't parse offset in line %s relocs", line)
+		if err := s.addReloc(sname, secnum, soff, styp, sval, line); err != nil {
+			return "", err
 		}
-		// Locate ref entry
-		rl, ok := s.refs[sval]
-		if !ok {
-			return fmt.Errorf("can't find refs entry in %s", line)
-		}
-		// Walk the ref list backwards, stopping when we hit end of obj.
-		rln := len(rl)
-		found := false
-		for i := range rl {
-			ri := &rl[rln-i-1]
-			if ri.objidx != s.objidx {
-				break
-			}
-			found = true
-			ri.offsets = append(ri.offsets, off)
+	}
+	return "", nil
+}
+
+// gnuAddendRe matches the addend GNU objdump shows after the symbol
+// of a relocation.
+var gnuAddendRe = regexp.MustCompile(`[-+]0x[0-9a-f]+$`)
+
+// addReloc records a relocation of type styp at offset soff (in hex) in
+// section sect (with symbol-table section number secnum, or 0 if not
+// known) against symbol sval, if interesting, for the current object.
+// The symbol table must already have been read.
+func (s *state) addReloc(sect string, secnum int, soff, styp, sval, line string) error {
+	if !s.isInterestingSym(sval) || isPairLow(styp) {
+		return nil
+	}
+	var off int
+	if n, err := fmt.Sscanf(soff, "%x", &off); n != 1 || err != nil {
+		return fmt.Errorf("can't parse offset in line %s relocs", line)
+	}
+	// Locate ref entry
+	rl, ok := s.refs[sval]
+	if !ok {
+		return fmt.Errorf("can't find refs entry in %s", line)
+	}
+	// The current object's entries are at the end of the ref list.
+	// Walk it backwards to the first of them, which gets the reloc
+	// (a symbol listed more than once in the symbol table shouldn't
+	// have its relocations counted more than once).
+	i := len(rl)
+	for i > 0 && rl[i-1].objidx == s.objidx {
+		i--
+	}
+	if i == len(rl) {
+		return fmt.Errorf("could not find ref info for reloc %s", line)
+	}
+	ri := &rl[i]
+	ri.relocs = append(ri.relocs, relocinfo{off: off, typ: styp, sect: sect, secnum: secnum,
+		kind: refKindOf(sval, styp, sect)})
+	return nil
+}
+
+// relocSecnum returns the symbol-table section number of the section
+// named sname of the current object whose relocations are listed
+// next, or 0 if it wasn't recorded. Sections with the same name (a
+// COMDAT per function, say) have their relocations listed in section
+// order, so the Nth list for a name is for the Nth section with it.
+func (s *state) relocSecnum(sname string) int {
+	if s.relocLists == nil {
+		s.relocLists = make(map[string]int)
+	}
+	n := s.relocLists[sname]
+	s.relocLists[sname]++
+	// The current object's sections are at the end of the table.
+	i := len(s.sects)
+	for i > 0 && s.sects[i-1].objidx == s.objidx {
+		i--
+	}
+	for _, si := range s.sects[i:] {
+		if si.name != sname {
+			continue
 		}
-		if !found {
-			return fmt.Errorf("could not find ref info for reloc %s", line)
+		if n == 0 {
+			return si.idx + 1
 		}
+		n--
 	}
-	return nil
+	return 0
 }
 
 func (s *state) readSections() error {
 	s.scanner.Scan() // advance past preamble
-	secre := regexp.MustCompile(`^\s+([0-9]+)\s+(\S+)\s+(\S+)\s+.*`)
 	for s.scanner.Scan() {
 		line := s.scanner.Text()
 		if line == "" {
 			return nil
 		}
-		m := secre.FindStringSubmatch(line)
-		if len(m) == 0 {
+		sindex, sname, ssiz, ok := parseSecLine(line)
+		if !ok {
 			return fmt.Errorf("bad line %s in sections table", line)
 		}
-		sidx := m[1]
-		sname := m[2]
-		ssz := m[3]
-		var ssiz int
-		var sindex int
-		if n, err := fmt.Sscanf(ssz, "%x", &ssiz); n != 1 || err != nil {
-			return fmt.Errorf("can't parse sec size in line %s in sections table", line)
-		}
-		if n, err := fmt.Sscanf(sidx, "%d", &sindex); n != 1 || err != nil {
-			return fmt.Errorf("can't parse idx in line %s in sections table", line)
-		}
-		s.secmap[sname] = len(s.sects)
-		s.sects = append(s.sects,
-			secinfo{
-				objidx: s.objidx,
-				name:   sname,
-				size:   ssiz,
-				idx:    sindex,
-			})
+		s.newSection(sname, ssiz, sindex)
+	}
+	return nil
+}
+
+// readSectionsGNU reads the section headers as output by GNU objdump,
+// in which each section is followed by a line of flags and the table
+// isn't necessarily followed by a blank line. Only the sections that
+// llvm-objdump is asked for in pass3 are recorded. If the table is
+// ended by the start of the next one, that line is returned.
+func (s *state) readSectionsGNU() (string, error) {
+	s.scanner.Scan() // advance past preamble
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			return "", nil
+		}
+		if !strings.HasPrefix(line, " ") {
+			return line, nil
+		}
+		sindex, sname, ssiz, ok := parseSecLine(line)
+		if !ok {
+			// section flags: CONTENTS, ALLOC, LOAD, ...
+			continue
+		}
+		if !pass3Sections[sname] {
+			continue
+		}
+		s.newSection(sname, ssiz, sindex)
+	}
+	return "", nil
+}
+
+// newSection records section sindex (numbered from 0) of the current
+// object.
+func (s *state) newSection(sname string, ssiz, sindex int) {
+	s.addSection(secinfo{
+		objidx: s.objidx,
+		name:   sname,
+		size:   ssiz,
+		idx:    sindex,
+	})
+}
+
+// secField returns the section of object objidx with symbol-table
+// section number secnum as shown in the report.
+func (s *state) secField(objidx, secnum int) string {
+	return textfmt.section(secnum, s.secName(objidx, secnum))
+}
+
+// refSections returns the sections that the references in ri come
+// from, in order of first appearance, as symbol-table section numbers
+// (0 where not known) and names. For a definition, it is the
+// section defining the symbol.
+func (s *state) refSections(ri *refinfo) ([]int, []string) {
+	if ri.secidx != 0 || len(ri.relocs) == 0 {
+		return []int{ri.secidx}, []string{s.secName(ri.objidx, ri.secidx)}
+	}
+	var nums []int
+	var names []string
+	seen := make(map[relocinfo]bool)
+	for _, r := range ri.relocs {
+		k := relocinfo{sect: r.sect, secnum: r.secnum}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		name := r.sect
+		if name == "" {
+			// From a hand-built state or an old -save file.
+			name = s.secName(ri.objidx, r.secnum)
+		}
+		nums = append(nums, r.secnum)
+		names = append(names, name)
+	}
+	return nums, names
+}
+
+// refSecField returns the S= field of the Refs listings for ri: the
+// section defining the symbol, or the sections the references come
+// from, comma-separated. The original layout has just the symbol's
+// section, which is 0 for a reference.
+func (s *state) refSecField(ri *refinfo) string {
+	if textfmt.original() {
+		return strconv.Itoa(ri.secidx)
+	}
+	nums, names := s.refSections(ri)
+	fields := make([]string, len(nums))
+	for i := range nums {
+		fields[i] = textfmt.section(nums[i], names[i])
+	}
+	return strings.Join(fields, ",")
+}
+
+// refSecName is like refSecField, but always gives section names.
+func (s *state) refSecName(ri *refinfo) string {
+	_, names := s.refSections(ri)
+	return strings.Join(names, ",")
+}
+
+// addSection adds si to the section table.
+func (s *state) addSection(si secinfo) {
+	if s.secindex[si.objidx] == nil {
+		s.secindex[si.objidx] = make(map[int]int)
+	}
+	s.secindex[si.objidx][si.idx] = len(s.sects)
+	s.sects = append(s.sects, si)
+}
+
+// symSection returns the section of object objidx with section number
+// secnum as used in symbol tables (numbered from 1), or nil if there
+// is no such section.
+func (s *state) symSection(objidx, secnum int) *secinfo {
+	if i, ok := s.secindex[objidx][secnum-1]; ok && secnum > 0 {
+		return &s.sects[i]
 	}
 	return nil
 }
 
+// secName returns the name of the section of object objidx with
+// symbol-table section number secnum, or for the special section
+// numbers, UNDEF, ABS or DEBUG. If there is no such section it returns
+// the number.
+func (s *state) secName(objidx, secnum int) string {
+	switch secnum {
+	case 0:
+		return "UNDEF"
+	case secAbsolute:
+		return "ABS"
+	case secDebug:
+		return "DEBUG"
+	}
+	if si := s.symSection(objidx, secnum); si != nil {
+		return si.name
+	}
+	return strconv.Itoa(secnum)
+}
+
 type objinfo struct {
 	objidx int
-	oname  string
+	// display label for the object
+	label string
+	// file to pass to the dumper
+	ofile string
 }
 
 func (s *state) collectWatchedFiles() []objinfo {
 	oinds := make(map[int]bool)
-	for k := range watched {
-		rl := s.refs[k]
+	for k, rl := range s.refs {
 		for _, ri := range rl {
+			if !s.isWatched(k, ri.objidx) {
+				continue
+			}
+			// There is nothing to disassemble for images, and
+			// objdump can't read Go objects.
+			if _, ok := s.imports[ri.objidx]; ok || s.goObjects[ri.objidx] {
+				continue
+			}
 			oinds[ri.objidx] = true
 		}
 	}
 	res := make([]objinfo, 0, len(oinds))
 	for oidx := range oinds {
-		res = append(res, objinfo{objidx: oidx, oname: s.objs[oidx]})
+		// Objects merged from saved state have no file to dump.
+		if s.files[oidx] == "" {
+			continue
+		}
+		res = append(res, objinfo{objidx: oidx, label: s.labels[oidx], ofile: s.files[oidx]})
 	}
 	sort.Slice(res, func(i, j int) bool {
-		if res[i].oname != res[j].oname {
-			return res[i].oname < res[j].oname
+		if res[i].label != res[j].label {
+			return res[i].label < res[j].label
 		}
 		return res[i].objidx < res[j].objidx
 	})
 	return res
 }
 
+// disasm returns the disassembly of watched file of, with source code
+// if withsrc is set.
+func (s *state) disasm(of objinfo, withsrc bool) ([]byte, error) {
+	r, err := s.dumper.Disasm(of.ofile, withsrc)
+	if err != nil {
+		return nil, err
+	}
+	pass := "disasm"
+	if withsrc {
+		pass = "disasm-source"
+	}
+	return readDump(s.keepTemp(of.objidx, pass, r))
+}
+
 func (s *state) dumpWatched() error {
 
 	// Figure out which files we're ging to e
 	ofiles := s.collectWatchedFiles()
 
+	// In offline mode, excerpts come from captured disassembly, keyed
+	// by dump (inputs may have been dropped from the -from-dump list).
+	var disasm map[string]string
+	if *fromdumpflag != "" {
+		if *fromdisasmflag == "" {
+			fmt.Fprintf(os.Stderr, "note: no excerpts for watched symbols with -from-dump (use -from-disasm)\n")
+			return nil
+		}
+		disasm = make(map[string]string)
+		dfiles := strings.Split(*fromdisasmflag, ",")
+		for i, dump := range strings.Split(*fromdumpflag, ",") {
+			disasm[dump] = dfiles[i]
+		}
+	} else if flavor == flavorDumpbin {
+		fmt.Fprintf(os.Stderr, "note: no excerpts for watched symbols with dumpbin\n")
+		return nil
+	} else if s.dumper == nil && len(ofiles) != 0 {
+		// The built-in reader can't disassemble.
+		if err := resolveDumper(); err != nil {
+			fmt.Fprintf(os.Stderr, "note: no excerpts for watched symbols: disassembly needs an objdump program, and none is usable\n")
+			verb(1, "%v", err)
+			return nil
+		}
+		s.dumper = newObjdumpDumper()
+	}
+
 	// Dump excerpts from each file.
+	defer func() { doing = "" }()
 	for _, of := range ofiles {
-		ofile := of.oname
-		cmd := exec.Command(*objdumpflag,
-			"-l", // line numbers
-			"-d", // assembly
-			"-r", // relocations
-			ofile)
-		out, err := cmd.Output()
+		doing = "disassembling " + of.label + " for excerpts"
+		if disasm != nil {
+			dfile, ok := disasm[of.ofile]
+			if !ok {
+				continue
+			}
+			out, err := readText(dfile)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(reportw, "\nexcerpts from %s for %s\n", dfile, of.label)
+			lines := strings.Split(string(out), "\n")
+			if err := s.emitExcerpts(lines, of, hasSourceLines(lines)); err != nil {
+				return err
+			}
+			continue
+		}
+		if *excerptsrcflag {
+			out, err := s.disasm(of, true)
+			if err == errNoDisasm {
+				fmt.Fprintf(os.Stderr, "note: no excerpts for watched symbols: %s can't disassemble\n", dumper)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// If there is no debug info (or the sources can't be
+			// found), fall back on the plain disassembly below.
+			lines := strings.Split(string(out), "\n")
+			if hasSourceLines(lines) {
+				fmt.Fprintf(reportw, "\nexcerpts from '%s -lSr %s`\n", disasmName(s.dumper), of.label)
+				if err := s.emitExcerpts(lines, of, true); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		out, err := s.disasm(of, false)
+		if err == errNoDisasm {
+			fmt.Fprintf(os.Stderr, "note: no excerpts for watched symbols: %s can't disassemble\n", dumper)
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("running llvm-objdump-14 on %s: %v", ofile, err)
+			return err
 		}
-		fmt.Printf("\nexcerpts from 'llvm-objdump-14 -ldr %s`\n", ofile)
-		if err := s.emitExcerpts(string(out), of.objidx); err != nil {
+		fmt.Fprintf(reportw, "\nexcerpts from '%s -ldr %s`\n", disasmName(s.dumper), of.label)
+		lines := strings.Split(string(out), "\n")
+		if err := s.emitExcerpts(lines, of, false); err != nil {
 			return err
 		}
 	}
+	if textfmt.original() {
+		s.writeNoExcerpts(os.Stderr)
+	} else {
+		s.writeNoExcerpts(reportw)
+	}
 	return nil
 }
 
-func (s *state) emitExcerpts(content string, oidx int) error {
+// ; C:\workdir/go/misc/cgo/test/test.go:80
+// ; cTest():
+var lineinfore = regexp.MustCompile(`^; (\S+:\d+|\S+\(\):)$`)
+
+// isCommentLine returns true if the specified line of objdump
+// disassembly output is a comment (line info or source code).
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(line, ";")
+}
+
+// isSourceLine returns true if the specified line of objdump
+// disassembly output is a line of interleaved source code (as
+// opposed to a "; file:line" or "; func():" line info comment).
+func isSourceLine(line string) bool {
+	return isCommentLine(line) && !lineinfore.MatchString(line)
+}
+
+func hasSourceLines(lines []string) bool {
+	for _, line := range lines {
+		if isSourceLine(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// excerptWindow returns the range of lines [lo,hi] to show for the
+// relocation at line i. This is a couple of lines before and after
+// the reloc, widened when source is interleaved so that the source
+// statement containing the reference is shown in its entirety.
+func excerptWindow(lines []string, i int, withsrc bool) (int, int) {
+	lo, hi := i-2, i+2
+	if !withsrc {
+		return lo, hi
+	}
+	// Walk back to the start of the source statement.
+	ci := i - 1
+	for ci > 0 && !isSourceLine(lines[ci]) && lines[ci] != "" {
+		ci--
+	}
+	for ci > 0 && isCommentLine(lines[ci-1]) {
+		ci--
+	}
+	if ci < lo {
+		lo = ci
+	}
+	// Walk forward to the end of the source statement.
+	ci = i + 1
+	for ci < len(lines) && !isCommentLine(lines[ci]) && lines[ci] != "" {
+		ci++
+	}
+	if ci-1 > hi {
+		hi = ci - 1
+	}
+	return lo, hi
+}
+
+func (s *state) emitExcerpts(lines []string, of objinfo, withsrc bool) error {
 	// 0000000000000000 <makeEvent>:
 	var fnstre = regexp.MustCompile(`^\S+\s+\<(\S+)\>\:\s*$`)
 	// 000000000000009b:  IMAGE_REL_AMD64_REL32	printf
-	var relocre = regexp.MustCompile(`^\s+(\S+)\:\s+IMAGE_\S+\s+(\S+)\s*$`)
+	// 0000000000000007:  R_X86_64_PLT32	bar-0x4
+	var relocre = regexp.MustCompile(`^\s+(\S+)\:\s+((?:IMAGE_|R_)\S+)\s+(\S+?)(?:[-+]0x[0-9a-f]+)?\s*$`)
 
 	fnLine := 0
-	lines := strings.Split(content, "\n")
 	painted := make(map[int]bool)
 	oimap := make(map[int]int)
 	ofmap := make(map[int]int)
 	fnmap := make(map[int]int)
+	symmap := make(map[int]string)
 	for i := range lines {
 		line := lines[i]
+		if isCommentLine(line) {
+			continue
+		}
 		m := fnstre.FindStringSubmatch(line)
 		if len(m) != 0 {
 			fnLine = i
@@ -572,21 +1780,23 @@ func (s *state) emitExcerpts(content string, oidx int) error {
 			continue
 		}
 		off := m[1]
-		fn := m[2]
-		if !watched[fn] {
+		fn := m[3]
+		if !s.isWatched(fn, of.objidx) || isPairLow(m[2]) {
 			continue
 		}
 		var offset int
 		if n, err := fmt.Sscanf(off, "%x", &offset); n != 1 || err != nil {
 			return fmt.Errorf("bad offset %s", off)
 		}
-		ri, rerr := s.findRefInfo(fn, offset, oidx)
+		ri, rerr := s.findRefInfo(fn, offset, of.objidx)
 		if rerr != nil {
 			return rerr
 		}
+		s.noteExcerpted(fn, ri.objidx)
 		oimap[i] = ri.objidx
 		ofmap[i] = offset
 		fnmap[i] = fnLine
+		symmap[i] = fn
 		painted[i] = true
 	}
 	for i := range lines {
@@ -594,15 +1804,24 @@ func (s *state) emitExcerpts(content string, oidx int) error {
 			continue
 		}
 		oi := oimap[i]
-		of := ofmap[i]
+		off := ofmap[i]
 		fn := fnmap[i]
-		fmt.Printf("\n=-= ref O%d off=0x%x:\n", oi, of)
+		label := " " + of.label
+		if textfmt.original() {
+			label = ""
+		}
+		if tag := demangledTag(symmap[i]); tag != "" {
+			fmt.Fprintf(reportw, "\n=-= ref O%d%s off=0x%x %q%s:\n", oi, label, off, symmap[i], tag)
+		} else {
+			fmt.Fprintf(reportw, "\n=-= ref O%d%s off=0x%x:\n", oi, label, off)
+		}
 		// func
-		fmt.Printf("%d: %s\n...\n", fn, lines[fn])
-		// reloc, couple of lines before and after
-		for ci := i - 2; ci <= i+2; ci++ {
+		fmt.Fprintf(reportw, "%d: %s\n...\n", fn, lines[fn])
+		// reloc, couple of lines (or source statement) before and after
+		lo, hi := excerptWindow(lines, i, withsrc)
+		for ci := lo; ci <= hi; ci++ {
 			if ci > 0 && ci < len(lines) {
-				fmt.Printf("%d: %s\n", ci, lines[ci])
+				fmt.Fprintf(reportw, "%d: %s\n", ci, lines[ci])
 			}
 		}
 	}
@@ -616,8 +1835,8 @@ func (s *state) findRefInfo(fn string, offset, oidx int) (*refinfo, error) {
 		if ri.objidx != oidx {
 			continue
 		}
-		for _, of := range ri.offsets {
-			if of == offset {
+		for _, r := range ri.relocs {
+			if r.off == offset {
 				// Found.
 				return ri, nil
 			}
@@ -628,52 +1847,479 @@ func (s *state) findRefInfo(fn string, offset, oidx int) (*refinfo, error) {
 	return nil, fmt.Errorf("could not find refinfo for fn=%s of=%x", fn, offset)
 }
 
+func verb(vlevel int, s string, a ...interface{}) {
+	if *verbflag >= vlevel {
+		fmt.Fprintf(os.Stderr, s, a...)
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
+
+// setupWatched populates the watched set from the -watch and
+// -watchfile flags, and compiles the -watchre patterns. For each
+// symbol X we also watch __imp_X.
+func setupWatched() error {
+	watched = make(map[string]bool)
+	watchedNames = nil
+	watchres = nil
+	if *watchreflag != "" {
+		for _, p := range strings.Split(*watchreflag, ",") {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("bad -watchre pattern %q: %v", p, err)
+			}
+			watchres = append(watchres, re)
+		}
+	}
+	var syms []string
+	if *watchsymsflag != "" {
+		syms = strings.Split(*watchsymsflag, ",")
+	}
+	if *watchfileflag != "" {
+		wsyms, err := readWatchFile(*watchfileflag)
+		if err != nil {
+			return err
+		}
+		syms = append(syms, wsyms...)
+	}
+	for _, s := range syms {
+		if !watched[s] {
+			watchedNames = append(watchedNames, s)
+		}
+		watched[s] = true
+		for _, is := range impNames(s) {
+			watched[is] = true
+		}
+	}
+	return nil
+}
+
+// readWatchFile reads a list of symbols to watch, one per line,
+// ignoring blank lines and '#' comments.
+func readWatchFile(wfile string) ([]string, error) {
+	content, err := readText(wfile)
+	if err != nil {
+		return nil, fmt.Errorf("reading watch file: %v", err)
+	}
+	var res []string
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, " \t") {
+			return nil, fmt.Errorf("%s:%d: malformed symbol %q (embedded whitespace)", wfile, i+1, line)
+		}
+		res = append(res, line)
+	}
+	return res, nil
+}
+
 func usage(msg string) {
 	if len(msg) > 0 {
 		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
 	}
-	fmt.Fprintf(os.Stderr, "usage: winimpsyms [flags] -i=X,Y,...,Z\n")
+	fmt.Fprintf(os.Stderr, "usage: winimpsyms [flags] -i=X,Y,...,Z [files]\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
 func fatal(s string, a ...interface{}) {
+	if interrupted.Load() {
+		// The failure is most likely down to the interrupt.
+		exitInterrupted()
+	}
 	fmt.Fprintf(os.Stderr, s, a...)
 	fmt.Fprintf(os.Stderr, "\n")
+	cleanup()
 	os.Exit(1)
 }
 
+// scratch directory for extracted archive members and the like,
+// created on demand.
+var tmpdir string
+
+// directories to remove on exit.
+var cleanupdirs []string
+
+func tempDir() (string, error) {
+	if tmpdir == "" {
+		td, err := os.MkdirTemp("", "winimpsym")
+		if err != nil {
+			return "", err
+		}
+		tmpdir = td
+		addCleanup(td)
+	}
+	return tmpdir, nil
+}
+
+func addCleanup(dir string) {
+	cleanupdirs = append(cleanupdirs, dir)
+}
+
+func cleanup() {
+	for _, dir := range cleanupdirs {
+		os.RemoveAll(dir)
+	}
+	cleanupdirs = nil
+	tmpdir = ""
+}
+
+// readFailed handles a failure to read object k in the specified pass:
+// with -continue-on-error the object is skipped from then on,
+// otherwise this is fatal (with the state so far, if withState).
+func (s *state) readFailed(k int, pass string, err error, withState bool) {
+	if interrupted.Load() {
+		exitInterrupted()
+	}
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("-deadline of %v exceeded", *deadlineflag)
+	}
+	if *continueflag {
+		fmt.Fprintf(os.Stderr, "error: reading %s (%s): %v\n", s.objs[k], pass, err)
+		s.skipped[k] = pass + " failed"
+		return
+	}
+	if withState {
+		fatal("reading %s (%s): %v\nstate: %s\n", s.objs[k], pass, err, s.String())
+	}
+	fatal("reading %s (%s): %v", s.objs[k], pass, err)
+}
+
 func main() {
 	flag.Parse()
-	if *inputsflag == "" {
-		usage("supply input files with -i option")
+	var err error
+	if sidecars, err = parseSidecars(*sidecarflag); err != nil {
+		usage(err.Error())
 	}
-	watched = make(map[string]bool)
-	if *watchsymsflag != "" {
-		for _, s := range strings.Split(*watchsymsflag, ",") {
-			watched[s] = true
-			watched[imppref+s] = true
+	if err := setupImpPrefixes(*impprefixflag); err != nil {
+		usage(err.Error())
+	}
+	if err := setupWatched(); err != nil {
+		fatal("%v", err)
+	}
+	if err := setupExcluded(); err != nil {
+		fatal("%v", err)
+	}
+	if err := checkSortFlag(*sortflag); err != nil {
+		usage(err.Error())
+	}
+	if err := setupColor(*colorflag, *outflag != "" && *outflag != "-"); err != nil {
+		usage(err.Error())
+	}
+	if textfmt, err = parseFormatVersion(*formatversionflag); err != nil {
+		usage(err.Error())
+	}
+	if err := checkIncludeFormat(*emitincludeformatflag); err != nil {
+		usage(err.Error())
+	}
+	var sarifrules []sarifRule
+	if sarifrules, err = parseSarifRules(*sarifrulesflag); err != nil {
+		usage(err.Error())
+	}
+	if *onlyflag != "" {
+		if onlyExpr, err = parseOnly(*onlyflag); err != nil {
+			usage(err.Error())
+		}
+	}
+	if n := countTrue(*briefflag, *formatflag != "", *ndjsonflag); n > 1 {
+		usage("only one of -brief, -format and -ndjson can be used")
+	}
+	if *formatflag != "" {
+		if reportTemplate, err = parseFormat(*formatflag); err != nil {
+			fatal("bad -format: %v", err)
+		}
+	}
+	switch *flavorflag {
+	case flavorAuto, flavorLLVM, flavorGNU, flavorDumpbin, flavorReadobj, flavorNative:
+	default:
+		usage(fmt.Sprintf("bad -dumper-flavor %q", *flavorflag))
+	}
+	flavor = *flavorflag
+	if flavor == flavorAuto {
+		flavor = flavorLLVM
+	}
+	// An explicitly chosen dumper is the only backend.
+	explicit := *flavorflag != flavorAuto || *objdumpflag != "" || os.Getenv(dumperEnv) != ""
+	var chain []*backend
+	if *fromdumpflag == "" && (*backendflag != backendAuto || !explicit) {
+		if chain, err = newBackends(*backendflag); err != nil {
+			usage(err.Error())
+		}
+		if *backendflag == backendGNU {
+			flavor = flavorGNU
+		}
+	} else if *fromdumpflag == "" && flavor != flavorNative {
+		if err := resolveDumper(); err != nil {
+			fatal("%v", err)
+		}
+	}
+	runCtx = handleInterrupts(context.Background())
+	if *deadlineflag > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, *deadlineflag)
+		defer cancel()
+	}
+	if *stateflag != "" && *fromdumpflag == "" {
+		if dcache, err = loadDumpCache(*stateflag); err != nil {
+			fatal("loading state: %v", err)
+		}
+	}
+	if *keeptempflag != "" {
+		if err := os.MkdirAll(*keeptempflag, 0777); err != nil {
+			fatal("%v", err)
+		}
+	}
+	report, err := openReport(*outflag)
+	if err != nil {
+		fatal("-o: %v", err)
+	}
+	if *ndjsonflag {
+		ndjson = newNDJSONWriter(reportw)
+	}
+	objs, files, dups := resolveObjects()
+	s := newState(objs, files)
+	s.dups = dups
+	s.chain = chain
+	if dumperArgv != nil {
+		s.dumper = newObjdumpDumper()
+	}
+	if *objrangeflag != "" {
+		lo, hi, err := parseObjRange(*objrangeflag, len(objs))
+		if err != nil {
+			fatal("%v", err)
+		}
+		for k := range objs {
+			if k < lo || k >= hi {
+				s.skipped[k] = "outside -objrange"
+			}
 		}
 	}
-	infiles := strings.Split(*inputsflag, ",")
-	s := newState(infiles)
-	for k, ifile := range infiles {
+	if *fromdumpflag == "" {
+		// Only the headers need to be read to check this.
+		for k, ifile := range files {
+			if _, ok := s.skipped[k]; !ok {
+				s.machines[k] = fileMachine(ifile)
+			}
+		}
+		s.checkMachines()
+		s.batchDumps(files)
+	}
+	for k, ifile := range files {
+		if _, ok := s.skipped[k]; ok {
+			continue
+		}
 		s.objidx = k
-		if err := s.pass1(ifile); err != nil {
-			fatal("reading %s: %v", ifile, err)
+		doing = fmt.Sprintf("reading %s (pass1)", objs[k])
+		err := runCtx.Err()
+		if err == nil {
+			err = s.pass1(ifile)
+		}
+		if err != nil {
+			s.readFailed(k, "pass1", err, false)
 		}
 	}
+	s.checkMachines()
 	s.pass2()
-	for k, ifile := range infiles {
-		s.objidx = k
-		if err := s.pass3(ifile); err != nil {
-			fatal("reading %s: %v\nstate: %s\n", ifile, err, s.String())
+	if *implibflag != "" {
+		if err := s.readImportLibs(); err != nil {
+			fatal("%v", err)
+		}
+	}
+	for k, ifile := range files {
+		if _, ok := s.skipped[k]; ok {
+			s.prov = append(s.prov, provenance{})
+		} else {
+			s.objidx = k
+			doing = fmt.Sprintf("reading %s (pass3)", objs[k])
+			err := runCtx.Err()
+			if err == nil {
+				err = s.pass3(ifile)
+			}
+			if err != nil {
+				s.readFailed(k, "pass3", err, true)
+				if len(s.prov) == k {
+					s.prov = append(s.prov, provenance{})
+				}
+			}
+		}
+		if ndjson != nil {
+			if err := ndjson.object(s, k); err != nil {
+				fatal("-ndjson: %v", err)
+			}
+		}
+	}
+	if interrupted.Load() {
+		exitInterrupted()
+	}
+	doing = ""
+	s.reportExcluded()
+	s.markDefPairs()
+	if dcache != nil {
+		verb(1, "reused state for %d of %d objects", dcache.hits, len(objs))
+		dcache.prune()
+		if err := dcache.save(*stateflag); err != nil {
+			fatal("saving state: %v", err)
+		}
+	}
+	if *loadflag != "" {
+		for _, lf := range strings.Split(*loadflag, ",") {
+			ss, err := readSavedState(lf)
+			if err != nil {
+				fatal("loading saved state: %v", err)
+			}
+			s.merge(ss, lf)
 		}
 	}
-	fmt.Fprintf(os.Stdout, "state: %s\n", s.String())
-	if len(watched) != 0 {
+	if *libpathflag != "" {
+		if err := s.readDefaultLibs(); err != nil {
+			fatal("%v", err)
+		}
+	}
+	s.applyAliases()
+	if watching() {
+		s.checkWatched()
+	}
+	if *saveflag != "" {
+		if err := s.save(*saveflag); err != nil {
+			fatal("saving state: %v", err)
+		}
+	}
+	if onlyExpr != nil {
+		s.applyOnly(onlyExpr)
+	}
+	if *demangleflag {
+		s.setupDemangled()
+	}
+	if ndjson != nil {
+		if err := ndjson.symbols(s); err != nil {
+			fatal("-ndjson: %v", err)
+		}
+		// The rest goes to stderr, leaving just the records.
+		reportw = os.Stderr
+	} else if reportTemplate != nil {
+		if err := reportTemplate.Execute(reportw, s.report()); err != nil {
+			fatal("-format: %v", err)
+		}
+	} else if *briefflag {
+		writeFormatHeader(reportw)
+		s.writeBreakdown(reportw)
+	} else {
+		writeFormatHeader(reportw)
+		fmt.Fprintf(reportw, "state: %s\n", s.String())
+	}
+	if watching() && !*noexcerptsflag {
+		// Keep a copy of the excerpts for the pages of -serve.
+		var excerpts strings.Builder
+		w := reportw
+		if *serveflag != "" {
+			reportw = io.MultiWriter(w, &excerpts)
+		}
 		if err := s.dumpWatched(); err != nil {
 			fatal("dumping watched syms: %v", err)
 		}
+		reportw = w
+		servedExcerpts = excerpts.String()
+	}
+	if *mapfileflag != "" {
+		mapsyms, err := parseMapFile(*mapfileflag)
+		if err != nil {
+			fatal("reading map file: %v", err)
+		}
+		if onlyExpr != nil {
+			mapsyms = s.onlyMapSyms(mapsyms)
+		}
+		fmt.Fprintf(reportw, "\nMap file cross-check (%s):\n", *mapfileflag)
+		s.checkMap(reportw, mapsyms)
+	}
+	if *relocstsvflag != "" {
+		if err := s.writeRelocsTSV(*relocstsvflag); err != nil {
+			fatal("writing relocation table: %v", err)
+		}
+	}
+	if *indexflag != "" {
+		if err := s.writeIndex(*indexflag); err != nil {
+			fatal("writing symbol index: %v", err)
+		}
+	}
+	if *emitdefflag != "" {
+		if err := s.writeDef(*emitdefflag); err != nil {
+			fatal("writing .def file: %v", err)
+		}
+	}
+	if *emitincludeflag != "" {
+		if err := s.writeInclude(*emitincludeflag, *emitincludeformatflag); err != nil {
+			fatal("writing /INCLUDE directives: %v", err)
+		}
+	}
+	if *sarifflag != "" {
+		if err := s.writeSARIF(*sarifflag, sarifrules); err != nil {
+			fatal("writing SARIF log: %v", err)
+		}
+	}
+	if *sqliteflag != "" {
+		if err := s.writeSQLite(*sqliteflag, *runlabelflag); err != nil {
+			fatal("writing SQLite database: %v", err)
+		}
+	}
+	if *graphmlflag != "" {
+		if err := s.writeGraphML(*graphmlflag, *graphmlwatchedflag); err != nil {
+			fatal("writing GraphML graph: %v", err)
+		}
+	}
+	if *htmlflag != "" {
+		if err := s.writeHTML(*htmlflag); err != nil {
+			fatal("writing HTML report: %v", err)
+		}
+	}
+	if *writebaselineflag != "" {
+		if err := s.writeBaseline(*writebaselineflag); err != nil {
+			fatal("writing baseline: %v", err)
+		}
+	}
+	if *baselineflag != "" {
+		baseline, err := readBaseline(*baselineflag)
+		if err != nil {
+			fatal("reading baseline: %v", err)
+		}
+		if onlyExpr != nil {
+			baseline = onlyBaseline(baseline, onlyExpr)
+		}
+		sb := &strings.Builder{}
+		if n := s.compareBaseline(sb, baseline); n != 0 {
+			fmt.Fprintf(reportw, "\nBaseline differences (%s):\n%s", *baselineflag, sb.String())
+			finishReport(s, report, baselineDiffExit)
+			os.Exit(baselineDiffExit)
+		}
+	}
+	if *checkresolvedflag {
+		if imps, _ := s.unresolved(); len(imps) != 0 {
+			finishReport(s, report, unresolvedExit)
+			os.Exit(unresolvedExit)
+		}
+	}
+	if *failondupdefsflag && s.hasDupConflicts() {
+		finishReport(s, report, dupDefsExit)
+		os.Exit(dupDefsExit)
+	}
+	finishReport(s, report, 0)
+}
+
+// finishReport completes the report, for a run ending with the
+// specified exit status, and cleans up.
+func finishReport(s *state, report *reportFile, status int) {
+	if ndjson != nil {
+		if err := ndjson.summary(s, status); err != nil {
+			fatal("-ndjson: %v", err)
+		}
+	}
+	if err := report.finish(); err != nil {
+		fatal("writing report: %v", err)
+	}
+	if *serveflag != "" {
+		if err := s.serve(*serveflag); err != nil {
+			fatal("serving results: %v", err)
+		}
 	}
+	cleanup()
 }
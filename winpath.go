@@ -0,0 +1,56 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// maxPath is the Windows MAX_PATH limit; longer paths have to be given
+// in extended-length ("\\?\") form.
+const maxPath = 260
+
+// longPathPrefix is the prefix that marks an extended-length path.
+const longPathPrefix = `\\?\`
+
+// normalizeWinPath converts an absolute Windows path longer than
+// MAX_PATH to extended-length form ("\\?\C:\..." or
+// "\\?\UNC\server\share\..."). Since extended-length paths are not
+// normalized by Windows, forward slashes are converted to backslashes
+// first. Shorter paths, relative paths, and paths that are already in
+// extended-length or device form are returned unchanged.
+func normalizeWinPath(p string) string {
+	if strings.HasPrefix(p, longPathPrefix) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+	if len(p) < maxPath {
+		return p
+	}
+	bp := strings.ReplaceAll(p, "/", `\`)
+	switch {
+	case strings.HasPrefix(bp, `\\`):
+		// UNC share: \\server\share\... => \\?\UNC\server\share\...
+		return longPathPrefix + `UNC\` + bp[2:]
+	case len(bp) >= 3 && bp[1] == ':' && bp[2] == '\\':
+		return longPathPrefix + bp
+	}
+	return p
+}
+
+// dumperPath returns the form of path to pass to the dumper. On Windows
+// this is an absolute, extended-length path when the absolute path is
+// too long to be used as is.
+func dumperPath(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+	ap, err := filepath.Abs(path)
+	if err != nil || len(ap) < maxPath {
+		return path
+	}
+	return normalizeWinPath(ap)
+}
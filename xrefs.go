@@ -0,0 +1,109 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Support for the Xrefs section of the report (-xrefs), which lists
+// for each import symbol the functions referring to it:
+//
+//	Xrefs:
+//	 "__imp_bar":
+//	  O0 callbar
+//	  O1 f
+//
+// Each relocation is attributed to the function containing it: the
+// symbol defined in the .text section at or before its offset (symbol
+// tables give no sizes for COFF functions, so a function is taken to
+// run up to the next one). A relocation in some other section, or
+// before the first function, is attributed to the section instead. This
+// uses only the symbol tables read in pass3, so functions are not
+// known for objects merged in with -load.
+
+// funcsym is a function symbol of an object.
+type funcsym struct {
+	secnum int
+	value  int
+	name   string
+}
+
+// noteFunc records symbol sname of the current object, with the
+// specified symbol-table section number and value, if it is a
+// function.
+func (s *state) noteFunc(sname string, secnum, value int) {
+	if secnum <= 0 || strings.HasPrefix(sname, ".") || strings.HasPrefix(sname, "$") {
+		return
+	}
+	if si := s.symSection(s.objidx, secnum); si == nil || si.name != ".text" {
+		return
+	}
+	s.funcs[s.objidx] = append(s.funcs[s.objidx], funcsym{secnum, value, sname})
+}
+
+// refFunction returns the function of object objidx containing
+// relocation r, or failing that the name of its section.
+func (s *state) refFunction(objidx int, r relocinfo) string {
+	var best *funcsym
+	for i := range s.funcs[objidx] {
+		fs := &s.funcs[objidx][i]
+		if fs.value > r.off || s.secName(objidx, fs.secnum) != r.sect {
+			continue
+		}
+		if best == nil || fs.value > best.value {
+			best = fs
+		}
+	}
+	if best == nil {
+		return r.sect
+	}
+	return best.name
+}
+
+// writeXrefs writes the Xrefs section to w.
+func (s *state) writeXrefs(w io.Writer) {
+	type xref struct {
+		objidx int
+		fn     string
+	}
+	syms := make([]string, 0, len(s.refs))
+	for k := range s.refs {
+		if isImpSym(k) {
+			syms = append(syms, k)
+		}
+	}
+	if len(syms) == 0 {
+		return
+	}
+	sort.Strings(syms)
+	fmt.Fprintf(w, "Xrefs:\n")
+	for _, sym := range syms {
+		seen := make(map[xref]bool)
+		var xrefs []xref
+		for _, ri := range s.refs[sym] {
+			for _, r := range ri.relocs {
+				xr := xref{ri.objidx, s.refFunction(ri.objidx, r)}
+				if !seen[xr] {
+					seen[xr] = true
+					xrefs = append(xrefs, xr)
+				}
+			}
+		}
+		sort.Slice(xrefs, func(i, j int) bool {
+			if xrefs[i].objidx != xrefs[j].objidx {
+				return xrefs[i].objidx < xrefs[j].objidx
+			}
+			return xrefs[i].fn < xrefs[j].fn
+		})
+		fmt.Fprintf(w, " %q:\n", sym)
+		for _, xr := range xrefs {
+			fmt.Fprintf(w, "  O%d %s\n", xr.objidx, xr.fn)
+		}
+	}
+}